@@ -74,7 +74,7 @@ var (
 	errorMap = map[error]func(error) Error{
 		client.ErrorMissingRedirectURI: errorMaker("bad_request", "Non-public clients must have at least one redirect URI", http.StatusBadRequest),
 
-		client.ErrorPublicClientRedirectURIs: errorMaker("bad_request", "Public clients cannot specify redirect URIs", http.StatusBadRequest),
+		client.ErrorPublicClientRedirectURIs: errorMaker("bad_request", "Public clients may only specify loopback or private-use URI scheme redirect URIs", http.StatusBadRequest),
 
 		client.ErrorPublicClientMissingName: errorMaker("bad_request", "Public clients require a ClientName", http.StatusBadRequest),
 
@@ -89,6 +89,7 @@ var (
 		adminschema.ErrorInvalidLogoURI:     errorMaker("bad_request", "invalid logoURI.", http.StatusBadRequest),
 		adminschema.ErrorInvalidClientURI:   errorMaker("bad_request", "invalid clientURI.", http.StatusBadRequest),
 		adminschema.ErrorNoRedirectURI:      errorMaker("bad_request", "invalid redirectURI.", http.StatusBadRequest),
+		adminschema.ErrorInvalidLifetime:    errorMaker("bad_request", "invalid token lifetime.", http.StatusBadRequest),
 	}
 )
 
@@ -144,7 +145,8 @@ func (a *AdminAPI) CreateClient(req adminschema.ClientCreateRequest) (adminschem
 	}
 
 	creds, err := a.clientManager.New(cli, &clientmanager.ClientOptions{
-		TrustedPeers: req.Client.TrustedPeers,
+		TrustedPeers:       req.Client.TrustedPeers,
+		TokenExchangePeers: req.Client.TokenExchangePeers,
 	})
 	if err != nil {
 		return adminschema.ClientCreateResponse{}, mapError(err)
@@ -8,10 +8,14 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/coreos/dex/device"
+	pstrings "github.com/coreos/dex/pkg/strings"
 	"github.com/coreos/dex/repo"
+	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
 )
 
@@ -26,12 +30,20 @@ var (
 	ErrorCantChooseRedirectURL = errors.New("must provide a redirect url; client has many")
 	ErrorNoValidRedirectURLs   = errors.New("no valid redirect URLs for this client.")
 
-	ErrorPublicClientRedirectURIs = errors.New("public clients cannot have redirect URIs")
+	ErrorPublicClientRedirectURIs = errors.New("public clients may only have loopback or private-use URI scheme redirect URIs")
 	ErrorPublicClientMissingName  = errors.New("public clients must have a name")
 
 	ErrorMissingRedirectURI = errors.New("no client redirect url given")
 
 	ErrorNotFound = errors.New("no data found")
+
+	ErrorAssertionReused = errors.New("client assertion has already been used")
+
+	ErrorInvalidSubjectType         = errors.New("subject type must be public or pairwise")
+	ErrorMissingSectorIdentifierURI = errors.New("pairwise clients whose redirect URIs have different hosts must have a sector identifier URI")
+
+	ErrorInvalidLifetime  = errors.New("token lifetimes cannot be negative")
+	ErrorInvalidGrantType = errors.New("not a supported grant type")
 )
 
 type ValidationError struct {
@@ -46,8 +58,23 @@ const (
 	bcryptHashCost = 10
 
 	OOBRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+	// GrantTypeTokenExchange is the grant type of token exchange requests
+	// (RFC 8693 Section 2.1).
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
 )
 
+// GrantTypes are the grant types clients may be allowed to use.
+var GrantTypes = []string{
+	oauth2.GrantTypeAuthCode,
+	oauth2.GrantTypeImplicit,
+	oauth2.GrantTypeClientCreds,
+	oauth2.GrantTypeUserCreds,
+	oauth2.GrantTypeRefreshToken,
+	device.GrantTypeDeviceCode,
+	GrantTypeTokenExchange,
+}
+
 func HashSecret(creds oidc.ClientCredentials) ([]byte, error) {
 	secretBytes, err := base64.URLEncoding.DecodeString(creds.Secret)
 	if err != nil {
@@ -67,8 +94,50 @@ type Client struct {
 	Metadata    oidc.ClientMetadata
 	Admin       bool
 	Public      bool
+
+	// Resources are the URIs of the resource servers the client may obtain
+	// access tokens for (RFC 8707). Unless the client requests specific
+	// resources, its access tokens are issued with these as their audience.
+	Resources []string
+
+	// PostLogoutRedirectURIs are the URLs the client may ask for the user to
+	// be sent to after logging out (OpenID Connect RP-Initiated Logout 1.0).
+	PostLogoutRedirectURIs []url.URL
+
+	// BackchannelLogoutURI is where the client is notified when a user's
+	// session it received tokens in ends (OpenID Connect Back-Channel Logout
+	// 1.0).
+	BackchannelLogoutURI *url.URL
+
+	// FirstParty clients are operated by the same party as dex, so users
+	// aren't asked to consent to the access they request.
+	FirstParty bool
+
+	// RequirePushedAuthorizationRequests clients may only send users to the
+	// authorization endpoint with a request URI returned by the pushed
+	// authorization request endpoint (RFC 9126 Section 6).
+	RequirePushedAuthorizationRequests bool
+
+	// IDTokenLifetime and AccessTokenLifetime are the lifetimes of the ID
+	// tokens and access tokens issued to the client, and RefreshTokenLifetime
+	// the absolute lifetime of its refresh tokens. The server's lifetimes are
+	// used for those which are zero.
+	IDTokenLifetime      time.Duration
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+
+	// AllowedGrantTypes are the grant types the client may use at the token
+	// endpoint, and AllowedScopes the scopes it may request besides openid.
+	// All are allowed if they're empty, except for the password grant.
+	AllowedGrantTypes []string
+	AllowedScopes     []string
 }
 
+// ValidRedirectURL returns the redirect URL a request to the authorization
+// endpoint should be answered with. Public clients may use the OOB redirect
+// URI and, per RFC 8252, any port of their registered loopback redirect URIs.
+// Public clients which haven't registered any may use any loopback redirect
+// URI.
 func (c Client) ValidRedirectURL(u *url.URL) (url.URL, error) {
 	if c.Public {
 		if u == nil {
@@ -77,24 +146,120 @@ func (c Client) ValidRedirectURL(u *url.URL) (url.URL, error) {
 		if u.String() == OOBRedirectURI {
 			return *u, nil
 		}
-
-		if u.Scheme != "http" {
+		if !ValidNativeRedirectURL(*u) {
 			return url.URL{}, ErrorInvalidRedirectURL
 		}
 
-		hostPort := strings.Split(u.Host, ":")
-		if len(hostPort) != 2 {
-			return url.URL{}, ErrorInvalidRedirectURL
+		if len(c.Metadata.RedirectURIs) == 0 {
+			if !loopbackRedirectURL(*u) {
+				return url.URL{}, ErrorInvalidRedirectURL
+			}
+			return *u, nil
+		}
+		for _, ru := range c.Metadata.RedirectURIs {
+			if reflect.DeepEqual(withoutLoopbackPort(ru), withoutLoopbackPort(*u)) {
+				return *u, nil
+			}
 		}
+		return url.URL{}, ErrorInvalidRedirectURL
+	}
 
-		if hostPort[0] != "localhost" || u.Path != "" || u.RawPath != "" || u.RawQuery != "" || u.Fragment != "" {
-			return url.URL{}, ErrorInvalidRedirectURL
+	return ValidRedirectURL(u, c.Metadata.RedirectURIs)
+}
+
+// ValidNativeRedirectURL reports whether u is a redirect URI public clients
+// may use: either an HTTP URL on a loopback address (RFC 8252 Section 7.3), or
+// one with a private-use scheme, which must contain a period so it's based on
+// a domain name the app's developer controls (RFC 8252 Section 7.1).
+func ValidNativeRedirectURL(u url.URL) bool {
+	if u.Fragment != "" || u.User != nil {
+		return false
+	}
+	if loopbackRedirectURL(u) {
+		return true
+	}
+	return strings.Contains(u.Scheme, ".") && u.Host == "" && u.Opaque == "" && u.Path != ""
+}
+
+// loopbackRedirectURL reports whether u is an HTTP URL on a loopback address.
+func loopbackRedirectURL(u url.URL) bool {
+	if u.Scheme != "http" {
+		return false
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// withoutLoopbackPort returns u without its port if it's a loopback URL, as
+// native apps listen on whichever port the OS gives them.
+func withoutLoopbackPort(u url.URL) url.URL {
+	if loopbackRedirectURL(u) {
+		u.Host = u.Hostname()
+		if strings.Contains(u.Host, ":") {
+			u.Host = "[" + u.Host + "]"
 		}
+	}
+	return u
+}
 
-		return *u, nil
+// PairwiseSubject reports whether the client registered the pairwise subject
+// type, so that users are known to it by subjects which differ from those
+// issued to clients of other sectors.
+func (c Client) PairwiseSubject() bool {
+	return c.Metadata.SubjectType == oidc.SubjectTypePairwise
+}
+
+// SectorIdentifier returns the host of the client's sector identifier URI, or
+// of its redirect URIs if it has none (OpenID Connect Core 1.0 Section 8.1).
+func (c Client) SectorIdentifier() (string, error) {
+	if u := c.Metadata.SectorIdentifierURI; u != nil {
+		return u.Hostname(), nil
+	}
+	var host string
+	for i, u := range c.Metadata.RedirectURIs {
+		if i > 0 && u.Hostname() != host {
+			return "", ErrorMissingSectorIdentifierURI
+		}
+		host = u.Hostname()
+	}
+	if host == "" {
+		return "", ErrorMissingSectorIdentifierURI
 	}
+	return host, nil
+}
 
-	return ValidRedirectURL(u, c.Metadata.RedirectURIs)
+// GrantTypeAllowed reports whether the client may use the grant type. Clients
+// without AllowedGrantTypes may use any but the password grant, which clients
+// must be explicitly allowed to use.
+func (c Client) GrantTypeAllowed(grantType string) bool {
+	if len(c.AllowedGrantTypes) == 0 {
+		return grantType != oauth2.GrantTypeUserCreds
+	}
+	return pstrings.ContainsString(c.AllowedGrantTypes, grantType)
+}
+
+// ScopeAllowed reports whether the client may request the scope. Every
+// client may request the openid scope.
+func (c Client) ScopeAllowed(scope string) bool {
+	return scope == "openid" || len(c.AllowedScopes) == 0 || pstrings.ContainsString(c.AllowedScopes, scope)
+}
+
+// ValidPostLogoutRedirectURL returns the passed in URL if it is one of the
+// client's registered post logout redirect URLs, and returns an error
+// otherwise.
+func (c Client) ValidPostLogoutRedirectURL(u *url.URL) (url.URL, error) {
+	if u == nil {
+		return url.URL{}, ErrorInvalidRedirectURL
+	}
+	for _, pu := range c.PostLogoutRedirectURIs {
+		if reflect.DeepEqual(pu, *u) {
+			return pu, nil
+		}
+	}
+	return url.URL{}, ErrorInvalidRedirectURL
 }
 
 type ClientRepo interface {
@@ -103,6 +268,11 @@ type ClientRepo interface {
 	// GetSecret returns the (base64 encoded) hashed client secret
 	GetSecret(tx repo.Transaction, clientID string) ([]byte, error)
 
+	// GetAssertionSecret returns the unhashed secret of a client which signs
+	// the JWTs it authenticates with using its secret (client_secret_jwt),
+	// or nil for any other client.
+	GetAssertionSecret(tx repo.Transaction, clientID string) ([]byte, error)
+
 	// All returns all registered Clients
 	All(tx repo.Transaction) ([]Client, error)
 
@@ -113,11 +283,46 @@ type ClientRepo interface {
 
 	Update(tx repo.Transaction, client Client) error
 
+	// Delete removes a Client, and the list of its trusted peers, from the
+	// repo. The refresh tokens, device grants and pushed authorization
+	// requests issued to it and the consents given to it are revoked, as are
+	// the pairwise subjects of its sector if no other client shares it. It's
+	// removed from the peers of other clients and from the clients of SSO
+	// sessions.
+	Delete(tx repo.Transaction, clientID string) error
+
+	// GetRegistrationAccessToken returns the hashed token a dynamically
+	// registered client manages its registration with (RFC 7592), or nil if
+	// the client has none.
+	GetRegistrationAccessToken(tx repo.Transaction, clientID string) ([]byte, error)
+
+	// SetRegistrationAccessToken sets the hashed registration access token
+	// of the client.
+	SetRegistrationAccessToken(tx repo.Transaction, clientID string, hashed []byte) error
+
 	// GetTrustedPeers returns the list of clients authorized to mint ID token for the given client.
 	GetTrustedPeers(tx repo.Transaction, clientID string) ([]string, error)
 
 	// SetTrustedPeers sets the list of clients authorized to mint ID token for the given client.
 	SetTrustedPeers(tx repo.Transaction, clientID string, clientIDs []string) error
+
+	// GetTokenExchangePeers returns the list of clients allowed to exchange
+	// users' tokens for tokens whose audience is the given client.
+	GetTokenExchangePeers(tx repo.Transaction, clientID string) ([]string, error)
+
+	// SetTokenExchangePeers sets the list of clients allowed to exchange
+	// users' tokens for tokens whose audience is the given client.
+	SetTokenExchangePeers(tx repo.Transaction, clientID string, clientIDs []string) error
+}
+
+// AssertionRepo records the JWTs clients have authenticated with (RFC 7523
+// Section 3), and the request objects they have pushed, so that each can only
+// be used once.
+type AssertionRepo interface {
+	// Use records the ID of a JWT issued by the client until the JWT expires.
+	// It returns ErrorAssertionReused if the client has already used a JWT
+	// with the same ID.
+	Use(clientID, jti string, expiresAt time.Time) error
 }
 
 // ValidRedirectURL returns the passed in URL if it is present in the redirectURLs list, and returns an error otherwise.
@@ -147,18 +352,40 @@ func ValidRedirectURL(rURL *url.URL, redirectURLs []url.URL) (url.URL, error) {
 
 // LoadableClient contains sufficient information for creating a Client and its related entities.
 type LoadableClient struct {
-	Client       Client
-	TrustedPeers []string
+	Client             Client
+	TrustedPeers       []string
+	TokenExchangePeers []string
 }
 
 func ClientsFromReader(r io.Reader) ([]LoadableClient, error) {
 	var c []struct {
-		ID           string   `json:"id"`
-		Secret       string   `json:"secret"`
-		RedirectURLs []string `json:"redirectURLs"`
-		Admin        bool     `json:"admin"`
-		Public       bool     `json:"public"`
-		TrustedPeers []string `json:"trustedPeers"`
+		ID                     string   `json:"id"`
+		Secret                 string   `json:"secret"`
+		RedirectURLs           []string `json:"redirectURLs"`
+		PostLogoutRedirectURLs []string `json:"postLogoutRedirectURLs"`
+		BackchannelLogoutURL   string   `json:"backchannelLogoutURL"`
+		Resources              []string `json:"resources"`
+		Admin                  bool     `json:"admin"`
+		Public                 bool     `json:"public"`
+		FirstParty             bool     `json:"firstParty"`
+		TrustedPeers           []string `json:"trustedPeers"`
+		TokenExchangePeers     []string `json:"tokenExchangePeers"`
+
+		RequirePushedAuthorizationRequests bool `json:"requirePushedAuthorizationRequests"`
+
+		IDTokenSignedResponseAlg string `json:"idTokenSignedResponseAlg"`
+		TokenEndpointAuthMethod  string `json:"tokenEndpointAuthMethod"`
+		JWKSURL                  string `json:"jwksURL"`
+
+		SubjectType         string `json:"subjectType"`
+		SectorIdentifierURL string `json:"sectorIdentifierURL"`
+
+		// Lifetimes are durations parsed by time.ParseDuration, e.g. "1h".
+		IDTokenLifetime      string   `json:"idTokenLifetime"`
+		AccessTokenLifetime  string   `json:"accessTokenLifetime"`
+		RefreshTokenLifetime string   `json:"refreshTokenLifetime"`
+		AllowedGrantTypes    []string `json:"allowedGrantTypes"`
+		AllowedScopes        []string `json:"allowedScopes"`
 	}
 	if err := json.NewDecoder(r).Decode(&c); err != nil {
 		return nil, err
@@ -179,6 +406,49 @@ func ClientsFromReader(r io.Reader) ([]LoadableClient, error) {
 			}
 			redirectURIs[j] = *uri
 		}
+		var postLogoutRedirectURIs []url.URL
+		for _, u := range client.PostLogoutRedirectURLs {
+			uri, err := url.Parse(u)
+			if err != nil {
+				return nil, err
+			}
+			postLogoutRedirectURIs = append(postLogoutRedirectURIs, *uri)
+		}
+		var backchannelLogoutURI *url.URL
+		if client.BackchannelLogoutURL != "" {
+			uri, err := url.Parse(client.BackchannelLogoutURL)
+			if err != nil {
+				return nil, err
+			}
+			backchannelLogoutURI = uri
+		}
+		var jwksURI *url.URL
+		if client.JWKSURL != "" {
+			uri, err := url.Parse(client.JWKSURL)
+			if err != nil {
+				return nil, err
+			}
+			jwksURI = uri
+		}
+		var sectorIdentifierURI *url.URL
+		if client.SectorIdentifierURL != "" {
+			uri, err := url.Parse(client.SectorIdentifierURL)
+			if err != nil {
+				return nil, err
+			}
+			sectorIdentifierURI = uri
+		}
+		var lifetimes [3]time.Duration
+		for j, lifetime := range []string{client.IDTokenLifetime, client.AccessTokenLifetime, client.RefreshTokenLifetime} {
+			if lifetime == "" {
+				continue
+			}
+			d, err := time.ParseDuration(lifetime)
+			if err != nil {
+				return nil, err
+			}
+			lifetimes[j] = d
+		}
 
 		clients[i] = LoadableClient{
 			Client: Client{
@@ -188,11 +458,31 @@ func ClientsFromReader(r io.Reader) ([]LoadableClient, error) {
 				},
 				Metadata: oidc.ClientMetadata{
 					RedirectURIs: redirectURIs,
+					IDTokenResponseOptions: oidc.JWAOptions{
+						SigningAlg: client.IDTokenSignedResponseAlg,
+					},
+					TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+					JWKSURI:                 jwksURI,
+					SubjectType:             client.SubjectType,
+					SectorIdentifierURI:     sectorIdentifierURI,
 				},
-				Admin:  client.Admin,
-				Public: client.Public,
+				Admin:                  client.Admin,
+				Public:                 client.Public,
+				Resources:              client.Resources,
+				PostLogoutRedirectURIs: postLogoutRedirectURIs,
+				BackchannelLogoutURI:   backchannelLogoutURI,
+				FirstParty:             client.FirstParty,
+
+				RequirePushedAuthorizationRequests: client.RequirePushedAuthorizationRequests,
+
+				IDTokenLifetime:      lifetimes[0],
+				AccessTokenLifetime:  lifetimes[1],
+				RefreshTokenLifetime: lifetimes[2],
+				AllowedGrantTypes:    client.AllowedGrantTypes,
+				AllowedScopes:        client.AllowedScopes,
 			},
-			TrustedPeers: client.TrustedPeers,
+			TrustedPeers:       client.TrustedPeers,
+			TokenExchangePeers: client.TokenExchangePeers,
 		}
 	}
 	return clients, nil
@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coreos/go-oidc/oidc"
 	"github.com/kylelemons/godebug/pretty"
@@ -19,20 +20,28 @@ var (
   "id": "my_id",
   "secret": "` + goodSecret1 + `",
   "redirectURLs": ["https://client.example.com"],
-  "admin": true
+  "admin": true,
+  "firstParty": true
 }`
 
 	goodClient2 = `{ 
   "id": "my_other_id",
   "secret": "` + goodSecret2 + `",
-  "redirectURLs": ["https://client2.example.com","https://client2_a.example.com"]
+  "redirectURLs": ["https://client2.example.com","https://client2_a.example.com"],
+  "idTokenSignedResponseAlg": "ES256"
 }`
 
 	goodClient3 = `{ 
   "id": "yet_another_id",
   "secret": "` + goodSecret3 + `",
   "redirectURLs": ["https://client3.example.com","https://client3_a.example.com"],
-  "trustedPeers":["goodClient1", "goodClient2"]
+  "trustedPeers":["goodClient1", "goodClient2"],
+  "tokenExchangePeers":["goodClient1"],
+  "tokenEndpointAuthMethod": "private_key_jwt",
+  "jwksURL": "https://client3.example.com/keys",
+  "requirePushedAuthorizationRequests": true,
+  "subjectType": "pairwise",
+  "sectorIdentifierURL": "https://client3.example.com/sector.json"
 }`
 
 	publicClient = `{ 
@@ -42,6 +51,32 @@ var (
   "public": true
 }`
 
+	logoutClient = `{ 
+  "id": "logout_client",
+  "secret": "` + goodSecret1 + `",
+  "redirectURLs": ["https://client.example.com/callback"],
+  "postLogoutRedirectURLs": ["https://client.example.com/logged-out"],
+  "backchannelLogoutURL": "https://client.example.com/backchannel-logout"
+}`
+
+	restrictedClient = `{ 
+  "id": "restricted_client",
+  "secret": "` + goodSecret2 + `",
+  "redirectURLs": ["https://client.example.com/callback"],
+  "idTokenLifetime": "1h",
+  "accessTokenLifetime": "30m",
+  "refreshTokenLifetime": "720h",
+  "allowedGrantTypes": ["authorization_code", "refresh_token"],
+  "allowedScopes": ["openid", "email", "offline_access"]
+}`
+
+	badLifetimeClient = `{ 
+  "id": "my_id",
+  "secret": "` + goodSecret1 + `",
+  "redirectURLs": ["https://client.example.com"],
+  "idTokenLifetime": "an hour"
+}`
+
 	badURLClient = `{ 
   "id": "my_id",
   "secret": "` + goodSecret1 + `",
@@ -88,7 +123,8 @@ func TestClientsFromReader(t *testing.T) {
 								mustParseURL(t, "https://client.example.com"),
 							},
 						},
-						Admin: true,
+						Admin:      true,
+						FirstParty: true,
 					},
 				},
 			},
@@ -107,7 +143,8 @@ func TestClientsFromReader(t *testing.T) {
 								mustParseURL(t, "https://client.example.com"),
 							},
 						},
-						Admin: true,
+						Admin:      true,
+						FirstParty: true,
 					},
 				},
 				{
@@ -121,6 +158,9 @@ func TestClientsFromReader(t *testing.T) {
 								mustParseURL(t, "https://client2.example.com"),
 								mustParseURL(t, "https://client2_a.example.com"),
 							},
+							IDTokenResponseOptions: oidc.JWAOptions{
+								SigningAlg: "ES256",
+							},
 						},
 					},
 				},
@@ -140,9 +180,15 @@ func TestClientsFromReader(t *testing.T) {
 								mustParseURL(t, "https://client3.example.com"),
 								mustParseURL(t, "https://client3_a.example.com"),
 							},
+							TokenEndpointAuthMethod: "private_key_jwt",
+							JWKSURI:                 &url.URL{Scheme: "https", Host: "client3.example.com", Path: "/keys"},
+							SubjectType:             "pairwise",
+							SectorIdentifierURI:     &url.URL{Scheme: "https", Host: "client3.example.com", Path: "/sector.json"},
 						},
+						RequirePushedAuthorizationRequests: true,
 					},
-					TrustedPeers: []string{"goodClient1", "goodClient2"},
+					TrustedPeers:       []string{"goodClient1", "goodClient2"},
+					TokenExchangePeers: []string{"goodClient1"},
 				},
 			},
 		},
@@ -166,6 +212,55 @@ func TestClientsFromReader(t *testing.T) {
 				},
 			},
 		},
+		{
+			json: "[" + logoutClient + "]",
+			want: []LoadableClient{
+				{
+					Client: Client{
+						Credentials: oidc.ClientCredentials{
+							ID:     "logout_client",
+							Secret: goodSecret1,
+						},
+						Metadata: oidc.ClientMetadata{
+							RedirectURIs: []url.URL{
+								mustParseURL(t, "https://client.example.com/callback"),
+							},
+						},
+						PostLogoutRedirectURIs: []url.URL{
+							mustParseURL(t, "https://client.example.com/logged-out"),
+						},
+						BackchannelLogoutURI: &url.URL{Scheme: "https", Host: "client.example.com", Path: "/backchannel-logout"},
+					},
+				},
+			},
+		},
+		{
+			json: "[" + restrictedClient + "]",
+			want: []LoadableClient{
+				{
+					Client: Client{
+						Credentials: oidc.ClientCredentials{
+							ID:     "restricted_client",
+							Secret: goodSecret2,
+						},
+						Metadata: oidc.ClientMetadata{
+							RedirectURIs: []url.URL{
+								mustParseURL(t, "https://client.example.com/callback"),
+							},
+						},
+						IDTokenLifetime:      time.Hour,
+						AccessTokenLifetime:  30 * time.Minute,
+						RefreshTokenLifetime: 720 * time.Hour,
+						AllowedGrantTypes:    []string{"authorization_code", "refresh_token"},
+						AllowedScopes:        []string{"openid", "email", "offline_access"},
+					},
+				},
+			},
+		},
+		{
+			json:    "[" + badLifetimeClient + "]",
+			wantErr: true,
+		},
 		{
 			json:    "[" + badURLClient + "]",
 			wantErr: true,
@@ -261,15 +356,66 @@ func TestClientValidRedirectURL(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			u:       "http://localhost:8080/hey_there",
+			u:     "http://localhost:8080/hey_there",
+			cli:   makeClient(true, []string{}),
+			wantU: "http://localhost:8080/hey_there",
+		},
+		{
+			u:     "http://127.0.0.1:49152/callback",
+			cli:   makeClient(true, []string{}),
+			wantU: "http://127.0.0.1:49152/callback",
+		},
+		{
+			u:     "http://[::1]:49152/callback",
+			cli:   makeClient(true, []string{}),
+			wantU: "http://[::1]:49152/callback",
+		},
+		{
+			u:       "http://auth.google.com:8080",
 			cli:     makeClient(true, []string{}),
 			wantErr: true,
 		},
 		{
-			u:       "http://auth.google.com:8080",
+			u:       "com.example.app:/callback",
 			cli:     makeClient(true, []string{}),
 			wantErr: true,
 		},
+		// public clients' registered loopback redirect URIs match any port
+		{
+			u:     "http://127.0.0.1:51004/callback",
+			cli:   makeClient(true, []string{"http://127.0.0.1/callback"}),
+			wantU: "http://127.0.0.1:51004/callback",
+		},
+		{
+			u:     "http://[::1]:51004/callback",
+			cli:   makeClient(true, []string{"http://[::1]:8080/callback"}),
+			wantU: "http://[::1]:51004/callback",
+		},
+		{
+			u:       "http://127.0.0.1:51004/other",
+			cli:     makeClient(true, []string{"http://127.0.0.1/callback"}),
+			wantErr: true,
+		},
+		{
+			u:       "http://localhost:51004/callback",
+			cli:     makeClient(true, []string{"http://127.0.0.1/callback"}),
+			wantErr: true,
+		},
+		{
+			u:     "com.example.app:/callback",
+			cli:   makeClient(true, []string{"com.example.app:/callback"}),
+			wantU: "com.example.app:/callback",
+		},
+		{
+			u:       "com.example.evil:/callback",
+			cli:     makeClient(true, []string{"com.example.app:/callback"}),
+			wantErr: true,
+		},
+		{
+			u:     OOBRedirectURI,
+			cli:   makeClient(true, []string{"com.example.app:/callback"}),
+			wantU: OOBRedirectURI,
+		},
 	}
 
 	for i, tt := range tests {
@@ -306,3 +452,89 @@ func mustParseURL(t *testing.T, s string) url.URL {
 	}
 	return *u
 }
+
+func TestValidNativeRedirectURL(t *testing.T) {
+	tests := []struct {
+		u    string
+		want bool
+	}{
+		{u: "http://localhost:8080", want: true},
+		{u: "http://127.0.0.1/callback", want: true},
+		{u: "http://[::1]:8080/callback?x=y", want: true},
+		{u: "com.example.app:/callback", want: true},
+		{u: "https://127.0.0.1/callback", want: false},
+		{u: "http://127.0.0.2/callback", want: false},
+		{u: "http://client.example.com/callback", want: false},
+		{u: "http://127.0.0.1/callback#fragment", want: false},
+		{u: "http://user@127.0.0.1/callback", want: false},
+		{u: "myapp:/callback", want: false},
+		{u: "com.example.app://callback", want: false},
+		{u: "com.example.app:callback", want: false},
+	}
+
+	for i, tt := range tests {
+		if got := ValidNativeRedirectURL(mustParseURL(t, tt.u)); got != tt.want {
+			t.Errorf("case %d: ValidNativeRedirectURL(%q): want=%t got=%t", i, tt.u, tt.want, got)
+		}
+	}
+}
+
+func TestClientValidPostLogoutRedirectURL(t *testing.T) {
+	cli := Client{
+		Metadata: oidc.ClientMetadata{
+			RedirectURIs: []url.URL{mustParseURL(t, "https://client.example.com/callback")},
+		},
+		PostLogoutRedirectURIs: []url.URL{
+			mustParseURL(t, "https://client.example.com/logged-out"),
+			mustParseURL(t, "https://client.example.com/bye"),
+		},
+	}
+
+	tests := []struct {
+		u       string
+		wantErr bool
+	}{
+		{
+			u: "https://client.example.com/logged-out",
+		},
+		{
+			u: "https://client.example.com/bye",
+		},
+		{
+			u:       "",
+			wantErr: true,
+		},
+		{
+			// Redirect URLs aren't valid post logout redirect URLs.
+			u:       "https://client.example.com/callback",
+			wantErr: true,
+		},
+		{
+			u:       "https://client.example.com/logged-out?foo=bar",
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		var testURL *url.URL
+		if tt.u != "" {
+			u := mustParseURL(t, tt.u)
+			testURL = &u
+		}
+
+		u, err := cli.ValidPostLogoutRedirectURL(testURL)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("case %d: want non-nil error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if diff := pretty.Compare(mustParseURL(t, tt.u), u); diff != "" {
+			t.Errorf("case %d: Compare(want, got): %v", i, diff)
+		}
+	}
+}
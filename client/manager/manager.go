@@ -9,6 +9,7 @@ import (
 	"github.com/coreos/dex/client"
 	pcrypto "github.com/coreos/dex/pkg/crypto"
 	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
 	"github.com/coreos/dex/repo"
 	"github.com/coreos/go-oidc/oidc"
 	"golang.org/x/crypto/bcrypt"
@@ -28,6 +29,10 @@ var (
 
 type ClientOptions struct {
 	TrustedPeers []string
+
+	// TokenExchangePeers are the clients allowed to exchange users' tokens
+	// for tokens whose audience is the new client.
+	TokenExchangePeers []string
 }
 
 type SecretGenerator func() ([]byte, error)
@@ -75,7 +80,7 @@ func NewClientManager(clientRepo client.ClientRepo, txnFactory repo.TransactionF
 // New creates and persists a new client with the given options, returning the generated credentials.
 // Any Credenials provided with the client are ignored and overwritten by the generated  ID and Secret.
 // "Normal" (i.e. non-Public) clients must have at least one valid RedirectURI in their Metadata.
-// Public clients must not have any RedirectURIs and must have a client name.
+// Public clients may only have native app RedirectURIs and must have a client name.
 func (m *ClientManager) New(cli client.Client, options *ClientOptions) (*oidc.ClientCredentials, error) {
 	tx, err := m.begin()
 	if err != nil {
@@ -106,6 +111,13 @@ func (m *ClientManager) New(cli client.Client, options *ClientOptions) (*oidc.Cl
 		}
 	}
 
+	if options != nil && len(options.TokenExchangePeers) > 0 {
+		err = m.clientRepo.SetTokenExchangePeers(tx, creds.ID, options.TokenExchangePeers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
@@ -188,6 +200,120 @@ func (m *ClientManager) Authenticate(creds oidc.ClientCredentials) (bool, error)
 	return ok, nil
 }
 
+// AssertionSecret returns the secret a client_secret_jwt client signs the
+// JWTs it authenticates with using.
+func (m *ClientManager) AssertionSecret(clientID string) ([]byte, error) {
+	secret, err := m.clientRepo.GetAssertionSecret(nil, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("client has no assertion secret")
+	}
+	return secret, nil
+}
+
+// Update validates and saves the client. Its secret, registration access token
+// and trusted peers are left unchanged.
+func (m *ClientManager) Update(cli client.Client) error {
+	if err := validateClient(cli); err != nil {
+		return err
+	}
+
+	tx, err := m.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cli.Credentials.Secret = ""
+	if err := m.clientRepo.Update(tx, cli); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RotateSecret generates a new secret for the client, returning its new
+// credentials. The client's previous secret is no longer accepted.
+func (m *ClientManager) RotateSecret(clientID string) (*oidc.ClientCredentials, error) {
+	tx, err := m.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cli, err := m.clientRepo.Get(tx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := m.secretGenerator()
+	if err != nil {
+		return nil, err
+	}
+	cli.Credentials.Secret = base64.URLEncoding.EncodeToString(secret)
+	if err := m.clientRepo.Update(tx, cli); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &cli.Credentials, nil
+}
+
+// Delete removes the client. Its credentials, and those of the tokens issued
+// to it, are no longer accepted.
+func (m *ClientManager) Delete(clientID string) error {
+	tx, err := m.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.clientRepo.Delete(tx, clientID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// NewRegistrationAccessToken generates the token the client manages its
+// registration with (RFC 7592 Section 3), replacing any it had before.
+func (m *ClientManager) NewRegistrationAccessToken(clientID string) (string, error) {
+	token, err := m.secretGenerator()
+	if err != nil {
+		return "", err
+	}
+	hashed, err := bcrypt.GenerateFromPassword(token, bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	if err := m.clientRepo.SetRegistrationAccessToken(nil, clientID, hashed); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(token), nil
+}
+
+// AuthenticateRegistrationAccessToken reports whether the token is the
+// client's registration access token. Clients which weren't registered
+// dynamically have none.
+func (m *ClientManager) AuthenticateRegistrationAccessToken(clientID, token string) (bool, error) {
+	hashed, err := m.clientRepo.GetRegistrationAccessToken(nil, clientID)
+	if err != nil {
+		if err == client.ErrorNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if hashed == nil {
+		return false, nil
+	}
+
+	dec, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return false, nil
+	}
+	return CompareHashAndPassword(hashed, dec) == nil, nil
+}
+
 func (m *ClientManager) addClientCredentials(cli *client.Client) error {
 	var seed string
 	if cli.Public {
@@ -231,12 +357,16 @@ func validateClient(cli client.Client) error {
 	// NOTE: please be careful changing the errors returned here; they are used
 	// downstream (eg. in the admin API) to determine the http errors returned.
 	if cli.Public {
-		if len(cli.Metadata.RedirectURIs) > 0 {
-			return client.ErrorPublicClientRedirectURIs
+		for _, ru := range cli.Metadata.RedirectURIs {
+			if ru.String() != client.OOBRedirectURI && !client.ValidNativeRedirectURL(ru) {
+				return client.ErrorPublicClientRedirectURIs
+			}
 		}
 		if cli.Metadata.ClientName == "" {
 			return client.ErrorPublicClientMissingName
 		}
+		// Metadata.Valid() only accepts HTTP(S) redirect URIs, so native
+		// ones are checked above.
 		cli.Metadata.RedirectURIs = []url.URL{
 			localHostRedirectURL,
 		}
@@ -250,6 +380,30 @@ func validateClient(cli client.Client) error {
 	if err != nil {
 		return client.ValidationError{Err: err}
 	}
+
+	switch cli.Metadata.SubjectType {
+	case "", oidc.SubjectTypePublic:
+	case oidc.SubjectTypePairwise:
+		// Public clients' redirect URIs are on loopback addresses or have
+		// private-use schemes, so don't identify them.
+		if cli.Public && cli.Metadata.SectorIdentifierURI == nil {
+			return client.ValidationError{Err: client.ErrorMissingSectorIdentifierURI}
+		}
+		if _, err := cli.SectorIdentifier(); err != nil {
+			return client.ValidationError{Err: err}
+		}
+	default:
+		return client.ValidationError{Err: client.ErrorInvalidSubjectType}
+	}
+
+	if cli.IDTokenLifetime < 0 || cli.AccessTokenLifetime < 0 || cli.RefreshTokenLifetime < 0 {
+		return client.ValidationError{Err: client.ErrorInvalidLifetime}
+	}
+	for _, grantType := range cli.AllowedGrantTypes {
+		if !pstrings.ContainsString(client.GrantTypes, grantType) {
+			return client.ValidationError{Err: client.ErrorInvalidGrantType}
+		}
+	}
 	return nil
 }
 
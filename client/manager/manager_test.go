@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/coreos/dex/client"
 	"github.com/coreos/dex/db"
@@ -169,6 +170,114 @@ func TestAuthenticate(t *testing.T) {
 	}
 }
 
+func TestRegistrationAccessToken(t *testing.T) {
+	f := makeTestFixtures()
+
+	// Clients which weren't registered dynamically have no token.
+	if ok, err := f.mgr.AuthenticateRegistrationAccessToken("client.example.com", goodSecret); err != nil || ok {
+		t.Fatalf("want no registration access token, got %t, %v", ok, err)
+	}
+
+	token, err := f.mgr.NewRegistrationAccessToken("client.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		clientID string
+		token    string
+		want     bool
+	}{
+		{"client.example.com", token, true},
+		{"client.example.com", token + "fluff", false},
+		{"client.example.com", "bar", false},
+		{"foo", token, false},
+	}
+	for i, tt := range tests {
+		ok, err := f.mgr.AuthenticateRegistrationAccessToken(tt.clientID, tt.token)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		} else if ok != tt.want {
+			t.Errorf("case %d: want %t, got %t", i, tt.want, ok)
+		}
+	}
+
+	// Updating the client keeps its token.
+	cli, err := f.mgr.Get("client.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cli.Metadata.ClientName = "Example"
+	if err := f.mgr.Update(cli); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, err := f.mgr.AuthenticateRegistrationAccessToken("client.example.com", token); err != nil || !ok {
+		t.Errorf("want registration access token kept, got %t, %v", ok, err)
+	}
+	if ok, err := f.mgr.Authenticate(oidc.ClientCredentials{ID: "client.example.com", Secret: goodSecret}); err != nil || !ok {
+		t.Errorf("want secret kept, got %t, %v", ok, err)
+	}
+}
+
+func TestRotateSecretAndDelete(t *testing.T) {
+	f := makeTestFixtures()
+	f.mgr.secretGenerator = func() ([]byte, error) {
+		return []byte("rotated"), nil
+	}
+
+	creds, err := f.mgr.RotateSecret("client.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, _ := f.mgr.Authenticate(*creds); !ok {
+		t.Errorf("want rotated secret accepted")
+	}
+	if ok, _ := f.mgr.Authenticate(oidc.ClientCredentials{ID: "client.example.com", Secret: goodSecret}); ok {
+		t.Errorf("want previous secret rejected")
+	}
+
+	if err := f.mgr.Delete("client.example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := f.mgr.Get("client.example.com"); err != client.ErrorNotFound {
+		t.Errorf("want %v, got %v", client.ErrorNotFound, err)
+	}
+	if err := f.mgr.Delete("client.example.com"); err != client.ErrorNotFound {
+		t.Errorf("want %v, got %v", client.ErrorNotFound, err)
+	}
+}
+
+func TestNewPublicClientRedirectURIs(t *testing.T) {
+	f := makeTestFixtures()
+	redirectURIs := []url.URL{
+		mustParseURL("http://127.0.0.1/callback"),
+		mustParseURL("com.example.app:/callback"),
+	}
+	creds, err := f.mgr.New(client.Client{
+		Metadata: oidc.ClientMetadata{
+			RedirectURIs: redirectURIs,
+			ClientName:   "app",
+		},
+		Public: true,
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cli, err := f.mgr.Get(creds.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cli.Metadata.RedirectURIs) != len(redirectURIs) {
+		t.Fatalf("want %d redirect URIs, got %v", len(redirectURIs), cli.Metadata.RedirectURIs)
+	}
+	for i, u := range redirectURIs {
+		if got := cli.Metadata.RedirectURIs[i]; got.String() != u.String() {
+			t.Errorf("redirect URI %d: want=%q got=%q", i, u.String(), got.String())
+		}
+	}
+}
+
 func TestValidateClient(t *testing.T) {
 	tests := []struct {
 		cli     client.Client
@@ -203,12 +312,117 @@ func TestValidateClient(t *testing.T) {
 			},
 			wantErr: client.ErrorPublicClientRedirectURIs,
 		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{
+						mustParseURL("http://127.0.0.1/callback"),
+						mustParseURL("http://[::1]/callback"),
+						mustParseURL("com.example.app:/callback"),
+					},
+					ClientName: "frank",
+				},
+				Public: true,
+			},
+		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{mustParseURL("myapp:/callback")},
+					ClientName:   "frank",
+				},
+				Public: true,
+			},
+			wantErr: client.ErrorPublicClientRedirectURIs,
+		},
 		{
 			cli: client.Client{
 				Public: true,
 			},
 			wantErr: client.ErrorPublicClientMissingName,
 		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{
+						mustParseURL("https://app.example.com/callback"),
+						mustParseURL("https://app.example.com/other"),
+					},
+					SubjectType: "pairwise",
+				},
+			},
+		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{
+						mustParseURL("https://app.example.com/callback"),
+						mustParseURL("https://other.example.com/callback"),
+					},
+					SubjectType: "pairwise",
+				},
+			},
+			wantErr: client.ValidationError{Err: client.ErrorMissingSectorIdentifierURI},
+		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{
+						mustParseURL("https://app.example.com/callback"),
+						mustParseURL("https://other.example.com/callback"),
+					},
+					SubjectType:         "pairwise",
+					SectorIdentifierURI: &url.URL{Scheme: "https", Host: "example.com", Path: "/sector.json"},
+				},
+			},
+		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					ClientName:  "frank",
+					SubjectType: "pairwise",
+				},
+				Public: true,
+			},
+			wantErr: client.ValidationError{Err: client.ErrorMissingSectorIdentifierURI},
+		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{mustParseURL("https://app.example.com/callback")},
+					SubjectType:  "anonymous",
+				},
+			},
+			wantErr: client.ValidationError{Err: client.ErrorInvalidSubjectType},
+		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{mustParseURL("https://app.example.com/callback")},
+				},
+				IDTokenLifetime:   time.Hour,
+				AllowedGrantTypes: []string{"authorization_code", "refresh_token"},
+				AllowedScopes:     []string{"openid", "email"},
+			},
+		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{mustParseURL("https://app.example.com/callback")},
+				},
+				RefreshTokenLifetime: -time.Hour,
+			},
+			wantErr: client.ValidationError{Err: client.ErrorInvalidLifetime},
+		},
+		{
+			cli: client.Client{
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{mustParseURL("https://app.example.com/callback")},
+				},
+				AllowedGrantTypes: []string{"magic"},
+			},
+			wantErr: client.ValidationError{Err: client.ErrorInvalidGrantType},
+		},
 	}
 
 	for i, tt := range tests {
@@ -218,3 +432,30 @@ func TestValidateClient(t *testing.T) {
 		}
 	}
 }
+
+func TestNewTokenExchangePeers(t *testing.T) {
+	f := makeTestFixtures()
+	creds, err := f.mgr.New(client.Client{
+		Metadata: oidc.ClientMetadata{
+			RedirectURIs: []url.URL{mustParseURL("https://api.example.com/callback")},
+		},
+	}, &ClientOptions{TokenExchangePeers: []string{"client.example.com"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	peers, err := f.clientRepo.GetTokenExchangePeers(nil, creds.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(peers) != 1 || peers[0] != "client.example.com" {
+		t.Errorf("want token exchange peers [client.example.com], got %v", peers)
+	}
+
+	if err := f.mgr.Delete(creds.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peers, err := f.clientRepo.GetTokenExchangePeers(nil, creds.ID); err != nil || len(peers) != 0 {
+		t.Errorf("want no token exchange peers after delete, got %v, %v", peers, err)
+	}
+}
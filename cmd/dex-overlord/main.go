@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/coreos/go-oidc/key"
+	"github.com/coreos/pkg/flagutil"
 	"github.com/go-gorp/gorp"
 
 	"github.com/coreos/dex/admin"
@@ -21,6 +22,7 @@ import (
 	"github.com/coreos/dex/pkg/log"
 	ptime "github.com/coreos/dex/pkg/time"
 	"github.com/coreos/dex/server"
+	"github.com/coreos/dex/signing"
 	"github.com/coreos/dex/user/manager"
 )
 
@@ -43,6 +45,8 @@ func main() {
 	dbMigrate := fs.Bool("db-migrate", true, "perform database migrations when starting up overlord. This includes the initial DB objects creation.")
 
 	keyPeriod := fs.Duration("key-period", 24*time.Hour, "length of time for-which a given key will be valid")
+	signingAlgs := flagutil.StringSliceFlag{signing.RS256}
+	fs.Var(&signingAlgs, "signing-algs", "comma separated list of algorithms signing keys are generated for. ID tokens are signed with the first unless a client registered another. Must include RS256.")
 	gcInterval := fs.Duration("gc-interval", time.Hour, "length of time between garbage collection runs")
 
 	adminListen := fs.String("admin-listen", "http://127.0.0.1:5557", "scheme, host and port for listening for administrative operation requests ")
@@ -87,6 +91,10 @@ func main() {
 		log.Fatalf("Must specify at least one key secret")
 	}
 
+	if err := signing.ValidAlgorithms(signingAlgs); err != nil {
+		log.Fatalf("Unable to use --signing-algs flag: %v", err)
+	}
+
 	dbCfg := db.Config{
 		DSN:                *dbURL,
 		MaxIdleConnections: 1,
@@ -117,7 +125,7 @@ func main() {
 	userRepo := db.NewUserRepo(dbc)
 	pwiRepo := db.NewPasswordInfoRepo(dbc)
 	connCfgRepo := db.NewConnectorConfigRepo(dbc)
-	clientRepo := db.NewClientRepo(dbc)
+	clientRepo := db.NewClientRepo(dbc, keySecrets.BytesSlice()...)
 	userManager := manager.NewUserManager(userRepo,
 		pwiRepo, connCfgRepo, db.TransactionFactory(dbc), manager.ManagerOptions{})
 	clientManager := clientmanager.NewClientManager(clientRepo, db.TransactionFactory(dbc), clientmanager.ManagerOptions{})
@@ -150,7 +158,7 @@ func main() {
 		time.Sleep(sleep)
 	}
 
-	krot := key.NewPrivateKeyRotator(kRepo, *keyPeriod)
+	krot := signing.NewPrivateKeyRotator(kRepo, *keyPeriod, signingAlgs)
 	s := server.NewAdminServer(adminAPI, krot, adminAPISecret.String())
 	h := s.HTTPHandler()
 	httpsrv := &http.Server{
@@ -20,6 +20,8 @@ import (
 	"github.com/coreos/dex/pkg/log"
 	ptime "github.com/coreos/dex/pkg/time"
 	"github.com/coreos/dex/server"
+	"github.com/coreos/dex/session"
+	"github.com/coreos/dex/signing"
 )
 
 var version = "DEV"
@@ -51,6 +53,21 @@ func main() {
 
 	enableClientRegistration := fs.Bool("enable-client-registration", false, "Allow dynamic registration of clients")
 
+	accessTokenValidity := fs.Duration("access-token-validity", server.DefaultAccessTokenValidityWindow, "Lifetime of the access tokens issued by the token endpoint.")
+
+	ssoSessionValidity := fs.Duration("sso-session-validity", session.DefaultSSOSessionValidityWindow, "How long users stay logged in to dex, allowing other clients to authenticate them without going through a connector again.")
+
+	refreshTokenAbsoluteLifetime := fs.Duration("refresh-token-absolute-lifetime", 0, "How long after users authorize a client its refresh tokens can be used, however often they're renewed. Unlimited if zero.")
+
+	refreshTokenIdleLifetime := fs.Duration("refresh-token-idle-lifetime", 0, "How long a refresh token can be used before it must be renewed. Unlimited if zero.")
+
+	passwordGrantConnectorID := fs.String("password-grant-connector-id", "", "ID of the connector used by password grant requests which don't specify a connector_id. Only the local and LDAP connectors support the password grant.")
+
+	pairwiseSubjectSalt := fs.String("pairwise-subject-salt", "", "A secret hashed into the subjects of users of clients registered with the pairwise subject type. Changing it changes those subjects. Without it, pairwise clients are refused.")
+
+	var allowedOrigins flagutil.StringSliceFlag
+	fs.Var(&allowedOrigins, "allowed-origins", "comma separated list of origins, such as https://app.example.com, whose scripts may call the discovery, keys, token and userinfo endpoints, besides the origins of clients' redirect URIs. \"*\" allows every origin.")
+
 	// Client credentials administration
 	apiUseClientCredentials := fs.Bool("api-use-client-credentials", false, "Forces API to authenticate using client credentials instead of ID token. Clients must be 'admin clients' to use the API.")
 
@@ -76,6 +93,8 @@ func main() {
 	connectors := fs.String("connectors", "./static/fixtures/connectors.json.sample", "JSON file containg set of IDPC configs")
 	clients := fs.String("clients", "./static/fixtures/clients.json.sample", "json file containing set of clients")
 	users := fs.String("users", "./static/fixtures/users.json.sample", "json file containing set of users")
+	signingAlgs := flagutil.StringSliceFlag{signing.RS256}
+	fs.Var(&signingAlgs, "signing-algs", "comma separated list of algorithms ID tokens may be signed with, the first of which is used unless a client registered another. Must include RS256.")
 
 	logDebug := fs.Bool("log-debug", false, "log debug-level information")
 	logTimestamps := fs.Bool("log-timestamps", false, "prefix log lines with timestamps")
@@ -151,6 +170,13 @@ func main() {
 		EnableClientRegistration:     *enableClientRegistration,
 		EnableClientCredentialAccess: *apiUseClientCredentials,
 		RegisterOnFirstLogin:         *registerOnFirstLogin,
+		AccessTokenValidityWindow:    *accessTokenValidity,
+		SSOSessionValidityWindow:     *ssoSessionValidity,
+		RefreshTokenAbsoluteLifetime: *refreshTokenAbsoluteLifetime,
+		RefreshTokenIdleLifetime:     *refreshTokenIdleLifetime,
+		PasswordGrantConnectorID:     *passwordGrantConnectorID,
+		PairwiseSubjectSalt:          *pairwiseSubjectSalt,
+		AllowedOrigins:               allowedOrigins,
 	}
 
 	if *noDB {
@@ -159,6 +185,7 @@ func main() {
 			ClientsFile:    *clients,
 			ConnectorsFile: *connectors,
 			UsersFile:      *users,
+			SigningAlgs:    signingAlgs,
 		}
 	} else {
 		if len(keySecrets.BytesSlice()) == 0 {
@@ -1,6 +1,7 @@
 package connector
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -76,6 +77,14 @@ func (c *LocalConnector) SetLocalIdentityProvider(idp *LocalIdentityProvider) {
 	c.idp = idp
 }
 
+// Identity checks the user's credentials against the local identity provider.
+func (c *LocalConnector) Identity(email, password string) (*oidc.Identity, error) {
+	if c.idp == nil {
+		return nil, errors.New("local identity provider not configured")
+	}
+	return c.idp.Identity(email, password)
+}
+
 func (c *LocalConnector) LoginURL(sessionKey, prompt string) (string, error) {
 	q := url.Values{}
 	q.Set("session_key", sessionKey)
@@ -66,6 +66,12 @@ type GroupsConnector interface {
 	Groups(fullUserID string) ([]string, error)
 }
 
+// PasswordConnector is a connector which can identify a user directly from a username and
+// password, rather than through a login page. This is optionally implemented by some connectors.
+type PasswordConnector interface {
+	Identity(username, password string) (*oidc.Identity, error)
+}
+
 type ConnectorConfigRepo interface {
 	All() ([]ConnectorConfig, error)
 	GetConnectorByID(repo.Transaction, string) (ConnectorConfig, error)
@@ -0,0 +1,37 @@
+// Package consent implements storage for end-users' approval of the clients
+// they log in to.
+package consent
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrorNotFound = errors.New("consent not found")
+
+// Consent records that a user allowed a client access to the scopes. The user
+// isn't asked again while the client requests no other scopes.
+type Consent struct {
+	UserID   string
+	ClientID string
+	Scope    []string
+
+	// UpdatedAt is when the user last approved the client.
+	UpdatedAt time.Time
+}
+
+type ConsentRepo interface {
+	// Get returns ErrorNotFound if the user never approved the client.
+	Get(userID, clientID string) (*Consent, error)
+
+	// GetByUser returns all of the user's consents.
+	GetByUser(userID string) ([]Consent, error)
+
+	// Set stores the consent, replacing any earlier consent of the user to
+	// the client.
+	Set(Consent) error
+
+	// Delete revokes the user's consent to the client. ErrorNotFound is
+	// returned if there is none.
+	Delete(userID, clientID string) error
+}
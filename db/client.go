@@ -7,18 +7,23 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"strings"
+	"time"
 
+	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
 	"github.com/go-gorp/gorp"
 
 	"github.com/coreos/dex/client"
+	pcrypto "github.com/coreos/dex/pkg/crypto"
 	"github.com/coreos/dex/pkg/log"
 	"github.com/coreos/dex/repo"
 )
 
 const (
-	clientTableName      = "client_identity"
-	trustedPeerTableName = "trusted_peers"
+	clientTableName            = "client_identity"
+	trustedPeerTableName       = "trusted_peers"
+	tokenExchangePeerTableName = "token_exchange_peers"
 
 	// postgres error codes
 	pgErrorCodeUniqueViolation = "23505" // unique_violation
@@ -26,6 +31,8 @@ const (
 
 var (
 	localHostRedirectURL = mustParseURL("http://localhost:0")
+
+	errorNoAssertionSecretKey = errors.New("a key secret is required to store the secrets of client_secret_jwt clients")
 )
 
 func init() {
@@ -42,6 +49,13 @@ func init() {
 		autoinc: false,
 		pkey:    []string{"client_id", "trusted_client_id"},
 	})
+
+	register(table{
+		name:    tokenExchangePeerTableName,
+		model:   tokenExchangePeerModel{},
+		autoinc: false,
+		pkey:    []string{"client_id", "peer_client_id"},
+	})
 }
 
 func newClientModel(cli client.Client) (*clientModel, error) {
@@ -50,10 +64,15 @@ func newClientModel(cli client.Client) (*clientModel, error) {
 		return nil, err
 	}
 
+	var nativeRedirectURIs []string
 	if cli.Public {
 		// Metadata.Valid(), and therefore json.Unmarshal(metadata) complains
-		// when there's no RedirectURIs, so we set them to a fixed value here,
-		// and remove it when translating back to a client.Client
+		// when there's no RedirectURIs or they aren't HTTP(S) URLs, so we set
+		// them to a fixed value here, store the client's own separately, and
+		// restore them when translating back to a client.Client
+		for _, u := range cli.Metadata.RedirectURIs {
+			nativeRedirectURIs = append(nativeRedirectURIs, u.String())
+		}
 		cli.Metadata.RedirectURIs = []url.URL{
 			localHostRedirectURL,
 		}
@@ -65,11 +84,37 @@ func newClientModel(cli client.Client) (*clientModel, error) {
 	}
 
 	cim := clientModel{
-		ID:       cli.Credentials.ID,
-		Secret:   hashed,
-		Metadata: string(bmeta),
-		DexAdmin: cli.Admin,
-		Public:   cli.Public,
+		ID:         cli.Credentials.ID,
+		Secret:     hashed,
+		Metadata:   string(bmeta),
+		DexAdmin:   cli.Admin,
+		Public:     cli.Public,
+		Resources:  strings.Join(cli.Resources, " "),
+		FirstParty: cli.FirstParty,
+
+		RequirePushedAuthRequests: cli.RequirePushedAuthorizationRequests,
+
+		IDTokenLifetime:      int64(cli.IDTokenLifetime / time.Second),
+		AccessTokenLifetime:  int64(cli.AccessTokenLifetime / time.Second),
+		RefreshTokenLifetime: int64(cli.RefreshTokenLifetime / time.Second),
+		AllowedGrantTypes:    strings.Join(cli.AllowedGrantTypes, " "),
+		AllowedScopes:        strings.Join(cli.AllowedScopes, " "),
+
+		NativeRedirectURIs: strings.Join(nativeRedirectURIs, " "),
+	}
+	postLogoutRedirectURIs := make([]string, len(cli.PostLogoutRedirectURIs))
+	for i, u := range cli.PostLogoutRedirectURIs {
+		postLogoutRedirectURIs[i] = u.String()
+	}
+	cim.PostLogoutRedirectURIs = strings.Join(postLogoutRedirectURIs, " ")
+	if cli.BackchannelLogoutURI != nil {
+		cim.BackchannelLogoutURI = cli.BackchannelLogoutURI.String()
+	}
+	// The JWTs a client_secret_jwt client authenticates with are signed using
+	// its secret, so it can't only be stored hashed. It is encrypted by the
+	// repo before being stored.
+	if cli.Metadata.TokenEndpointAuthMethod == oauth2.AuthMethodClientSecretJWT {
+		cim.AssertionSecret = []byte(cli.Credentials.Secret)
 	}
 
 	return &cim, nil
@@ -81,6 +126,37 @@ type clientModel struct {
 	Metadata string `db:"metadata"`
 	DexAdmin bool   `db:"dex_admin"`
 	Public   bool   `db:"public"`
+
+	// Resources is a space separated list of resource URIs.
+	Resources string `db:"resources"`
+
+	// PostLogoutRedirectURIs is a space separated list of URLs.
+	PostLogoutRedirectURIs string `db:"post_logout_redirect_uris"`
+
+	BackchannelLogoutURI string `db:"backchannel_logout_uri"`
+
+	FirstParty bool `db:"first_party"`
+
+	AssertionSecret []byte `db:"assertion_secret"`
+
+	RequirePushedAuthRequests bool `db:"require_pushed_auth_requests"`
+
+	// RegistrationAccessToken is the hashed token the client's registration
+	// is managed with, if it was registered dynamically.
+	RegistrationAccessToken []byte `db:"registration_access_token"`
+
+	// The lifetimes of the tokens issued to the client, in seconds.
+	IDTokenLifetime      int64 `db:"id_token_lifetime"`
+	AccessTokenLifetime  int64 `db:"access_token_lifetime"`
+	RefreshTokenLifetime int64 `db:"refresh_token_lifetime"`
+
+	// AllowedGrantTypes and AllowedScopes are space separated lists.
+	AllowedGrantTypes string `db:"allowed_grant_types"`
+	AllowedScopes     string `db:"allowed_scopes"`
+
+	// NativeRedirectURIs is a space separated list of a public client's
+	// redirect URIs, which aren't stored in its metadata.
+	NativeRedirectURIs string `db:"native_redirect_uris"`
 }
 
 type trustedPeerModel struct {
@@ -88,13 +164,48 @@ type trustedPeerModel struct {
 	TrustedClientID string `db:"trusted_client_id"`
 }
 
+type tokenExchangePeerModel struct {
+	ClientID     string `db:"client_id"`
+	PeerClientID string `db:"peer_client_id"`
+}
+
 func (m *clientModel) Client() (*client.Client, error) {
 	ci := client.Client{
 		Credentials: oidc.ClientCredentials{
 			ID: m.ID,
 		},
-		Admin:  m.DexAdmin,
-		Public: m.Public,
+		Admin:      m.DexAdmin,
+		Public:     m.Public,
+		FirstParty: m.FirstParty,
+
+		RequirePushedAuthorizationRequests: m.RequirePushedAuthRequests,
+
+		IDTokenLifetime:      time.Duration(m.IDTokenLifetime) * time.Second,
+		AccessTokenLifetime:  time.Duration(m.AccessTokenLifetime) * time.Second,
+		RefreshTokenLifetime: time.Duration(m.RefreshTokenLifetime) * time.Second,
+	}
+	if m.Resources != "" {
+		ci.Resources = strings.Fields(m.Resources)
+	}
+	if m.AllowedGrantTypes != "" {
+		ci.AllowedGrantTypes = strings.Fields(m.AllowedGrantTypes)
+	}
+	if m.AllowedScopes != "" {
+		ci.AllowedScopes = strings.Fields(m.AllowedScopes)
+	}
+	for _, s := range strings.Fields(m.PostLogoutRedirectURIs) {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		ci.PostLogoutRedirectURIs = append(ci.PostLogoutRedirectURIs, *u)
+	}
+	if m.BackchannelLogoutURI != "" {
+		u, err := url.Parse(m.BackchannelLogoutURI)
+		if err != nil {
+			return nil, err
+		}
+		ci.BackchannelLogoutURI = u
 	}
 
 	if err := json.Unmarshal([]byte(m.Metadata), &ci.Metadata); err != nil {
@@ -103,23 +214,53 @@ func (m *clientModel) Client() (*client.Client, error) {
 
 	if ci.Public {
 		ci.Metadata.RedirectURIs = []url.URL{}
+		for _, s := range strings.Fields(m.NativeRedirectURIs) {
+			u, err := url.Parse(s)
+			if err != nil {
+				return nil, err
+			}
+			ci.Metadata.RedirectURIs = append(ci.Metadata.RedirectURIs, *u)
+		}
 	}
 
 	return &ci, nil
 }
 
-func NewClientRepo(dbm *gorp.DbMap) client.ClientRepo {
-	return newClientRepo(dbm)
+// NewClientRepo returns a client repo which encrypts the secrets of
+// client_secret_jwt clients with the first of the given key secrets, and
+// decrypts them with any of them. Such clients can't be stored without a key
+// secret.
+func NewClientRepo(dbm *gorp.DbMap, secrets ...[]byte) client.ClientRepo {
+	return newClientRepo(dbm, secrets...)
 }
 
-func newClientRepo(dbm *gorp.DbMap) *clientRepo {
+func newClientRepo(dbm *gorp.DbMap, secrets ...[]byte) *clientRepo {
 	return &clientRepo{
-		db: &db{dbm},
+		db:      &db{dbm},
+		secrets: secrets,
 	}
 }
 
 type clientRepo struct {
 	*db
+	secrets [][]byte
+}
+
+// encryptAssertionSecret encrypts the secret of a client_secret_jwt client
+// before it's stored.
+func (r *clientRepo) encryptAssertionSecret(cm *clientModel) error {
+	if len(cm.AssertionSecret) == 0 {
+		return nil
+	}
+	if len(r.secrets) == 0 {
+		return errorNoAssertionSecretKey
+	}
+	v, err := pcrypto.Encrypt(cm.AssertionSecret, r.secrets[0])
+	if err != nil {
+		return err
+	}
+	cm.AssertionSecret = v
+	return nil
 }
 
 func (r *clientRepo) Get(tx repo.Transaction, clientID string) (client.Client, error) {
@@ -153,6 +294,40 @@ func (r *clientRepo) GetSecret(tx repo.Transaction, clientID string) ([]byte, er
 	return m.Secret, nil
 }
 
+func (r *clientRepo) GetAssertionSecret(tx repo.Transaction, clientID string) ([]byte, error) {
+	m, err := r.getModel(tx, clientID)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	if len(m.AssertionSecret) == 0 {
+		return nil, nil
+	}
+	for _, secret := range r.secrets {
+		if v, err := pcrypto.Decrypt(m.AssertionSecret, secret); err == nil {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("unable to decrypt the secret of client %s", clientID)
+}
+
+func (r *clientRepo) GetRegistrationAccessToken(tx repo.Transaction, clientID string) ([]byte, error) {
+	m, err := r.getModel(tx, clientID)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return m.RegistrationAccessToken, nil
+}
+
+func (r *clientRepo) SetRegistrationAccessToken(tx repo.Transaction, clientID string, hashed []byte) error {
+	m, err := r.getModel(tx, clientID)
+	if err != nil {
+		return err
+	}
+	m.RegistrationAccessToken = hashed
+	_, err = r.executor(tx).Update(m)
+	return err
+}
+
 func (r *clientRepo) Update(tx repo.Transaction, cli client.Client) error {
 	if cli.Credentials.ID == "" {
 		return client.ErrorNotFound
@@ -193,6 +368,9 @@ func (r *clientRepo) New(tx repo.Transaction, cli client.Client) (*oidc.ClientCr
 	if err != nil {
 		return nil, err
 	}
+	if err := r.encryptAssertionSecret(cim); err != nil {
+		return nil, err
+	}
 
 	if err := r.executor(tx).Insert(cim); err != nil {
 		if isAlreadyExistsErr(err) {
@@ -233,13 +411,16 @@ func (r *clientRepo) All(tx repo.Transaction) ([]client.Client, error) {
 	return cs, nil
 }
 
-func NewClientRepoFromClients(dbm *gorp.DbMap, cs []client.LoadableClient) (client.ClientRepo, error) {
-	repo := NewClientRepo(dbm).(*clientRepo)
+func NewClientRepoFromClients(dbm *gorp.DbMap, cs []client.LoadableClient, secrets ...[]byte) (client.ClientRepo, error) {
+	repo := newClientRepo(dbm, secrets...)
 	for _, c := range cs {
 		cm, err := newClientModel(c.Client)
 		if err != nil {
 			return nil, err
 		}
+		if err := repo.encryptAssertionSecret(cm); err != nil {
+			return nil, err
+		}
 		err = repo.executor(nil).Insert(cm)
 		if err != nil {
 			return nil, err
@@ -250,6 +431,10 @@ func NewClientRepoFromClients(dbm *gorp.DbMap, cs []client.LoadableClient) (clie
 			return nil, err
 		}
 
+		err = repo.SetTokenExchangePeers(nil, c.Client.Credentials.ID, c.TokenExchangePeers)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return repo, nil
 }
@@ -294,10 +479,129 @@ func (r *clientRepo) update(tx repo.Transaction, cli client.Client) error {
 	if err != nil {
 		return err
 	}
+	if err := r.encryptAssertionSecret(cm); err != nil {
+		return err
+	}
+	old, err := r.getModel(tx, cli.Credentials.ID)
+	if err != nil {
+		return err
+	}
+	// Clients fetched from the repo don't carry their secret, so updates
+	// without one leave it unchanged.
+	if cli.Credentials.Secret == "" {
+		cm.Secret = old.Secret
+		if cli.Metadata.TokenEndpointAuthMethod == oauth2.AuthMethodClientSecretJWT {
+			cm.AssertionSecret = old.AssertionSecret
+		}
+	}
+	cm.RegistrationAccessToken = old.RegistrationAccessToken
 	_, err = ex.Update(cm)
 	return err
 }
 
+func (r *clientRepo) Delete(tx repo.Transaction, clientID string) error {
+	ex := r.executor(tx)
+	cm, err := r.getModel(tx, clientID)
+	if err != nil {
+		return err
+	}
+
+	// Everything the client was granted is revoked along with it, so that a
+	// client later registered with the same ID doesn't inherit it, and it is
+	// removed from the peers of other clients.
+	for _, c := range []struct{ table, column string }{
+		{trustedPeerTableName, "client_id"},
+		{trustedPeerTableName, "trusted_client_id"},
+		{tokenExchangePeerTableName, "client_id"},
+		{tokenExchangePeerTableName, "peer_client_id"},
+		{refreshTokenTableName, "client_id"},
+		{consentTableName, "client_id"},
+		{deviceGrantTableName, "client_id"},
+		{pushedAuthRequestTableName, "client_id"},
+	} {
+		qt := r.quote(c.table)
+		_, err = ex.Exec(fmt.Sprintf("DELETE from %s where %s = $1", qt, c.column), clientID)
+		if err != nil {
+			return err
+		}
+	}
+	if err := r.removeSSOSessionClient(tx, clientID); err != nil {
+		return err
+	}
+	if err := r.removePairwiseSubjects(tx, cm); err != nil {
+		return err
+	}
+	_, err = ex.Delete(cm)
+	return err
+}
+
+// removeSSOSessionClient removes the client from the clients SSO sessions were
+// used to log in to, so it's no longer notified when they end.
+func (r *clientRepo) removeSSOSessionClient(tx repo.Transaction, clientID string) error {
+	ex := r.executor(tx)
+	// The client IDs are space separated, so padding them with spaces lets
+	// the query match whole IDs only. They are still compared exactly below.
+	q := fmt.Sprintf(`SELECT * FROM %s WHERE (' ' || client_ids || ' ') LIKE $1 ESCAPE '\'`, r.quote(ssoSessionTableName))
+	var sessions []ssoSessionModel
+	if _, err := ex.Select(&sessions, q, "% "+likeEscaper.Replace(clientID)+" %"); err != nil {
+		return err
+	}
+	for _, m := range sessions {
+		var ids []string
+		for _, id := range strings.Fields(m.ClientIDs) {
+			if id != clientID {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == len(strings.Fields(m.ClientIDs)) {
+			continue
+		}
+		m.ClientIDs = strings.Join(ids, " ")
+		if _, err := ex.Update(&m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// removePairwiseSubjects removes the pairwise subjects issued to the client's
+// sector, unless other clients of the sector remain which may still resolve
+// them.
+func (r *clientRepo) removePairwiseSubjects(tx repo.Transaction, cm *clientModel) error {
+	cli, err := cm.Client()
+	if err != nil {
+		return err
+	}
+	if !cli.PairwiseSubject() {
+		return nil
+	}
+	sector, err := cli.SectorIdentifier()
+	if err != nil {
+		// Clients without a sector were never issued pairwise subjects.
+		return nil
+	}
+
+	clients, err := r.All(tx)
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if c.Credentials.ID == cli.Credentials.ID || !c.PairwiseSubject() {
+			continue
+		}
+		if s, err := c.SectorIdentifier(); err == nil && s == sector {
+			return nil
+		}
+	}
+
+	qt := r.quote(pairwiseSubjectTableName)
+	_, err = r.executor(tx).Exec(fmt.Sprintf("DELETE from %s where sector_identifier = $1", qt), sector)
+	return err
+}
+
 func (r *clientRepo) GetTrustedPeers(tx repo.Transaction, clientID string) ([]string, error) {
 	ex := r.executor(tx)
 	if clientID == "" {
@@ -350,6 +654,51 @@ func (r *clientRepo) SetTrustedPeers(tx repo.Transaction, clientID string, clien
 	return nil
 }
 
+func (r *clientRepo) GetTokenExchangePeers(tx repo.Transaction, clientID string) ([]string, error) {
+	ex := r.executor(tx)
+	if clientID == "" {
+		return nil, client.ErrorInvalidClientID
+	}
+
+	qt := r.quote(tokenExchangePeerTableName)
+	var ids []string
+	_, err := ex.Select(&ids, fmt.Sprintf("SELECT peer_client_id from %s where client_id = $1", qt), clientID)
+
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return ids, nil
+}
+
+func (r *clientRepo) SetTokenExchangePeers(tx repo.Transaction, clientID string, clientIDs []string) error {
+	ex := r.executor(tx)
+	qt := r.quote(tokenExchangePeerTableName)
+
+	_, err := ex.Exec(fmt.Sprintf("DELETE from %s where client_id = $1", qt), clientID)
+	if err != nil {
+		return err
+	}
+
+	// Ensure that the client exists.
+	_, err = r.get(tx, clientID)
+	if err != nil {
+		return err
+	}
+
+	rows := []interface{}{}
+	for _, curID := range clientIDs {
+		rows = append(rows, &tokenExchangePeerModel{
+			ClientID:     clientID,
+			PeerClientID: curID,
+		})
+	}
+	return ex.Insert(rows...)
+}
+
 func mustParseURL(s string) url.URL {
 	u, err := url.Parse(s)
 	if err != nil {
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gorp/gorp"
+
+	"github.com/coreos/dex/client"
+)
+
+const (
+	clientAssertionTableName = "client_assertion"
+)
+
+func init() {
+	register(table{
+		name:    clientAssertionTableName,
+		model:   clientAssertionModel{},
+		autoinc: false,
+		pkey:    []string{"client_id", "jti"},
+	})
+}
+
+type clientAssertionModel struct {
+	ClientID  string `db:"client_id"`
+	JTI       string `db:"jti"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+func NewClientAssertionRepo(dbm *gorp.DbMap) *ClientAssertionRepo {
+	return &ClientAssertionRepo{db: &db{dbm}}
+}
+
+type ClientAssertionRepo struct {
+	*db
+}
+
+func (r *ClientAssertionRepo) Use(clientID, jti string, expiresAt time.Time) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Expired assertions are rejected before their IDs are checked, so
+	// they're no longer needed.
+	qt := r.quote(clientAssertionTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE expires_at < $1", qt)
+	if _, err := r.executor(tx).Exec(q, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	m := clientAssertionModel{
+		ClientID:  clientID,
+		JTI:       jti,
+		ExpiresAt: expiresAt.Unix(),
+	}
+	if err := r.executor(tx).Insert(&m); err != nil {
+		if isAlreadyExistsErr(err) {
+			return client.ErrorAssertionReused
+		}
+		return err
+	}
+	return tx.Commit()
+}
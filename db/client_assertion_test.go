@@ -0,0 +1,33 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/dex/client"
+)
+
+func TestClientAssertionRepoUse(t *testing.T) {
+	r := NewClientAssertionRepo(NewMemDB())
+	exp := time.Now().Add(time.Minute)
+
+	if err := r.Use("client-1", "jti-1", exp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Use("client-1", "jti-1", exp); err != client.ErrorAssertionReused {
+		t.Errorf("want %v, got %v", client.ErrorAssertionReused, err)
+	}
+
+	// IDs only need to be unique per client.
+	if err := r.Use("client-2", "jti-1", exp); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Expired assertions are forgotten.
+	if err := r.Use("client-1", "jti-2", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Use("client-1", "jti-2", exp); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/consent"
+	"github.com/coreos/dex/device"
+	"github.com/coreos/dex/par"
+	"github.com/coreos/dex/refresh"
+	"github.com/coreos/dex/session"
+	"github.com/coreos/dex/user"
+)
+
+func TestClientRepoAssertionSecret(t *testing.T) {
+	secret := base64.URLEncoding.EncodeToString([]byte("secret"))
+	cli := client.Client{
+		Credentials: oidc.ClientCredentials{ID: "client-1", Secret: secret},
+		Metadata: oidc.ClientMetadata{
+			RedirectURIs:            []url.URL{{Scheme: "https", Host: "client-1.example.com", Path: "/callback"}},
+			TokenEndpointAuthMethod: oauth2.AuthMethodClientSecretJWT,
+		},
+	}
+	oldKey := []byte("abcdefghijklmnopqrstuvwxyz123456")
+	newKey := []byte("123456abcdefghijklmnopqrstuvwxyz")
+
+	dbm := NewMemDB()
+	if _, err := NewClientRepo(dbm).New(nil, cli); err != errorNoAssertionSecretKey {
+		t.Fatalf("want %v storing a client_secret_jwt client without a key secret, got %v", errorNoAssertionSecretKey, err)
+	}
+
+	repo := newClientRepo(dbm, oldKey)
+	if _, err := repo.New(nil, cli); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cm, err := repo.getModel(nil, "client-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(cm.AssertionSecret, []byte(secret)) {
+		t.Errorf("client secret stored unencrypted")
+	}
+
+	// Secrets encrypted with a previous key secret can still be decrypted.
+	got, err := NewClientRepo(dbm, newKey, oldKey).GetAssertionSecret(nil, "client-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != secret {
+		t.Errorf("want secret %q, got %q", secret, got)
+	}
+	if _, err := NewClientRepo(dbm, newKey).GetAssertionSecret(nil, "client-1"); err == nil {
+		t.Errorf("want error decrypting with another key secret")
+	}
+}
+
+func TestClientRepoDeleteRevokesGrants(t *testing.T) {
+	dbm := NewMemDB()
+	clientRepo := NewClientRepo(dbm)
+	refreshRepo := NewRefreshTokenRepo(dbm)
+	consentRepo := NewConsentRepo(dbm)
+	ssoRepo := NewSSOSessionRepo(dbm)
+	deviceRepo := NewDeviceGrantRepo(dbm)
+	parRepo := NewPushedAuthRequestRepo(dbm)
+	pairwiseRepo := NewPairwiseSubjectRepo(dbm)
+
+	var tokens []string
+	for _, id := range []string{"client-1", "client-10"} {
+		_, err := clientRepo.New(nil, client.Client{
+			Credentials: oidc.ClientCredentials{
+				ID:     id,
+				Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
+			},
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{{Scheme: "https", Host: id + ".example.com", Path: "/callback"}},
+				SubjectType:  oidc.SubjectTypePairwise,
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		now := time.Now().UTC()
+		err = deviceRepo.Create(device.Grant{
+			DeviceCode: "device-" + id,
+			UserCode:   "USER-" + id,
+			ClientID:   id,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(device.DefaultGrantValidityWindow),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := parRepo.Create(par.Request{RequestURI: "urn:" + id, ClientID: id, ExpiresAt: now.Add(time.Minute)}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := pairwiseRepo.Create(user.NewPairwiseSubject(id+".example.com", "elroy-id", []byte("salt"))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		token, err := refreshRepo.Create("elroy-id", id, "local", []string{"openid"}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tokens = append(tokens, token)
+		if err := consentRepo.Set(consent.Consent{UserID: "elroy-id", ClientID: id, Scope: []string{"openid"}, UpdatedAt: time.Now().UTC()}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := clientRepo.SetTrustedPeers(nil, "client-10", []string{"client-1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := clientRepo.SetTokenExchangePeers(nil, "client-10", []string{"client-1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now().UTC()
+	err := ssoRepo.Create(session.SSOSession{
+		ID:          "sso-1",
+		UserID:      "elroy-id",
+		ConnectorID: "local",
+		Identity:    oidc.Identity{ID: "elroy-id"},
+		AuthTime:    now,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(session.DefaultSSOSessionValidityWindow),
+		ClientIDs:   []string{"client-10", "client-1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := clientRepo.Delete(nil, "client-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, _, _, err := refreshRepo.Verify("client-1", tokens[0]); err != refresh.ErrorInvalidToken {
+		t.Errorf("want %v for the deleted client's refresh token, got %v", refresh.ErrorInvalidToken, err)
+	}
+	if _, err := consentRepo.Get("elroy-id", "client-1"); err != consent.ErrorNotFound {
+		t.Errorf("want %v for the deleted client's consent, got %v", consent.ErrorNotFound, err)
+	}
+	if peers, err := clientRepo.GetTrustedPeers(nil, "client-10"); err != nil || len(peers) != 0 {
+		t.Errorf("want the deleted client removed from other clients' trusted peers, got %v, %v", peers, err)
+	}
+	if peers, err := clientRepo.GetTokenExchangePeers(nil, "client-10"); err != nil || len(peers) != 0 {
+		t.Errorf("want the deleted client removed from other clients' token exchange peers, got %v, %v", peers, err)
+	}
+	if _, err := deviceRepo.GetByDeviceCode("device-client-1"); err != device.ErrorNotFound {
+		t.Errorf("want %v for the deleted client's device grant, got %v", device.ErrorNotFound, err)
+	}
+	if _, err := parRepo.Get("urn:client-1"); err != par.ErrorNotFound {
+		t.Errorf("want %v for the deleted client's pushed authorization request, got %v", par.ErrorNotFound, err)
+	}
+	ps := user.NewPairwiseSubject("client-1.example.com", "elroy-id", []byte("salt"))
+	if _, err := pairwiseRepo.Get(ps.Subject); err != user.ErrorNotFound {
+		t.Errorf("want %v for the deleted client's pairwise subject, got %v", user.ErrorNotFound, err)
+	}
+	sso, err := ssoRepo.Get("sso-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare([]string{"client-10"}, sso.ClientIDs); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	// The grants of other clients are left alone.
+	if _, _, _, _, err := refreshRepo.Verify("client-10", tokens[1]); err != nil {
+		t.Errorf("unexpected error verifying other client's refresh token: %v", err)
+	}
+	if _, err := consentRepo.Get("elroy-id", "client-10"); err != nil {
+		t.Errorf("unexpected error getting other client's consent: %v", err)
+	}
+	if _, err := deviceRepo.GetByDeviceCode("device-client-10"); err != nil {
+		t.Errorf("unexpected error getting other client's device grant: %v", err)
+	}
+	if _, err := parRepo.Get("urn:client-10"); err != nil {
+		t.Errorf("unexpected error getting other client's pushed authorization request: %v", err)
+	}
+	ps = user.NewPairwiseSubject("client-10.example.com", "elroy-id", []byte("salt"))
+	if _, err := pairwiseRepo.Get(ps.Subject); err != nil {
+		t.Errorf("unexpected error getting other client's pairwise subject: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp"
+
+	"github.com/coreos/dex/consent"
+	"github.com/coreos/dex/pkg/log"
+)
+
+const (
+	consentTableName = "consent"
+)
+
+func init() {
+	register(table{
+		name:    consentTableName,
+		model:   consentModel{},
+		autoinc: false,
+		pkey:    []string{"user_id", "client_id"},
+	})
+}
+
+type consentModel struct {
+	UserID    string `db:"user_id"`
+	ClientID  string `db:"client_id"`
+	Scope     string `db:"scope"`
+	UpdatedAt int64  `db:"updated_at"`
+}
+
+func (m *consentModel) consent() consent.Consent {
+	c := consent.Consent{
+		UserID:   m.UserID,
+		ClientID: m.ClientID,
+		Scope:    strings.Fields(m.Scope),
+	}
+
+	if m.UpdatedAt != 0 {
+		c.UpdatedAt = time.Unix(m.UpdatedAt, 0).UTC()
+	}
+
+	return c
+}
+
+func newConsentModel(c *consent.Consent) *consentModel {
+	m := consentModel{
+		UserID:   c.UserID,
+		ClientID: c.ClientID,
+		Scope:    strings.Join(c.Scope, " "),
+	}
+
+	if !c.UpdatedAt.IsZero() {
+		m.UpdatedAt = c.UpdatedAt.Unix()
+	}
+
+	return &m
+}
+
+func NewConsentRepo(dbm *gorp.DbMap) *ConsentRepo {
+	return &ConsentRepo{db: &db{dbm}}
+}
+
+type ConsentRepo struct {
+	*db
+}
+
+func (r *ConsentRepo) Get(userID, clientID string) (*consent.Consent, error) {
+	m, err := r.executor(nil).Get(consentModel{}, userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, consent.ErrorNotFound
+	}
+
+	cm, ok := m.(*consentModel)
+	if !ok {
+		log.Errorf("expected consentModel but found %v", reflect.TypeOf(m))
+		return nil, errors.New("unrecognized model")
+	}
+
+	c := cm.consent()
+	return &c, nil
+}
+
+func (r *ConsentRepo) GetByUser(userID string) ([]consent.Consent, error) {
+	qt := r.quote(consentTableName)
+	q := fmt.Sprintf("SELECT * FROM %s WHERE user_id = $1 ORDER BY client_id", qt)
+	var cms []consentModel
+	if _, err := r.executor(nil).Select(&cms, q, userID); err != nil {
+		return nil, err
+	}
+
+	cs := make([]consent.Consent, len(cms))
+	for i, cm := range cms {
+		cs[i] = cm.consent()
+	}
+	return cs, nil
+}
+
+func (r *ConsentRepo) Set(c consent.Consent) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qt := r.quote(consentTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE user_id = $1 AND client_id = $2", qt)
+	if _, err := r.executor(tx).Exec(q, c.UserID, c.ClientID); err != nil {
+		return err
+	}
+	if err := r.executor(tx).Insert(newConsentModel(&c)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *ConsentRepo) Delete(userID, clientID string) error {
+	qt := r.quote(consentTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE user_id = $1 AND client_id = $2", qt)
+	res, err := r.executor(nil).Exec(q, userID, clientID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return consent.ErrorNotFound
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/coreos/dex/consent"
+)
+
+func TestConsentRepo(t *testing.T) {
+	r := NewConsentRepo(NewMemDB())
+	now := time.Now().UTC().Round(time.Second)
+
+	if _, err := r.Get("elroy-id", "client-1"); err != consent.ErrorNotFound {
+		t.Errorf("want %v, got %v", consent.ErrorNotFound, err)
+	}
+
+	c1 := consent.Consent{
+		UserID:    "elroy-id",
+		ClientID:  "client-1",
+		Scope:     []string{"openid", "email"},
+		UpdatedAt: now,
+	}
+	c2 := consent.Consent{
+		UserID:    "elroy-id",
+		ClientID:  "client-2",
+		Scope:     []string{"openid"},
+		UpdatedAt: now,
+	}
+	other := consent.Consent{
+		UserID:    "penny-id",
+		ClientID:  "client-1",
+		Scope:     []string{"openid"},
+		UpdatedAt: now,
+	}
+	for _, c := range []consent.Consent{c1, c2, other} {
+		if err := r.Set(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Setting a consent again replaces it.
+	c1.Scope = []string{"openid", "email", "groups"}
+	if err := r.Set(c1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := r.Get(c1.UserID, c1.ClientID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(c1, got); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	all, err := r.GetByUser("elroy-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare([]consent.Consent{c1, c2}, all); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	if err := r.Delete(c1.UserID, c1.ClientID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Delete(c1.UserID, c1.ClientID); err != consent.ErrorNotFound {
+		t.Errorf("want %v, got %v", consent.ErrorNotFound, err)
+	}
+	if _, err := r.Get(other.UserID, other.ClientID); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jonboulle/clockwork"
+
+	"github.com/coreos/dex/device"
+	"github.com/coreos/dex/pkg/log"
+)
+
+const (
+	deviceGrantTableName = "device_grant"
+)
+
+func init() {
+	register(table{
+		name:    deviceGrantTableName,
+		model:   deviceGrantModel{},
+		autoinc: false,
+		pkey:    []string{"device_code"},
+	})
+}
+
+type deviceGrantModel struct {
+	DeviceCode   string `db:"device_code"`
+	UserCode     string `db:"user_code"`
+	ClientID     string `db:"client_id"`
+	Scope        string `db:"scope"`
+	SessionID    string `db:"session_id"`
+	Approved     bool   `db:"approved"`
+	PollInterval int64  `db:"poll_interval"`
+	LastPolledAt int64  `db:"last_polled_at"`
+	CreatedAt    int64  `db:"created_at"`
+	ExpiresAt    int64  `db:"expires_at"`
+}
+
+func (m *deviceGrantModel) grant() *device.Grant {
+	g := device.Grant{
+		DeviceCode: m.DeviceCode,
+		UserCode:   m.UserCode,
+		ClientID:   m.ClientID,
+		Scope:      strings.Fields(m.Scope),
+		SessionID:  m.SessionID,
+		Approved:   m.Approved,
+		Interval:   time.Duration(m.PollInterval) * time.Second,
+	}
+
+	if m.LastPolledAt != 0 {
+		g.LastPolledAt = time.Unix(m.LastPolledAt, 0).UTC()
+	}
+
+	if m.CreatedAt != 0 {
+		g.CreatedAt = time.Unix(m.CreatedAt, 0).UTC()
+	}
+
+	if m.ExpiresAt != 0 {
+		g.ExpiresAt = time.Unix(m.ExpiresAt, 0).UTC()
+	}
+
+	return &g
+}
+
+func newDeviceGrantModel(g *device.Grant) *deviceGrantModel {
+	m := deviceGrantModel{
+		DeviceCode:   g.DeviceCode,
+		UserCode:     g.UserCode,
+		ClientID:     g.ClientID,
+		Scope:        strings.Join(g.Scope, " "),
+		SessionID:    g.SessionID,
+		Approved:     g.Approved,
+		PollInterval: int64(g.Interval.Seconds()),
+	}
+
+	if !g.LastPolledAt.IsZero() {
+		m.LastPolledAt = g.LastPolledAt.Unix()
+	}
+
+	if !g.CreatedAt.IsZero() {
+		m.CreatedAt = g.CreatedAt.Unix()
+	}
+
+	if !g.ExpiresAt.IsZero() {
+		m.ExpiresAt = g.ExpiresAt.Unix()
+	}
+
+	return &m
+}
+
+func NewDeviceGrantRepo(dbm *gorp.DbMap) *DeviceGrantRepo {
+	return NewDeviceGrantRepoWithClock(dbm, clockwork.NewRealClock())
+}
+
+func NewDeviceGrantRepoWithClock(dbm *gorp.DbMap, clock clockwork.Clock) *DeviceGrantRepo {
+	return &DeviceGrantRepo{db: &db{dbm}, clock: clock}
+}
+
+type DeviceGrantRepo struct {
+	*db
+	clock clockwork.Clock
+}
+
+func (r *DeviceGrantRepo) Create(g device.Grant) error {
+	return r.executor(nil).Insert(newDeviceGrantModel(&g))
+}
+
+func (r *DeviceGrantRepo) GetByDeviceCode(deviceCode string) (*device.Grant, error) {
+	m, err := r.executor(nil).Get(deviceGrantModel{}, deviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, device.ErrorNotFound
+	}
+
+	dm, ok := m.(*deviceGrantModel)
+	if !ok {
+		log.Errorf("expected deviceGrantModel but found %v", reflect.TypeOf(m))
+		return nil, errors.New("unrecognized model")
+	}
+
+	return r.unexpired(dm)
+}
+
+func (r *DeviceGrantRepo) GetByUserCode(userCode string) (*device.Grant, error) {
+	qt := r.quote(deviceGrantTableName)
+	q := fmt.Sprintf("SELECT * FROM %s WHERE user_code = $1", qt)
+	var dm deviceGrantModel
+	if err := r.executor(nil).SelectOne(&dm, q, userCode); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, device.ErrorNotFound
+		}
+		return nil, err
+	}
+
+	return r.unexpired(&dm)
+}
+
+func (r *DeviceGrantRepo) unexpired(dm *deviceGrantModel) (*device.Grant, error) {
+	g := dm.grant()
+	if g.ExpiresAt.Before(r.clock.Now()) {
+		return nil, device.ErrorExpired
+	}
+	return g, nil
+}
+
+func (r *DeviceGrantRepo) Update(g device.Grant) error {
+	n, err := r.executor(nil).Update(newDeviceGrantModel(&g))
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return errors.New("update affected unexpected number of rows")
+	}
+	return nil
+}
+
+func (r *DeviceGrantRepo) Delete(deviceCode string) error {
+	qt := r.quote(deviceGrantTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE device_code = $1", qt)
+	res, err := r.executor(nil).Exec(q, deviceCode)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return device.ErrorNotFound
+	}
+	return nil
+}
+
+func (r *DeviceGrantRepo) purge() error {
+	qt := r.quote(deviceGrantTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE expires_at < $1", qt)
+	res, err := r.executor(nil).Exec(q, r.clock.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	d := "unknown # of"
+	if n, err := res.RowsAffected(); err == nil {
+		if n == 0 {
+			return nil
+		}
+		d = fmt.Sprintf("%d", n)
+	}
+
+	log.Infof("Deleted %s stale row(s) from %s table", d, deviceGrantTableName)
+	return nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/coreos/dex/device"
+)
+
+func TestDeviceGrantRepo(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	r := NewDeviceGrantRepoWithClock(NewMemDB(), clock)
+
+	g := device.Grant{
+		DeviceCode: "device-code",
+		UserCode:   "BCDFGHJK",
+		ClientID:   "client.example.com",
+		Scope:      []string{"openid", "offline_access"},
+		Interval:   device.DefaultPollInterval,
+		CreatedAt:  clock.Now(),
+		ExpiresAt:  clock.Now().Add(device.DefaultGrantValidityWindow),
+	}
+	if err := r.Create(g); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := r.GetByUserCode(g.UserCode)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(g, got); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	g.SessionID = "session-id"
+	g.Approved = true
+	g.LastPolledAt = clock.Now()
+	if err := r.Update(g); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err = r.GetByDeviceCode(g.DeviceCode)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(g, got); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	if _, err := r.GetByUserCode("XXXXXXXX"); err != device.ErrorNotFound {
+		t.Errorf("want %v, got %v", device.ErrorNotFound, err)
+	}
+
+	// Expired grants are no longer returned, and are purged.
+	clock.Advance(device.DefaultGrantValidityWindow + time.Second)
+	if _, err := r.GetByDeviceCode(g.DeviceCode); err != device.ErrorExpired {
+		t.Errorf("want %v, got %v", device.ErrorExpired, err)
+	}
+	if err := r.purge(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.GetByDeviceCode(g.DeviceCode); err != device.ErrorNotFound {
+		t.Errorf("want %v, got %v", device.ErrorNotFound, err)
+	}
+	if err := r.Delete(g.DeviceCode); err != device.ErrorNotFound {
+		t.Errorf("want %v, got %v", device.ErrorNotFound, err)
+	}
+}
@@ -22,6 +22,10 @@ type namedPurger struct {
 func NewGarbageCollector(dbm *gorp.DbMap, ival time.Duration) *GarbageCollector {
 	sRepo := NewSessionRepo(dbm)
 	skRepo := NewSessionKeyRepo(dbm)
+	dgRepo := NewDeviceGrantRepo(dbm)
+	ssoRepo := NewSSOSessionRepo(dbm)
+	parRepo := NewPushedAuthRequestRepo(dbm)
+	rtRepo := newRefreshTokenRepo(dbm, RefreshTokenRepoOptions{})
 
 	purgers := []namedPurger{
 		namedPurger{
@@ -32,6 +36,22 @@ func NewGarbageCollector(dbm *gorp.DbMap, ival time.Duration) *GarbageCollector
 			name:   "session_key",
 			purger: skRepo,
 		},
+		namedPurger{
+			name:   "device_grant",
+			purger: dgRepo,
+		},
+		namedPurger{
+			name:   "sso_session",
+			purger: ssoRepo,
+		},
+		namedPurger{
+			name:   "pushed_auth_request",
+			purger: parRepo,
+		},
+		namedPurger{
+			name:   "refresh_token",
+			purger: rtRepo,
+		},
 	}
 
 	gc := GarbageCollector{
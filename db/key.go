@@ -1,6 +1,8 @@
 package db
 
 import (
+	"crypto"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
@@ -10,6 +12,7 @@ import (
 	"github.com/go-gorp/gorp"
 
 	pcrypto "github.com/coreos/dex/pkg/crypto"
+	"github.com/coreos/dex/signing"
 	"github.com/coreos/go-oidc/key"
 )
 
@@ -29,50 +32,81 @@ func init() {
 	})
 }
 
-func newPrivateKeySetModel(pks *key.PrivateKeySet) (*privateKeySetModel, error) {
+func newPrivateKeySetModel(pks *signing.PrivateKeySet) (*privateKeySetModel, error) {
 	pkeys := pks.Keys()
 	keys := make([]privateKeyModel, len(pkeys))
 	for i, pkey := range pkeys {
 		keys[i] = privateKeyModel{
-			ID:    pkey.ID(),
-			PKCS1: x509.MarshalPKCS1PrivateKey(pkey.PrivateKey),
+			ID:  pkey.ID(),
+			Alg: pkey.Alg,
 		}
+		// RS256 keys are stored as they were before other algorithms were
+		// supported.
+		if pkey.Alg == signing.RS256 {
+			keys[i].PKCS1 = x509.MarshalPKCS1PrivateKey(pkey.Key.(*rsa.PrivateKey))
+			continue
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(pkey.Key)
+		if err != nil {
+			return nil, err
+		}
+		keys[i].PKCS8 = der
 	}
 
 	m := privateKeySetModel{
-		Keys:      keys,
-		ExpiresAt: pks.ExpiresAt(),
+		Keys:       keys,
+		Algorithms: pks.Algorithms(),
+		ExpiresAt:  pks.ExpiresAt(),
 	}
 
 	return &m, nil
 }
 
 type privateKeyModel struct {
-	ID    string `json:"id"`
-	PKCS1 []byte `json:"pkcs1"`
+	ID string `json:"id"`
+
+	// Keys stored before other algorithms were supported have no alg, and
+	// are RS256 keys.
+	Alg   string `json:"alg,omitempty"`
+	PKCS1 []byte `json:"pkcs1,omitempty"`
+	PKCS8 []byte `json:"pkcs8,omitempty"`
 }
 
-func (m *privateKeyModel) PrivateKey() (*key.PrivateKey, error) {
-	d, err := x509.ParsePKCS1PrivateKey(m.PKCS1)
+func (m *privateKeyModel) PrivateKey() (*signing.PrivateKey, error) {
+	if m.Alg == "" || m.Alg == signing.RS256 {
+		d, err := x509.ParsePKCS1PrivateKey(m.PKCS1)
+		if err != nil {
+			return nil, err
+		}
+		return &signing.PrivateKey{KeyID: m.ID, Alg: signing.RS256, Key: d}, nil
+	}
+
+	d, err := x509.ParsePKCS8PrivateKey(m.PKCS8)
 	if err != nil {
 		return nil, err
 	}
+	k, ok := d.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", d)
+	}
 
-	pk := key.PrivateKey{
-		KeyID:      m.ID,
-		PrivateKey: d,
+	pk := signing.PrivateKey{
+		KeyID: m.ID,
+		Alg:   m.Alg,
+		Key:   k,
 	}
 
 	return &pk, nil
 }
 
 type privateKeySetModel struct {
-	Keys      []privateKeyModel `json:"keys"`
-	ExpiresAt time.Time         `json:"expires_at"`
+	Keys       []privateKeyModel `json:"keys"`
+	Algorithms []string          `json:"algs,omitempty"`
+	ExpiresAt  time.Time         `json:"expires_at"`
 }
 
-func (m *privateKeySetModel) PrivateKeySet() (*key.PrivateKeySet, error) {
-	keys := make([]*key.PrivateKey, len(m.Keys))
+func (m *privateKeySetModel) PrivateKeySet() (*signing.PrivateKeySet, error) {
+	keys := make([]*signing.PrivateKey, len(m.Keys))
 	for i, pkm := range m.Keys {
 		pk, err := pkm.PrivateKey()
 		if err != nil {
@@ -80,7 +114,12 @@ func (m *privateKeySetModel) PrivateKeySet() (*key.PrivateKeySet, error) {
 		}
 		keys[i] = pk
 	}
-	return key.NewPrivateKeySet(keys, m.ExpiresAt), nil
+
+	algs := m.Algorithms
+	if len(algs) == 0 {
+		algs = []string{signing.RS256}
+	}
+	return signing.NewPrivateKeySet(keys, algs, m.ExpiresAt), nil
 }
 
 type privateKeySetBlob struct {
@@ -124,7 +163,7 @@ func (r *PrivateKeySetRepo) Set(ks key.KeySet) error {
 		return err
 	}
 
-	pks, ok := ks.(*key.PrivateKeySet)
+	pks, ok := ks.(*signing.PrivateKeySet)
 	if !ok {
 		return errors.New("unable to cast to PrivateKeySet")
 	}
@@ -174,7 +213,7 @@ func (r *PrivateKeySetRepo) Get() (key.KeySet, error) {
 		return nil, errors.New("unable to cast to KeySet")
 	}
 
-	var pks *key.PrivateKeySet
+	var pks *signing.PrivateKeySet
 	for _, secret := range r.secrets {
 		var j []byte
 
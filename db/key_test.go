@@ -1,7 +1,17 @@
 package db
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
 	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/coreos/dex/signing"
 )
 
 func TestNewPrivateKeySetRepoInvalidKey(t *testing.T) {
@@ -14,3 +24,90 @@ func TestNewPrivateKeySetRepoInvalidKey(t *testing.T) {
 		t.Fatalf("Expected non-nil error when creating repo with no key secrets")
 	}
 }
+
+func TestPrivateKeySetModel(t *testing.T) {
+	var keys []*signing.PrivateKey
+	for _, alg := range []string{signing.ES256, signing.RS256, signing.EdDSA, signing.PS256} {
+		k, err := signing.GeneratePrivateKey(alg)
+		if err != nil {
+			t.Fatalf("Unable to generate %s key: %v", alg, err)
+		}
+		keys = append(keys, k)
+	}
+	ks := signing.NewPrivateKeySet(keys, []string{signing.ES256, signing.RS256, signing.EdDSA}, time.Now().Add(time.Minute))
+
+	m, err := newPrivateKeySetModel(ks)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var got privateKeySetModel
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	gotKS, err := got.PrivateKeySet()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if diff := pretty.Compare(ks.Algorithms(), gotKS.Algorithms()); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+	if !ks.ExpiresAt().Equal(gotKS.ExpiresAt()) {
+		t.Errorf("want expiry %v, got %v", ks.ExpiresAt(), gotKS.ExpiresAt())
+	}
+	gotKeys := gotKS.Keys()
+	if len(gotKeys) != len(keys) {
+		t.Fatalf("want %d keys, got %d", len(keys), len(gotKeys))
+	}
+	// Keys are compared with their Equal methods, as the internal
+	// representation of decoded keys may differ.
+	for i, want := range keys {
+		got := gotKeys[i]
+		if got.KeyID != want.KeyID || got.Alg != want.Alg {
+			t.Errorf("key %d: want %s %s, got %s %s", i, want.Alg, want.KeyID, got.Alg, got.KeyID)
+		}
+		k, ok := want.Key.(interface {
+			Equal(crypto.PrivateKey) bool
+		})
+		if !ok || !k.Equal(got.Key) {
+			t.Errorf("key %d: %s key not decoded", i, want.Alg)
+		}
+	}
+}
+
+func TestPrivateKeySetModelOldFormat(t *testing.T) {
+	k, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Keys stored before other algorithms were supported.
+	b, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]interface{}{
+			{"id": "1234", "pkcs1": x509.MarshalPKCS1PrivateKey(k)},
+		},
+		"expires_at": time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var m privateKeySetModel
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ks, err := m.PrivateKeySet()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if algs := ks.Algorithms(); len(algs) != 1 || algs[0] != signing.RS256 {
+		t.Errorf("want algorithms [RS256], got %v", algs)
+	}
+	if active := ks.Active(signing.RS256); active == nil || active.KeyID != "1234" {
+		t.Errorf("want active RS256 key 1234, got %v", active)
+	}
+}
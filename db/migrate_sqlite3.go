@@ -17,7 +17,34 @@ CREATE TABLE client_identity (
     secret blob,
     metadata text,
     dex_admin integer,
-    public integer
+    public integer,
+    resources text,
+    post_logout_redirect_uris text,
+    backchannel_logout_uri text,
+    first_party integer,
+    assertion_secret blob,
+    require_pushed_auth_requests integer,
+    registration_access_token blob,
+    id_token_lifetime bigint,
+    access_token_lifetime bigint,
+    refresh_token_lifetime bigint,
+    allowed_grant_types text,
+    allowed_scopes text,
+    native_redirect_uris text
+);
+
+CREATE TABLE client_assertion (
+    client_id text NOT NULL,
+    jti text NOT NULL,
+    expires_at bigint,
+    UNIQUE (client_id, jti)
+);
+
+CREATE TABLE consent (
+    user_id text NOT NULL,
+    client_id text NOT NULL,
+    scope text,
+    updated_at bigint
 );
 
 CREATE TABLE connector_config (
@@ -26,23 +53,55 @@ CREATE TABLE connector_config (
     config text
 );
 
+CREATE TABLE device_grant (
+    device_code text NOT NULL UNIQUE,
+    user_code text NOT NULL UNIQUE,
+    client_id text,
+    scope text,
+    session_id text,
+    approved integer,
+    poll_interval bigint,
+    last_polled_at bigint,
+    created_at bigint,
+    expires_at bigint
+);
+
 CREATE TABLE key (
     value blob
 );
 
+CREATE TABLE pairwise_subject (
+    subject text NOT NULL UNIQUE,
+    sector_identifier text,
+    user_id text
+);
+
 CREATE TABLE password_info (
     user_id text NOT NULL UNIQUE,
     password text,
     password_expires bigint
 );
 
+CREATE TABLE pushed_auth_request (
+    request_uri text NOT NULL UNIQUE,
+    client_id text,
+    params text,
+    expires_at bigint
+);
+
 CREATE TABLE refresh_token (
     id integer PRIMARY KEY,
     payload_hash blob,
     user_id text,
     client_id text,
     connector_id text,
-    scopes text
+    scopes text,
+    claims_request text,
+    family_id bigint,
+    parent_id bigint,
+    rotated integer,
+    expires_at bigint,
+    family_expires_at bigint
 );
 
 CREATE TABLE remote_identity_mapping (
@@ -65,7 +124,17 @@ CREATE TABLE session (
     register integer,
     nonce text,
     scope text,
-    groups text
+    groups text,
+    code_challenge text,
+    code_challenge_method text,
+    auth_time bigint,
+    sso_session_id text,
+    claims_request text,
+    response_type text,
+    response_mode text,
+    sso_login_nonce text,
+    auth_error text,
+    prompt_consent integer
 );
 
 CREATE TABLE session_key (
@@ -75,9 +144,25 @@ CREATE TABLE session_key (
     stale integer
 );
 
+CREATE TABLE sso_session (
+    id text NOT NULL UNIQUE,
+    user_id text,
+    connector_id text,
+    identity text,
+    auth_time bigint,
+    created_at bigint,
+    expires_at bigint,
+    client_ids text
+);
+
 CREATE TABLE trusted_peers (
     client_id text NOT NULL,
     trusted_client_id text NOT NULL
 );
 
+CREATE TABLE token_exchange_peers (
+    client_id text NOT NULL,
+    peer_client_id text NOT NULL
+);
+
 `
@@ -0,0 +1,12 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS "device_grant" (
+       "device_code" text not null primary key,
+       "user_code" text not null unique,
+       "client_id" text,
+       "scope" text,
+       "session_id" text,
+       "approved" boolean,
+       "poll_interval" bigint,
+       "last_polled_at" bigint,
+       "created_at" bigint,
+       "expires_at" bigint) ;
@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE client_identity ADD COLUMN "resources" text;
+
+UPDATE "client_identity" SET "resources" = '';
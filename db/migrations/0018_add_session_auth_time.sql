@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE session ADD COLUMN "auth_time" bigint;
+
+UPDATE "session" SET "auth_time" = 0;
@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS "sso_session" (
+       "id" text not null primary key,
+       "user_id" text,
+       "connector_id" text,
+       "identity" text,
+       "auth_time" bigint,
+       "created_at" bigint,
+       "expires_at" bigint) ;
+
+ALTER TABLE session ADD COLUMN "sso_session_id" text;
+
+UPDATE "session" SET "sso_session_id" = '';
@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE client_identity ADD COLUMN "post_logout_redirect_uris" text;
+
+UPDATE "client_identity" SET "post_logout_redirect_uris" = '';
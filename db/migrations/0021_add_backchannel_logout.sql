@@ -0,0 +1,8 @@
+-- +migrate Up
+ALTER TABLE client_identity ADD COLUMN "backchannel_logout_uri" text;
+
+UPDATE "client_identity" SET "backchannel_logout_uri" = '';
+
+ALTER TABLE sso_session ADD COLUMN "client_ids" text;
+
+UPDATE "sso_session" SET "client_ids" = '';
@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS "consent" (
+       "user_id" text not null,
+       "client_id" text not null,
+       "scope" text,
+       "updated_at" bigint,
+       primary key ("user_id", "client_id")) ;
+
+ALTER TABLE client_identity ADD COLUMN "first_party" boolean;
+
+-- Clients which existed before consent was introduced keep logging users in
+-- without asking them.
+UPDATE "client_identity" SET "first_party" = true;
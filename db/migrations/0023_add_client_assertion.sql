@@ -0,0 +1,8 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS "client_assertion" (
+       "client_id" text not null,
+       "jti" text not null,
+       "expires_at" bigint,
+       primary key ("client_id", "jti")) ;
+
+ALTER TABLE client_identity ADD COLUMN "assertion_secret" bytea;
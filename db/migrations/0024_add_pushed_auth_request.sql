@@ -0,0 +1,11 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS "pushed_auth_request" (
+       "request_uri" text not null,
+       "client_id" text,
+       "params" text,
+       "expires_at" bigint,
+       primary key ("request_uri")) ;
+
+ALTER TABLE client_identity ADD COLUMN "require_pushed_auth_requests" boolean;
+
+UPDATE "client_identity" SET "require_pushed_auth_requests" = false;
@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE session ADD COLUMN "claims_request" text;
+
+UPDATE "session" SET "claims_request" = '';
@@ -0,0 +1,6 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS "pairwise_subject" (
+       "subject" text not null,
+       "sector_identifier" text,
+       "user_id" text,
+       primary key ("subject")) ;
@@ -0,0 +1,8 @@
+-- +migrate Up
+ALTER TABLE refresh_token ADD COLUMN "family_id" bigint;
+ALTER TABLE refresh_token ADD COLUMN "parent_id" bigint;
+ALTER TABLE refresh_token ADD COLUMN "rotated" boolean;
+ALTER TABLE refresh_token ADD COLUMN "expires_at" bigint;
+ALTER TABLE refresh_token ADD COLUMN "family_expires_at" bigint;
+
+UPDATE "refresh_token" SET "family_id" = "id", "parent_id" = 0, "rotated" = false, "expires_at" = 0, "family_expires_at" = 0;
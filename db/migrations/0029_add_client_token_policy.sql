@@ -0,0 +1,8 @@
+-- +migrate Up
+ALTER TABLE client_identity ADD COLUMN "id_token_lifetime" bigint;
+ALTER TABLE client_identity ADD COLUMN "access_token_lifetime" bigint;
+ALTER TABLE client_identity ADD COLUMN "refresh_token_lifetime" bigint;
+ALTER TABLE client_identity ADD COLUMN "allowed_grant_types" text;
+ALTER TABLE client_identity ADD COLUMN "allowed_scopes" text;
+
+UPDATE "client_identity" SET "id_token_lifetime" = 0, "access_token_lifetime" = 0, "refresh_token_lifetime" = 0, "allowed_grant_types" = '', "allowed_scopes" = '';
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE session ADD COLUMN "response_type" text;
+ALTER TABLE session ADD COLUMN "response_mode" text;
+
+UPDATE "session" SET "response_type" = '', "response_mode" = '';
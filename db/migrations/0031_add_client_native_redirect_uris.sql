@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE client_identity ADD COLUMN "native_redirect_uris" text;
+
+UPDATE "client_identity" SET "native_redirect_uris" = '';
@@ -0,0 +1,5 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS "token_exchange_peers" (
+       "client_id" text not null,
+       "peer_client_id" text not null,
+       primary key ("client_id", "peer_client_id")) ;
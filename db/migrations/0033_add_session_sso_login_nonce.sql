@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE session ADD COLUMN "sso_login_nonce" text;
+
+UPDATE "session" SET "sso_login_nonce" = '';
@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE session ADD COLUMN "auth_error" text;
+
+UPDATE "session" SET "auth_error" = '';
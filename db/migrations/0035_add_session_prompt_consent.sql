@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE session ADD COLUMN "prompt_consent" boolean;
+
+UPDATE "session" SET "prompt_consent" = false;
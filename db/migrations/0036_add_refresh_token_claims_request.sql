@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE refresh_token ADD COLUMN "claims_request" text;
+
+UPDATE "refresh_token" SET "claims_request" = '';
@@ -96,5 +96,137 @@ var PostgresMigrations migrate.MigrationSource = &migrate.MemoryMigrationSource{
 				"-- +migrate Up\nALTER TABLE refresh_token ADD COLUMN \"connector_id\" text;\nALTER TABLE session ADD COLUMN \"groups\" text;\n",
 			},
 		},
+		{
+			Id: "0015_add_session_code_challenge.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE session ADD COLUMN \"code_challenge\" text;\nALTER TABLE session ADD COLUMN \"code_challenge_method\" text;\n",
+			},
+		},
+		{
+			Id: "0016_add_device_grant.sql",
+			Up: []string{
+				"-- +migrate Up\nCREATE TABLE IF NOT EXISTS \"device_grant\" (\n       \"device_code\" text not null primary key,\n       \"user_code\" text not null unique,\n       \"client_id\" text,\n       \"scope\" text,\n       \"session_id\" text,\n       \"approved\" boolean,\n       \"poll_interval\" bigint,\n       \"last_polled_at\" bigint,\n       \"created_at\" bigint,\n       \"expires_at\" bigint) ;\n",
+			},
+		},
+		{
+			Id: "0017_add_client_resources.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE client_identity ADD COLUMN \"resources\" text;\n\nUPDATE \"client_identity\" SET \"resources\" = '';\n",
+			},
+		},
+		{
+			Id: "0018_add_session_auth_time.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE session ADD COLUMN \"auth_time\" bigint;\n\nUPDATE \"session\" SET \"auth_time\" = 0;\n",
+			},
+		},
+		{
+			Id: "0019_add_sso_session.sql",
+			Up: []string{
+				"-- +migrate Up\nCREATE TABLE IF NOT EXISTS \"sso_session\" (\n       \"id\" text not null primary key,\n       \"user_id\" text,\n       \"connector_id\" text,\n       \"identity\" text,\n       \"auth_time\" bigint,\n       \"created_at\" bigint,\n       \"expires_at\" bigint) ;\n\nALTER TABLE session ADD COLUMN \"sso_session_id\" text;\n\nUPDATE \"session\" SET \"sso_session_id\" = '';\n",
+			},
+		},
+		{
+			Id: "0020_add_client_post_logout_redirect_uris.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE client_identity ADD COLUMN \"post_logout_redirect_uris\" text;\n\nUPDATE \"client_identity\" SET \"post_logout_redirect_uris\" = '';\n",
+			},
+		},
+		{
+			Id: "0021_add_backchannel_logout.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE client_identity ADD COLUMN \"backchannel_logout_uri\" text;\n\nUPDATE \"client_identity\" SET \"backchannel_logout_uri\" = '';\n\nALTER TABLE sso_session ADD COLUMN \"client_ids\" text;\n\nUPDATE \"sso_session\" SET \"client_ids\" = '';\n",
+			},
+		},
+		{
+			Id: "0022_add_consent.sql",
+			Up: []string{
+				"-- +migrate Up\nCREATE TABLE IF NOT EXISTS \"consent\" (\n       \"user_id\" text not null,\n       \"client_id\" text not null,\n       \"scope\" text,\n       \"updated_at\" bigint,\n       primary key (\"user_id\", \"client_id\")) ;\n\nALTER TABLE client_identity ADD COLUMN \"first_party\" boolean;\n\n-- Clients which existed before consent was introduced keep logging users in\n-- without asking them.\nUPDATE \"client_identity\" SET \"first_party\" = true;\n",
+			},
+		},
+		{
+			Id: "0023_add_client_assertion.sql",
+			Up: []string{
+				"-- +migrate Up\nCREATE TABLE IF NOT EXISTS \"client_assertion\" (\n       \"client_id\" text not null,\n       \"jti\" text not null,\n       \"expires_at\" bigint,\n       primary key (\"client_id\", \"jti\")) ;\n\nALTER TABLE client_identity ADD COLUMN \"assertion_secret\" bytea;\n",
+			},
+		},
+		{
+			Id: "0024_add_pushed_auth_request.sql",
+			Up: []string{
+				"-- +migrate Up\nCREATE TABLE IF NOT EXISTS \"pushed_auth_request\" (\n       \"request_uri\" text not null,\n       \"client_id\" text,\n       \"params\" text,\n       \"expires_at\" bigint,\n       primary key (\"request_uri\")) ;\n\nALTER TABLE client_identity ADD COLUMN \"require_pushed_auth_requests\" boolean;\n\nUPDATE \"client_identity\" SET \"require_pushed_auth_requests\" = false;\n",
+			},
+		},
+		{
+			Id: "0025_add_session_claims_request.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE session ADD COLUMN \"claims_request\" text;\n\nUPDATE \"session\" SET \"claims_request\" = '';\n",
+			},
+		},
+		{
+			Id: "0026_add_pairwise_subject.sql",
+			Up: []string{
+				"-- +migrate Up\nCREATE TABLE IF NOT EXISTS \"pairwise_subject\" (\n       \"subject\" text not null,\n       \"sector_identifier\" text,\n       \"user_id\" text,\n       primary key (\"subject\")) ;\n",
+			},
+		},
+		{
+			Id: "0027_add_client_registration_access_token.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE client_identity ADD COLUMN \"registration_access_token\" bytea;\n",
+			},
+		},
+		{
+			Id: "0028_add_refresh_token_family.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE refresh_token ADD COLUMN \"family_id\" bigint;\nALTER TABLE refresh_token ADD COLUMN \"parent_id\" bigint;\nALTER TABLE refresh_token ADD COLUMN \"rotated\" boolean;\nALTER TABLE refresh_token ADD COLUMN \"expires_at\" bigint;\nALTER TABLE refresh_token ADD COLUMN \"family_expires_at\" bigint;\n\nUPDATE \"refresh_token\" SET \"family_id\" = \"id\", \"parent_id\" = 0, \"rotated\" = false, \"expires_at\" = 0, \"family_expires_at\" = 0;\n",
+			},
+		},
+		{
+			Id: "0029_add_client_token_policy.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE client_identity ADD COLUMN \"id_token_lifetime\" bigint;\nALTER TABLE client_identity ADD COLUMN \"access_token_lifetime\" bigint;\nALTER TABLE client_identity ADD COLUMN \"refresh_token_lifetime\" bigint;\nALTER TABLE client_identity ADD COLUMN \"allowed_grant_types\" text;\nALTER TABLE client_identity ADD COLUMN \"allowed_scopes\" text;\n\nUPDATE \"client_identity\" SET \"id_token_lifetime\" = 0, \"access_token_lifetime\" = 0, \"refresh_token_lifetime\" = 0, \"allowed_grant_types\" = '', \"allowed_scopes\" = '';\n",
+			},
+		},
+		{
+			Id: "0030_add_session_response_type.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE session ADD COLUMN \"response_type\" text;\nALTER TABLE session ADD COLUMN \"response_mode\" text;\n\nUPDATE \"session\" SET \"response_type\" = '', \"response_mode\" = '';\n",
+			},
+		},
+		{
+			Id: "0031_add_client_native_redirect_uris.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE client_identity ADD COLUMN \"native_redirect_uris\" text;\n\nUPDATE \"client_identity\" SET \"native_redirect_uris\" = '';\n",
+			},
+		},
+		{
+			Id: "0032_add_token_exchange_peers.sql",
+			Up: []string{
+				"-- +migrate Up\nCREATE TABLE IF NOT EXISTS \"token_exchange_peers\" (\n       \"client_id\" text not null,\n       \"peer_client_id\" text not null,\n       primary key (\"client_id\", \"peer_client_id\")) ;\n",
+			},
+		},
+		{
+			Id: "0033_add_session_sso_login_nonce.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE session ADD COLUMN \"sso_login_nonce\" text;\n\nUPDATE \"session\" SET \"sso_login_nonce\" = '';\n",
+			},
+		},
+		{
+			Id: "0034_add_session_auth_error.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE session ADD COLUMN \"auth_error\" text;\n\nUPDATE \"session\" SET \"auth_error\" = '';\n",
+			},
+		},
+		{
+			Id: "0035_add_session_prompt_consent.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE session ADD COLUMN \"prompt_consent\" boolean;\n\nUPDATE \"session\" SET \"prompt_consent\" = false;\n",
+			},
+		},
+		{
+			Id: "0036_add_refresh_token_claims_request.sql",
+			Up: []string{
+				"-- +migrate Up\nALTER TABLE refresh_token ADD COLUMN \"claims_request\" text;\n\nUPDATE \"refresh_token\" SET \"claims_request\" = '';\n",
+			},
+		},
 	},
 }
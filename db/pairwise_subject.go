@@ -0,0 +1,82 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/go-gorp/gorp"
+
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/user"
+)
+
+const (
+	pairwiseSubjectTableName = "pairwise_subject"
+)
+
+func init() {
+	register(table{
+		name:    pairwiseSubjectTableName,
+		model:   pairwiseSubjectModel{},
+		autoinc: false,
+		pkey:    []string{"subject"},
+	})
+}
+
+type pairwiseSubjectModel struct {
+	Subject          string `db:"subject"`
+	SectorIdentifier string `db:"sector_identifier"`
+	UserID           string `db:"user_id"`
+}
+
+func (m *pairwiseSubjectModel) pairwiseSubject() user.PairwiseSubject {
+	return user.PairwiseSubject{
+		Subject:          m.Subject,
+		SectorIdentifier: m.SectorIdentifier,
+		UserID:           m.UserID,
+	}
+}
+
+func newPairwiseSubjectModel(ps *user.PairwiseSubject) *pairwiseSubjectModel {
+	return &pairwiseSubjectModel{
+		Subject:          ps.Subject,
+		SectorIdentifier: ps.SectorIdentifier,
+		UserID:           ps.UserID,
+	}
+}
+
+func NewPairwiseSubjectRepo(dbm *gorp.DbMap) *PairwiseSubjectRepo {
+	return &PairwiseSubjectRepo{db: &db{dbm}}
+}
+
+type PairwiseSubjectRepo struct {
+	*db
+}
+
+func (r *PairwiseSubjectRepo) Create(ps user.PairwiseSubject) error {
+	err := r.executor(nil).Insert(newPairwiseSubjectModel(&ps))
+	if err != nil && isAlreadyExistsErr(err) {
+		// Pairwise subjects are derived from the sector and user, so an
+		// existing row records the same subject.
+		return nil
+	}
+	return err
+}
+
+func (r *PairwiseSubjectRepo) Get(subject string) (user.PairwiseSubject, error) {
+	m, err := r.executor(nil).Get(pairwiseSubjectModel{}, subject)
+	if err != nil {
+		return user.PairwiseSubject{}, err
+	}
+
+	if m == nil {
+		return user.PairwiseSubject{}, user.ErrorNotFound
+	}
+
+	pm, ok := m.(*pairwiseSubjectModel)
+	if !ok {
+		log.Errorf("expected pairwiseSubjectModel but found %v", reflect.TypeOf(m))
+		return user.PairwiseSubject{}, errors.New("unrecognized model")
+	}
+	return pm.pairwiseSubject(), nil
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/coreos/dex/user"
+)
+
+func TestPairwiseSubjectRepo(t *testing.T) {
+	r := NewPairwiseSubjectRepo(NewMemDB())
+
+	ps := user.NewPairwiseSubject("client.example.com", "ID-1", []byte("salt"))
+	if err := r.Create(ps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Subjects are recorded each time they're issued.
+	if err := r.Create(ps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := r.Get(ps.Subject)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(ps, got); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	if _, err := r.Get("ID-1"); err != user.ErrorNotFound {
+		t.Errorf("want %v, got %v", user.ErrorNotFound, err)
+	}
+}
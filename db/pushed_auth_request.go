@@ -0,0 +1,147 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jonboulle/clockwork"
+
+	"github.com/coreos/dex/par"
+	"github.com/coreos/dex/pkg/log"
+)
+
+const (
+	pushedAuthRequestTableName = "pushed_auth_request"
+)
+
+func init() {
+	register(table{
+		name:    pushedAuthRequestTableName,
+		model:   pushedAuthRequestModel{},
+		autoinc: false,
+		pkey:    []string{"request_uri"},
+	})
+}
+
+type pushedAuthRequestModel struct {
+	RequestURI string `db:"request_uri"`
+	ClientID   string `db:"client_id"`
+
+	// Params are the URL encoded authorization request parameters.
+	Params string `db:"params"`
+
+	ExpiresAt int64 `db:"expires_at"`
+}
+
+func (m *pushedAuthRequestModel) request() (*par.Request, error) {
+	params, err := url.ParseQuery(m.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	r := par.Request{
+		RequestURI: m.RequestURI,
+		ClientID:   m.ClientID,
+		Params:     params,
+	}
+
+	if m.ExpiresAt != 0 {
+		r.ExpiresAt = time.Unix(m.ExpiresAt, 0).UTC()
+	}
+
+	return &r, nil
+}
+
+func newPushedAuthRequestModel(r *par.Request) *pushedAuthRequestModel {
+	m := pushedAuthRequestModel{
+		RequestURI: r.RequestURI,
+		ClientID:   r.ClientID,
+		Params:     r.Params.Encode(),
+	}
+
+	if !r.ExpiresAt.IsZero() {
+		m.ExpiresAt = r.ExpiresAt.Unix()
+	}
+
+	return &m
+}
+
+func NewPushedAuthRequestRepo(dbm *gorp.DbMap) *PushedAuthRequestRepo {
+	return NewPushedAuthRequestRepoWithClock(dbm, clockwork.NewRealClock())
+}
+
+func NewPushedAuthRequestRepoWithClock(dbm *gorp.DbMap, clock clockwork.Clock) *PushedAuthRequestRepo {
+	return &PushedAuthRequestRepo{db: &db{dbm}, clock: clock}
+}
+
+type PushedAuthRequestRepo struct {
+	*db
+	clock clockwork.Clock
+}
+
+func (r *PushedAuthRequestRepo) Create(req par.Request) error {
+	return r.executor(nil).Insert(newPushedAuthRequestModel(&req))
+}
+
+func (r *PushedAuthRequestRepo) Get(requestURI string) (*par.Request, error) {
+	m, err := r.executor(nil).Get(pushedAuthRequestModel{}, requestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, par.ErrorNotFound
+	}
+
+	pm, ok := m.(*pushedAuthRequestModel)
+	if !ok {
+		log.Errorf("expected pushedAuthRequestModel but found %v", reflect.TypeOf(m))
+		return nil, errors.New("unrecognized model")
+	}
+
+	req, err := pm.request()
+	if err != nil {
+		return nil, err
+	}
+	if req.ExpiresAt.Before(r.clock.Now()) {
+		return nil, par.ErrorExpired
+	}
+	return req, nil
+}
+
+func (r *PushedAuthRequestRepo) Delete(requestURI string) error {
+	qt := r.quote(pushedAuthRequestTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE request_uri = $1", qt)
+	res, err := r.executor(nil).Exec(q, requestURI)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return par.ErrorNotFound
+	}
+	return nil
+}
+
+func (r *PushedAuthRequestRepo) purge() error {
+	qt := r.quote(pushedAuthRequestTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE expires_at < $1", qt)
+	res, err := r.executor(nil).Exec(q, r.clock.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	d := "unknown # of"
+	if n, err := res.RowsAffected(); err == nil {
+		if n == 0 {
+			return nil
+		}
+		d = fmt.Sprintf("%d", n)
+	}
+
+	log.Infof("Deleted %s stale row(s) from %s table", d, pushedAuthRequestTableName)
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/coreos/dex/par"
+)
+
+func TestPushedAuthRequestRepo(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	r := NewPushedAuthRequestRepoWithClock(NewMemDB(), clock)
+
+	req := par.Request{
+		RequestURI: par.RequestURIPrefix + "abc",
+		ClientID:   "client.example.com",
+		Params: url.Values{
+			"client_id":     {"client.example.com"},
+			"response_type": {"code"},
+			"scope":         {"openid email"},
+		},
+		ExpiresAt: clock.Now().Add(par.DefaultValidityWindow),
+	}
+	if err := r.Create(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := r.Get(req.RequestURI)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(req, got); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	if _, err := r.Get(par.RequestURIPrefix + "xyz"); err != par.ErrorNotFound {
+		t.Errorf("want %v, got %v", par.ErrorNotFound, err)
+	}
+
+	if err := r.Delete(req.RequestURI); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.Get(req.RequestURI); err != par.ErrorNotFound {
+		t.Errorf("want %v, got %v", par.ErrorNotFound, err)
+	}
+
+	// Expired requests are no longer returned, and are purged.
+	if err := r.Create(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock.Advance(par.DefaultValidityWindow + time.Second)
+	if _, err := r.Get(req.RequestURI); err != par.ErrorExpired {
+		t.Errorf("want %v, got %v", par.ErrorExpired, err)
+	}
+	if err := r.purge(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Delete(req.RequestURI); err != par.ErrorNotFound {
+		t.Errorf("want %v, got %v", par.ErrorNotFound, err)
+	}
+}
@@ -2,13 +2,16 @@ package db
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-gorp/gorp"
+	"github.com/jonboulle/clockwork"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/coreos/dex/client"
@@ -16,6 +19,7 @@ import (
 	"github.com/coreos/dex/refresh"
 	"github.com/coreos/dex/repo"
 	"github.com/coreos/dex/scope"
+	"github.com/coreos/dex/session"
 )
 
 const (
@@ -33,7 +37,10 @@ func init() {
 
 type refreshTokenRepo struct {
 	*db
-	tokenGenerator refresh.RefreshTokenGenerator
+	tokenGenerator   refresh.RefreshTokenGenerator
+	clock            clockwork.Clock
+	absoluteLifetime time.Duration
+	idleLifetime     time.Duration
 }
 
 type refreshTokenModel struct {
@@ -43,6 +50,26 @@ type refreshTokenModel struct {
 	ClientID    string `db:"client_id"`
 	ConnectorID string `db:"connector_id"`
 	Scopes      string `db:"scopes"`
+
+	// ClaimsRequest is the JSON encoded claims request the token was issued
+	// with, or empty.
+	ClaimsRequest string `db:"claims_request"`
+
+	// FamilyID is the ID of the token the token was renewed from, directly
+	// or through other renewals, which was created when the user authorized
+	// the client. ParentID is the ID of the token it was directly renewed
+	// from, or 0.
+	FamilyID int64 `db:"family_id"`
+	ParentID int64 `db:"parent_id"`
+
+	// Rotated tokens have been renewed, and are only kept to detect them
+	// being presented again.
+	Rotated bool `db:"rotated"`
+
+	// ExpiresAt is when the token expires, and FamilyExpiresAt when every
+	// token of its family does, in seconds since the epoch. 0 if they don't.
+	ExpiresAt       int64 `db:"expires_at"`
+	FamilyExpiresAt int64 `db:"family_expires_at"`
 }
 
 // buildToken combines the token ID and token payload to create a new token.
@@ -79,22 +106,73 @@ func checkTokenPayload(payloadHash, payload []byte) error {
 	return nil
 }
 
+type RefreshTokenRepoOptions struct {
+	TokenGenerator refresh.RefreshTokenGenerator
+	Clock          clockwork.Clock
+
+	// AbsoluteLifetime limits how long after the user authorized the client
+	// its refresh tokens can be used, however often they're renewed.
+	AbsoluteLifetime time.Duration
+
+	// IdleLifetime limits how long a refresh token can be used before it
+	// must be renewed.
+	IdleLifetime time.Duration
+}
+
 func NewRefreshTokenRepo(dbm *gorp.DbMap) refresh.RefreshTokenRepo {
-	return NewRefreshTokenRepoWithGenerator(dbm, refresh.DefaultRefreshTokenGenerator)
+	return NewRefreshTokenRepoWithOptions(dbm, RefreshTokenRepoOptions{})
 }
 
 func NewRefreshTokenRepoWithGenerator(dbm *gorp.DbMap, gen refresh.RefreshTokenGenerator) refresh.RefreshTokenRepo {
+	return NewRefreshTokenRepoWithOptions(dbm, RefreshTokenRepoOptions{TokenGenerator: gen})
+}
+
+// NewRefreshTokenRepoWithOptions returns a refresh token repo whose tokens
+// expire after the lifetimes of the options. Zero lifetimes are unlimited.
+func NewRefreshTokenRepoWithOptions(dbm *gorp.DbMap, options RefreshTokenRepoOptions) refresh.RefreshTokenRepo {
+	return newRefreshTokenRepo(dbm, options)
+}
+
+func newRefreshTokenRepo(dbm *gorp.DbMap, options RefreshTokenRepoOptions) *refreshTokenRepo {
+	if options.TokenGenerator == nil {
+		options.TokenGenerator = refresh.DefaultRefreshTokenGenerator
+	}
+	if options.Clock == nil {
+		options.Clock = clockwork.NewRealClock()
+	}
 	return &refreshTokenRepo{
-		db:             &db{dbm},
-		tokenGenerator: gen,
+		db:               &db{dbm},
+		tokenGenerator:   options.TokenGenerator,
+		clock:            options.Clock,
+		absoluteLifetime: options.AbsoluteLifetime,
+		idleLifetime:     options.IdleLifetime,
 	}
 }
 
-func (r *refreshTokenRepo) Create(userID, clientID, connectorID string, scopes []string) (string, error) {
-	return r.create(nil, userID, clientID, connectorID, scopes)
+func (r *refreshTokenRepo) Create(userID, clientID, connectorID string, scopes []string, claims *session.ClaimsRequest) (string, error) {
+	var claimsRequest string
+	if claims != nil {
+		data, err := json.Marshal(claims)
+		if err != nil {
+			return "", err
+		}
+		claimsRequest = string(data)
+	}
+
+	tx, err := r.begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	token, err := r.create(tx, userID, clientID, connectorID, scopes, claimsRequest, nil)
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
 }
 
-func (r *refreshTokenRepo) Verify(clientID, token string) (userID, connectorID string, scope scope.Scopes, err error) {
+func (r *refreshTokenRepo) Verify(clientID, token string) (userID, connectorID string, scope scope.Scopes, claims *session.ClaimsRequest, err error) {
 	return r.verify(nil, clientID, token)
 }
 
@@ -113,23 +191,35 @@ func (r *refreshTokenRepo) Revoke(userID, token string) error {
 
 func (r *refreshTokenRepo) RenewRefreshToken(clientID, userID, oldToken string) (newRefreshToken string, err error) {
 	// Verify
-	userID, connectorID, scopes, err := r.verify(nil, clientID, oldToken)
+	record, err := r.verifyRecord(nil, clientID, oldToken)
 	if err != nil {
 		return "", err
 	}
 
-	// Revoke old refresh token
+	// Rotate old refresh token
 	tx, err := r.begin()
 	if err != nil {
 		return "", err
 	}
 	defer tx.Rollback()
-	if err := r.revoke(tx, userID, oldToken); err != nil {
+	q := fmt.Sprintf("UPDATE %s SET rotated = $1 WHERE id = $2 AND rotated = $3", r.quote(refreshTokenTableName))
+	res, err := r.executor(tx).Exec(q, true, record.ID, false)
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err != nil {
 		return "", err
+	} else if n == 0 {
+		// The token was concurrently renewed with another request.
+		tx.Rollback()
+		if err := r.reused(record); err != nil {
+			return "", err
+		}
+		return "", refresh.ErrorInvalidToken
 	}
 
 	// Renew refresh token
-	newRefreshToken, err = r.create(tx, userID, clientID, connectorID, scopes)
+	newRefreshToken, err = r.create(tx, record.UserID, clientID, record.ConnectorID, record.scopes(), record.ClaimsRequest, record)
 	if err != nil {
 		return "", err
 	}
@@ -145,10 +235,10 @@ func (r *refreshTokenRepo) RevokeTokensForClient(userID, clientID string) error
 
 func (r *refreshTokenRepo) ClientsWithRefreshTokens(userID string) ([]client.Client, error) {
 	q := `SELECT c.* FROM %s as c
-	INNER JOIN %s as r ON c.id = r.client_id WHERE r.user_id = $1;`
+	INNER JOIN %s as r ON c.id = r.client_id WHERE r.user_id = $1 AND r.rotated = $2;`
 	q = fmt.Sprintf(q, r.quote(clientTableName), r.quote(refreshTokenTableName))
 	var clients []clientModel
-	if _, err := r.executor(nil).Select(&clients, q, userID); err != nil {
+	if _, err := r.executor(nil).Select(&clients, q, userID, false); err != nil {
 		return nil, err
 	}
 
@@ -185,36 +275,81 @@ func (r *refreshTokenRepo) get(tx repo.Transaction, tokenID int64) (*refreshToke
 	return record, nil
 }
 
-func (r *refreshTokenRepo) verify(tx repo.Transaction, clientID, token string) (userID, connectorID string, scope scope.Scopes, err error) {
-	tokenID, tokenPayload, err := parseToken(token)
+func (r *refreshTokenRepo) verify(tx repo.Transaction, clientID, token string) (userID, connectorID string, scope scope.Scopes, claims *session.ClaimsRequest, err error) {
+	record, err := r.verifyRecord(tx, clientID, token)
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	if record.ClaimsRequest != "" {
+		if err := json.Unmarshal([]byte(record.ClaimsRequest), &claims); err != nil {
+			return "", "", nil, nil, err
+		}
+	}
+	return record.UserID, record.ConnectorID, record.scopes(), claims, nil
+}
 
+// verifyRecord returns the record of a token which belongs to the client and
+// hasn't expired. Presenting a token which was already renewed revokes every
+// token of its family, since either the client or whoever presented the token
+// must have stolen it.
+func (r *refreshTokenRepo) verifyRecord(tx repo.Transaction, clientID, token string) (*refreshTokenModel, error) {
+	tokenID, tokenPayload, err := parseToken(token)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	record, err := r.get(tx, tokenID)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	if record.ClientID != clientID {
-		return "", "", nil, refresh.ErrorInvalidClientID
+		return nil, refresh.ErrorInvalidClientID
 	}
 
 	// Check if the hash of token received is the same stored in database
 	if err = checkTokenPayload(record.PayloadHash, tokenPayload); err != nil {
-		return
+		return nil, err
 	}
 
-	var scopes []string
-	if len(record.Scopes) > 0 {
-		scopes = strings.Split(record.Scopes, " ")
+	if record.Rotated {
+		if err := r.reused(record); err != nil {
+			return nil, err
+		}
+		return nil, refresh.ErrorInvalidToken
 	}
 
-	return record.UserID, record.ConnectorID, scopes, nil
+	if record.ExpiresAt != 0 && r.clock.Now().Unix() >= record.ExpiresAt {
+		return nil, refresh.ErrorInvalidToken
+	}
+
+	return record, nil
+}
+
+// reused revokes the family of a token which was presented after it was
+// renewed.
+func (r *refreshTokenRepo) reused(record *refreshTokenModel) error {
+	log.Warningf("Security event: refresh token %d of client %s was presented after it was renewed, revoking its family for user %s",
+		record.ID, record.ClientID, record.UserID)
+	return r.revokeFamily(nil, record.FamilyID)
 }
 
-func (r *refreshTokenRepo) create(tx repo.Transaction, userID, clientID, connectorID string, scopes []string) (string, error) {
+func (r *refreshTokenRepo) revokeFamily(tx repo.Transaction, familyID int64) error {
+	q := fmt.Sprintf("DELETE FROM %s WHERE family_id = $1", r.quote(refreshTokenTableName))
+	_, err := r.executor(tx).Exec(q, familyID)
+	return err
+}
+
+func (m *refreshTokenModel) scopes() scope.Scopes {
+	if len(m.Scopes) == 0 {
+		return nil
+	}
+	return strings.Split(m.Scopes, " ")
+}
+
+// create generates a new token, which is renewed from parent, or starts a new
+// family if parent is nil. The claims request is JSON encoded.
+func (r *refreshTokenRepo) create(tx repo.Transaction, userID, clientID, connectorID string, scopes []string, claimsRequest string, parent *refreshTokenModel) (string, error) {
 	if userID == "" {
 		return "", refresh.ErrorInvalidUserID
 	}
@@ -234,20 +369,67 @@ func (r *refreshTokenRepo) create(tx repo.Transaction, userID, clientID, connect
 	}
 
 	record := &refreshTokenModel{
-		PayloadHash: payloadHash,
-		UserID:      userID,
-		ClientID:    clientID,
-		ConnectorID: connectorID,
-		Scopes:      strings.Join(scopes, " "),
+		PayloadHash:   payloadHash,
+		UserID:        userID,
+		ClientID:      clientID,
+		ConnectorID:   connectorID,
+		Scopes:        strings.Join(scopes, " "),
+		ClaimsRequest: claimsRequest,
+	}
+
+	now := r.clock.Now()
+	if parent != nil {
+		record.FamilyID = parent.FamilyID
+		record.ParentID = parent.ID
+		record.FamilyExpiresAt = parent.FamilyExpiresAt
+	} else {
+		absoluteLifetime, err := r.clientAbsoluteLifetime(tx, clientID)
+		if err != nil {
+			return "", err
+		}
+		if absoluteLifetime > 0 {
+			record.FamilyExpiresAt = now.Add(absoluteLifetime).Unix()
+		}
+	}
+	record.ExpiresAt = record.FamilyExpiresAt
+	if r.idleLifetime > 0 {
+		idleExpiresAt := now.Add(r.idleLifetime).Unix()
+		if record.ExpiresAt == 0 || idleExpiresAt < record.ExpiresAt {
+			record.ExpiresAt = idleExpiresAt
+		}
 	}
 
-	if err := r.executor(tx).Insert(record); err != nil {
+	exec := r.executor(tx)
+	if err := exec.Insert(record); err != nil {
 		return "", err
 	}
+	if parent == nil {
+		record.FamilyID = record.ID
+		if _, err := exec.Update(record); err != nil {
+			return "", err
+		}
+	}
 
 	return buildToken(record.ID, tokenPayload), nil
 }
 
+// clientAbsoluteLifetime returns the absolute lifetime of the client's refresh
+// tokens, which is the repo's unless the client was configured with its own.
+func (r *refreshTokenRepo) clientAbsoluteLifetime(tx repo.Transaction, clientID string) (time.Duration, error) {
+	cli, err := (&clientRepo{db: r.db}).Get(tx, clientID)
+	switch err {
+	case nil:
+	case client.ErrorNotFound:
+		return r.absoluteLifetime, nil
+	default:
+		return 0, err
+	}
+	if cli.RefreshTokenLifetime > 0 {
+		return cli.RefreshTokenLifetime, nil
+	}
+	return r.absoluteLifetime, nil
+}
+
 func (r *refreshTokenRepo) revoke(tx repo.Transaction, userID, token string) error {
 	tokenID, tokenPayload, err := parseToken(token)
 	if err != nil {
@@ -268,13 +450,38 @@ func (r *refreshTokenRepo) revoke(tx repo.Transaction, userID, token string) err
 		return err
 	}
 
-	deleted, err := exec.Delete(record)
+	// The tokens the token was renewed from or to are revoked too.
+	q := fmt.Sprintf("DELETE FROM %s WHERE family_id = $1", r.quote(refreshTokenTableName))
+	res, err := exec.Exec(q, record.FamilyID)
 	if err != nil {
 		return err
 	}
-	if deleted == 0 {
+	if deleted, err := res.RowsAffected(); err == nil && deleted == 0 {
 		return refresh.ErrorInvalidToken
 	}
 
 	return nil
 }
+
+// purge deletes expired tokens, and the rotated tokens of families whose
+// latest token was revoked or expired.
+func (r *refreshTokenRepo) purge() error {
+	qt := r.quote(refreshTokenTableName)
+	q := fmt.Sprintf(`DELETE FROM %s WHERE (expires_at != 0 AND expires_at < $1)
+	OR (rotated = $2 AND family_id NOT IN (SELECT family_id FROM %s WHERE rotated = $3))`, qt, qt)
+	res, err := r.executor(nil).Exec(q, r.clock.Now().Unix(), true, false)
+	if err != nil {
+		return err
+	}
+
+	d := "unknown # of"
+	if n, err := res.RowsAffected(); err == nil {
+		if n == 0 {
+			return nil
+		}
+		d = fmt.Sprintf("%d", n)
+	}
+
+	log.Infof("Deleted %s stale row(s) from %s table", d, refreshTokenTableName)
+	return nil
+}
@@ -2,7 +2,16 @@ package db
 
 import (
 	"bytes"
+	"encoding/base64"
+	"net/url"
 	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/oidc"
+	"github.com/jonboulle/clockwork"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/refresh"
 )
 
 func TestBuildAndParseToken(t *testing.T) {
@@ -29,3 +38,136 @@ func TestBuildAndParseToken(t *testing.T) {
 		}
 	}
 }
+
+func TestRefreshTokenFamilyReuse(t *testing.T) {
+	r := newRefreshTokenRepo(NewMemDB(), RefreshTokenRepoOptions{})
+
+	token1, err := r.Create("user-1", "client-1", "local", []string{"openid"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token2, err := r.RenewRefreshToken("client-1", "user-1", token1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token3, err := r.RenewRefreshToken("client-1", "user-1", token2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, err := r.Create("user-1", "client-1", "local", []string{"openid"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, _, _ := parseToken(token3)
+	record, err := r.get(nil, id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wantFamilyID, _, _ := parseToken(token1); record.FamilyID != wantFamilyID {
+		t.Errorf("want family %d, got %d", wantFamilyID, record.FamilyID)
+	}
+	if wantParentID, _, _ := parseToken(token2); record.ParentID != wantParentID {
+		t.Errorf("want parent %d, got %d", wantParentID, record.ParentID)
+	}
+
+	// Presenting a renewed token revokes its whole family.
+	if _, err := r.RenewRefreshToken("client-1", "user-1", token1); err != refresh.ErrorInvalidToken {
+		t.Errorf("want %v, got %v", refresh.ErrorInvalidToken, err)
+	}
+	for i, token := range []string{token1, token2, token3} {
+		if _, _, _, _, err := r.Verify("client-1", token); err != refresh.ErrorInvalidToken {
+			t.Errorf("token %d: want %v, got %v", i+1, refresh.ErrorInvalidToken, err)
+		}
+	}
+	if _, _, _, _, err := r.Verify("client-1", other); err != nil {
+		t.Errorf("want other family kept, got %v", err)
+	}
+}
+
+func TestRefreshTokenLifetimes(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	r := newRefreshTokenRepo(NewMemDB(), RefreshTokenRepoOptions{
+		Clock:            clock,
+		AbsoluteLifetime: 10 * time.Hour,
+		IdleLifetime:     4 * time.Hour,
+	})
+
+	token, err := r.Create("user-1", "client-1", "local", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Tokens renewed before they're idle for too long can be used until the
+	// absolute lifetime of their family.
+	for i := 0; i < 3; i++ {
+		clock.Advance(3 * time.Hour)
+		if token, err = r.RenewRefreshToken("client-1", "user-1", token); err != nil {
+			t.Fatalf("renewal %d: unexpected error: %v", i, err)
+		}
+	}
+	clock.Advance(time.Hour)
+	if _, _, _, _, err := r.Verify("client-1", token); err != refresh.ErrorInvalidToken {
+		t.Errorf("want %v after absolute lifetime, got %v", refresh.ErrorInvalidToken, err)
+	}
+
+	idle, err := r.Create("user-1", "client-1", "local", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock.Advance(4*time.Hour + time.Second)
+	if _, _, _, _, err := r.Verify("client-1", idle); err != refresh.ErrorInvalidToken {
+		t.Errorf("want %v after idle lifetime, got %v", refresh.ErrorInvalidToken, err)
+	}
+
+	// Expired tokens, and the renewed tokens of their family, are purged.
+	if err := r.purge(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n, err := r.executor(nil).SelectInt("SELECT count(*) FROM " + r.quote(refreshTokenTableName))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("want all tokens purged, got %d", n)
+	}
+}
+
+func TestRefreshTokenClientLifetime(t *testing.T) {
+	dbm := NewMemDB()
+	clock := clockwork.NewFakeClock()
+	r := newRefreshTokenRepo(dbm, RefreshTokenRepoOptions{
+		Clock:            clock,
+		AbsoluteLifetime: 10 * time.Hour,
+	})
+
+	cli := client.Client{
+		Credentials: oidc.ClientCredentials{
+			ID:     "client-1",
+			Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
+		},
+		Metadata: oidc.ClientMetadata{
+			RedirectURIs: []url.URL{{Scheme: "https", Host: "client.example.com", Path: "/callback"}},
+		},
+		RefreshTokenLifetime: 2 * time.Hour,
+	}
+	if _, err := newClientRepo(dbm).New(nil, cli); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The client's lifetime is used instead of the repo's.
+	token, err := r.Create("user-1", "client-1", "local", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, err := r.Create("user-1", "client-2", "local", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock.Advance(2*time.Hour + time.Second)
+	if _, _, _, _, err := r.Verify("client-1", token); err != refresh.ErrorInvalidToken {
+		t.Errorf("want %v after client lifetime, got %v", refresh.ErrorInvalidToken, err)
+	}
+	if _, _, _, _, err := r.Verify("client-2", other); err != nil {
+		t.Errorf("want token of client without lifetime valid, got %v", err)
+	}
+}
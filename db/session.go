@@ -45,6 +45,24 @@ type sessionModel struct {
 	Nonce       string `db:"nonce"`
 	Scope       string `db:"scope"`
 	Groups      string `db:"groups"`
+
+	CodeChallenge       string `db:"code_challenge"`
+	CodeChallengeMethod string `db:"code_challenge_method"`
+
+	AuthTime      int64  `db:"auth_time"`
+	SSOSessionID  string `db:"sso_session_id"`
+	SSOLoginNonce string `db:"sso_login_nonce"`
+
+	// ClaimsRequest is the JSON encoded claims request parameter.
+	ClaimsRequest string `db:"claims_request"`
+
+	ResponseType string `db:"response_type"`
+	ResponseMode string `db:"response_mode"`
+
+	// AuthError is the JSON encoded error response to pass to the client.
+	AuthError string `db:"auth_error"`
+
+	PromptConsent bool `db:"prompt_consent"`
 }
 
 func (s *sessionModel) session() (*session.Session, error) {
@@ -64,23 +82,43 @@ func (s *sessionModel) session() (*session.Session, error) {
 	}
 
 	ses := session.Session{
-		ID:          s.ID,
-		State:       session.SessionState(s.State),
-		ClientID:    s.ClientID,
-		ClientState: s.ClientState,
-		RedirectURL: *ru,
-		Identity:    ident,
-		ConnectorID: s.ConnectorID,
-		UserID:      s.UserID,
-		Register:    s.Register,
-		Nonce:       s.Nonce,
-		Scope:       strings.Fields(s.Scope),
+		ID:            s.ID,
+		State:         session.SessionState(s.State),
+		ClientID:      s.ClientID,
+		ClientState:   s.ClientState,
+		RedirectURL:   *ru,
+		Identity:      ident,
+		ConnectorID:   s.ConnectorID,
+		UserID:        s.UserID,
+		Register:      s.Register,
+		PromptConsent: s.PromptConsent,
+		Nonce:         s.Nonce,
+		Scope:         strings.Fields(s.Scope),
+
+		CodeChallenge:       s.CodeChallenge,
+		CodeChallengeMethod: s.CodeChallengeMethod,
+
+		SSOSessionID:  s.SSOSessionID,
+		SSOLoginNonce: s.SSOLoginNonce,
+
+		ResponseType: s.ResponseType,
+		ResponseMode: s.ResponseMode,
 	}
 	if s.Groups != "" {
 		if err := json.Unmarshal([]byte(s.Groups), &ses.Groups); err != nil {
 			return nil, fmt.Errorf("failed to decode groups in session: %v", err)
 		}
 	}
+	if s.ClaimsRequest != "" {
+		if err := json.Unmarshal([]byte(s.ClaimsRequest), &ses.ClaimsRequest); err != nil {
+			return nil, fmt.Errorf("failed to decode claims request in session: %v", err)
+		}
+	}
+	if s.AuthError != "" {
+		if err := json.Unmarshal([]byte(s.AuthError), &ses.AuthError); err != nil {
+			return nil, fmt.Errorf("failed to decode error in session: %v", err)
+		}
+	}
 
 	if s.CreatedAt != 0 {
 		ses.CreatedAt = time.Unix(s.CreatedAt, 0).UTC()
@@ -90,6 +128,10 @@ func (s *sessionModel) session() (*session.Session, error) {
 		ses.ExpiresAt = time.Unix(s.ExpiresAt, 0).UTC()
 	}
 
+	if s.AuthTime != 0 {
+		ses.AuthTime = time.Unix(s.AuthTime, 0).UTC()
+	}
+
 	return &ses, nil
 }
 
@@ -100,17 +142,27 @@ func newSessionModel(s *session.Session) (*sessionModel, error) {
 	}
 
 	sm := sessionModel{
-		ID:          s.ID,
-		State:       string(s.State),
-		ClientID:    s.ClientID,
-		ClientState: s.ClientState,
-		RedirectURL: s.RedirectURL.String(),
-		Identity:    string(b),
-		ConnectorID: s.ConnectorID,
-		UserID:      s.UserID,
-		Register:    s.Register,
-		Nonce:       s.Nonce,
-		Scope:       strings.Join(s.Scope, " "),
+		ID:            s.ID,
+		State:         string(s.State),
+		ClientID:      s.ClientID,
+		ClientState:   s.ClientState,
+		RedirectURL:   s.RedirectURL.String(),
+		Identity:      string(b),
+		ConnectorID:   s.ConnectorID,
+		UserID:        s.UserID,
+		Register:      s.Register,
+		PromptConsent: s.PromptConsent,
+		Nonce:         s.Nonce,
+		Scope:         strings.Join(s.Scope, " "),
+
+		CodeChallenge:       s.CodeChallenge,
+		CodeChallengeMethod: s.CodeChallengeMethod,
+
+		SSOSessionID:  s.SSOSessionID,
+		SSOLoginNonce: s.SSOLoginNonce,
+
+		ResponseType: s.ResponseType,
+		ResponseMode: s.ResponseMode,
 	}
 
 	if s.Groups != nil {
@@ -121,6 +173,22 @@ func newSessionModel(s *session.Session) (*sessionModel, error) {
 		sm.Groups = string(data)
 	}
 
+	if s.ClaimsRequest != nil {
+		data, err := json.Marshal(s.ClaimsRequest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal claims request: %v", err)
+		}
+		sm.ClaimsRequest = string(data)
+	}
+
+	if s.AuthError != nil {
+		data, err := json.Marshal(s.AuthError)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal error: %v", err)
+		}
+		sm.AuthError = string(data)
+	}
+
 	if !s.CreatedAt.IsZero() {
 		sm.CreatedAt = s.CreatedAt.Unix()
 	}
@@ -129,6 +197,10 @@ func newSessionModel(s *session.Session) (*sessionModel, error) {
 		sm.ExpiresAt = s.ExpiresAt.Unix()
 	}
 
+	if !s.AuthTime.IsZero() {
+		sm.AuthTime = s.AuthTime.Unix()
+	}
+
 	return &sm, nil
 }
 
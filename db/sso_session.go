@@ -0,0 +1,241 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jonboulle/clockwork"
+
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/repo"
+	"github.com/coreos/dex/session"
+)
+
+const (
+	ssoSessionTableName = "sso_session"
+)
+
+func init() {
+	register(table{
+		name:    ssoSessionTableName,
+		model:   ssoSessionModel{},
+		autoinc: false,
+		pkey:    []string{"id"},
+	})
+}
+
+type ssoSessionModel struct {
+	ID          string `db:"id"`
+	UserID      string `db:"user_id"`
+	ConnectorID string `db:"connector_id"`
+	Identity    string `db:"identity"`
+	AuthTime    int64  `db:"auth_time"`
+	CreatedAt   int64  `db:"created_at"`
+	ExpiresAt   int64  `db:"expires_at"`
+
+	// ClientIDs is a space separated list of client IDs.
+	ClientIDs string `db:"client_ids"`
+}
+
+func (m *ssoSessionModel) ssoSession() (*session.SSOSession, error) {
+	var ident oidc.Identity
+	if err := json.Unmarshal([]byte(m.Identity), &ident); err != nil {
+		return nil, err
+	}
+	if ident.ExpiresAt.IsZero() {
+		ident.ExpiresAt = time.Time{}
+	}
+
+	s := session.SSOSession{
+		ID:          m.ID,
+		UserID:      m.UserID,
+		ConnectorID: m.ConnectorID,
+		Identity:    ident,
+	}
+	if m.ClientIDs != "" {
+		s.ClientIDs = strings.Fields(m.ClientIDs)
+	}
+
+	if m.AuthTime != 0 {
+		s.AuthTime = time.Unix(m.AuthTime, 0).UTC()
+	}
+
+	if m.CreatedAt != 0 {
+		s.CreatedAt = time.Unix(m.CreatedAt, 0).UTC()
+	}
+
+	if m.ExpiresAt != 0 {
+		s.ExpiresAt = time.Unix(m.ExpiresAt, 0).UTC()
+	}
+
+	return &s, nil
+}
+
+func newSSOSessionModel(s *session.SSOSession) (*ssoSessionModel, error) {
+	b, err := json.Marshal(s.Identity)
+	if err != nil {
+		return nil, err
+	}
+
+	m := ssoSessionModel{
+		ID:          s.ID,
+		UserID:      s.UserID,
+		ConnectorID: s.ConnectorID,
+		Identity:    string(b),
+		ClientIDs:   strings.Join(s.ClientIDs, " "),
+	}
+
+	if !s.AuthTime.IsZero() {
+		m.AuthTime = s.AuthTime.Unix()
+	}
+
+	if !s.CreatedAt.IsZero() {
+		m.CreatedAt = s.CreatedAt.Unix()
+	}
+
+	if !s.ExpiresAt.IsZero() {
+		m.ExpiresAt = s.ExpiresAt.Unix()
+	}
+
+	return &m, nil
+}
+
+func NewSSOSessionRepo(dbm *gorp.DbMap) *SSOSessionRepo {
+	return NewSSOSessionRepoWithClock(dbm, clockwork.NewRealClock())
+}
+
+func NewSSOSessionRepoWithClock(dbm *gorp.DbMap, clock clockwork.Clock) *SSOSessionRepo {
+	return &SSOSessionRepo{db: &db{dbm}, clock: clock}
+}
+
+type SSOSessionRepo struct {
+	*db
+	clock clockwork.Clock
+}
+
+func (r *SSOSessionRepo) get(tx repo.Transaction, id string) (*ssoSessionModel, error) {
+	m, err := r.executor(tx).Get(ssoSessionModel{}, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, session.ErrorSSOSessionNotFound
+	}
+
+	sm, ok := m.(*ssoSessionModel)
+	if !ok {
+		log.Errorf("expected ssoSessionModel but found %v", reflect.TypeOf(m))
+		return nil, errors.New("unrecognized model")
+	}
+	if sm.ExpiresAt < r.clock.Now().Unix() {
+		return nil, session.ErrorSSOSessionNotFound
+	}
+	return sm, nil
+}
+
+func (r *SSOSessionRepo) Get(id string) (*session.SSOSession, error) {
+	sm, err := r.get(nil, id)
+	if err != nil {
+		return nil, err
+	}
+	return sm.ssoSession()
+}
+
+func (r *SSOSessionRepo) AddClient(id, clientID string) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sm, err := r.get(tx, id)
+	if err != nil {
+		return err
+	}
+	clientIDs := strings.Fields(sm.ClientIDs)
+	for _, c := range clientIDs {
+		if c == clientID {
+			return nil
+		}
+	}
+	sm.ClientIDs = strings.Join(append(clientIDs, clientID), " ")
+	if _, err := r.executor(tx).Update(sm); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SSOSessionRepo) GetByUser(userID string) ([]session.SSOSession, error) {
+	qt := r.quote(ssoSessionTableName)
+	q := fmt.Sprintf("SELECT * FROM %s WHERE user_id = $1 AND expires_at >= $2", qt)
+	var sms []ssoSessionModel
+	if _, err := r.executor(nil).Select(&sms, q, userID, r.clock.Now().Unix()); err != nil {
+		return nil, err
+	}
+
+	ss := make([]session.SSOSession, len(sms))
+	for i, sm := range sms {
+		s, err := sm.ssoSession()
+		if err != nil {
+			return nil, err
+		}
+		ss[i] = *s
+	}
+	return ss, nil
+}
+
+func (r *SSOSessionRepo) Create(s session.SSOSession) error {
+	m, err := newSSOSessionModel(&s)
+	if err != nil {
+		return err
+	}
+	return r.executor(nil).Insert(m)
+}
+
+func (r *SSOSessionRepo) Delete(id string) error {
+	qt := r.quote(ssoSessionTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE id = $1", qt)
+	res, err := r.executor(nil).Exec(q, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return session.ErrorSSOSessionNotFound
+	}
+	return nil
+}
+
+func (r *SSOSessionRepo) DeleteByUser(userID string) error {
+	qt := r.quote(ssoSessionTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE user_id = $1", qt)
+	_, err := r.executor(nil).Exec(q, userID)
+	return err
+}
+
+func (r *SSOSessionRepo) purge() error {
+	qt := r.quote(ssoSessionTableName)
+	q := fmt.Sprintf("DELETE FROM %s WHERE expires_at < $1", qt)
+	res, err := r.executor(nil).Exec(q, r.clock.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	d := "unknown # of"
+	if n, err := res.RowsAffected(); err == nil {
+		if n == 0 {
+			return nil
+		}
+		d = fmt.Sprintf("%d", n)
+	}
+
+	log.Infof("Deleted %s stale row(s) from %s table", d, ssoSessionTableName)
+	return nil
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/oidc"
+	"github.com/jonboulle/clockwork"
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/coreos/dex/session"
+)
+
+func TestSSOSessionRepo(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	r := NewSSOSessionRepoWithClock(NewMemDB(), clock)
+
+	s := session.SSOSession{
+		ID:          "sso-1",
+		UserID:      "elroy-id",
+		ConnectorID: "local",
+		Identity:    oidc.Identity{ID: "elroy-id", Email: "elroy@example.com"},
+		AuthTime:    clock.Now(),
+		CreatedAt:   clock.Now(),
+		ExpiresAt:   clock.Now().Add(session.DefaultSSOSessionValidityWindow),
+	}
+	other := s
+	other.ID = "sso-2"
+	for _, s := range []session.SSOSession{s, other} {
+		if err := r.Create(s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	got, err := r.Get(s.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(s, got); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	if _, err := r.Get("sso-unknown"); err != session.ErrorSSOSessionNotFound {
+		t.Errorf("want %v, got %v", session.ErrorSSOSessionNotFound, err)
+	}
+
+	if err := r.Delete(s.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.Get(s.ID); err != session.ErrorSSOSessionNotFound {
+		t.Errorf("want %v, got %v", session.ErrorSSOSessionNotFound, err)
+	}
+	if err := r.Delete(s.ID); err != session.ErrorSSOSessionNotFound {
+		t.Errorf("want %v, got %v", session.ErrorSSOSessionNotFound, err)
+	}
+
+	// Expired sessions are no longer returned, and are purged.
+	clock.Advance(session.DefaultSSOSessionValidityWindow + time.Second)
+	if _, err := r.Get(other.ID); err != session.ErrorSSOSessionNotFound {
+		t.Errorf("want %v, got %v", session.ErrorSSOSessionNotFound, err)
+	}
+	if err := r.purge(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Delete(other.ID); err != session.ErrorSSOSessionNotFound {
+		t.Errorf("want %v, got %v", session.ErrorSSOSessionNotFound, err)
+	}
+}
+
+func TestSSOSessionRepoByUser(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	r := NewSSOSessionRepoWithClock(NewMemDB(), clock)
+
+	s := session.SSOSession{
+		ID:          "sso-1",
+		UserID:      "elroy-id",
+		ConnectorID: "local",
+		Identity:    oidc.Identity{ID: "elroy-id", Email: "elroy@example.com"},
+		AuthTime:    clock.Now(),
+		ClientIDs:   []string{"client-1"},
+		CreatedAt:   clock.Now(),
+		ExpiresAt:   clock.Now().Add(session.DefaultSSOSessionValidityWindow),
+	}
+	other := s
+	other.ID = "sso-2"
+	other.UserID = "penny-id"
+	for _, s := range []session.SSOSession{s, other} {
+		if err := r.Create(s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Clients are only recorded once.
+	for _, clientID := range []string{"client-2", "client-1", "client-2"} {
+		if err := r.AddClient(s.ID, clientID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := r.AddClient("sso-unknown", "client-1"); err != session.ErrorSSOSessionNotFound {
+		t.Errorf("want %v, got %v", session.ErrorSSOSessionNotFound, err)
+	}
+
+	s.ClientIDs = []string{"client-1", "client-2"}
+	got, err := r.GetByUser(s.UserID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare([]session.SSOSession{s}, got); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	if err := r.DeleteByUser(s.UserID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err = r.GetByUser(s.UserID); err != nil || len(got) != 0 {
+		t.Errorf("want no SSO sessions, got %v, err %v", got, err)
+	}
+	if _, err := r.Get(other.ID); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Expired sessions are no longer returned.
+	clock.Advance(session.DefaultSSOSessionValidityWindow + time.Second)
+	if got, err = r.GetByUser(other.UserID); err != nil || len(got) != 0 {
+		t.Errorf("want no SSO sessions, got %v, err %v", got, err)
+	}
+}
@@ -0,0 +1,137 @@
+// Package device implements storage for the OAuth 2.0 Device Authorization
+// Grant.
+// See: https://tools.ietf.org/html/rfc8628
+package device
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/coreos/dex/pkg/crypto"
+)
+
+const (
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// DefaultGrantValidityWindow is how long the user has to enter the user
+	// code and complete the login.
+	DefaultGrantValidityWindow = 10 * time.Minute
+
+	// DefaultPollInterval is the minimum amount of time clients must wait
+	// between polling requests (RFC 8628 Section 3.2).
+	DefaultPollInterval = 5 * time.Second
+
+	// SlowDownIncrement is added to the poll interval each time a client
+	// polls too frequently (RFC 8628 Section 3.5).
+	SlowDownIncrement = 5 * time.Second
+
+	deviceCodeLength = 32
+
+	// User codes are drawn from an alphabet without vowels, to avoid
+	// accidentally generating words, or easily confused characters
+	// (RFC 8628 Section 6.1).
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength  = 8
+)
+
+var (
+	ErrorNotFound = errors.New("device grant not found")
+	ErrorExpired  = errors.New("device grant expired")
+)
+
+// Grant is a pending device authorization.
+type Grant struct {
+	// DeviceCode is the secret polled with by the device.
+	DeviceCode string
+
+	// UserCode is entered by the end-user on the verification page.
+	UserCode string
+
+	ClientID string
+	Scope    []string
+
+	// SessionID is the session started by the end-user to log in on behalf
+	// of the device.
+	SessionID string
+
+	// Approved is set once the session has identified a user.
+	Approved bool
+
+	// Interval is the minimum time between polling requests.
+	Interval     time.Duration
+	LastPolledAt time.Time
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type GrantRepo interface {
+	Create(Grant) error
+
+	// GetByDeviceCode and GetByUserCode return ErrorNotFound if the grant
+	// does not exist, and ErrorExpired if it has expired.
+	GetByDeviceCode(deviceCode string) (*Grant, error)
+	GetByUserCode(userCode string) (*Grant, error)
+
+	Update(Grant) error
+	Delete(deviceCode string) error
+}
+
+// NewDeviceCode generates a random device code.
+func NewDeviceCode() (string, error) {
+	b, err := crypto.RandBytes(deviceCodeLength)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewCSRFToken generates a random token protecting the verification page's
+// confirmation form against cross-site request forgery.
+func NewCSRFToken() (string, error) {
+	return NewDeviceCode()
+}
+
+// NewUserCode generates a random user code in its normalized form.
+func NewUserCode() (string, error) {
+	code := make([]byte, 0, userCodeLength)
+	for len(code) < userCodeLength {
+		b, err := crypto.RandBytes(userCodeLength)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			// Reject values which would bias the distribution.
+			if int(c) >= 256-256%len(userCodeCharset) {
+				continue
+			}
+			code = append(code, userCodeCharset[int(c)%len(userCodeCharset)])
+			if len(code) == userCodeLength {
+				break
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// NormalizeUserCode converts a user code as entered by the end-user into its
+// normalized form, ignoring case and any separators.
+func NormalizeUserCode(s string) string {
+	s = strings.ToUpper(s)
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(userCodeCharset, r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// FormatUserCode splits a normalized user code into two halves for display.
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
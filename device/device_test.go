@@ -0,0 +1,53 @@
+package device
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewUserCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := NewUserCode()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(code) != userCodeLength {
+			t.Fatalf("want user code of length %d, got %q", userCodeLength, code)
+		}
+		if got := NormalizeUserCode(code); got != code {
+			t.Fatalf("user code %q not normalized, got %q", code, got)
+		}
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"BCDF-GHJK", "BCDFGHJK"},
+		{"bcdf-ghjk", "BCDFGHJK"},
+		{" bcdf ghjk ", "BCDFGHJK"},
+		{"BCDFGHJK", "BCDFGHJK"},
+	}
+
+	for i, tt := range tests {
+		if got := NormalizeUserCode(tt.code); got != tt.want {
+			t.Errorf("case %d: want %q, got %q", i, tt.want, got)
+		}
+	}
+}
+
+func TestFormatUserCode(t *testing.T) {
+	code := "BCDFGHJK"
+	want := "BCDF-GHJK"
+	if got := FormatUserCode(code); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if got := NormalizeUserCode(FormatUserCode(code)); got != code {
+		t.Errorf("want %q, got %q", code, got)
+	}
+	if strings.Contains(FormatUserCode("BCD"), "-") {
+		t.Errorf("unexpected separator in malformed code")
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/coreos/go-oidc/key"
 	"github.com/coreos/go-oidc/oidc"
 	"github.com/go-gorp/gorp"
 	"github.com/kylelemons/godebug/pretty"
@@ -16,6 +15,7 @@ import (
 	"github.com/coreos/dex/client/manager"
 	"github.com/coreos/dex/db"
 	"github.com/coreos/dex/session"
+	"github.com/coreos/dex/signing"
 )
 
 func connect(t *testing.T) *gorp.DbMap {
@@ -111,17 +111,16 @@ func TestDBPrivateKeySetRepoSetGet(t *testing.T) {
 	s2 := []byte("oooooooooooooooooooooooooooooooo")
 	s3 := []byte("wwwwwwwwwwwwwwwwwwwwwwwwwwwwwwww")
 
-	keys := []*key.PrivateKey{}
-	for i := 0; i < 2; i++ {
-		k, err := key.GeneratePrivateKey()
+	keys := []*signing.PrivateKey{}
+	for _, alg := range []string{signing.RS256, signing.ES256, signing.RS256} {
+		k, err := signing.GeneratePrivateKey(alg)
 		if err != nil {
-			t.Fatalf("Unable to generate RSA key: %v", err)
+			t.Fatalf("Unable to generate %s key: %v", alg, err)
 		}
 		keys = append(keys, k)
 	}
 
-	ks := key.NewPrivateKeySet(
-		[]*key.PrivateKey{keys[0], keys[1]}, time.Now().Add(time.Minute))
+	ks := signing.NewPrivateKeySet(keys, []string{signing.RS256, signing.ES256}, time.Now().Add(time.Minute))
 
 	tests := []struct {
 		setSecrets [][]byte
@@ -111,6 +111,23 @@ func TestSessionRepoCreateGet(t *testing.T) {
 			Nonce:       "oncenay",
 			Groups:      []string{"group1", "group2"},
 		},
+		session.Session{
+			ID:          "claims",
+			ClientState: "blargh",
+			ExpiresAt:   time.Unix(789, 0).UTC(),
+			ClaimsRequest: &session.ClaimsRequest{
+				UserInfo: map[string]*session.ClaimRequest{"email_verified": {Essential: true}},
+				IDToken:  map[string]*session.ClaimRequest{"groups": nil},
+			},
+		},
+		session.Session{
+			ID:           "implicit",
+			ClientState:  "blargh",
+			ExpiresAt:    time.Unix(789, 0).UTC(),
+			Nonce:        "oncenay",
+			ResponseType: "id_token token",
+			ResponseMode: "form_post",
+		},
 	}
 
 	for i, tt := range tests {
@@ -20,6 +20,7 @@ import (
 	phttp "github.com/coreos/dex/pkg/http"
 	"github.com/coreos/dex/refresh/refreshtest"
 	"github.com/coreos/dex/server"
+	"github.com/coreos/dex/session"
 	"github.com/coreos/dex/session/manager"
 	"github.com/coreos/dex/user"
 )
@@ -231,7 +232,12 @@ func TestHTTPExchangeTokenRefreshToken(t *testing.T) {
 
 	// this will actually happen due to some interaction between the
 	// end-user and a remote identity provider
-	sessionID, err := sm.NewSession("bogus_idpc", ci.Credentials.ID, "bogus", url.URL{}, "", false, []string{"openid", "offline_access", "email", "profile"})
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: "bogus_idpc",
+		ClientID:    ci.Credentials.ID,
+		ClientState: "bogus",
+		Scope:       []string{"openid", "offline_access", "email", "profile"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
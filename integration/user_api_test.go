@@ -21,6 +21,7 @@ import (
 	"github.com/coreos/dex/db"
 	schema "github.com/coreos/dex/schema/workerschema"
 	"github.com/coreos/dex/server"
+	"github.com/coreos/dex/session"
 	"github.com/coreos/dex/user"
 	"github.com/coreos/dex/user/api"
 )
@@ -148,14 +149,15 @@ func makeUserAPITestFixtures(clientCredsFlag bool) *userAPITestFixtures {
 		return []key.PublicKey{*key.NewPublicKey(testPrivKey.JWK())}
 	}
 
-	jwtvFactory := func(clientID string) oidc.JWTVerifier {
-		return oidc.NewJWTVerifier(testIssuerURL.String(), clientID, noop, keysFunc)
+	jwtvFactory := func(clientID string) server.JWTVerifier {
+		v := oidc.NewJWTVerifier(testIssuerURL.String(), clientID, noop, keysFunc)
+		return &v
 	}
 
 	refreshRepo := db.NewRefreshTokenRepo(dbMap)
 	for _, user := range userUsers {
 		if _, err := refreshRepo.Create(user.User.ID, testClientID,
-			"", append([]string{"offline_access"}, oidc.DefaultScope...)); err != nil {
+			"", append([]string{"offline_access"}, oidc.DefaultScope...), nil); err != nil {
 			panic("Failed to create refresh token: " + err.Error())
 		}
 	}
@@ -163,7 +165,7 @@ func makeUserAPITestFixtures(clientCredsFlag bool) *userAPITestFixtures {
 	f.emailer = &testEmailer{}
 	um.Clock = clock
 
-	api := api.NewUsersAPI(um, clientManager, refreshRepo, f.emailer, "local", clientCredsFlag)
+	api := api.NewUsersAPI(um, clientManager, refreshRepo, db.NewSSOSessionRepo(dbMap), db.NewConsentRepo(dbMap), db.NewPairwiseSubjectRepo(dbMap), testLogoutNotifier{}, f.emailer, "local", clientCredsFlag)
 	usrSrv := server.NewUserMgmtServer(api, jwtvFactory, um, clientManager, clientCredsFlag)
 	f.hSrv = httptest.NewServer(usrSrv.HTTPHandler())
 
@@ -997,6 +999,10 @@ func TestResendEmailInvitation(t *testing.T) {
 	}
 }
 
+type testLogoutNotifier struct{}
+
+func (testLogoutNotifier) NotifyLogout(session.SSOSession) {}
+
 type testEmailer struct {
 	cantEmail       bool
 	lastEmail       string
@@ -0,0 +1,60 @@
+// Package par implements storage for OAuth 2.0 Pushed Authorization Requests.
+// See: https://tools.ietf.org/html/rfc9126
+package par
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/coreos/dex/pkg/crypto"
+)
+
+const (
+	// RequestURIPrefix begins every request URI handed out for a pushed
+	// authorization request (RFC 9126 Section 2.2).
+	RequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+	// DefaultValidityWindow is how long the client has to send the user to
+	// the authorization endpoint with the request URI.
+	DefaultValidityWindow = 90 * time.Second
+
+	requestURILength = 32
+)
+
+var (
+	ErrorNotFound = errors.New("pushed authorization request not found")
+	ErrorExpired  = errors.New("pushed authorization request expired")
+)
+
+// Request is an authorization request pushed by a client, to be referred to by
+// its request URI.
+type Request struct {
+	RequestURI string
+	ClientID   string
+
+	// Params are the authorization request parameters.
+	Params url.Values
+
+	ExpiresAt time.Time
+}
+
+type RequestRepo interface {
+	Create(Request) error
+
+	// Get returns ErrorNotFound if the request does not exist, and
+	// ErrorExpired if it has expired.
+	Get(requestURI string) (*Request, error)
+
+	Delete(requestURI string) error
+}
+
+// NewRequestURI generates a random request URI.
+func NewRequestURI() (string, error) {
+	b, err := crypto.RandBytes(requestURILength)
+	if err != nil {
+		return "", err
+	}
+	return RequestURIPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
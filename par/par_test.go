@@ -0,0 +1,23 @@
+package par
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewRequestURI(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		uri, err := NewRequestURI()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.HasPrefix(uri, RequestURIPrefix) {
+			t.Fatalf("want request URI with prefix %q, got %q", RequestURIPrefix, uri)
+		}
+		if seen[uri] {
+			t.Fatalf("request URI %q generated twice", uri)
+		}
+		seen[uri] = true
+	}
+}
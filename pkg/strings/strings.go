@@ -0,0 +1,11 @@
+package strings
+
+// ContainsString reports whether s is an element of list.
+func ContainsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
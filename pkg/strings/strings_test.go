@@ -0,0 +1,35 @@
+package strings
+
+import (
+	"testing"
+)
+
+func TestContainsString(t *testing.T) {
+	tests := []struct {
+		list []string
+		s    string
+		want bool
+	}{
+		{
+			list: []string{"openid", "email"},
+			s:    "email",
+			want: true,
+		},
+		{
+			list: []string{"openid", "email"},
+			s:    "mail",
+			want: false,
+		},
+		{
+			list: nil,
+			s:    "",
+			want: false,
+		},
+	}
+
+	for i, tt := range tests {
+		if got := ContainsString(tt.list, tt.s); got != tt.want {
+			t.Errorf("case %d: want %t, got %t", i, tt.want, got)
+		}
+	}
+}
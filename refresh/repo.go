@@ -6,6 +6,7 @@ import (
 
 	"github.com/coreos/dex/client"
 	"github.com/coreos/dex/scope"
+	"github.com/coreos/dex/session"
 )
 
 const (
@@ -42,14 +43,15 @@ func DefaultRefreshTokenGenerator() ([]byte, error) {
 type RefreshTokenRepo interface {
 	// Create generates and returns a new refresh token for the given client-user pair.
 	// The scopes will be stored with the refresh token, and used to verify
-	// against future OIDC refresh requests' scopes.
+	// against future OIDC refresh requests' scopes. The claims request, which
+	// may be nil, is stored too, so refreshed tokens carry the same claims.
 	// On success the token will be returned.
-	Create(userID, clientID, connectorID string, scope []string) (string, error)
+	Create(userID, clientID, connectorID string, scope []string, claims *session.ClaimsRequest) (string, error)
 
 	// Verify verifies that a token belongs to the client.
-	// It returns the user ID to which the token belongs, and the scopes stored
-	// with token.
-	Verify(clientID, token string) (userID, connectorID string, scope scope.Scopes, err error)
+	// It returns the user ID to which the token belongs, and the scopes and
+	// claims request stored with token.
+	Verify(clientID, token string) (userID, connectorID string, scope scope.Scopes, claims *session.ClaimsRequest, err error)
 
 	// Revoke deletes the refresh token if the token belongs to the given userID.
 	Revoke(userID, token string) error
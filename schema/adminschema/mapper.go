@@ -3,6 +3,7 @@ package adminschema
 import (
 	"errors"
 	"net/url"
+	"time"
 
 	"github.com/coreos/dex/client"
 	"github.com/coreos/go-oidc/oidc"
@@ -13,6 +14,7 @@ var (
 	ErrorInvalidRedirectURI = errors.New("Invalid Redirect URI")
 	ErrorInvalidLogoURI     = errors.New("Invalid Logo URI")
 	ErrorInvalidClientURI   = errors.New("Invalid Client URI")
+	ErrorInvalidLifetime    = errors.New("Invalid Token Lifetime")
 )
 
 func MapSchemaClientToClient(sc Client) (client.Client, error) {
@@ -24,7 +26,13 @@ func MapSchemaClientToClient(sc Client) (client.Client, error) {
 		Metadata: oidc.ClientMetadata{
 			RedirectURIs: make([]url.URL, len(sc.RedirectURIs)),
 		},
-		Public: sc.Public,
+		Public:     sc.Public,
+		FirstParty: sc.FirstParty,
+
+		RequirePushedAuthorizationRequests: sc.RequirePushedAuthorizationRequests,
+
+		AllowedGrantTypes: sc.AllowedGrantTypes,
+		AllowedScopes:     sc.AllowedScopes,
 	}
 	for i, ru := range sc.RedirectURIs {
 		if ru == "" {
@@ -57,6 +65,25 @@ func MapSchemaClientToClient(sc Client) (client.Client, error) {
 		c.Metadata.ClientURI = clientURI
 	}
 
+	lifetimes := []struct {
+		s string
+		d *time.Duration
+	}{
+		{sc.IdTokenLifetime, &c.IDTokenLifetime},
+		{sc.AccessTokenLifetime, &c.AccessTokenLifetime},
+		{sc.RefreshTokenLifetime, &c.RefreshTokenLifetime},
+	}
+	for _, l := range lifetimes {
+		if l.s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(l.s)
+		if err != nil {
+			return client.Client{}, ErrorInvalidLifetime
+		}
+		*l.d = d
+	}
+
 	c.Admin = sc.IsAdmin
 	return c, nil
 }
@@ -68,6 +95,12 @@ func MapClientToSchemaClient(c client.Client) Client {
 		RedirectURIs: make([]string, len(c.Metadata.RedirectURIs)),
 		IsAdmin:      c.Admin,
 		Public:       c.Public,
+		FirstParty:   c.FirstParty,
+
+		RequirePushedAuthorizationRequests: c.RequirePushedAuthorizationRequests,
+
+		AllowedGrantTypes: c.AllowedGrantTypes,
+		AllowedScopes:     c.AllowedScopes,
 	}
 	for i, u := range c.Metadata.RedirectURIs {
 		cl.RedirectURIs[i] = u.String()
@@ -81,5 +114,14 @@ func MapClientToSchemaClient(c client.Client) Client {
 	if c.Metadata.ClientURI != nil {
 		cl.ClientURI = c.Metadata.ClientURI.String()
 	}
+	if c.IDTokenLifetime != 0 {
+		cl.IdTokenLifetime = c.IDTokenLifetime.String()
+	}
+	if c.AccessTokenLifetime != 0 {
+		cl.AccessTokenLifetime = c.AccessTokenLifetime.String()
+	}
+	if c.RefreshTokenLifetime != 0 {
+		cl.RefreshTokenLifetime = c.RefreshTokenLifetime.String()
+	}
 	return cl
 }
@@ -3,6 +3,7 @@ package adminschema
 import (
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/coreos/go-oidc/oidc"
 	"github.com/kylelemons/godebug/pretty"
@@ -27,6 +28,9 @@ func TestMapSchemaClientToClient(t *testing.T) {
 				ClientName: "Bill",
 				LogoURI:    "https://logo.example.com",
 				ClientURI:  "https://clientURI.example.com",
+				FirstParty: true,
+
+				RequirePushedAuthorizationRequests: true,
 			},
 			want: client.Client{
 				Credentials: oidc.ClientCredentials{
@@ -42,6 +46,9 @@ func TestMapSchemaClientToClient(t *testing.T) {
 					LogoURI:    mustParseURL(t, "https://logo.example.com"),
 					ClientURI:  mustParseURL(t, "https://clientURI.example.com"),
 				},
+				FirstParty: true,
+
+				RequirePushedAuthorizationRequests: true,
 			},
 		}, {
 			sc: Client{
@@ -60,6 +67,41 @@ func TestMapSchemaClientToClient(t *testing.T) {
 				},
 				Public: true,
 			},
+		}, {
+			sc: Client{
+				Id:                   "789",
+				Secret:               "sec_789",
+				RedirectURIs:         []string{"https://client.example.com"},
+				IdTokenLifetime:      "1h",
+				AccessTokenLifetime:  "30m",
+				RefreshTokenLifetime: "720h",
+				AllowedGrantTypes:    []string{"authorization_code", "refresh_token"},
+				AllowedScopes:        []string{"email"},
+			},
+			want: client.Client{
+				Credentials: oidc.ClientCredentials{
+					ID:     "789",
+					Secret: "sec_789",
+				},
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{
+						*mustParseURL(t, "https://client.example.com"),
+					},
+				},
+				IDTokenLifetime:      time.Hour,
+				AccessTokenLifetime:  30 * time.Minute,
+				RefreshTokenLifetime: 720 * time.Hour,
+				AllowedGrantTypes:    []string{"authorization_code", "refresh_token"},
+				AllowedScopes:        []string{"email"},
+			},
+		}, {
+			sc: Client{
+				Id:              "789",
+				Secret:          "sec_789",
+				RedirectURIs:    []string{"https://client.example.com"},
+				IdTokenLifetime: "an hour",
+			},
+			wantErr: true,
 		}, {
 			sc: Client{
 				Id:     "123",
@@ -108,6 +150,9 @@ func TestMapClientToClientSchema(t *testing.T) {
 				ClientName: "Bill",
 				LogoURI:    "https://logo.example.com",
 				ClientURI:  "https://clientURI.example.com",
+				FirstParty: true,
+
+				RequirePushedAuthorizationRequests: true,
 			},
 			c: client.Client{
 				Credentials: oidc.ClientCredentials{
@@ -123,6 +168,33 @@ func TestMapClientToClientSchema(t *testing.T) {
 					LogoURI:    mustParseURL(t, "https://logo.example.com"),
 					ClientURI:  mustParseURL(t, "https://clientURI.example.com"),
 				},
+				FirstParty: true,
+
+				RequirePushedAuthorizationRequests: true,
+			},
+		},
+		{
+			want: Client{
+				Id:                   "789",
+				Secret:               "sec_789",
+				RedirectURIs:         []string{"https://client.example.com"},
+				IdTokenLifetime:      "1h0m0s",
+				RefreshTokenLifetime: "720h0m0s",
+				AllowedScopes:        []string{"email"},
+			},
+			c: client.Client{
+				Credentials: oidc.ClientCredentials{
+					ID:     "789",
+					Secret: "sec_789",
+				},
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{
+						*mustParseURL(t, "https://client.example.com"),
+					},
+				},
+				IDTokenLifetime:      time.Hour,
+				RefreshTokenLifetime: 720 * time.Hour,
+				AllowedScopes:        []string{"email"},
 			},
 		},
 		{
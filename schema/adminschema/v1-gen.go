@@ -110,6 +110,19 @@ type Admin struct {
 }
 
 type Client struct {
+	// AccessTokenLifetime: OPTIONAL. Lifetime of the access tokens issued to
+	// the client, as a duration such as "1h". The server's lifetime is used
+	// if omitted.
+	AccessTokenLifetime string `json:"accessTokenLifetime,omitempty"`
+
+	// AllowedGrantTypes: OPTIONAL. Grant types the client may use at the
+	// token endpoint. All grant types are allowed if omitted.
+	AllowedGrantTypes []string `json:"allowedGrantTypes,omitempty"`
+
+	// AllowedScopes: OPTIONAL. Scopes the client may request besides openid.
+	// All scopes are allowed if omitted.
+	AllowedScopes []string `json:"allowedScopes,omitempty"`
+
 	// ClientName: OPTIONAL for normal cliens. Name of the Client to be
 	// presented to the End-User. If desired, representation of this Claim
 	// in different languages and scripts is represented as described in
@@ -125,10 +138,20 @@ type Client struct {
 	// Languages and Scripts ) .
 	ClientURI string `json:"clientURI,omitempty"`
 
+	// FirstParty: OPTIONAL. Determines if the client is operated by the
+	// same party as dex. Users are not asked to consent to the access
+	// first-party clients request.
+	FirstParty bool `json:"firstParty,omitempty"`
+
 	// Id: The client ID. If specified in a client create request, it will
 	// be used as the ID. Otherwise, the server will choose the ID.
 	Id string `json:"id,omitempty"`
 
+	// IdTokenLifetime: OPTIONAL. Lifetime of the ID tokens issued to the
+	// client, as a duration such as "1h". The server's lifetime is used if
+	// omitted.
+	IdTokenLifetime string `json:"idTokenLifetime,omitempty"`
+
 	IsAdmin bool `json:"isAdmin,omitempty"`
 
 	// LogoURI: OPTIONAL. URL that references a logo for the Client
@@ -140,9 +163,10 @@ type Client struct {
 	LogoURI string `json:"logoURI,omitempty"`
 
 	// Public: OPTIONAL. Determines if the client is public. Public clients
-	// have certain restrictions: They cannot use their credentials to
-	// obtain a client JWT. Their redirects URLs cannot be specified: they
-	// are always http://localhost:$PORT or urn:ietf:wg:oauth:2.0:oob.
+	// have certain restrictions: They cannot use their credentials to obtain
+	// a client JWT. Their redirect URLs are limited to those of native apps,
+	// and default to any http URL on a loopback address. They may always use
+	// urn:ietf:wg:oauth:2.0:oob.
 	Public bool `json:"public,omitempty"`
 
 	// RedirectURIs: REQUIRED for normal clients. Array of Redirection URI
@@ -150,16 +174,33 @@ type Client struct {
 	// values MUST exactly match the redirect_uri parameter value used in
 	// each Authorization Request, with the matching performed as described
 	// in Section 6.2.1 of [RFC3986] ( Berners-Lee, T., Fielding, R., and L.
-	// Masinter, “Uniform Resource Identifier (URI): Generic Syntax,”
-	// January 2005. ) (Simple String Comparison). DISALLOWED for public
-	// clients.
+	// Masinter, “Uniform Resource Identifier (URI): Generic Syntax,” January
+	// 2005. ) (Simple String Comparison). OPTIONAL for public clients, which
+	// may only use http URLs on loopback addresses, which match any port,
+	// and private-use URI schemes containing a period, such as
+	// com.example.app:/callback.
 	RedirectURIs []string `json:"redirectURIs,omitempty"`
 
+	// RefreshTokenLifetime: OPTIONAL. How long after the user authorizes the
+	// client its refresh tokens can be used, as a duration such as "720h".
+	// The server's lifetime is used if omitted.
+	RefreshTokenLifetime string `json:"refreshTokenLifetime,omitempty"`
+
+	// RequirePushedAuthorizationRequests: OPTIONAL. Determines if the
+	// client may only send users to the authorization endpoint with a
+	// request URI returned by the pushed authorization request endpoint.
+	RequirePushedAuthorizationRequests bool `json:"requirePushedAuthorizationRequests,omitempty"`
+
 	// Secret: The client secret. If specified in a client create request,
 	// it will be used as the secret. Otherwise, the server will choose the
 	// secret. Must be a base64 URLEncoded string.
 	Secret string `json:"secret,omitempty"`
 
+	// TokenExchangePeers: Array of ClientIDs of clients that are allowed
+	// to exchange users' tokens for tokens whose audience is the client
+	// being created.
+	TokenExchangePeers []string `json:"tokenExchangePeers,omitempty"`
+
 	// TrustedPeers: Array of ClientIDs of clients that are allowed to mint
 	// ID tokens for the client being created.
 	TrustedPeers []string `json:"trustedPeers,omitempty"`
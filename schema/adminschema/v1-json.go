@@ -72,7 +72,7 @@ const DiscoveryJSON = `{
           "items": {
             "type": "string"
           },
-          "description": "REQUIRED for normal clients. Array of Redirection URI values used by the Client. One of these registered Redirection URI values MUST exactly match the redirect_uri parameter value used in each Authorization Request, with the matching performed as described in Section 6.2.1 of [RFC3986] ( Berners-Lee, T., Fielding, R., and L. Masinter, “Uniform Resource Identifier (URI): Generic Syntax,” January 2005. ) (Simple String Comparison). DISALLOWED for public clients."
+          "description": "REQUIRED for normal clients. Array of Redirection URI values used by the Client. One of these registered Redirection URI values MUST exactly match the redirect_uri parameter value used in each Authorization Request, with the matching performed as described in Section 6.2.1 of [RFC3986] ( Berners-Lee, T., Fielding, R., and L. Masinter, “Uniform Resource Identifier (URI): Generic Syntax,” January 2005. ) (Simple String Comparison). OPTIONAL for public clients, which may only use http URLs on loopback addresses, which match any port, and private-use URI schemes containing a period, such as com.example.app:/callback."
         },
         "clientName": {
           "type": "string",
@@ -86,6 +86,13 @@ const DiscoveryJSON = `{
           "type": "string",
           "description": "OPTIONAL. URL of the home page of the Client. The value of this field MUST point to a valid Web page. If present, the server SHOULD display this URL to the End-User in a followable fashion. If desired, representation of this Claim in different languages and scripts is represented as described in Section 2.1 ( Metadata Languages and Scripts ) ."
         },
+        "tokenExchangePeers": {
+          "type": "array",
+          "items": {
+            "type": "string"
+          },
+          "description": "Array of ClientIDs of clients that are allowed to exchange users' tokens for tokens whose audience is the client being created."
+        },
         "trustedPeers": {
           "type": "array",
           "items": {
@@ -95,7 +102,41 @@ const DiscoveryJSON = `{
         },
         "public": {
           "type": "boolean",
-          "description": "OPTIONAL. Determines if the client is public. Public clients have certain restrictions: They cannot use their credentials to obtain a client JWT. Their redirects URLs cannot be specified: they are always http://localhost:$PORT or urn:ietf:wg:oauth:2.0:oob."
+          "description": "OPTIONAL. Determines if the client is public. Public clients have certain restrictions: They cannot use their credentials to obtain a client JWT. Their redirect URLs are limited to those of native apps, and default to any http URL on a loopback address. They may always use urn:ietf:wg:oauth:2.0:oob."
+        },
+        "firstParty": {
+          "type": "boolean",
+          "description": "OPTIONAL. Determines if the client is operated by the same party as dex. Users are not asked to consent to the access first-party clients request."
+        },
+        "requirePushedAuthorizationRequests": {
+          "type": "boolean",
+          "description": "OPTIONAL. Determines if the client may only send users to the authorization endpoint with a request URI returned by the pushed authorization request endpoint."
+        },
+        "idTokenLifetime": {
+          "type": "string",
+          "description": "OPTIONAL. Lifetime of the ID tokens issued to the client, as a duration such as \"1h\". The server's lifetime is used if omitted."
+        },
+        "accessTokenLifetime": {
+          "type": "string",
+          "description": "OPTIONAL. Lifetime of the access tokens issued to the client, as a duration such as \"1h\". The server's lifetime is used if omitted."
+        },
+        "refreshTokenLifetime": {
+          "type": "string",
+          "description": "OPTIONAL. How long after the user authorizes the client its refresh tokens can be used, as a duration such as \"720h\". The server's lifetime is used if omitted."
+        },
+        "allowedGrantTypes": {
+          "type": "array",
+          "items": {
+            "type": "string"
+          },
+          "description": "OPTIONAL. Grant types the client may use at the token endpoint. All grant types are allowed if omitted."
+        },
+        "allowedScopes": {
+          "type": "array",
+          "items": {
+            "type": "string"
+          },
+          "description": "OPTIONAL. Scopes the client may request besides openid. All scopes are allowed if omitted."
         }
       }
     },
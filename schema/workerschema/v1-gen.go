@@ -45,7 +45,9 @@ func New(client *http.Client) (*Service, error) {
 		return nil, errors.New("client is nil")
 	}
 	s := &Service{client: client, BasePath: basePath}
+	s.Consent = NewConsentService(s)
 	s.RefreshClient = NewRefreshClientService(s)
+	s.SSOSession = NewSSOSessionService(s)
 	s.Users = NewUsersService(s)
 	return s, nil
 }
@@ -54,11 +56,24 @@ type Service struct {
 	client   *http.Client
 	BasePath string // API endpoint base URL
 
+	Consent *ConsentService
+
 	RefreshClient *RefreshClientService
 
+	SSOSession *SSOSessionService
+
 	Users *UsersService
 }
 
+func NewConsentService(s *Service) *ConsentService {
+	rs := &ConsentService{s: s}
+	return rs
+}
+
+type ConsentService struct {
+	s *Service
+}
+
 func NewRefreshClientService(s *Service) *RefreshClientService {
 	rs := &RefreshClientService{s: s}
 	return rs
@@ -68,6 +83,15 @@ type RefreshClientService struct {
 	s *Service
 }
 
+func NewSSOSessionService(s *Service) *SSOSessionService {
+	rs := &SSOSessionService{s: s}
+	return rs
+}
+
+type SSOSessionService struct {
+	s *Service
+}
+
 func NewUsersService(s *Service) *UsersService {
 	rs := &UsersService{s: s}
 	return rs
@@ -77,6 +101,24 @@ type UsersService struct {
 	s *Service
 }
 
+type Consent struct {
+	ClientID string `json:"clientID,omitempty"`
+
+	ClientName string `json:"clientName,omitempty"`
+
+	ClientURI string `json:"clientURI,omitempty"`
+
+	LogoURI string `json:"logoURI,omitempty"`
+
+	Scopes []string `json:"scopes,omitempty"`
+
+	UpdatedAt string `json:"updatedAt,omitempty"`
+}
+
+type ConsentList struct {
+	Consents []*Consent `json:"consents,omitempty"`
+}
+
 type Error struct {
 	Error string `json:"error,omitempty"`
 
@@ -160,6 +202,154 @@ type UsersResponse struct {
 	Users []*User `json:"users,omitempty"`
 }
 
+// method id "dex.Consent.List":
+
+type ConsentListCall struct {
+	s      *Service
+	userid string
+	opt_   map[string]interface{}
+}
+
+// List: List the clients the specified user has approved access for.
+func (r *ConsentService) List(userid string) *ConsentListCall {
+	c := &ConsentListCall{s: r.s, opt_: make(map[string]interface{})}
+	c.userid = userid
+	return c
+}
+
+// Fields allows partial responses to be retrieved.
+// See https://developers.google.com/gdata/docs/2.0/basics#PartialResponse
+// for more information.
+func (c *ConsentListCall) Fields(s ...googleapi.Field) *ConsentListCall {
+	c.opt_["fields"] = googleapi.CombineFields(s)
+	return c
+}
+
+func (c *ConsentListCall) Do() (*ConsentList, error) {
+	var body io.Reader = nil
+	params := make(url.Values)
+	params.Set("alt", "json")
+	if v, ok := c.opt_["fields"]; ok {
+		params.Set("fields", fmt.Sprintf("%v", v))
+	}
+	urls := googleapi.ResolveRelative(c.s.BasePath, "account/{userid}/consents")
+	urls += "?" + params.Encode()
+	req, _ := http.NewRequest("GET", urls, body)
+	googleapi.Expand(req.URL, map[string]string{
+		"userid": c.userid,
+	})
+	req.Header.Set("User-Agent", "google-api-go-client/0.5")
+	res, err := c.s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer googleapi.CloseBody(res)
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+	var ret *ConsentList
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+	// {
+	//   "description": "List the clients the specified user has approved access for.",
+	//   "httpMethod": "GET",
+	//   "id": "dex.Consent.List",
+	//   "parameterOrder": [
+	//     "userid"
+	//   ],
+	//   "parameters": {
+	//     "userid": {
+	//       "location": "path",
+	//       "required": true,
+	//       "type": "string"
+	//     }
+	//   },
+	//   "path": "account/{userid}/consents",
+	//   "response": {
+	//     "$ref": "ConsentList"
+	//   }
+	// }
+
+}
+
+// method id "dex.Consent.Revoke":
+
+type ConsentRevokeCall struct {
+	s        *Service
+	userid   string
+	clientid string
+	opt_     map[string]interface{}
+}
+
+// Revoke: Revoke the specified user's approval of the client, along
+// with the refresh tokens issued to it. The user is asked for consent
+// again the next time they log in to the client.
+func (r *ConsentService) Revoke(userid string, clientid string) *ConsentRevokeCall {
+	c := &ConsentRevokeCall{s: r.s, opt_: make(map[string]interface{})}
+	c.userid = userid
+	c.clientid = clientid
+	return c
+}
+
+// Fields allows partial responses to be retrieved.
+// See https://developers.google.com/gdata/docs/2.0/basics#PartialResponse
+// for more information.
+func (c *ConsentRevokeCall) Fields(s ...googleapi.Field) *ConsentRevokeCall {
+	c.opt_["fields"] = googleapi.CombineFields(s)
+	return c
+}
+
+func (c *ConsentRevokeCall) Do() error {
+	var body io.Reader = nil
+	params := make(url.Values)
+	params.Set("alt", "json")
+	if v, ok := c.opt_["fields"]; ok {
+		params.Set("fields", fmt.Sprintf("%v", v))
+	}
+	urls := googleapi.ResolveRelative(c.s.BasePath, "account/{userid}/consents/{clientid}")
+	urls += "?" + params.Encode()
+	req, _ := http.NewRequest("DELETE", urls, body)
+	googleapi.Expand(req.URL, map[string]string{
+		"userid":   c.userid,
+		"clientid": c.clientid,
+	})
+	req.Header.Set("User-Agent", "google-api-go-client/0.5")
+	res, err := c.s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer googleapi.CloseBody(res)
+	if err := googleapi.CheckResponse(res); err != nil {
+		return err
+	}
+	return nil
+	// {
+	//   "description": "Revoke the specified user's approval of the client, along with the refresh tokens issued to it. The user is asked for consent again the next time they log in to the client.",
+	//   "httpMethod": "DELETE",
+	//   "id": "dex.Consent.Revoke",
+	//   "parameterOrder": [
+	//     "userid",
+	//     "clientid"
+	//   ],
+	//   "parameters": {
+	//     "clientid": {
+	//       "location": "path",
+	//       "required": true,
+	//       "type": "string"
+	//     },
+	//     "userid": {
+	//       "location": "path",
+	//       "required": true,
+	//       "type": "string"
+	//     }
+	//   },
+	//   "path": "account/{userid}/consents/{clientid}"
+	// }
+
+}
+
 // method id "dex.RefreshClient.List":
 
 type RefreshClientListCall struct {
@@ -308,6 +498,72 @@ func (c *RefreshClientRevokeCall) Do() error {
 
 }
 
+// method id "dex.SSOSession.Revoke":
+
+type SSOSessionRevokeCall struct {
+	s      *Service
+	userid string
+	opt_   map[string]interface{}
+}
+
+// Revoke: End all of the specified user's SSO sessions, logging them
+// out of dex in every browser.
+func (r *SSOSessionService) Revoke(userid string) *SSOSessionRevokeCall {
+	c := &SSOSessionRevokeCall{s: r.s, opt_: make(map[string]interface{})}
+	c.userid = userid
+	return c
+}
+
+// Fields allows partial responses to be retrieved.
+// See https://developers.google.com/gdata/docs/2.0/basics#PartialResponse
+// for more information.
+func (c *SSOSessionRevokeCall) Fields(s ...googleapi.Field) *SSOSessionRevokeCall {
+	c.opt_["fields"] = googleapi.CombineFields(s)
+	return c
+}
+
+func (c *SSOSessionRevokeCall) Do() error {
+	var body io.Reader = nil
+	params := make(url.Values)
+	params.Set("alt", "json")
+	if v, ok := c.opt_["fields"]; ok {
+		params.Set("fields", fmt.Sprintf("%v", v))
+	}
+	urls := googleapi.ResolveRelative(c.s.BasePath, "account/{userid}/sessions")
+	urls += "?" + params.Encode()
+	req, _ := http.NewRequest("DELETE", urls, body)
+	googleapi.Expand(req.URL, map[string]string{
+		"userid": c.userid,
+	})
+	req.Header.Set("User-Agent", "google-api-go-client/0.5")
+	res, err := c.s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer googleapi.CloseBody(res)
+	if err := googleapi.CheckResponse(res); err != nil {
+		return err
+	}
+	return nil
+	// {
+	//   "description": "End all of the specified user's SSO sessions, logging them out of dex in every browser.",
+	//   "httpMethod": "DELETE",
+	//   "id": "dex.SSOSession.Revoke",
+	//   "parameterOrder": [
+	//     "userid"
+	//   ],
+	//   "parameters": {
+	//     "userid": {
+	//       "location": "path",
+	//       "required": true,
+	//       "type": "string"
+	//     }
+	//   },
+	//   "path": "account/{userid}/sessions"
+	// }
+
+}
+
 // method id "dex.User.Create":
 
 type UsersCreateCall struct {
@@ -468,7 +724,8 @@ type UsersGetCall struct {
 	opt_ map[string]interface{}
 }
 
-// Get: Get a single User object by id.
+// Get: Get a single User object by id, or by the pairwise subject the
+// User is known by to some clients.
 func (r *UsersService) Get(id string) *UsersGetCall {
 	c := &UsersGetCall{s: r.s, opt_: make(map[string]interface{})}
 	c.id = id
@@ -511,7 +768,7 @@ func (c *UsersGetCall) Do() (*UserResponse, error) {
 	}
 	return ret, nil
 	// {
-	//   "description": "Get a single User object by id.",
+	//   "description": "Get a single User object by id, or by the pairwise subject the User is known by to some clients.",
 	//   "httpMethod": "GET",
 	//   "id": "dex.User.Get",
 	//   "parameterOrder": [
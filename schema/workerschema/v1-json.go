@@ -59,6 +59,47 @@ const DiscoveryJSON = `{
         }
       }
     },
+    "Consent": {
+      "id": "Consent",
+      "type": "object",
+      "description": "A client the user has approved access for, with the scopes they approved.",
+      "properties": {
+        "clientID": {
+          "type": "string"
+        },
+        "clientName": {
+          "type": "string"
+        },
+        "logoURI": {
+          "type": "string"
+        },
+        "clientURI": {
+          "type": "string"
+        },
+        "scopes": {
+          "type": "array",
+          "items": {
+            "type": "string"
+          }
+        },
+        "updatedAt": {
+          "type": "string",
+          "format": "date-time"
+        }
+      }
+    },
+    "ConsentList": {
+      "id": "ConsentList",
+      "type": "object",
+      "properties": {
+        "consents": {
+          "type": "array",
+          "items": {
+            "$ref": "Consent"
+          }
+        }
+      }
+    },
     "RefreshClientList": {
       "id": "RefreshClientList",
       "type": "object",
@@ -218,7 +259,7 @@ const DiscoveryJSON = `{
         },
         "Get": {
           "id": "dex.User.Get",
-          "description": "Get a single User object by id.",
+          "description": "Get a single User object by id, or by the pairwise subject the User is known by to some clients.",
           "httpMethod": "GET",
           "path": "users/{id}",
           "parameters": {
@@ -337,6 +378,71 @@ const DiscoveryJSON = `{
           }
         }
       }
+    },
+    "Consent": {
+      "methods": {
+        "List": {
+          "id": "dex.Consent.List",
+          "description": "List the clients the specified user has approved access for.",
+          "httpMethod": "GET",
+          "path": "account/{userid}/consents",
+          "parameters": {
+            "userid": {
+              "type": "string",
+              "required": true,
+              "location": "path"
+            }
+          },
+          "parameterOrder": [
+            "userid"
+          ],
+          "response": {
+            "$ref": "ConsentList"
+          }
+        },
+        "Revoke": {
+          "id": "dex.Consent.Revoke",
+          "description": "Revoke the specified user's approval of the client, along with the refresh tokens issued to it. The user is asked for consent again the next time they log in to the client.",
+          "httpMethod": "DELETE",
+          "path": "account/{userid}/consents/{clientid}",
+          "parameterOrder": [
+            "userid",
+            "clientid"
+          ],
+          "parameters": {
+            "clientid": {
+              "type": "string",
+              "required": true,
+              "location": "path"
+            },
+            "userid": {
+              "type": "string",
+              "required": true,
+              "location": "path"
+            }
+          }
+        }
+      }
+    },
+    "SSOSession": {
+      "methods": {
+        "Revoke": {
+          "id": "dex.SSOSession.Revoke",
+          "description": "End all of the specified user's SSO sessions, logging them out of dex in every browser.",
+          "httpMethod": "DELETE",
+          "path": "account/{userid}/sessions",
+          "parameterOrder": [
+            "userid"
+          ],
+          "parameters": {
+            "userid": {
+              "type": "string",
+              "required": true,
+              "location": "path"
+            }
+          }
+        }
+      }
     }
   }
 }
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+
+	"github.com/coreos/dex/pkg/crypto"
+	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+	"github.com/coreos/dex/scope"
+)
+
+const (
+	// DefaultAccessTokenValidityWindow is used when the server isn't configured
+	// with an access token lifetime.
+	DefaultAccessTokenValidityWindow = time.Hour
+
+	// accessTokenJWTType is the typ header of access tokens, which
+	// distinguishes them from ID tokens signed with the same keys (RFC 9068
+	// Section 2.1).
+	accessTokenJWTType = "at+jwt"
+
+	claimClientID = "client_id"
+	claimScope    = "scope"
+	claimAct      = "act"
+
+	// claimUserInfoClaims names the claims the client asked the UserInfo
+	// endpoint to return in its claims request.
+	claimUserInfoClaims = "userinfo_claims"
+)
+
+// accessTokenAudience determines the audience of an access token issued to the
+// client. Clients may request tokens for specific resources (RFC 8707 Section 2),
+// which must be among the client's configured resources; clients without any
+// may not request resources. Otherwise, tokens are issued for all of the
+// client's configured resources, or for dex itself if there are none, allowing
+// the token to be used at the UserInfo endpoint.
+func (s *Server) accessTokenAudience(clientID string, resources []string) ([]string, error) {
+	cli, err := s.Client(clientID)
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", clientID, err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	if len(resources) == 0 {
+		if len(cli.Resources) > 0 {
+			return cli.Resources, nil
+		}
+		return []string{s.IssuerURL.String()}, nil
+	}
+
+	for _, res := range resources {
+		u, err := url.Parse(res)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			err := oauth2.NewError(errorInvalidTarget)
+			err.Description = fmt.Sprintf("invalid resource %q", res)
+			return nil, err
+		}
+		if !pstrings.ContainsString(cli.Resources, res) {
+			err := oauth2.NewError(errorInvalidTarget)
+			err.Description = fmt.Sprintf("client is not allowed to access resource %q", res)
+			return nil, err
+		}
+	}
+	return resources, nil
+}
+
+// accessToken issues a JWT access token (RFC 9068) to the client on behalf of
+// the subject, which is the subject identifier of a user, or the client ID
+// itself for tokens obtained using client credentials. The user's groups are included if the groups scope
+// was granted or groups is not nil, so they can be returned by the UserInfo
+// endpoint, along with the other claims it should return from userInfoClaims.
+// Tokens obtained by token exchange name the party acting on the subject's
+// behalf in act (RFC 8693 Section 4.1).
+func (s *Server) accessToken(clientID, sub string, aud, scopes, groups, userInfoClaims []string, act jose.Claims) (*jose.JWT, time.Time, error) {
+	signer, err := s.KeyManager.Signer()
+	if err != nil {
+		log.Errorf("Failed to generate access token: %v", err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	jti, err := crypto.RandBytes(16)
+	if err != nil {
+		log.Errorf("Failed to generate access token ID: %v", err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	cli, err := s.Client(clientID)
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", clientID, err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	validity := cli.AccessTokenLifetime
+	if validity == 0 {
+		validity = s.AccessTokenValidityWindow
+	}
+	if validity == 0 {
+		validity = DefaultAccessTokenValidityWindow
+	}
+	now := time.Now()
+	exp := now.Add(validity)
+
+	claims := jose.Claims{
+		"iss":         s.IssuerURL.String(),
+		"sub":         sub,
+		"iat":         now.Unix(),
+		"exp":         exp.Unix(),
+		"jti":         base64.RawURLEncoding.EncodeToString(jti),
+		claimClientID: clientID,
+	}
+	if len(aud) == 1 {
+		claims.Add("aud", aud[0])
+	} else {
+		claims.Add("aud", aud)
+	}
+	if len(scopes) > 0 {
+		claims.Add(claimScope, strings.Join(scopes, " "))
+	}
+	if groups != nil || scope.Scopes(scopes).HasScope(scope.ScopeGroups) {
+		if groups == nil {
+			groups = []string{}
+		}
+		claims.Add("groups", groups)
+	}
+	if len(userInfoClaims) > 0 {
+		claims.Add(claimUserInfoClaims, userInfoClaims)
+	}
+	if act != nil {
+		claims.Add(claimAct, act)
+	}
+
+	jwt, err := newSignedJWT(claims, signer, accessTokenJWTType)
+	if err != nil {
+		log.Errorf("Failed to generate access token: %v", err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	return jwt, exp, nil
+}
+
+// newSignedJWT signs the claims of a token which isn't an ID token, setting its
+// typ header to the given type rather than the JWT jose.NewSignedJWT sets.
+func newSignedJWT(claims jose.Claims, signer jose.Signer, typ string) (*jose.JWT, error) {
+	header := jose.JOSEHeader{
+		jose.HeaderKeyAlgorithm: signer.Alg(),
+		jose.HeaderKeyID:        signer.ID(),
+	}
+	jwt, err := jose.NewJWT(header, claims)
+	if err != nil {
+		return nil, err
+	}
+	jwt.Header[jose.HeaderMediaType] = typ
+	b, err := json.Marshal(jwt.Header)
+	if err != nil {
+		return nil, err
+	}
+	jwt.RawHeader = base64.RawURLEncoding.EncodeToString(b)
+
+	if jwt.Signature, err = signer.Sign([]byte(jwt.Data())); err != nil {
+		return nil, err
+	}
+	return &jwt, nil
+}
+
+// isAccessToken reports whether a verified token is an access token rather
+// than an ID token, by its typ header.
+func isAccessToken(jwt jose.JWT) bool {
+	typ := strings.ToLower(jwt.Header[jose.HeaderMediaType])
+	return typ == accessTokenJWTType || typ == "application/"+accessTokenJWTType
+}
+
+// tokenClientID returns the client a verified token was issued to. Access
+// tokens name their client, while an ID token's client is its authorized party
+// or audience.
+func tokenClientID(claims jose.Claims) string {
+	if clientID, ok, _ := claims.StringClaim(claimClientID); ok {
+		return clientID
+	}
+	if azp, ok, _ := claims.StringClaim("azp"); ok {
+		return azp
+	}
+	if aud := audienceClaim(claims); len(aud) > 0 {
+		return aud[0]
+	}
+	return ""
+}
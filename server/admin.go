@@ -13,7 +13,7 @@ import (
 	"github.com/coreos/dex/connector"
 	"github.com/coreos/dex/pkg/log"
 	"github.com/coreos/dex/schema/adminschema"
-	"github.com/coreos/go-oidc/key"
+	"github.com/coreos/dex/signing"
 )
 
 const (
@@ -36,7 +36,7 @@ type AdminServer struct {
 	secret   string
 }
 
-func NewAdminServer(adminAPI *admin.AdminAPI, rotator *key.PrivateKeyRotator, secret string) *AdminServer {
+func NewAdminServer(adminAPI *admin.AdminAPI, rotator *signing.PrivateKeyRotator, secret string) *AdminServer {
 	return &AdminServer{
 		adminAPI: adminAPI,
 		checker: health.Checker{
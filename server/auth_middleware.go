@@ -49,6 +49,13 @@ func (c *clientTokenMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request
 		respondError()
 		return
 	}
+	// Clients authenticate with the ID token obtained using their
+	// credentials, never an access token.
+	if isAccessToken(jwt) {
+		log.Error("Access token presented as a client token")
+		respondError()
+		return
+	}
 
 	keys, err := c.keysFunc()
 	if err != nil {
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+
+	"github.com/coreos/dex/client"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+	"github.com/coreos/dex/session"
+)
+
+// Response modes, which determine how the authorization response is passed to
+// the client.
+// See: https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseModes
+// and https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html
+const (
+	responseModeQuery    = "query"
+	responseModeFragment = "fragment"
+	responseModeFormPost = "form_post"
+)
+
+// responseTypeCodeToken is the one hybrid flow response type go-oidc doesn't
+// define.
+const responseTypeCodeToken = "code token"
+
+var (
+	// responseTypesSupported are the response types of the authorization
+	// code, implicit and hybrid flows.
+	responseTypesSupported = []string{
+		oauth2.ResponseTypeCode,
+		oauth2.ResponseTypeIDToken,
+		oauth2.ResponseTypeIDTokenToken,
+		oauth2.ResponseTypeCodeIDToken,
+		responseTypeCodeToken,
+		oauth2.ResponseTypeCodeIDTokenToken,
+	}
+
+	responseModesSupported = []string{responseModeQuery, responseModeFragment, responseModeFormPost}
+)
+
+// parseResponseType validates the response_type and response_mode parameters
+// of an authorization request, returning the response type in the form it's
+// listed in responseTypesSupported, and the response mode to use. Responses
+// which include tokens must not be passed in the query string, so they're
+// passed in the fragment unless the client asks for form_post.
+func parseResponseType(responseType, responseMode string) (string, string, error) {
+	var rt string
+	for _, t := range responseTypesSupported {
+		if oauth2.ResponseTypesEqual(t, responseType) {
+			rt = t
+			break
+		}
+	}
+	if rt == "" {
+		err := oauth2.NewError(oauth2.ErrorUnsupportedResponseType)
+		err.Description = fmt.Sprintf("unsupported response_type %q", responseType)
+		return "", "", err
+	}
+
+	switch responseMode {
+	case "":
+		if rt == oauth2.ResponseTypeCode {
+			return rt, responseModeQuery, nil
+		}
+		return rt, responseModeFragment, nil
+	case responseModeQuery:
+		if rt != oauth2.ResponseTypeCode {
+			err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+			err.Description = fmt.Sprintf("response_mode query can't be used with response_type %q", rt)
+			return "", "", err
+		}
+	case responseModeFragment, responseModeFormPost:
+	default:
+		err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+		err.Description = fmt.Sprintf("unsupported response_mode %q", responseMode)
+		return "", "", err
+	}
+	return rt, responseMode, nil
+}
+
+// responseTypeHas reports whether a response type includes t, which is one of
+// "code", "id_token" or "token". An empty response type is equivalent to
+// "code".
+func responseTypeHas(responseType, t string) bool {
+	if responseType == "" {
+		responseType = oauth2.ResponseTypeCode
+	}
+	return pstrings.ContainsString(strings.Fields(responseType), t)
+}
+
+// responseTypeGrantTypes returns the grant types a client must be allowed to
+// use to request a response type. Returning tokens from the authorization
+// endpoint is the implicit grant.
+func responseTypeGrantTypes(responseType string) []string {
+	var gts []string
+	if responseTypeHas(responseType, "code") {
+		gts = append(gts, oauth2.GrantTypeAuthCode)
+	}
+	if responseTypeHas(responseType, "token") || responseType == oauth2.ResponseTypeIDToken {
+		gts = append(gts, oauth2.GrantTypeImplicit)
+	}
+	return gts
+}
+
+// authResponseURL returns the URL which passes the response to the
+// authorization request of the identified session to the client. If the user
+// hasn't approved the client's access yet, the URL of the consent page is
+// returned instead, and if the response is to be posted to the client, the URL
+// of the page which does so.
+func (s *Server) authResponseURL(ses *session.Session) (string, error) {
+	required, err := s.sessionConsentRequired(ses)
+	if err != nil {
+		return "", fmt.Errorf("checking consent: %v", err)
+	}
+	if required {
+		key, err := s.SessionManager.NewSessionKey(ses.ID)
+		if err != nil {
+			return "", fmt.Errorf("creating new session key: %v", err)
+		}
+		u := s.consentURL(key)
+		return u.String(), nil
+	}
+	if ses.ResponseMode == responseModeFormPost {
+		return s.formPostURL(ses.ID)
+	}
+
+	params, err := s.authResponse(ses)
+	if err != nil {
+		return "", err
+	}
+
+	ru := ses.RedirectURL
+	if ru.String() == client.OOBRedirectURI {
+		ru = s.absURL(httpPathOOB)
+	}
+	if ses.ResponseMode == responseModeFragment {
+		// The parameters are already encoded, and mustn't be escaped again.
+		ru.Fragment = ""
+		return ru.String() + "#" + params.Encode(), nil
+	}
+	q := ru.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	ru.RawQuery = q.Encode()
+	return ru.String(), nil
+}
+
+// authResponse issues the code and tokens of the response type the identified
+// session asked for, returning the parameters of the authorization response.
+// Sessions which don't ask for a code are ended, as the client can't exchange
+// them for further tokens.
+func (s *Server) authResponse(ses *session.Session) (url.Values, error) {
+	params := url.Values{}
+
+	var code string
+	if responseTypeHas(ses.ResponseType, "code") {
+		var err error
+		if code, err = s.SessionManager.NewSessionKey(ses.ID); err != nil {
+			return nil, fmt.Errorf("creating new session key: %v", err)
+		}
+		params.Set("code", code)
+	} else {
+		if _, err := s.SessionManager.Kill(ses.ID); err != nil {
+			return nil, fmt.Errorf("killing session: %v", err)
+		}
+	}
+
+	var accessToken *jose.JWT
+	if responseTypeHas(ses.ResponseType, "token") {
+		aud, err := s.accessTokenAudience(ses.ClientID, nil)
+		if err != nil {
+			return nil, err
+		}
+		var expiresAt time.Time
+		if accessToken, expiresAt, err = s.sessionAccessToken(ses, aud); err != nil {
+			return nil, err
+		}
+		params.Set("access_token", accessToken.Encode())
+		params.Set("token_type", "bearer")
+		params.Set("expires_in", fmt.Sprint(int64(expiresAt.Sub(time.Now()).Seconds())))
+	}
+
+	if responseTypeHas(ses.ResponseType, "id_token") {
+		cli, err := s.Client(ses.ClientID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching client %s: %v", ses.ClientID, err)
+		}
+		jwt, err := s.sessionIDToken(ses, cli, accessToken, code)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("id_token", jwt.Encode())
+	}
+
+	params.Set("state", ses.ClientState)
+	return params, nil
+}
+
+// formPostURL returns the URL of the page which posts the response to the
+// authorization request of the session, or its error response, to the client.
+func (s *Server) formPostURL(sessionID string) (string, error) {
+	key, err := s.SessionManager.NewSessionKey(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("creating new session key: %v", err)
+	}
+	u := s.absURL(httpPathFormPost)
+	q := url.Values{}
+	q.Set("code", key)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+type formPostTemplateData struct {
+	Error   bool
+	Message string
+
+	// Action is the client's redirect URI, which Params are posted to.
+	Action string
+	Params map[string]string
+}
+
+// handleFormPostFunc passes the authorization response of an identified
+// session to the client using the form_post response mode, rendering a form
+// which the user's browser submits to the client's redirect URI. Sessions whose
+// user couldn't be logged in pass their error response instead.
+func handleFormPostFunc(s *Server, tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, "GET only acceptable method")
+			return
+		}
+
+		writeFormPostError := func(msg string) {
+			execTemplateWithStatus(w, tpl, formPostTemplateData{Error: true, Message: msg}, http.StatusBadRequest)
+		}
+		internalError := func(err error) {
+			log.Errorf("Form post response failed: %v", err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		sessionID, err := s.SessionManager.ExchangeKey(r.URL.Query().Get("code"))
+		if err != nil {
+			writeFormPostError("Your login has expired. Please log in again.")
+			return
+		}
+		ses, err := s.SessionManager.Get(sessionID)
+		if err != nil {
+			internalError(err)
+			return
+		}
+		if ses == nil || ses.ResponseMode != responseModeFormPost {
+			writeFormPostError("Your login has expired. Please log in again.")
+			return
+		}
+
+		var params url.Values
+		switch {
+		case ses.AuthError != nil:
+			params = authErrorParams(ses.AuthError, ses.ClientState)
+		case ses.State == session.SessionStateIdentified:
+			if params, err = s.authResponse(ses); err != nil {
+				internalError(err)
+				return
+			}
+		default:
+			writeFormPostError("Your login has expired. Please log in again.")
+			return
+		}
+		writeFormPost(w, tpl, ses.RedirectURL, params)
+	}
+}
+
+// writeFormPost renders the form which the user's browser submits to post the
+// parameters of an authorization response to the client's redirect URI.
+func writeFormPost(w http.ResponseWriter, tpl *template.Template, redirectURL url.URL, params url.Values) {
+	td := formPostTemplateData{
+		Action: redirectURL.String(),
+		Params: make(map[string]string),
+	}
+	for k := range params {
+		td.Params[k] = params.Get(k)
+	}
+
+	// The page may contain tokens, and must not be cached.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	execTemplate(w, tpl, td)
+}
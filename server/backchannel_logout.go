@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+
+	"github.com/coreos/dex/pkg/crypto"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	ptime "github.com/coreos/dex/pkg/time"
+	"github.com/coreos/dex/session"
+)
+
+const (
+	// The event reported by logout tokens (OpenID Connect Back-Channel Logout
+	// 1.0 Section 2.4).
+	backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+	// logoutTokenJWTType is the typ header of logout tokens, which keeps them
+	// from being mistaken for ID tokens (OpenID Connect Back-Channel Logout
+	// 1.0 Section 2.4).
+	logoutTokenJWTType = "logout+jwt"
+
+	logoutTokenValidityWindow = 2 * time.Minute
+
+	backchannelLogoutAttempts   = 5
+	backchannelLogoutMaxBackoff = time.Minute
+)
+
+var defaultBackchannelLogoutClient = &http.Client{Timeout: 10 * time.Second}
+
+// NotifyLogout sends a logout token to every client the user logged in to
+// during the SSO session which registered a back-channel logout URI. Logout
+// tokens are delivered in the background, and failed deliveries are retried
+// with exponential backoff before giving up.
+func (s *Server) NotifyLogout(sso session.SSOSession) {
+	for _, clientID := range sso.ClientIDs {
+		cli, err := s.Client(clientID)
+		if err != nil {
+			log.Errorf("Failed fetching client %s for back-channel logout: %v", clientID, err)
+			continue
+		}
+		if cli.BackchannelLogoutURI == nil {
+			continue
+		}
+
+		jwt, err := s.logoutToken(clientID, sso)
+		if err != nil {
+			log.Errorf("Failed to generate logout token for client %s: %v", clientID, err)
+			continue
+		}
+		go s.deliverLogoutToken(clientID, *cli.BackchannelLogoutURI, jwt.Encode())
+	}
+}
+
+// logoutToken creates a logout token telling the client that the SSO session
+// has ended (OpenID Connect Back-Channel Logout 1.0 Section 2.4).
+func (s *Server) logoutToken(clientID string, sso session.SSOSession) (*jose.JWT, error) {
+	signer, err := s.idTokenSigner(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	jti, err := crypto.RandBytes(16)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := s.subject(clientID, sso.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := jose.Claims{
+		"iss": s.IssuerURL.String(),
+		"sub": sub,
+		"aud": clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(logoutTokenValidityWindow).Unix(),
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"sid": session.SID(sso.ID),
+		"events": map[string]interface{}{
+			backchannelLogoutEvent: map[string]interface{}{},
+		},
+	}
+	return newSignedJWT(claims, signer, logoutTokenJWTType)
+}
+
+func (s *Server) deliverLogoutToken(clientID string, u url.URL, token string) {
+	hc := s.BackchannelLogoutClient
+	if hc == nil {
+		hc = defaultBackchannelLogoutClient
+	}
+
+	var sleep time.Duration
+	for attempt := 1; ; attempt++ {
+		err := postLogoutToken(hc, u, token)
+		if err == nil {
+			log.Infof("Back-channel logout delivered: clientID=%s", clientID)
+			return
+		}
+		if attempt == backchannelLogoutAttempts {
+			log.Errorf("Giving up back-channel logout to client %s after %d attempts: %v", clientID, attempt, err)
+			return
+		}
+
+		sleep = ptime.ExpBackoff(sleep, backchannelLogoutMaxBackoff)
+		log.Errorf("Back-channel logout to client %s failed, retrying in %v: %v", clientID, sleep, err)
+		time.Sleep(sleep)
+	}
+}
+
+// postLogoutToken sends the logout token to the client's back-channel logout
+// URI (OpenID Connect Back-Channel Logout 1.0 Section 2.5).
+func postLogoutToken(hc phttp.Client, u url.URL, token string) error {
+	body := url.Values{"logout_token": []string{token}}.Encode()
+	req, err := http.NewRequest("POST", u.String(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+	"github.com/coreos/dex/signing"
+)
+
+const (
+	// The client assertion type of JWTs clients authenticate with (RFC 7523
+	// Section 2.2).
+	clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// The only algorithm client_secret_jwt clients may sign JWTs with.
+	clientSecretJWTAlg = "HS256"
+
+	// maxClientAssertionLifetime is the longest a client assertion may be
+	// valid for, which also bounds how long its ID must be remembered.
+	maxClientAssertionLifetime = 5 * time.Minute
+)
+
+var (
+	// clientAuthMethodsSupported are the ways clients may authenticate to the
+	// token, introspection and revocation endpoints.
+	clientAuthMethodsSupported = []string{
+		oauth2.AuthMethodClientSecretBasic,
+		oauth2.AuthMethodClientSecretPost,
+		oauth2.AuthMethodClientSecretJWT,
+		oauth2.AuthMethodPrivateKeyJWT,
+	}
+
+	// clientAuthSigningAlgsSupported are the algorithms the JWTs clients
+	// authenticate with may be signed with.
+	clientAuthSigningAlgsSupported = append([]string{clientSecretJWTAlg}, signing.Algorithms...)
+
+	defaultClientJWKSClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// clientCredentials returns the credentials the client presented with the
+// request, if any. The client may authenticate with its secret using the Basic
+// HTTP authentication scheme (RFC 6749 Section 2.3.1), with its secret in the
+// client_id and client_secret parameters, or with a JWT in the
+// client_assertion parameter (RFC 7523 Section 2.2). JWTs are returned in
+// place of the secret, and are told apart from secrets by the authentication
+// method the client registered.
+func clientCredentials(r *http.Request) (oidc.ClientCredentials, bool, error) {
+	creds, ok, err := basicAuthClientCredentials(r)
+	if err != nil {
+		return oidc.ClientCredentials{}, false, err
+	}
+
+	methods := 0
+	if ok {
+		methods++
+	}
+	clientID := r.PostForm.Get("client_id")
+	if secret := r.PostForm.Get("client_secret"); secret != "" {
+		// Some clients, such as go-oidc's, send their secret both ways.
+		post := oidc.ClientCredentials{ID: clientID, Secret: secret}
+		if !ok || post != creds {
+			creds = post
+			methods++
+		}
+	}
+	if assertion := r.PostForm.Get("client_assertion"); assertion != "" {
+		if typ := r.PostForm.Get("client_assertion_type"); typ != clientAssertionTypeJWTBearer {
+			return oidc.ClientCredentials{}, false, fmt.Errorf("unsupported client assertion type %q", typ)
+		}
+		// The client is identified by the JWT's subject, so its claims are
+		// read before its signature is verified.
+		jwt, err := jose.ParseJWT(assertion)
+		if err != nil {
+			return oidc.ClientCredentials{}, false, fmt.Errorf("invalid client assertion: %v", err)
+		}
+		claims, err := jwt.Claims()
+		if err != nil {
+			return oidc.ClientCredentials{}, false, fmt.Errorf("invalid client assertion: %v", err)
+		}
+		sub, _, _ := claims.StringClaim("sub")
+		if clientID != "" && clientID != sub {
+			return oidc.ClientCredentials{}, false, errors.New("client assertion subject doesn't match client_id")
+		}
+		creds = oidc.ClientCredentials{ID: sub, Secret: assertion}
+		methods++
+	}
+
+	switch {
+	case methods > 1:
+		// RFC 6749 Section 2.3.
+		return oidc.ClientCredentials{}, false, errors.New("more than one client authentication method used")
+	case methods == 0:
+		return oidc.ClientCredentials{}, false, nil
+	case creds.ID == "":
+		return oidc.ClientCredentials{}, false, errors.New("missing client ID")
+	}
+	return creds, true, nil
+}
+
+// usesClientAssertion reports whether the client authenticates with JWTs
+// rather than its secret.
+func usesClientAssertion(cli client.Client) bool {
+	switch cli.Metadata.TokenEndpointAuthMethod {
+	case oauth2.AuthMethodClientSecretJWT, oauth2.AuthMethodPrivateKeyJWT:
+		return true
+	}
+	return false
+}
+
+// verifyClientAssertion checks the JWT the client authenticated with, as
+// described by RFC 7523 Section 3, and records its ID so it can't be used
+// again.
+func (s *Server) verifyClientAssertion(cli client.Client, assertion string) error {
+	jwt, err := jose.ParseJWT(assertion)
+	if err != nil {
+		return err
+	}
+	claims, err := jwt.Claims()
+	if err != nil {
+		return err
+	}
+
+	clientID := cli.Credentials.ID
+	iss, _, _ := claims.StringClaim("iss")
+	sub, _, _ := claims.StringClaim("sub")
+	if iss != clientID || sub != clientID {
+		return errors.New("issuer and subject must be the client ID")
+	}
+	if !s.validClientAssertionAudience(audienceClaim(claims)) {
+		return errors.New("audience doesn't identify this server")
+	}
+
+	now := time.Now()
+	exp, ok, err := claims.TimeClaim("exp")
+	if err != nil || !ok {
+		return errors.New("missing expiry")
+	}
+	if now.After(exp) {
+		return errors.New("assertion has expired")
+	}
+	if exp.Sub(now) > maxClientAssertionLifetime {
+		return errors.New("assertion expires too far in the future")
+	}
+	if iat, ok, err := claims.TimeClaim("iat"); err != nil || (ok && exp.Sub(iat) > maxClientAssertionLifetime) {
+		return errors.New("assertion is valid for too long")
+	}
+	if nbf, ok, err := claims.TimeClaim("nbf"); err != nil || (ok && now.Before(nbf)) {
+		return errors.New("assertion is not valid yet")
+	}
+	jti, _, _ := claims.StringClaim("jti")
+	if jti == "" {
+		return errors.New("missing JWT ID")
+	}
+
+	alg := jwt.Header[jose.HeaderKeyAlgorithm]
+	if want := cli.Metadata.TokenEndpointAuthSigningAlg; want != "" && alg != want {
+		return fmt.Errorf("assertion signed with %q rather than %q", alg, want)
+	}
+	if err := s.verifyClientSignature(cli, jwt); err != nil {
+		return err
+	}
+
+	return s.ClientAssertionRepo.Use(clientID, jti, exp)
+}
+
+// verifyClientSignature checks that a JWT was signed by the client, with its
+// secret if it's a client_secret_jwt client and with its keys otherwise.
+func (s *Server) verifyClientSignature(cli client.Client, jwt jose.JWT) error {
+	alg := jwt.Header[jose.HeaderKeyAlgorithm]
+
+	if cli.Metadata.TokenEndpointAuthMethod == oauth2.AuthMethodClientSecretJWT {
+		if alg != clientSecretJWTAlg {
+			return fmt.Errorf("unsupported algorithm %q", alg)
+		}
+		secret, err := s.ClientManager.AssertionSecret(cli.Credentials.ID)
+		if err != nil {
+			return err
+		}
+		v, err := jose.NewVerifierHMAC(jose.JWK{Secret: secret})
+		if err != nil {
+			return err
+		}
+		return v.Verify(jwt.Signature, []byte(jwt.Data()))
+	}
+
+	if !signing.Supported(alg) {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	keys, err := s.clientJWKs(cli)
+	if err != nil {
+		return fmt.Errorf("fetching client keys: %v", err)
+	}
+	if !signing.VerifySignature(jwt, keys) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// validClientAssertionAudience reports whether the audience of a JWT a client
+// authenticates with includes dex, as either its issuer or one of the
+// endpoints clients authenticate to.
+func (s *Server) validClientAssertionAudience(aud []string) bool {
+	valid := []string{s.IssuerURL.String()}
+	for _, p := range []string{httpPathToken, httpPathIntrospect, httpPathRevoke, httpPathDeviceCode} {
+		u := s.absURL(p)
+		valid = append(valid, u.String())
+	}
+	for _, a := range aud {
+		if pstrings.ContainsString(valid, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientJWKs returns the keys a private_key_jwt client signs the JWTs it
+// authenticates with with, either registered along with the client or fetched
+// from its jwks_uri. Registered keys must be RSA keys, as that's all
+// oidc.ClientMetadata can hold.
+func (s *Server) clientJWKs(cli client.Client) ([]signing.JWK, error) {
+	if jwks := cli.Metadata.JWKS; jwks != nil {
+		keys := make([]signing.JWK, len(jwks.Keys))
+		for i, k := range jwks.Keys {
+			keys[i] = signing.NewJWK(k)
+			// Keys which don't name an algorithm may be used with any
+			// RSA algorithm.
+			keys[i].Alg = k.Alg
+		}
+		return keys, nil
+	}
+	if cli.Metadata.JWKSURI == nil {
+		return nil, errors.New("client has no keys")
+	}
+
+	hc := s.ClientJWKSClient
+	if hc == nil {
+		hc = defaultClientJWKSClient
+	}
+	return fetchJWKs(hc, *cli.Metadata.JWKSURI)
+}
+
+func fetchJWKs(hc phttp.Client, u url.URL) ([]signing.JWK, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+
+	// Keys of unsupported types are skipped rather than failing the whole
+	// set.
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	var keys []signing.JWK
+	for _, raw := range set.Keys {
+		var k signing.JWK
+		if err := json.Unmarshal(raw, &k); err != nil {
+			log.Debugf("Skipping client key: %v", err)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// authenticateClient checks the client's credentials. If allowPublic is set,
+// public clients may identify themselves without presenting a secret.
+func (s *Server) authenticateClient(creds oidc.ClientCredentials, allowPublic bool) error {
+	if creds.Secret == "" {
+		cli, err := s.Client(creds.ID)
+		if err != nil || !cli.Public || !allowPublic {
+			log.Errorf("Failed to Authenticate client %s without a secret", creds.ID)
+			return oauth2.NewError(oauth2.ErrorInvalidClient)
+		}
+		return nil
+	}
+
+	cli, err := s.Client(creds.ID)
+	if err == client.ErrorNotFound {
+		log.Errorf("Failed to Authenticate unknown client %s", creds.ID)
+		return oauth2.NewError(oauth2.ErrorInvalidClient)
+	}
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", creds.ID, err)
+		return oauth2.NewError(oauth2.ErrorServerError)
+	}
+	if usesClientAssertion(cli) {
+		if err := s.verifyClientAssertion(cli, creds.Secret); err != nil {
+			log.Errorf("Failed to Authenticate client %s: %v", creds.ID, err)
+			return oauth2.NewError(oauth2.ErrorInvalidClient)
+		}
+		return nil
+	}
+
+	ok, err := s.ClientManager.Authenticate(creds)
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", creds.ID, err)
+		return oauth2.NewError(oauth2.ErrorServerError)
+	}
+	if !ok {
+		log.Errorf("Failed to Authenticate client %s", creds.ID)
+		return oauth2.NewError(oauth2.ErrorInvalidClient)
+	}
+	return nil
+}
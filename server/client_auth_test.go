@@ -0,0 +1,336 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/signing"
+)
+
+func TestClientCredentials(t *testing.T) {
+	assertion := func(sub string) string {
+		jwt, err := jose.NewSignedJWT(jose.Claims{"sub": sub}, jose.NewSignerHMAC("", []byte("secret")))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return jwt.Encode()
+	}
+
+	tests := []struct {
+		form       url.Values
+		user, pass string
+
+		wantCreds oidc.ClientCredentials
+		wantOK    bool
+		wantErr   bool
+	}{
+		{
+			form: url.Values{},
+		},
+		{
+			form:      url.Values{},
+			user:      "client-1",
+			pass:      "secret",
+			wantCreds: oidc.ClientCredentials{ID: "client-1", Secret: "secret"},
+			wantOK:    true,
+		},
+		{
+			form:      url.Values{"client_id": {"client-1"}, "client_secret": {"secret"}},
+			wantCreds: oidc.ClientCredentials{ID: "client-1", Secret: "secret"},
+			wantOK:    true,
+		},
+		{
+			// The same secret may be sent both ways.
+			form:      url.Values{"client_id": {"client-1"}, "client_secret": {"secret"}},
+			user:      "client-1",
+			pass:      "secret",
+			wantCreds: oidc.ClientCredentials{ID: "client-1", Secret: "secret"},
+			wantOK:    true,
+		},
+		{
+			form:    url.Values{"client_id": {"client-1"}, "client_secret": {"other-secret"}},
+			user:    "client-1",
+			pass:    "secret",
+			wantErr: true,
+		},
+		{
+			// A client_id alone doesn't authenticate the client.
+			form: url.Values{"client_id": {"client-1"}},
+		},
+		{
+			form: url.Values{
+				"client_assertion_type": {clientAssertionTypeJWTBearer},
+				"client_assertion":      {assertion("client-1")},
+			},
+			wantCreds: oidc.ClientCredentials{ID: "client-1", Secret: assertion("client-1")},
+			wantOK:    true,
+		},
+		{
+			form: url.Values{
+				"client_id":             {"client-1"},
+				"client_assertion_type": {clientAssertionTypeJWTBearer},
+				"client_assertion":      {assertion("client-1")},
+			},
+			wantCreds: oidc.ClientCredentials{ID: "client-1", Secret: assertion("client-1")},
+			wantOK:    true,
+		},
+		{
+			form: url.Values{
+				"client_id":             {"client-2"},
+				"client_assertion_type": {clientAssertionTypeJWTBearer},
+				"client_assertion":      {assertion("client-1")},
+			},
+			wantErr: true,
+		},
+		{
+			form: url.Values{
+				"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:saml2-bearer"},
+				"client_assertion":      {assertion("client-1")},
+			},
+			wantErr: true,
+		},
+		{
+			form: url.Values{
+				"client_assertion_type": {clientAssertionTypeJWTBearer},
+				"client_assertion":      {assertion("client-1")},
+			},
+			user:    "client-1",
+			pass:    "secret",
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		r, err := http.NewRequest("POST", "http://example.com/token", strings.NewReader(tt.form.Encode()))
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		creds, ok, err := clientCredentials(r)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("case %d: want error %t, got %v", i, tt.wantErr, err)
+			continue
+		}
+		if ok != tt.wantOK || creds != tt.wantCreds {
+			t.Errorf("case %d: want %v, %t, got %v, %t", i, tt.wantCreds, tt.wantOK, creds, ok)
+		}
+	}
+}
+
+func TestHandleTokenFuncClientAuthentication(t *testing.T) {
+	secret := base64.URLEncoding.EncodeToString([]byte("secret"))
+	rsaKey, err := key.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ecKey, err := signing.GeneratePrivateKey(signing.ES256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newClient := func(id string, m oidc.ClientMetadata) client.LoadableClient {
+		m.RedirectURIs = []url.URL{{Scheme: "https", Host: id, Path: "/callback"}}
+		return client.LoadableClient{
+			Client: client.Client{
+				Credentials: oidc.ClientCredentials{ID: id, Secret: secret},
+				Metadata:    m,
+			},
+		}
+	}
+	clients := []client.LoadableClient{
+		newClient("secret.example.com", oidc.ClientMetadata{}),
+		newClient("secret-jwt.example.com", oidc.ClientMetadata{
+			TokenEndpointAuthMethod: oauth2.AuthMethodClientSecretJWT,
+		}),
+		newClient("key-jwt.example.com", oidc.ClientMetadata{
+			TokenEndpointAuthMethod: oauth2.AuthMethodPrivateKeyJWT,
+			JWKS:                    &jose.JWKSet{Keys: []jose.JWK{rsaKey.JWK()}},
+		}),
+		newClient("uri-jwt.example.com", oidc.ClientMetadata{
+			TokenEndpointAuthMethod:     oauth2.AuthMethodPrivateKeyJWT,
+			TokenEndpointAuthSigningAlg: signing.ES256,
+			JWKSURI:                     &url.URL{Scheme: "https", Host: "uri-jwt.example.com", Path: "/keys"},
+		}),
+	}
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+
+	keys := http.NewServeMux()
+	keys.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(signing.JWKSet{Keys: []signing.JWK{ecKey.JWK()}})
+	})
+	f.srv.ClientJWKSClient = &phttp.HandlerClient{Handler: keys}
+
+	hmacSigner := jose.NewSignerHMAC("", []byte(secret))
+	jti := 0
+	assertion := func(clientID string, signer jose.Signer, edit func(jose.Claims)) string {
+		jti++
+		claims := jose.Claims{
+			"iss": clientID,
+			"sub": clientID,
+			"aud": "http://server.example.com/token",
+			"jti": "jti-" + strconv.Itoa(jti),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		if edit != nil {
+			edit(claims)
+		}
+		jwt, err := jose.NewSignedJWT(claims, signer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return jwt.Encode()
+	}
+	assertionForm := func(assertion string) url.Values {
+		return url.Values{
+			"client_assertion_type": {clientAssertionTypeJWTBearer},
+			"client_assertion":      {assertion},
+		}
+	}
+	replayed := assertion("secret-jwt.example.com", hmacSigner, nil)
+
+	tests := []struct {
+		form     url.Values
+		wantCode int
+	}{
+		{
+			// client_secret_post
+			form:     url.Values{"client_id": {"secret.example.com"}, "client_secret": {secret}},
+			wantCode: http.StatusOK,
+		},
+		{
+			form:     url.Values{"client_id": {"secret.example.com"}, "client_secret": {"bad-secret"}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			// Clients which registered a JWT authentication method can't
+			// authenticate with their secret.
+			form:     url.Values{"client_id": {"secret-jwt.example.com"}, "client_secret": {secret}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			// Clients which didn't can't authenticate with a JWT.
+			form:     assertionForm(assertion("secret.example.com", hmacSigner, nil)),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form:     assertionForm(replayed),
+			wantCode: http.StatusOK,
+		},
+		{
+			form:     assertionForm(replayed),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form: assertionForm(assertion("secret-jwt.example.com", hmacSigner, func(c jose.Claims) {
+				c["aud"] = []string{"https://other.example.com", "http://server.example.com"}
+			})),
+			wantCode: http.StatusOK,
+		},
+		{
+			form: assertionForm(assertion("secret-jwt.example.com", hmacSigner, func(c jose.Claims) {
+				c["aud"] = "https://other.example.com"
+			})),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form: assertionForm(assertion("secret-jwt.example.com", hmacSigner, func(c jose.Claims) {
+				c["exp"] = time.Now().Add(-time.Minute).Unix()
+			})),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form: assertionForm(assertion("secret-jwt.example.com", hmacSigner, func(c jose.Claims) {
+				c["exp"] = time.Now().Add(time.Hour).Unix()
+			})),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form: assertionForm(assertion("secret-jwt.example.com", hmacSigner, func(c jose.Claims) {
+				c["iat"] = time.Now().Add(-time.Hour).Unix()
+			})),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form: assertionForm(assertion("secret-jwt.example.com", hmacSigner, func(c jose.Claims) {
+				delete(c, "jti")
+			})),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form: assertionForm(assertion("secret-jwt.example.com", hmacSigner, func(c jose.Claims) {
+				c["iss"] = "secret.example.com"
+			})),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form:     assertionForm(assertion("secret-jwt.example.com", jose.NewSignerHMAC("", []byte("bad-secret")), nil)),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			// client_secret_jwt clients must sign with their secret.
+			form:     assertionForm(assertion("secret-jwt.example.com", rsaKey.Signer(), nil)),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form:     assertionForm(assertion("key-jwt.example.com", rsaKey.Signer(), nil)),
+			wantCode: http.StatusOK,
+		},
+		{
+			// A private_key_jwt client's secret isn't a key.
+			form:     assertionForm(assertion("key-jwt.example.com", hmacSigner, nil)),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form:     assertionForm(assertion("key-jwt.example.com", testPrivKey.Signer(), nil)),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			form:     assertionForm(assertion("uri-jwt.example.com", ecKey.Signer(), nil)),
+			wantCode: http.StatusOK,
+		},
+		{
+			// The client registered ES256 as its signing algorithm.
+			form:     assertionForm(assertion("uri-jwt.example.com", rsaKey.Signer(), nil)),
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for i, tt := range tests {
+		tt.form.Set("grant_type", oauth2.GrantTypeClientCreds)
+		req, err := http.NewRequest("POST", "http://server.example.com/token", strings.NewReader(tt.form.Encode()))
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		handleTokenFunc(f.srv).ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("case %d: want HTTP %d, got %d: %s", i, tt.wantCode, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+)
+
+// clientConfigurationURL is where a dynamically registered client manages its
+// registration (RFC 7592 Section 2).
+func (s *Server) clientConfigurationURL(clientID string) *url.URL {
+	u := s.absURL(httpPathClientRegistration, clientID)
+	return &u
+}
+
+// handleClientConfiguration lets dynamically registered clients read, update
+// and delete their registration, authenticating with the registration access
+// token they were issued when they registered.
+func (s *Server) handleClientConfiguration(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "PUT", "DELETE":
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		phttp.WriteError(w, http.StatusMethodNotAllowed, "GET, PUT and DELETE only acceptable methods")
+		return
+	}
+
+	clientID := strings.TrimPrefix(r.URL.Path, s.absURL(httpPathClientRegistration).Path+"/")
+	cli, err := s.authenticateClientConfiguration(r, clientID)
+	if err != nil {
+		writeBearerError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeResponseWithBody(w, http.StatusOK, s.newClientRegistrationResponse(cli))
+	case "PUT":
+		resp, aerr := s.handleClientUpdateRequest(r, cli)
+		if aerr != nil {
+			code := http.StatusBadRequest
+			if aerr.Type == oauth2.ErrorServerError {
+				code = http.StatusInternalServerError
+			}
+			writeResponseWithBody(w, code, aerr)
+			return
+		}
+		writeResponseWithBody(w, http.StatusOK, resp)
+	case "DELETE":
+		if err := s.ClientManager.Delete(clientID); err != nil {
+			log.Errorf("Failed to delete client %s: %v", clientID, err)
+			writeAPIError(w, http.StatusInternalServerError, newAPIError(oauth2.ErrorServerError, "unable to delete client"))
+			return
+		}
+		s.clientOrigins.invalidate()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authenticateClientConfiguration returns the client whose registration the
+// request manages, if it carries the client's registration access token.
+// Unknown clients are reported like invalid tokens (RFC 7592 Section 2).
+func (s *Server) authenticateClientConfiguration(r *http.Request, clientID string) (client.Client, error) {
+	token, err := oidc.ExtractBearerToken(r)
+	if err != nil {
+		oerr := oauth2.NewError(errorInvalidToken)
+		oerr.Description = "missing or invalid bearer token"
+		return client.Client{}, oerr
+	}
+
+	ok, err := s.ClientManager.AuthenticateRegistrationAccessToken(clientID, token)
+	if err != nil {
+		log.Errorf("Failed to authenticate registration access token of client %s: %v", clientID, err)
+		return client.Client{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	if !ok {
+		oerr := oauth2.NewError(errorInvalidToken)
+		oerr.Description = "invalid registration access token"
+		return client.Client{}, oerr
+	}
+
+	cli, err := s.Client(clientID)
+	if err != nil {
+		log.Errorf("Failed to get client %s: %v", clientID, err)
+		return client.Client{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	return cli, nil
+}
+
+// handleClientUpdateRequest replaces the registered metadata of the client with
+// that of the request (RFC 7592 Section 2.2). Clients which include their
+// secret in the request keep it; otherwise a new secret is issued.
+func (s *Server) handleClientUpdateRequest(r *http.Request, cli client.Client) (*clientRegistrationResponse, *apiError) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, newAPIError(oauth2.ErrorInvalidRequest, err.Error())
+	}
+	var creds struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return nil, newAPIError(oauth2.ErrorInvalidRequest, err.Error())
+	}
+	if creds.ClientID != cli.Credentials.ID {
+		return nil, newAPIError(oauth2.ErrorInvalidRequest, "client_id does not match the registration being updated")
+	}
+	if creds.ClientSecret != "" {
+		ok, err := s.ClientManager.Authenticate(oidc.ClientCredentials{ID: creds.ClientID, Secret: creds.ClientSecret})
+		if err != nil {
+			log.Errorf("Failed to authenticate client %s: %v", creds.ClientID, err)
+			return nil, newAPIError(oauth2.ErrorServerError, "unable to save client metadata")
+		}
+		if !ok {
+			return nil, newAPIError(oauth2.ErrorInvalidRequest, "client_secret does not match the client's secret")
+		}
+	}
+
+	updated, aerr := s.registeredClient(body)
+	if aerr != nil {
+		return nil, aerr
+	}
+	if updated.Public != cli.Public {
+		return nil, newAPIError(invalidClientMetadata, "public clients cannot become confidential clients, nor vice versa")
+	}
+	// Clients which start authenticating with client_secret_jwt need a new
+	// secret, as only the hash of their previous one is kept.
+	rotateSecret := creds.ClientSecret == "" ||
+		(updated.Metadata.TokenEndpointAuthMethod == oauth2.AuthMethodClientSecretJWT &&
+			cli.Metadata.TokenEndpointAuthMethod != oauth2.AuthMethodClientSecretJWT)
+
+	// Only the metadata the client registered is replaced.
+	cli.Metadata = updated.Metadata
+	cli.PostLogoutRedirectURIs = updated.PostLogoutRedirectURIs
+	cli.BackchannelLogoutURI = updated.BackchannelLogoutURI
+	cli.RequirePushedAuthorizationRequests = updated.RequirePushedAuthorizationRequests
+
+	if err := s.ClientManager.Update(cli); err != nil {
+		log.Errorf("Failed to update client %s: %v", cli.Credentials.ID, err)
+		return nil, newAPIError(oauth2.ErrorServerError, "unable to save client metadata")
+	}
+	s.clientOrigins.invalidate()
+
+	if rotateSecret {
+		rotated, err := s.ClientManager.RotateSecret(cli.Credentials.ID)
+		if err != nil {
+			log.Errorf("Failed to rotate secret of client %s: %v", cli.Credentials.ID, err)
+			return nil, newAPIError(oauth2.ErrorServerError, "unable to save client metadata")
+		}
+		cli.Credentials = *rotated
+	} else {
+		cli.Credentials.Secret = creds.ClientSecret
+	}
+	return s.newClientRegistrationResponse(cli), nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-oidc/oidc"
+)
+
+func TestClientConfiguration(t *testing.T) {
+	var handler http.Handler
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	defer testServer.Close()
+
+	issuerURL, err := url.Parse(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	f.srv.IssuerURL = *issuerURL
+	f.srv.EnableClientRegistration = true
+	handler = f.srv.HTTPHandler()
+
+	resp, err := http.Post(testServer.URL+httpPathClientRegistration, "application/json", strings.NewReader(`{
+		"redirect_uris": ["https://client.example.org/callback"],
+		"client_name": "Example"
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var reg oidc.ClientRegistrationResponse
+	err = json.NewDecoder(resp.Body).Decode(&reg)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if reg.RegistrationAccessToken == "" {
+		t.Fatalf("no registration_access_token in registration response")
+	}
+	if want := testServer.URL + httpPathClientRegistration + "/" + reg.ClientID; reg.RegistrationClientURI != want {
+		t.Fatalf("want registration_client_uri %q, got %q", want, reg.RegistrationClientURI)
+	}
+
+	do := func(method, token, body string) (int, *oidc.ClientRegistrationResponse) {
+		req, err := http.NewRequest(method, reg.RegistrationClientURI, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		var r oidc.ClientRegistrationResponse
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp.StatusCode, &r
+	}
+
+	if code, _ := do("GET", "bogus", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET with invalid token: want status %d, got %d", http.StatusUnauthorized, code)
+	}
+	code, r := do("GET", reg.RegistrationAccessToken, "")
+	if code != http.StatusOK {
+		t.Fatalf("GET: want status %d, got %d", http.StatusOK, code)
+	}
+	if r.ClientID != reg.ClientID || r.ClientName != "Example" || r.ClientSecret != "" {
+		t.Errorf("GET: unexpected registration: %#v", r)
+	}
+
+	// The client_id of an update must be that of the registration.
+	if code, _ := do("PUT", reg.RegistrationAccessToken, `{
+		"client_id": "other.example.org",
+		"redirect_uris": ["https://client.example.org/callback"]
+	}`); code != http.StatusBadRequest {
+		t.Errorf("PUT of other client: want status %d, got %d", http.StatusBadRequest, code)
+	}
+	if code, _ := do("PUT", reg.RegistrationAccessToken, `{
+		"client_id": "`+reg.ClientID+`",
+		"redirect_uris": ["https://client.example.org/callback"],
+		"grant_types": ["password"]
+	}`); code != http.StatusBadRequest {
+		t.Errorf("PUT of invalid metadata: want status %d, got %d", http.StatusBadRequest, code)
+	}
+
+	code, r = do("PUT", reg.RegistrationAccessToken, `{
+		"client_id": "`+reg.ClientID+`",
+		"redirect_uris": ["https://client.example.org/callback2"]
+	}`)
+	if code != http.StatusOK {
+		t.Fatalf("PUT: want status %d, got %d", http.StatusOK, code)
+	}
+	if r.ClientSecret == "" {
+		t.Errorf("PUT without client_secret: want a new secret")
+	}
+	cli, err := f.srv.Client(reg.ClientID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cli.Metadata.RedirectURIs) != 1 || cli.Metadata.RedirectURIs[0].Path != "/callback2" || cli.Metadata.ClientName != "" {
+		t.Errorf("PUT: metadata not replaced: %#v", cli.Metadata)
+	}
+	if ok, _ := f.clientManager.Authenticate(oidc.ClientCredentials{ID: reg.ClientID, Secret: r.ClientSecret}); !ok {
+		t.Errorf("PUT: secret in response not accepted")
+	}
+
+	if code, _ := do("DELETE", reg.RegistrationAccessToken, ""); code != http.StatusNoContent {
+		t.Fatalf("DELETE: want status %d, got %d", http.StatusNoContent, code)
+	}
+	if _, err := f.srv.Client(reg.ClientID); err == nil {
+		t.Errorf("DELETE: client not deleted")
+	}
+	if code, _ := do("GET", reg.RegistrationAccessToken, ""); code != http.StatusUnauthorized {
+		t.Errorf("GET of deleted client: want status %d, got %d", http.StatusUnauthorized, code)
+	}
+}
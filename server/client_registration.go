@@ -2,10 +2,18 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/coreos/dex/client"
+	phttp "github.com/coreos/dex/pkg/http"
 	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+	"github.com/coreos/dex/signing"
 
 	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
@@ -14,8 +22,14 @@ import (
 const (
 	invalidRedirectURI    = "invalid_redirect_uri"
 	invalidClientMetadata = "invalid_client_metadata"
+
+	// authMethodNone is registered by public clients, which don't
+	// authenticate at the token endpoint (RFC 7591 Section 2).
+	authMethodNone = "none"
 )
 
+var defaultSectorIdentifierClient = &http.Client{Timeout: 10 * time.Second}
+
 func (s *Server) handleClientRegistration(w http.ResponseWriter, r *http.Request) {
 	resp, err := s.handleClientRegistrationRequest(r)
 	if err != nil {
@@ -29,28 +43,333 @@ func (s *Server) handleClientRegistration(w http.ResponseWriter, r *http.Request
 	}
 }
 
-func (s *Server) handleClientRegistrationRequest(r *http.Request) (*oidc.ClientRegistrationResponse, *apiError) {
+// clientMetadataExtensions is client metadata defined by specifications other
+// than OpenID Connect Dynamic Client Registration, which dex accepts alongside
+// oidc.ClientMetadata.
+type clientMetadataExtensions struct {
+	// OpenID Connect RP-Initiated Logout 1.0 Section 3.1.
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris,omitempty"`
+
+	// OpenID Connect Back-Channel Logout 1.0 Section 2.2.
+	BackchannelLogoutURI string `json:"backchannel_logout_uri,omitempty"`
+
+	// RFC 9126 Section 6.
+	RequirePushedAuthorizationRequests bool `json:"require_pushed_authorization_requests,omitempty"`
+}
+
+// clientRegistrationResponse is an oidc.ClientRegistrationResponse which also
+// reports the client's extension metadata.
+type clientRegistrationResponse struct {
+	oidc.ClientRegistrationResponse
+	Extensions clientMetadataExtensions
+}
+
+func (c *clientRegistrationResponse) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(&c.ClientRegistrationResponse)
+	if err != nil {
+		return nil, err
+	}
+	ext, err := json.Marshal(c.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	return appendJSONObject(b, ext), nil
+}
+
+// parseURIs parses the values of a client metadata field which lists absolute
+// URIs without fragments.
+func parseURIs(field string, uris []string) ([]url.URL, error) {
+	var parsed []url.URL
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid %s %q", field, uri)
+		}
+		parsed = append(parsed, *u)
+	}
+	return parsed, nil
+}
+
+// validClientAuthMetadata checks that the client registered a supported way of
+// authenticating, or none, and that private_key_jwt clients registered their
+// keys.
+func validClientAuthMetadata(m oidc.ClientMetadata) error {
+	method := m.TokenEndpointAuthMethod
+	if method != "" && method != authMethodNone && !pstrings.ContainsString(clientAuthMethodsSupported, method) {
+		return fmt.Errorf("unsupported token_endpoint_auth_method %q", method)
+	}
+
+	switch alg := m.TokenEndpointAuthSigningAlg; {
+	case alg == "":
+	case method == oauth2.AuthMethodClientSecretJWT && alg != clientSecretJWTAlg,
+		method == oauth2.AuthMethodPrivateKeyJWT && !signing.Supported(alg),
+		method != oauth2.AuthMethodClientSecretJWT && method != oauth2.AuthMethodPrivateKeyJWT:
+		return fmt.Errorf("unsupported token_endpoint_auth_signing_alg %q", alg)
+	}
+
+	if method == oauth2.AuthMethodPrivateKeyJWT {
+		// OpenID Connect Dynamic Client Registration 1.0 Section 2.
+		if (m.JWKS == nil) == (m.JWKSURI == nil) {
+			return errors.New("private_key_jwt clients must register exactly one of jwks and jwks_uri")
+		}
+	}
+	return nil
+}
+
+// validSubjectType checks that the client registered a supported subject type.
+// The sector identifier URI of pairwise clients must list all of their
+// redirect URIs, and those which registered none must only have redirect URIs
+// on one host (OpenID Connect Core 1.0 Section 8.1). Pairwise clients are only
+// accepted if a pairwise subject salt is configured.
+func (s *Server) validSubjectType(m oidc.ClientMetadata) error {
+	switch m.SubjectType {
+	case "", oidc.SubjectTypePublic:
+		return nil
+	case oidc.SubjectTypePairwise:
+		if len(s.PairwiseSubjectSalt) == 0 {
+			return errors.New("pairwise subject_type is not supported")
+		}
+	default:
+		return fmt.Errorf("unsupported subject_type %q", m.SubjectType)
+	}
+
+	if m.SectorIdentifierURI == nil {
+		_, err := client.Client{Metadata: m}.SectorIdentifier()
+		return err
+	}
+	if m.SectorIdentifierURI.Scheme != "https" {
+		return errors.New("sector_identifier_uri must use https")
+	}
+
+	hc := s.SectorIdentifierClient
+	if hc == nil {
+		hc = defaultSectorIdentifierClient
+	}
+	uris, err := fetchSectorRedirectURIs(hc, *m.SectorIdentifierURI)
+	if err != nil {
+		return fmt.Errorf("failed to fetch sector_identifier_uri: %v", err)
+	}
+	for _, u := range m.RedirectURIs {
+		if !pstrings.ContainsString(uris, u.String()) {
+			return fmt.Errorf("redirect URI %q is not listed by sector_identifier_uri", u.String())
+		}
+	}
+	return nil
+}
+
+// fetchSectorRedirectURIs fetches the JSON array of redirect URIs served from
+// a sector identifier URI.
+func fetchSectorRedirectURIs(hc phttp.Client, u url.URL) ([]string, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+	var uris []string
+	if err := json.NewDecoder(resp.Body).Decode(&uris); err != nil {
+		return nil, err
+	}
+	return uris, nil
+}
+
+// nativeClientMetadata is the client metadata which determines whether a
+// client registers as a public client, and the redirect URIs it registers.
+type nativeClientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	ApplicationType         string   `json:"application_type"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+}
+
+// public reports whether the client registers as a public client: native
+// apps can't keep a secret (RFC 8252 Section 8.5), so they register as such
+// unless they ask for another way of authenticating, which isn't supported.
+func (m nativeClientMetadata) public() (bool, error) {
+	switch {
+	case m.TokenEndpointAuthMethod == authMethodNone:
+		return true, nil
+	case m.ApplicationType != "native":
+		return false, nil
+	case m.TokenEndpointAuthMethod == "":
+		return true, nil
+	}
+	return false, fmt.Errorf("native clients must use token_endpoint_auth_method %q", authMethodNone)
+}
+
+// nativeRedirectURIs parses the redirect URIs a public client registered,
+// which must be loopback or private-use URI scheme redirect URIs.
+func (m nativeClientMetadata) nativeRedirectURIs() ([]url.URL, error) {
+	if len(m.RedirectURIs) == 0 {
+		return nil, errors.New("zero redirect URLs")
+	}
+	uris, err := parseURIs("redirect_uri", m.RedirectURIs)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range uris {
+		if !client.ValidNativeRedirectURL(u) {
+			return nil, fmt.Errorf("redirect URI %q is neither a loopback nor a private-use URI scheme redirect URI", u.String())
+		}
+	}
+	return uris, nil
+}
+
+// withPlaceholderRedirectURIs replaces the redirect URIs in a registration
+// request's body, as oidc.ClientMetadata only accepts HTTP(S) ones.
+func withPlaceholderRedirectURIs(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	fields["redirect_uris"] = json.RawMessage(`["http://localhost:0"]`)
+	return json.Marshal(fields)
+}
+
+// registeredClient validates the client metadata of a registration request,
+// returning the client it registers.
+func (s *Server) registeredClient(body []byte) (client.Client, *apiError) {
+	var native nativeClientMetadata
+	if err := json.Unmarshal(body, &native); err != nil {
+		return client.Client{}, newAPIError(oauth2.ErrorInvalidRequest, err.Error())
+	}
+	public, err := native.public()
+	if err != nil {
+		return client.Client{}, newAPIError(invalidClientMetadata, err.Error())
+	}
+	var redirectURIs []url.URL
+	if public {
+		if redirectURIs, err = native.nativeRedirectURIs(); err != nil {
+			return client.Client{}, newAPIError(invalidRedirectURI, err.Error())
+		}
+		// The client's own redirect URIs are restored once the rest of
+		// its metadata has been validated.
+		if body, err = withPlaceholderRedirectURIs(body); err != nil {
+			return client.Client{}, newAPIError(oauth2.ErrorInvalidRequest, err.Error())
+		}
+	}
+
 	var clientMetadata oidc.ClientMetadata
-	if err := json.NewDecoder(r.Body).Decode(&clientMetadata); err != nil {
-		return nil, newAPIError(oauth2.ErrorInvalidRequest, err.Error())
+	if err := json.Unmarshal(body, &clientMetadata); err != nil {
+		return client.Client{}, newAPIError(oauth2.ErrorInvalidRequest, err.Error())
+	}
+	var extensions clientMetadataExtensions
+	if err := json.Unmarshal(body, &extensions); err != nil {
+		return client.Client{}, newAPIError(oauth2.ErrorInvalidRequest, err.Error())
 	}
 	if err := s.ProviderConfig().Supports(clientMetadata); err != nil {
-		return nil, newAPIError(invalidClientMetadata, err.Error())
+		return client.Client{}, newAPIError(invalidClientMetadata, err.Error())
+	}
+	// Clients must be explicitly allowed to use the password grant by an
+	// administrator.
+	if pstrings.ContainsString(clientMetadata.GrantTypes, oauth2.GrantTypeUserCreds) {
+		return client.Client{}, newAPIError(invalidClientMetadata, "grant type \"password\" cannot be registered dynamically")
+	}
+
+	if err := validClientAuthMetadata(clientMetadata); err != nil {
+		return client.Client{}, newAPIError(invalidClientMetadata, err.Error())
+	}
+
+	if public {
+		if clientMetadata.ClientName == "" {
+			return client.Client{}, newAPIError(invalidClientMetadata, client.ErrorPublicClientMissingName.Error())
+		}
+		clientMetadata.RedirectURIs = redirectURIs
+		clientMetadata.TokenEndpointAuthMethod = authMethodNone
+	}
+
+	if err := s.validSubjectType(clientMetadata); err != nil {
+		return client.Client{}, newAPIError(invalidClientMetadata, err.Error())
+	}
+
+	postLogoutRedirectURIs, err := parseURIs("post_logout_redirect_uri", extensions.PostLogoutRedirectURIs)
+	if err != nil {
+		return client.Client{}, newAPIError(invalidClientMetadata, err.Error())
+	}
+
+	var backchannelLogoutURI *url.URL
+	if extensions.BackchannelLogoutURI != "" {
+		uris, err := parseURIs("backchannel_logout_uri", []string{extensions.BackchannelLogoutURI})
+		if err != nil {
+			return client.Client{}, newAPIError(invalidClientMetadata, err.Error())
+		}
+		backchannelLogoutURI = &uris[0]
 	}
 
 	// metadata is guarenteed to have at least one redirect_uri by earlier validation.
-	cli := client.Client{
-		Metadata: clientMetadata,
+	return client.Client{
+		Public:                 public,
+		Metadata:               clientMetadata,
+		PostLogoutRedirectURIs: postLogoutRedirectURIs,
+		BackchannelLogoutURI:   backchannelLogoutURI,
+
+		RequirePushedAuthorizationRequests: extensions.RequirePushedAuthorizationRequests,
+	}, nil
+}
+
+// newClientRegistrationResponse reports the registered metadata of a client,
+// and the URI it manages its registration at.
+func (s *Server) newClientRegistrationResponse(cli client.Client) *clientRegistrationResponse {
+	var postLogoutRedirectURIs []string
+	for _, u := range cli.PostLogoutRedirectURIs {
+		postLogoutRedirectURIs = append(postLogoutRedirectURIs, u.String())
+	}
+	var backchannelLogoutURI string
+	if cli.BackchannelLogoutURI != nil {
+		backchannelLogoutURI = cli.BackchannelLogoutURI.String()
+	}
+	// Public clients aren't issued a secret they couldn't keep.
+	secret := cli.Credentials.Secret
+	if cli.Public {
+		secret = ""
+	}
+	return &clientRegistrationResponse{
+		ClientRegistrationResponse: oidc.ClientRegistrationResponse{
+			ClientID:              cli.Credentials.ID,
+			ClientSecret:          secret,
+			RegistrationClientURI: s.clientConfigurationURL(cli.Credentials.ID).String(),
+			ClientMetadata:        cli.Metadata,
+		},
+		Extensions: clientMetadataExtensions{
+			PostLogoutRedirectURIs: postLogoutRedirectURIs,
+			BackchannelLogoutURI:   backchannelLogoutURI,
+
+			RequirePushedAuthorizationRequests: cli.RequirePushedAuthorizationRequests,
+		},
+	}
+}
+
+func (s *Server) handleClientRegistrationRequest(r *http.Request) (*clientRegistrationResponse, *apiError) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, newAPIError(oauth2.ErrorInvalidRequest, err.Error())
+	}
+	cli, aerr := s.registeredClient(body)
+	if aerr != nil {
+		return nil, aerr
 	}
+
 	creds, err := s.ClientManager.New(cli, nil)
 	if err != nil {
 		log.Errorf("Failed to create new client identity: %v", err)
 		return nil, newAPIError(oauth2.ErrorServerError, "unable to save client metadata")
 	}
+	s.clientOrigins.invalidate()
+	cli.Credentials = *creds
 
-	return &oidc.ClientRegistrationResponse{
-		ClientID:       creds.ID,
-		ClientSecret:   creds.Secret,
-		ClientMetadata: clientMetadata,
-	}, nil
+	token, err := s.ClientManager.NewRegistrationAccessToken(creds.ID)
+	if err != nil {
+		log.Errorf("Failed to create registration access token: %v", err)
+		return nil, newAPIError(oauth2.ErrorServerError, "unable to save client metadata")
+	}
+
+	resp := s.newClientRegistrationResponse(cli)
+	resp.RegistrationAccessToken = token
+	return resp, nil
 }
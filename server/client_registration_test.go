@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -13,6 +14,8 @@ import (
 	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
 	"github.com/kylelemons/godebug/pretty"
+
+	phttp "github.com/coreos/dex/pkg/http"
 )
 
 func TestClientRegistration(t *testing.T) {
@@ -71,6 +74,180 @@ func TestClientRegistration(t *testing.T) {
 			}`,
 			http.StatusCreated,
 		},
+		{
+			// The sector identifier URI must list all redirect URIs.
+			`{
+				"redirect_uris":[
+					"https://client.example.org/callback",
+					"https://client.example.org/callback2"
+				],
+				"subject_type": "pairwise",
+				"sector_identifier_uri": "https://other.example.net/partial_redirect_uris.json"
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			`{
+				"redirect_uris":[
+					"https://client.example.org/callback",
+					"https://client.example.org/callback2"
+				],
+				"subject_type": "pairwise"
+			}`,
+			http.StatusCreated,
+		},
+		{
+			// Redirect URIs on several hosts require a sector identifier URI.
+			`{
+				"redirect_uris":[
+					"https://client.example.org/callback",
+					"https://client2.example.org/callback"
+				],
+				"subject_type": "pairwise"
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			`{
+				"redirect_uris":[
+					"https://client.example.org/callback"
+				],
+				"subject_type": "anonymous"
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			// The password grant can't be requested through dynamic registration.
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"grant_types": ["authorization_code", "password"]
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"post_logout_redirect_uris": [
+					"https://client.example.org/logged-out"
+				]
+			}`,
+			http.StatusCreated,
+		},
+		{
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"require_pushed_authorization_requests": true,
+				"request_object_signing_alg": "ES256"
+			}`,
+			http.StatusCreated,
+		},
+		{
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"request_object_signing_alg": "none"
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			// Post logout redirect URIs must be absolute.
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"post_logout_redirect_uris": [
+					"/logged-out"
+				]
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"id_token_signed_response_alg": "RS256"
+			}`,
+			http.StatusCreated,
+		},
+		{
+			// ID tokens are only signed with the algorithms keys are
+			// generated for.
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"id_token_signed_response_alg": "ES256"
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"token_endpoint_auth_method": "client_secret_post"
+			}`,
+			http.StatusCreated,
+		},
+		{
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"token_endpoint_auth_method": "client_secret_jwt",
+				"token_endpoint_auth_signing_alg": "HS256"
+			}`,
+			http.StatusCreated,
+		},
+		{
+			// client_secret_jwt clients sign JWTs with their secret.
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"token_endpoint_auth_method": "client_secret_jwt",
+				"token_endpoint_auth_signing_alg": "RS256"
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"token_endpoint_auth_method": "private_key_jwt",
+				"token_endpoint_auth_signing_alg": "ES256",
+				"jwks_uri": "https://client.example.org/keys"
+			}`,
+			http.StatusCreated,
+		},
+		{
+			// private_key_jwt clients must register their keys.
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"token_endpoint_auth_method": "private_key_jwt"
+			}`,
+			http.StatusBadRequest,
+		},
+		{
+			`{
+				"redirect_uris": [
+					"https://client.example.org/callback"
+				],
+				"token_endpoint_auth_method": "tls_client_auth"
+			}`,
+			http.StatusBadRequest,
+		},
 	}
 
 	var handler http.Handler
@@ -85,6 +262,14 @@ func TestClientRegistration(t *testing.T) {
 	}
 	defer testServer.Close()
 
+	sectors := http.NewServeMux()
+	sectors.HandleFunc("/file_of_redirect_uris.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]string{"https://client.example.org/callback", "https://client.example.org/callback2"})
+	})
+	sectors.HandleFunc("/partial_redirect_uris.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]string{"https://client.example.org/callback"})
+	})
+
 	for i, tt := range tests {
 		fixtures, err := makeTestFixtures()
 		if err != nil {
@@ -92,6 +277,7 @@ func TestClientRegistration(t *testing.T) {
 		}
 		fixtures.srv.IssuerURL = *issuerURL
 		fixtures.srv.EnableClientRegistration = true
+		fixtures.srv.SectorIdentifierClient = &phttp.HandlerClient{Handler: sectors}
 
 		handler = fixtures.srv.HTTPHandler()
 
@@ -135,8 +321,16 @@ func TestClientRegistration(t *testing.T) {
 			}
 
 			// Read registration response.
+			respBody, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return fmt.Errorf("read response: %v", err)
+			}
 			var r oidc.ClientRegistrationResponse
-			if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			if err := json.Unmarshal(respBody, &r); err != nil {
+				return fmt.Errorf("decode response: %v", err)
+			}
+			var ext clientMetadataExtensions
+			if err := json.Unmarshal(respBody, &ext); err != nil {
 				return fmt.Errorf("decode response: %v", err)
 			}
 			if r.ClientID == "" {
@@ -152,6 +346,21 @@ func TestClientRegistration(t *testing.T) {
 				return fmt.Errorf("metadata in response did not match metadata in db: %s", diff)
 			}
 
+			cli, err := fixtures.srv.Client(r.ClientID)
+			if err != nil {
+				return fmt.Errorf("failed to lookup client id after creation")
+			}
+			var postLogoutRedirectURIs []string
+			for _, u := range cli.PostLogoutRedirectURIs {
+				postLogoutRedirectURIs = append(postLogoutRedirectURIs, u.String())
+			}
+			if diff := pretty.Compare(postLogoutRedirectURIs, ext.PostLogoutRedirectURIs); diff != "" {
+				return fmt.Errorf("post logout redirect URIs in response did not match db: %s", diff)
+			}
+			if cli.RequirePushedAuthorizationRequests != ext.RequirePushedAuthorizationRequests {
+				return fmt.Errorf("want require_pushed_authorization_requests %t, got %t", ext.RequirePushedAuthorizationRequests, cli.RequirePushedAuthorizationRequests)
+			}
+
 			return nil
 		}()
 		if err != nil {
@@ -159,3 +368,123 @@ func TestClientRegistration(t *testing.T) {
 		}
 	}
 }
+
+func TestClientRegistrationNative(t *testing.T) {
+	tests := []struct {
+		body string
+		err  string
+
+		wantRedirectURIs []string
+	}{
+		{
+			body: `{
+				"redirect_uris": ["com.example.app:/cb", "http://127.0.0.1/cb"],
+				"client_name": "Example App",
+				"token_endpoint_auth_method": "none"
+			}`,
+			wantRedirectURIs: []string{"com.example.app:/cb", "http://127.0.0.1/cb"},
+		},
+		{
+			// Native apps are public clients by default.
+			body: `{
+				"redirect_uris": ["com.example.app:/cb"],
+				"client_name": "Example App",
+				"application_type": "native"
+			}`,
+			wantRedirectURIs: []string{"com.example.app:/cb"},
+		},
+		{
+			body: `{
+				"redirect_uris": ["https://client.example.org/callback"],
+				"client_name": "Example App",
+				"token_endpoint_auth_method": "none"
+			}`,
+			err: invalidRedirectURI,
+		},
+		{
+			// Private-use URI schemes must be based on a domain name.
+			body: `{
+				"redirect_uris": ["myapp:/cb"],
+				"client_name": "Example App",
+				"token_endpoint_auth_method": "none"
+			}`,
+			err: invalidRedirectURI,
+		},
+		{
+			body: `{
+				"redirect_uris": ["com.example.app:/cb"],
+				"token_endpoint_auth_method": "none"
+			}`,
+			err: invalidClientMetadata,
+		},
+		{
+			body: `{
+				"redirect_uris": ["com.example.app:/cb"],
+				"client_name": "Example App",
+				"application_type": "native",
+				"token_endpoint_auth_method": "client_secret_basic"
+			}`,
+			err: invalidClientMetadata,
+		},
+		{
+			// Only public clients may register native redirect URIs.
+			body: `{
+				"redirect_uris": ["com.example.app:/cb"],
+				"client_name": "Example App"
+			}`,
+			err: oauth2.ErrorInvalidRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("case %d: error making test fixtures: %v", i, err)
+		}
+		f.srv.EnableClientRegistration = true
+
+		req, err := http.NewRequest("POST", httpPathClientRegistration, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		resp, aerr := f.srv.handleClientRegistrationRequest(req)
+		if tt.err != "" {
+			if aerr == nil || aerr.Type != tt.err {
+				t.Errorf("case %d: want error %q, got %v", i, tt.err, aerr)
+			}
+			continue
+		}
+		if aerr != nil {
+			t.Errorf("case %d: unexpected error: %v", i, aerr)
+			continue
+		}
+		if resp.ClientSecret != "" {
+			t.Errorf("case %d: public client was issued a secret", i)
+		}
+		if resp.ClientMetadata.TokenEndpointAuthMethod != authMethodNone {
+			t.Errorf("case %d: want token_endpoint_auth_method %q, got %q", i, authMethodNone, resp.ClientMetadata.TokenEndpointAuthMethod)
+		}
+
+		cli, err := f.srv.Client(resp.ClientID)
+		if err != nil {
+			t.Errorf("case %d: failed to lookup client after creation: %v", i, err)
+			continue
+		}
+		if !cli.Public {
+			t.Errorf("case %d: client was not registered as a public client", i)
+		}
+		var redirectURIs []string
+		for _, u := range cli.Metadata.RedirectURIs {
+			redirectURIs = append(redirectURIs, u.String())
+		}
+		if diff := pretty.Compare(tt.wantRedirectURIs, redirectURIs); diff != "" {
+			t.Errorf("case %d: Compare(want, got) = %v", i, diff)
+		}
+		for _, ru := range tt.wantRedirectURIs {
+			u, _ := url.Parse(ru)
+			if _, err := cli.ValidRedirectURL(u); err != nil {
+				t.Errorf("case %d: registered redirect URI %q not accepted: %v", i, ru, err)
+			}
+		}
+	}
+}
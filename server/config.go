@@ -13,7 +13,6 @@ import (
 	texttemplate "text/template"
 	"time"
 
-	"github.com/coreos/go-oidc/key"
 	"github.com/coreos/pkg/health"
 	"github.com/go-gorp/gorp"
 
@@ -22,7 +21,9 @@ import (
 	"github.com/coreos/dex/connector"
 	"github.com/coreos/dex/db"
 	"github.com/coreos/dex/email"
+	pcrypto "github.com/coreos/dex/pkg/crypto"
 	sessionmanager "github.com/coreos/dex/session/manager"
+	"github.com/coreos/dex/signing"
 	"github.com/coreos/dex/user"
 	useremail "github.com/coreos/dex/user/email"
 	usermanager "github.com/coreos/dex/user/manager"
@@ -41,6 +42,13 @@ type ServerConfig struct {
 	EnableClientRegistration     bool
 	EnableClientCredentialAccess bool
 	RegisterOnFirstLogin         bool
+	AccessTokenValidityWindow    time.Duration
+	SSOSessionValidityWindow     time.Duration
+	RefreshTokenAbsoluteLifetime time.Duration
+	RefreshTokenIdleLifetime     time.Duration
+	PasswordGrantConnectorID     string
+	PairwiseSubjectSalt          string
+	AllowedOrigins               []string
 }
 
 type StateConfigurer interface {
@@ -51,6 +59,10 @@ type SingleServerConfig struct {
 	ClientsFile    string
 	ConnectorsFile string
 	UsersFile      string
+
+	// SigningAlgs are the algorithms keys are generated for, the first of
+	// which is the default for ID tokens. If empty, only RS256 is used.
+	SigningAlgs []string
 }
 
 type MultiServerConfig struct {
@@ -70,7 +82,16 @@ func (cfg *ServerConfig) Server() (*Server, error) {
 		return nil, err
 	}
 
-	km := key.NewPrivateKeyManager()
+	var origins []string
+	for _, o := range cfg.AllowedOrigins {
+		origin, err := parseAllowedOrigin(o)
+		if err != nil {
+			return nil, err
+		}
+		origins = append(origins, origin)
+	}
+
+	km := signing.NewPrivateKeyManager()
 	srv := Server{
 		IssuerURL:  *iu,
 		KeyManager: km,
@@ -83,6 +104,13 @@ func (cfg *ServerConfig) Server() (*Server, error) {
 		EnableClientRegistration:     cfg.EnableClientRegistration,
 		EnableClientCredentialAccess: cfg.EnableClientCredentialAccess,
 		RegisterOnFirstLogin:         cfg.RegisterOnFirstLogin,
+		AccessTokenValidityWindow:    cfg.AccessTokenValidityWindow,
+		SSOSessionValidityWindow:     cfg.SSOSessionValidityWindow,
+		RefreshTokenAbsoluteLifetime: cfg.RefreshTokenAbsoluteLifetime,
+		RefreshTokenIdleLifetime:     cfg.RefreshTokenIdleLifetime,
+		PasswordGrantConnectorID:     cfg.PasswordGrantConnectorID,
+		PairwiseSubjectSalt:          []byte(cfg.PairwiseSubjectSalt),
+		AllowedOrigins:               origins,
 	}
 
 	err = cfg.StateConfig.Configure(&srv)
@@ -103,16 +131,27 @@ func (cfg *ServerConfig) Server() (*Server, error) {
 }
 
 func (cfg *SingleServerConfig) Configure(srv *Server) error {
-	k, err := key.GeneratePrivateKey()
-	if err != nil {
+	algs := cfg.SigningAlgs
+	if len(algs) == 0 {
+		algs = []string{signing.RS256}
+	}
+	if err := signing.ValidAlgorithms(algs); err != nil {
 		return err
 	}
+	var keys []*signing.PrivateKey
+	for _, alg := range algs {
+		k, err := signing.GeneratePrivateKey(alg)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+	}
 
 	dbMap := db.NewMemDB()
 
-	ks := key.NewPrivateKeySet([]*key.PrivateKey{k}, time.Now().Add(24*time.Hour))
-	kRepo := key.NewPrivateKeySetRepo()
-	if err = kRepo.Set(ks); err != nil {
+	ks := signing.NewPrivateKeySet(keys, algs, time.Now().Add(24*time.Hour))
+	kRepo := signing.NewPrivateKeySetRepo()
+	if err := kRepo.Set(ks); err != nil {
 		return err
 	}
 
@@ -121,7 +160,13 @@ func (cfg *SingleServerConfig) Configure(srv *Server) error {
 		return fmt.Errorf("unable to read clients from file %s: %v", cfg.ClientsFile, err)
 	}
 
-	clientRepo, err := db.NewClientRepoFromClients(dbMap, clients)
+	// The in-memory database doesn't outlive the process, so neither need
+	// the key secret the repo encrypts client secrets with.
+	keySecret, err := pcrypto.RandBytes(32)
+	if err != nil {
+		return err
+	}
+	clientRepo, err := db.NewClientRepoFromClients(dbMap, clients, keySecret)
 	if err != nil {
 		return err
 	}
@@ -158,7 +203,16 @@ func (cfg *SingleServerConfig) Configure(srv *Server) error {
 		return err
 	}
 
-	refTokRepo := db.NewRefreshTokenRepo(dbMap)
+	refTokRepo := db.NewRefreshTokenRepoWithOptions(dbMap, db.RefreshTokenRepoOptions{
+		AbsoluteLifetime: srv.RefreshTokenAbsoluteLifetime,
+		IdleLifetime:     srv.RefreshTokenIdleLifetime,
+	})
+	deviceGrantRepo := db.NewDeviceGrantRepo(dbMap)
+	ssoSessionRepo := db.NewSSOSessionRepo(dbMap)
+	consentRepo := db.NewConsentRepo(dbMap)
+	clientAssertionRepo := db.NewClientAssertionRepo(dbMap)
+	pushedAuthRequestRepo := db.NewPushedAuthRequestRepo(dbMap)
+	pairwiseSubjectRepo := db.NewPairwiseSubjectRepo(dbMap)
 
 	txnFactory := db.TransactionFactory(dbMap)
 	userManager := usermanager.NewUserManager(userRepo, pwiRepo, cfgRepo, txnFactory, usermanager.ManagerOptions{})
@@ -175,6 +229,12 @@ func (cfg *SingleServerConfig) Configure(srv *Server) error {
 	srv.PasswordInfoRepo = pwiRepo
 	srv.SessionManager = sm
 	srv.RefreshTokenRepo = refTokRepo
+	srv.DeviceGrantRepo = deviceGrantRepo
+	srv.SSOSessionRepo = ssoSessionRepo
+	srv.ConsentRepo = consentRepo
+	srv.ClientAssertionRepo = clientAssertionRepo
+	srv.PushedAuthRequestRepo = pushedAuthRequestRepo
+	srv.PairwiseSubjectRepo = pairwiseSubjectRepo
 	srv.HealthChecks = append(srv.HealthChecks, db.NewHealthChecker(dbMap))
 	srv.dbMap = dbMap
 	return nil
@@ -258,7 +318,7 @@ func (cfg *MultiServerConfig) Configure(srv *Server) error {
 		return fmt.Errorf("unable to create PrivateKeySetRepo: %v", err)
 	}
 
-	ciRepo := db.NewClientRepo(dbc)
+	ciRepo := db.NewClientRepo(dbc, cfg.KeySecrets...)
 	sRepo := db.NewSessionRepo(dbc)
 	skRepo := db.NewSessionKeyRepo(dbc)
 	cfgRepo := db.NewConnectorConfigRepo(dbc)
@@ -266,7 +326,16 @@ func (cfg *MultiServerConfig) Configure(srv *Server) error {
 	pwiRepo := db.NewPasswordInfoRepo(dbc)
 	userManager := usermanager.NewUserManager(userRepo, pwiRepo, cfgRepo, db.TransactionFactory(dbc), usermanager.ManagerOptions{})
 	clientManager := clientmanager.NewClientManager(ciRepo, db.TransactionFactory(dbc), clientmanager.ManagerOptions{})
-	refreshTokenRepo := db.NewRefreshTokenRepo(dbc)
+	refreshTokenRepo := db.NewRefreshTokenRepoWithOptions(dbc, db.RefreshTokenRepoOptions{
+		AbsoluteLifetime: srv.RefreshTokenAbsoluteLifetime,
+		IdleLifetime:     srv.RefreshTokenIdleLifetime,
+	})
+	deviceGrantRepo := db.NewDeviceGrantRepo(dbc)
+	ssoSessionRepo := db.NewSSOSessionRepo(dbc)
+	consentRepo := db.NewConsentRepo(dbc)
+	clientAssertionRepo := db.NewClientAssertionRepo(dbc)
+	pushedAuthRequestRepo := db.NewPushedAuthRequestRepo(dbc)
+	pairwiseSubjectRepo := db.NewPairwiseSubjectRepo(dbc)
 
 	sm := sessionmanager.NewSessionManager(sRepo, skRepo)
 
@@ -279,6 +348,12 @@ func (cfg *MultiServerConfig) Configure(srv *Server) error {
 	srv.PasswordInfoRepo = pwiRepo
 	srv.SessionManager = sm
 	srv.RefreshTokenRepo = refreshTokenRepo
+	srv.DeviceGrantRepo = deviceGrantRepo
+	srv.SSOSessionRepo = ssoSessionRepo
+	srv.ConsentRepo = consentRepo
+	srv.ClientAssertionRepo = clientAssertionRepo
+	srv.PushedAuthRequestRepo = pushedAuthRequestRepo
+	srv.PairwiseSubjectRepo = pairwiseSubjectRepo
 	srv.HealthChecks = append(srv.HealthChecks, db.NewHealthChecker(dbc))
 	srv.dbMap = dbc
 	return nil
@@ -321,6 +396,10 @@ func setTemplates(srv *Server, tpls *template.Template) error {
 		{SendResetPasswordEmailTemplateName, &srv.SendResetPasswordEmailTemplate},
 		{ResetPasswordTemplateName, &srv.ResetPasswordTemplate},
 		{OOBTemplateName, &srv.OOBTemplate},
+		{DeviceTemplateName, &srv.DeviceTemplate},
+		{LogoutTemplateName, &srv.LogoutTemplate},
+		{ConsentTemplateName, &srv.ConsentTemplate},
+		{FormPostTemplateName, &srv.FormPostTemplate},
 	} {
 		tpl, err := findTemplate(t.templateName, tpls)
 		if err != nil {
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-oidc/oauth2"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/consent"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/scope"
+	"github.com/coreos/dex/session"
+)
+
+// ConsentRequired reports whether the user has to approve the client's access
+// to the scopes before it's given a code. Users are only asked once for the
+// same scopes, and never for first-party clients.
+func (s *Server) ConsentRequired(clientID, userID string, scopes scope.Scopes) (bool, error) {
+	cli, err := s.Client(clientID)
+	if err != nil {
+		return false, err
+	}
+	if cli.FirstParty {
+		return false, nil
+	}
+
+	c, err := s.ConsentRepo.Get(userID, clientID)
+	switch {
+	case err == consent.ErrorNotFound:
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	return !scope.Scopes(c.Scope).Contains(scopes), nil
+}
+
+// sessionConsentRequired reports whether the user of the session has to
+// approve the client's access, either because they haven't yet, or because the
+// client asked for them to be prompted again with prompt=consent.
+func (s *Server) sessionConsentRequired(ses *session.Session) (bool, error) {
+	if ses.PromptConsent {
+		return true, nil
+	}
+	return s.ConsentRequired(ses.ClientID, ses.UserID, ses.Scope)
+}
+
+// consentURL returns the URL of the page asking the user of the session
+// referred to by key to approve the client's access.
+func (s *Server) consentURL(key string) url.URL {
+	u := s.absURL(httpPathConsent)
+	q := url.Values{}
+	q.Set("code", key)
+	u.RawQuery = q.Encode()
+	return u
+}
+
+type consentScope struct {
+	Name        string
+	Description string
+}
+
+type consentTemplateData struct {
+	Error   bool
+	Message string
+	Denied  bool
+
+	ClientName string
+	ClientURI  string
+	LogoURI    string
+	Scopes     []consentScope
+	Code       string
+}
+
+// consentScopes describes the access requested by the scopes to the user.
+func (s *Server) consentScopes(scopes scope.Scopes) []consentScope {
+	var cs []consentScope
+	for _, sc := range scopes {
+		var desc string
+		switch {
+		case sc == "openid":
+			desc = "Verify your identity"
+		case sc == "email":
+			desc = "View your email address"
+		case sc == "profile":
+			desc = "View your name"
+		case sc == scope.ScopeGroups:
+			desc = "View the groups you belong to"
+		case sc == "offline_access":
+			desc = "Keep access while you're not logged in"
+		case strings.HasPrefix(sc, scope.ScopeGoogleCrossClient):
+			peerID := sc[len(scope.ScopeGoogleCrossClient):]
+			name := peerID
+			if peer, err := s.Client(peerID); err == nil && peer.Metadata.ClientName != "" {
+				name = peer.Metadata.ClientName
+			}
+			desc = fmt.Sprintf("Log you in to %s", name)
+		default:
+			continue
+		}
+		cs = append(cs, consentScope{Name: sc, Description: desc})
+	}
+	return cs
+}
+
+// handleConsentFunc asks the user of an identified session to approve the
+// access the client requested. Once they do, they're sent to the client with
+// the authorization response, and their decision is remembered for later
+// requests.
+func handleConsentFunc(s *Server, tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, "GET and POST only acceptable methods")
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Errorf("error parsing request: %v", err)
+			phttp.WriteError(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+
+		writeConsentError := func(msg string) {
+			execTemplateWithStatus(w, tpl, consentTemplateData{Error: true, Message: msg}, http.StatusBadRequest)
+		}
+		internalError := func(err error) {
+			log.Errorf("Consent failed: %v", err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		sessionID, err := s.SessionManager.ExchangeKey(r.Form.Get("code"))
+		if err != nil {
+			writeConsentError("Your login has expired. Please log in again.")
+			return
+		}
+		ses, err := s.SessionManager.Get(sessionID)
+		if err != nil {
+			internalError(err)
+			return
+		}
+		if ses == nil || ses.State != session.SessionStateIdentified {
+			writeConsentError("Your login has expired. Please log in again.")
+			return
+		}
+
+		if r.Method == "GET" {
+			cli, err := s.Client(ses.ClientID)
+			if err != nil {
+				internalError(err)
+				return
+			}
+			code, err := s.SessionManager.NewSessionKey(sessionID)
+			if err != nil {
+				internalError(err)
+				return
+			}
+
+			td := consentTemplateData{
+				ClientName: cli.Metadata.ClientName,
+				Scopes:     s.consentScopes(ses.Scope),
+				Code:       code,
+			}
+			if td.ClientName == "" {
+				td.ClientName = ses.ClientID
+			}
+			if cli.Metadata.ClientURI != nil {
+				td.ClientURI = cli.Metadata.ClientURI.String()
+			}
+			if cli.Metadata.LogoURI != nil {
+				td.LogoURI = cli.Metadata.LogoURI.String()
+			}
+			execTemplate(w, tpl, td)
+			return
+		}
+
+		if r.PostForm.Get("approve") == "" {
+			if _, err := s.SessionManager.Kill(sessionID); err != nil {
+				internalError(err)
+				return
+			}
+			log.Infof("Session %s consent denied: clientID=%s", sessionID, ses.ClientID)
+
+			// If the user can't be sent back to the client, they're told
+			// access was denied instead.
+			ru := ses.RedirectURL
+			deviceCallback := s.absURL(httpPathDeviceCallback)
+			if ru.String() == client.OOBRedirectURI || ru.String() == deviceCallback.String() {
+				execTemplate(w, tpl, consentTemplateData{Denied: true})
+				return
+			}
+			redirectAuthError(w, s.FormPostTemplate, oauth2.NewError(errorAccessDenied), ses.ClientState, ru, ses.ResponseMode)
+			return
+		}
+
+		// Earlier approvals of other scopes still stand.
+		scopes := append(scope.Scopes{}, ses.Scope...)
+		if c, err := s.ConsentRepo.Get(ses.UserID, ses.ClientID); err == nil {
+			for _, sc := range c.Scope {
+				if !scopes.HasScope(sc) {
+					scopes = append(scopes, sc)
+				}
+			}
+		} else if err != consent.ErrorNotFound {
+			internalError(err)
+			return
+		}
+
+		c := consent.Consent{
+			UserID:    ses.UserID,
+			ClientID:  ses.ClientID,
+			Scope:     scopes,
+			UpdatedAt: s.SessionManager.Clock.Now(),
+		}
+		if err := s.ConsentRepo.Set(c); err != nil {
+			internalError(err)
+			return
+		}
+		log.Infof("Session %s consent given: user=%s clientID=%s", sessionID, ses.UserID, ses.ClientID)
+
+		// The user was just prompted, so they aren't again.
+		ses.PromptConsent = false
+		ru, err := s.authResponseURL(ses)
+		if err != nil {
+			internalError(err)
+			return
+		}
+		w.Header().Set("Location", ru)
+		w.WriteHeader(http.StatusFound)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+)
+
+// Cross-origin resource sharing lets scripts served from other origins call
+// the endpoints browser-based clients need: discovery, keys, token and
+// UserInfo. See: https://fetch.spec.whatwg.org/#http-cors-protocol
+var (
+	// corsAllowedHeaders are the request headers scripts may send.
+	corsAllowedHeaders = []string{"Accept", "Authorization", "Content-Type"}
+
+	// corsExposedHeaders are the response headers scripts may read, besides
+	// the CORS-safelisted ones.
+	corsExposedHeaders = []string{"WWW-Authenticate"}
+
+	// corsMaxAge is how long browsers may cache the result of a preflight
+	// request.
+	corsMaxAge = 10 * time.Minute
+
+	// clientOriginsTTL is how long the origins of clients' redirect URIs are
+	// cached. Clients changed through this server are seen right away, but
+	// those changed elsewhere, such as through the admin API, may take this
+	// long to be seen.
+	clientOriginsTTL = time.Minute
+)
+
+// originAllowed reports whether scripts served from origin may call dex's
+// cross-origin endpoints. Origins listed in AllowedOrigins are allowed, as are
+// the origins of the HTTP(S) redirect URIs registered by clients.
+func (s *Server) originAllowed(origin string) (bool, error) {
+	for _, o := range s.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true, nil
+		}
+	}
+	return s.clientOrigins.contains(origin, time.Now(), s.ClientManager.All)
+}
+
+// originCache holds the origins of clients' redirect URIs, so that they
+// needn't all be loaded for every cross-origin request.
+type originCache struct {
+	mu        sync.Mutex
+	origins   map[string]bool
+	expiresAt time.Time
+}
+
+// contains reports whether origin is that of a client's redirect URI, loading
+// the clients again if the cache is empty or expired.
+func (c *originCache) contains(origin string, now time.Time, load func() ([]client.Client, error)) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.origins == nil || !now.Before(c.expiresAt) {
+		clients, err := load()
+		if err != nil {
+			return false, err
+		}
+		c.origins = make(map[string]bool)
+		for _, cli := range clients {
+			for _, ru := range cli.Metadata.RedirectURIs {
+				if o := urlOrigin(ru); o != "" {
+					c.origins[o] = true
+				}
+			}
+		}
+		c.expiresAt = now.Add(clientOriginsTTL)
+	}
+	return c.origins[origin], nil
+}
+
+// invalidate empties the cache, after clients were changed.
+func (c *originCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.origins = nil
+}
+
+// urlOrigin returns the origin of an HTTP or HTTPS URL as serialized in the
+// Origin header, or an empty string for other URLs.
+func urlOrigin(u url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	if (scheme != "http" && scheme != "https") || u.Host == "" {
+		return ""
+	}
+	host := strings.ToLower(u.Host)
+	if (scheme == "http" && u.Port() == "80") || (scheme == "https" && u.Port() == "443") {
+		host = strings.TrimSuffix(host, ":"+u.Port())
+	}
+	return scheme + "://" + host
+}
+
+// parseAllowedOrigin validates an origin configured as allowed, returning it in
+// the form browsers send it in. "*" allows every origin.
+func parseAllowedOrigin(origin string) (string, error) {
+	if origin == "*" {
+		return origin, nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", fmt.Errorf("invalid allowed origin %q: %v", origin, err)
+	}
+	o := urlOrigin(*u)
+	if o == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid allowed origin %q: must be an HTTP or HTTPS scheme and host, with an optional port", origin)
+	}
+	return o, nil
+}
+
+// handleCORS allows scripts from allowed origins to call the endpoint served by
+// h, which accepts the given methods. Preflight requests are answered without
+// calling h.
+func (s *Server) handleCORS(h http.Handler, methods ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		allowed, err := s.originAllowed(origin)
+		if err != nil {
+			log.Errorf("Failed checking whether origin %q is allowed: %v", origin, err)
+		}
+
+		reqMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != "OPTIONS" || reqMethod == "" {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !allowed || !pstrings.ContainsString(methods, reqMethod) || !corsHeadersAllowed(r.Header.Get("Access-Control-Request-Headers")) {
+			log.Debugf("Rejected preflight request from origin %q: method=%s headers=%q", origin, reqMethod, r.Header.Get("Access-Control-Request-Headers"))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// corsHeadersAllowed reports whether every header listed in the
+// Access-Control-Request-Headers header of a preflight request is allowed.
+func corsHeadersAllowed(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		var ok bool
+		for _, a := range corsAllowedHeaders {
+			if strings.EqualFold(h, a) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+)
+
+func TestHandleCORS(t *testing.T) {
+	tests := []struct {
+		allowedOrigins []string
+		method         string
+		path           string
+		header         http.Header
+
+		wantCode        int
+		wantAllowOrigin string
+		wantAllowMethod string
+	}{
+		// origins of clients' redirect URIs are allowed
+		{
+			method:          "GET",
+			path:            httpPathDiscovery,
+			header:          http.Header{"Origin": {"http://client.example.com"}},
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://client.example.com",
+		},
+		{
+			method:   "GET",
+			path:     httpPathDiscovery,
+			header:   http.Header{"Origin": {"https://evil.example.com"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			allowedOrigins:  []string{"https://spa.example.com"},
+			method:          "GET",
+			path:            httpPathKeys,
+			header:          http.Header{"Origin": {"https://spa.example.com"}},
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "https://spa.example.com",
+		},
+		{
+			allowedOrigins:  []string{"*"},
+			method:          "GET",
+			path:            httpPathKeys,
+			header:          http.Header{"Origin": {"https://spa.example.com"}},
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "https://spa.example.com",
+		},
+
+		// preflight requests
+		{
+			method: "OPTIONS",
+			path:   httpPathToken,
+			header: http.Header{
+				"Origin":                         {"http://client.example.com"},
+				"Access-Control-Request-Method":  {"POST"},
+				"Access-Control-Request-Headers": {"content-type, authorization"},
+			},
+			wantCode:        http.StatusNoContent,
+			wantAllowOrigin: "http://client.example.com",
+			wantAllowMethod: "POST",
+		},
+		{
+			method: "OPTIONS",
+			path:   httpPathUserInfo,
+			header: http.Header{
+				"Origin":                        {"http://client.example.com"},
+				"Access-Control-Request-Method": {"GET"},
+			},
+			wantCode:        http.StatusNoContent,
+			wantAllowOrigin: "http://client.example.com",
+			wantAllowMethod: "GET, POST",
+		},
+		// method not accepted by the endpoint
+		{
+			method: "OPTIONS",
+			path:   httpPathToken,
+			header: http.Header{
+				"Origin":                        {"http://client.example.com"},
+				"Access-Control-Request-Method": {"DELETE"},
+			},
+			wantCode: http.StatusForbidden,
+		},
+		// header which isn't allowed
+		{
+			method: "OPTIONS",
+			path:   httpPathToken,
+			header: http.Header{
+				"Origin":                         {"http://client.example.com"},
+				"Access-Control-Request-Method":  {"POST"},
+				"Access-Control-Request-Headers": {"x-custom"},
+			},
+			wantCode: http.StatusForbidden,
+		},
+		// origin which isn't allowed
+		{
+			method: "OPTIONS",
+			path:   httpPathToken,
+			header: http.Header{
+				"Origin":                        {"https://evil.example.com"},
+				"Access-Control-Request-Method": {"POST"},
+			},
+			wantCode: http.StatusForbidden,
+		},
+		// endpoint which can't be called cross-origin
+		{
+			method: "OPTIONS",
+			path:   httpPathAuth,
+			header: http.Header{
+				"Origin":                        {"http://client.example.com"},
+				"Access-Control-Request-Method": {"GET"},
+			},
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("error making test fixtures: %v", err)
+		}
+		f.srv.AllowedOrigins = tt.allowedOrigins
+
+		req, err := http.NewRequest(tt.method, "http://server.example.com"+tt.path, nil)
+		if err != nil {
+			t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+		}
+		req.Header = tt.header
+		w := httptest.NewRecorder()
+		f.srv.HTTPHandler().ServeHTTP(w, req)
+
+		if w.Code != tt.wantCode {
+			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, tt.wantCode, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowOrigin {
+			t.Errorf("case %d: want Access-Control-Allow-Origin %q, got %q", i, tt.wantAllowOrigin, got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.wantAllowMethod {
+			t.Errorf("case %d: want Access-Control-Allow-Methods %q, got %q", i, tt.wantAllowMethod, got)
+		}
+	}
+}
+
+func TestOriginCache(t *testing.T) {
+	var loads int
+	clients := []client.Client{{
+		Metadata: oidc.ClientMetadata{
+			RedirectURIs: []url.URL{{Scheme: "https", Host: "client.example.com", Path: "/callback"}},
+		},
+	}}
+	load := func() ([]client.Client, error) {
+		loads++
+		return clients, nil
+	}
+
+	var c originCache
+	now := time.Now()
+	check := func(origin string, want bool, wantLoads int) {
+		got, err := c.contains(origin, now, load)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("%s: want allowed %t, got %t", origin, want, got)
+		}
+		if loads != wantLoads {
+			t.Errorf("%s: want %d loads of the clients, got %d", origin, wantLoads, loads)
+		}
+	}
+
+	check("https://client.example.com", true, 1)
+	check("https://evil.example.com", false, 1)
+
+	// Changed clients are seen once the cache is invalidated or expires.
+	clients = append(clients, client.Client{
+		Metadata: oidc.ClientMetadata{
+			RedirectURIs: []url.URL{{Scheme: "https", Host: "new.example.com", Path: "/callback"}},
+		},
+	})
+	check("https://new.example.com", false, 1)
+	c.invalidate()
+	check("https://new.example.com", true, 2)
+
+	clients = clients[:1]
+	now = now.Add(clientOriginsTTL)
+	check("https://new.example.com", false, 3)
+}
+
+func TestParseAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		origin  string
+		want    string
+		wantErr bool
+	}{
+		{origin: "*", want: "*"},
+		{origin: "https://app.example.com", want: "https://app.example.com"},
+		{origin: "HTTPS://App.Example.com:443/", want: "https://app.example.com"},
+		{origin: "http://localhost:8080", want: "http://localhost:8080"},
+		{origin: "https://app.example.com/callback", wantErr: true},
+		{origin: "ftp://app.example.com", wantErr: true},
+		{origin: "app.example.com", wantErr: true},
+	}
+
+	for i, tt := range tests {
+		got, err := parseAllowedOrigin(tt.origin)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("case %d: want error, got %q", i, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("case %d: want %q, got %q", i, tt.want, got)
+		}
+	}
+}
@@ -10,12 +10,14 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
 	"github.com/kylelemons/godebug/pretty"
 
 	"github.com/coreos/dex/client"
 	"github.com/coreos/dex/connector"
 	"github.com/coreos/dex/scope"
+	"github.com/coreos/dex/session"
 )
 
 func makeCrossClientTestFixtures() (*testFixtures, error) {
@@ -133,27 +135,27 @@ func TestHandleAuthCrossClient(t *testing.T) {
 	tests := []struct {
 		scopes   []string
 		clientID string
-		wantCode int
+
+		// wantError is the error the client is redirected back with, if
+		// any.
+		wantError string
 	}{
 		{
-			scopes:   []string{scope.ScopeGoogleCrossClient + "client_a"},
-			clientID: "client_b",
-			wantCode: http.StatusBadRequest,
+			scopes:    []string{scope.ScopeGoogleCrossClient + "client_a"},
+			clientID:  "client_b",
+			wantError: oauth2.ErrorInvalidRequest,
 		},
 		{
 			scopes:   []string{scope.ScopeGoogleCrossClient + "client_b"},
 			clientID: "client_a",
-			wantCode: http.StatusFound,
 		},
 		{
 			scopes:   []string{scope.ScopeGoogleCrossClient + "client_b"},
 			clientID: "client_a",
-			wantCode: http.StatusFound,
 		},
 		{
 			scopes:   []string{scope.ScopeGoogleCrossClient + "client_c"},
 			clientID: "client_a",
-			wantCode: http.StatusFound,
 		},
 		{
 			// Two clients that client_a is authorized to mint tokens for.
@@ -162,7 +164,6 @@ func TestHandleAuthCrossClient(t *testing.T) {
 				scope.ScopeGoogleCrossClient + "client_b",
 			},
 			clientID: "client_a",
-			wantCode: http.StatusFound,
 		},
 		{
 			// Two clients that client_a is authorized to mint tokens for.
@@ -170,8 +171,8 @@ func TestHandleAuthCrossClient(t *testing.T) {
 				scope.ScopeGoogleCrossClient + "client_c",
 				scope.ScopeGoogleCrossClient + "client_a",
 			},
-			clientID: "client_b",
-			wantCode: http.StatusBadRequest,
+			clientID:  "client_b",
+			wantError: oauth2.ErrorInvalidRequest,
 		},
 	}
 
@@ -180,7 +181,7 @@ func TestHandleAuthCrossClient(t *testing.T) {
 	}
 
 	for i, tt := range tests {
-		hdlr := handleAuthFunc(f.srv, url.URL{}, idpcs, nil, true)
+		hdlr := handleAuthFunc(f.srv, url.URL{}, idpcs, nil, f.srv.FormPostTemplate, true)
 		w := httptest.NewRecorder()
 
 		query := url.Values{
@@ -197,10 +198,18 @@ func TestHandleAuthCrossClient(t *testing.T) {
 		}
 
 		hdlr.ServeHTTP(w, req)
-		if tt.wantCode != w.Code {
-			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, tt.wantCode, w.Code)
+		if w.Code != http.StatusFound {
+			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusFound, w.Code)
+			continue
+		}
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Errorf("case %d: invalid Location header: %v", i, err)
 			continue
 		}
+		if got := loc.Query().Get("error"); got != tt.wantError {
+			t.Errorf("case %d: want error %q, got %q", i, tt.wantError, got)
+		}
 	}
 
 }
@@ -264,7 +273,12 @@ func TestServerCodeTokenCrossClient(t *testing.T) {
 			scopes = append(scopes, scope.ScopeGoogleCrossClient+client)
 		}
 
-		sessionID, err := sm.NewSession("bogus_idpc", tt.clientID, "bogus", url.URL{}, "", false, scopes)
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID: "bogus_idpc",
+			ClientID:    tt.clientID,
+			ClientState: "bogus",
+			Scope:       scopes,
+		})
 		if err != nil {
 			t.Fatalf("case %d: unexpected error: %v", i, err)
 		}
@@ -283,7 +297,7 @@ func TestServerCodeTokenCrossClient(t *testing.T) {
 			t.Fatalf("case %d: unexpected error: %v", i, err)
 		}
 
-		jwt, token, expiresAt, err := f.srv.CodeToken(f.clientCreds[tt.clientID], key)
+		jwt, _, token, expiresAt, err := f.srv.CodeToken(f.clientCreds[tt.clientID], key, "", nil)
 		if err != nil {
 			t.Fatalf("case %d: unexpected error: %v", i, err)
 		}
@@ -0,0 +1,424 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/device"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/session"
+)
+
+const cookieDeviceCSRF = "dex_device_csrf"
+
+// NewDeviceGrant creates a device authorization for the client, to be approved
+// by the end-user on the verification page (RFC 8628 Section 3.1).
+func (s *Server) NewDeviceGrant(creds oidc.ClientCredentials, scope []string) (*device.Grant, error) {
+	if err := s.authenticateClient(creds, true); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkGrantType(creds.ID, device.GrantTypeDeviceCode); err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := device.NewDeviceCode()
+	if err != nil {
+		log.Errorf("Failed to generate device code: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	userCode, err := device.NewUserCode()
+	if err != nil {
+		log.Errorf("Failed to generate user code: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	now := time.Now().UTC()
+	g := device.Grant{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   creds.ID,
+		Scope:      scope,
+		Interval:   device.DefaultPollInterval,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(device.DefaultGrantValidityWindow),
+	}
+	if err := s.DeviceGrantRepo.Create(g); err != nil {
+		log.Errorf("Failed to create device grant: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	log.Infof("Device grant created: clientID=%s", creds.ID)
+	return &g, nil
+}
+
+// DeviceToken is polled by the device until the end-user has logged in
+// (RFC 8628 Section 3.4).
+func (s *Server) DeviceToken(creds oidc.ClientCredentials, deviceCode string, resources []string) (*jose.JWT, *jose.JWT, string, time.Time, error) {
+	if err := s.authenticateClient(creds, true); err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	if err := s.checkGrantType(creds.ID, device.GrantTypeDeviceCode); err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	aud, err := s.accessTokenAudience(creds.ID, resources)
+	if err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	g, err := s.DeviceGrantRepo.GetByDeviceCode(deviceCode)
+	switch err {
+	case nil:
+	case device.ErrorExpired:
+		return nil, nil, "", time.Time{}, oauth2.NewError(errorExpiredToken)
+	case device.ErrorNotFound:
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	default:
+		log.Errorf("Failed to fetch device grant: %v", err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	if g.ClientID != creds.ID {
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	}
+
+	if !g.Approved {
+		now := time.Now().UTC()
+		errType := errorAuthorizationPending
+		if !g.LastPolledAt.IsZero() && now.Sub(g.LastPolledAt) < g.Interval {
+			// The client must wait longer between requests from now on.
+			g.Interval += device.SlowDownIncrement
+			errType = errorSlowDown
+		}
+		g.LastPolledAt = now
+		if err := s.DeviceGrantRepo.Update(*g); err != nil {
+			log.Errorf("Failed to update device grant: %v", err)
+			return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		}
+		return nil, nil, "", time.Time{}, oauth2.NewError(errType)
+	}
+
+	// Device codes can only be exchanged once.
+	switch err := s.DeviceGrantRepo.Delete(deviceCode); err {
+	case nil:
+	case device.ErrorNotFound:
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	default:
+		log.Errorf("Failed to delete device grant: %v", err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	ses, err := s.SessionManager.Kill(g.SessionID)
+	if err != nil {
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	}
+	if ses.ClientID != creds.ID || ses.UserID == "" {
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	}
+
+	jwt, accessToken, refreshToken, expiresAt, err := s.sessionToken(ses, aud)
+	if err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	log.Infof("Session %s device token sent: clientID=%s", ses.ID, creds.ID)
+	return jwt, accessToken, refreshToken, expiresAt, nil
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+func handleDeviceCodeFunc(srv OIDCServer, verificationURL url.URL) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, fmt.Sprintf("POST only acceptable method"))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Errorf("error parsing request: %v", err)
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidRequest), "")
+			return
+		}
+
+		creds, ok, err := clientCredentials(r)
+		switch {
+		case err != nil:
+			log.Errorf("error decoding client credentials: %v", err)
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), "")
+			return
+		case ok:
+		case r.PostForm.Get("client_id") != "":
+			creds = oidc.ClientCredentials{ID: r.PostForm.Get("client_id")}
+		default:
+			log.Errorf("missing client credentials")
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), "")
+			return
+		}
+
+		scopes := strings.Fields(r.PostForm.Get("scope"))
+		if err := validateScopes(srv, creds.ID, scopes); err != nil {
+			log.Error(err)
+			writeTokenError(w, err, "")
+			return
+		}
+
+		g, err := srv.NewDeviceGrant(creds, scopes)
+		if err != nil {
+			writeTokenError(w, err, "")
+			return
+		}
+
+		complete := verificationURL
+		q := complete.Query()
+		q.Set("user_code", device.FormatUserCode(g.UserCode))
+		complete.RawQuery = q.Encode()
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeResponseWithBody(w, http.StatusOK, deviceAuthorizationResponse{
+			DeviceCode:              g.DeviceCode,
+			UserCode:                device.FormatUserCode(g.UserCode),
+			VerificationURI:         verificationURL.String(),
+			VerificationURIComplete: complete.String(),
+			ExpiresIn:               int64(g.ExpiresAt.Sub(g.CreatedAt).Seconds()),
+			Interval:                int64(g.Interval.Seconds()),
+		})
+	}
+}
+
+type deviceTemplateData struct {
+	Error    bool
+	Message  string
+	UserCode string
+	Approved bool
+
+	// ClientName and Scopes describe the access the device requested, which
+	// the end-user confirms by logging in with one of the connectors in Links.
+	ClientName string
+	Scopes     []consentScope
+	Links      []Link
+
+	// CSRFToken is posted back with the chosen connector, and must match the
+	// browser's CSRF cookie for the grant to be bound.
+	CSRFToken string
+}
+
+// handleDeviceFunc serves the verification page, where the end-user enters
+// the user code displayed by the device, and is shown the client and the
+// access it requested. The grant is only bound to a session once they confirm
+// by POSTing the connector to log in with, so that following a link to the
+// page doesn't start logging them in for a device somebody else controls
+// (RFC 8628 Section 5.4).
+func handleDeviceFunc(s *Server, tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, "GET and POST only acceptable methods")
+			return
+		}
+
+		userCode := r.FormValue("user_code")
+		if userCode == "" {
+			execTemplate(w, tpl, deviceTemplateData{})
+			return
+		}
+
+		td := deviceTemplateData{UserCode: userCode}
+		g, err := s.DeviceGrantRepo.GetByUserCode(device.NormalizeUserCode(userCode))
+		switch err {
+		case nil:
+		case device.ErrorNotFound, device.ErrorExpired:
+			td.Error = true
+			td.Message = "Invalid or expired code. Please check the code displayed by your device and try again."
+			execTemplateWithStatus(w, tpl, td, http.StatusBadRequest)
+			return
+		default:
+			log.Errorf("Failed to fetch device grant: %v", err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		if g.SessionID != "" {
+			td.Error = true
+			td.Message = "This code has already been used."
+			execTemplateWithStatus(w, tpl, td, http.StatusBadRequest)
+			return
+		}
+
+		connectorID := r.PostFormValue("connector_id")
+		idpc, ok := s.connector(connectorID)
+		if r.Method == "POST" && ok && !validDeviceCSRFToken(r) {
+			log.Infof("Device grant confirmation of client %s without a valid CSRF token", g.ClientID)
+			ok = false
+		}
+		if r.Method != "POST" || !ok {
+			cli, err := s.Client(g.ClientID)
+			if err != nil {
+				log.Errorf("Failed fetching client %s from repo: %v", g.ClientID, err)
+				phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			td.ClientName = cli.Metadata.ClientName
+			if td.ClientName == "" {
+				td.ClientName = g.ClientID
+			}
+			td.Scopes = s.consentScopes(g.Scope)
+			for _, idpc := range s.Connectors {
+				id := idpc.ID()
+				displayName, ok := connectorDisplayNameMap[id]
+				if !ok {
+					displayName = id
+				}
+				td.Links = append(td.Links, Link{
+					ID:          id,
+					DisplayName: displayName,
+				})
+			}
+			if td.CSRFToken, err = device.NewCSRFToken(); err != nil {
+				log.Errorf("Failed to generate CSRF token: %v", err)
+				phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			http.SetCookie(w, newDeviceCSRFCookie(s.IssuerURL, td.CSRFToken))
+			execTemplate(w, tpl, td)
+			return
+		}
+		http.SetCookie(w, expiredDeviceCSRFCookie(s.IssuerURL))
+
+		// The user code is passed as the client state, and returned to the
+		// device callback once the user has logged in.
+		sessionID, err := s.SessionManager.NewSession(session.AuthRequest{
+			ConnectorID: connectorID,
+			ClientID:    g.ClientID,
+			ClientState: g.UserCode,
+			RedirectURL: s.absURL(httpPathDeviceCallback),
+			Scope:       g.Scope,
+		})
+		if err != nil {
+			log.Errorf("Error creating new session: %v", err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		key, err := s.SessionManager.NewSessionKey(sessionID)
+		if err != nil {
+			log.Errorf("Error creating new session key: %v", err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		g.SessionID = sessionID
+		if err := s.DeviceGrantRepo.Update(*g); err != nil {
+			log.Errorf("Failed to update device grant: %v", err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		log.Infof("Session %s created for device grant: clientID=%s", sessionID, g.ClientID)
+
+		lu, err := idpc.LoginURL(key, "")
+		if err != nil {
+			log.Errorf("Connector.LoginURL failed: %v", err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		http.SetCookie(w, createLastSeenCookie())
+		w.Header().Set("Location", lu)
+		w.WriteHeader(http.StatusFound)
+	}
+}
+
+// validDeviceCSRFToken reports whether the request posts the CSRF token of the
+// browser's CSRF cookie, which is only the case for the confirmation form the
+// browser was served.
+func validDeviceCSRFToken(r *http.Request) bool {
+	token := r.PostFormValue("csrf_token")
+	c, err := r.Cookie(cookieDeviceCSRF)
+	if err != nil || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) == 1
+}
+
+// newDeviceCSRFCookie creates the cookie holding the CSRF token of the device
+// confirmation form.
+func newDeviceCSRFCookie(baseURL url.URL, token string) *http.Cookie {
+	c := newSSOSessionCookie(baseURL, token)
+	c.Name = cookieDeviceCSRF
+	return c
+}
+
+// expiredDeviceCSRFCookie creates a cookie which removes the browser's device
+// CSRF cookie.
+func expiredDeviceCSRFCookie(baseURL url.URL) *http.Cookie {
+	c := newDeviceCSRFCookie(baseURL, "")
+	c.MaxAge = -1
+	return c
+}
+
+// handleDeviceCallbackFunc is redirected to once the end-user has logged in,
+// approving the device grant bound to the session.
+func handleDeviceCallbackFunc(s *Server, tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, "GET only acceptable method")
+			return
+		}
+
+		q := r.URL.Query()
+		if q.Get("error") != "" {
+			td := deviceTemplateData{
+				Error:   true,
+				Message: "Your login failed. Please restart the login on your device.",
+			}
+			execTemplateWithStatus(w, tpl, td, http.StatusBadRequest)
+			return
+		}
+
+		sessionID, err := s.SessionManager.ExchangeKey(q.Get("code"))
+		if err != nil {
+			phttp.WriteError(w, http.StatusBadRequest, "Invalid Session")
+			return
+		}
+
+		td := deviceTemplateData{UserCode: device.FormatUserCode(q.Get("state"))}
+		g, err := s.DeviceGrantRepo.GetByUserCode(q.Get("state"))
+		if err != nil || g.SessionID != sessionID {
+			log.Errorf("No device grant found for session %s: %v", sessionID, err)
+			td.Error = true
+			td.Message = "Your login has expired. Please restart the login on your device."
+			execTemplateWithStatus(w, tpl, td, http.StatusBadRequest)
+			return
+		}
+
+		g.Approved = true
+		if err := s.DeviceGrantRepo.Update(*g); err != nil {
+			log.Errorf("Failed to update device grant: %v", err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		log.Infof("Session %s device grant approved: clientID=%s", sessionID, g.ClientID)
+
+		td.Approved = true
+		execTemplate(w, tpl, td)
+	}
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"fmt"
+	"html/template"
 	"net/http"
 	"net/url"
 
@@ -12,6 +14,41 @@ const (
 	errorInvalidRequest        = "invalid_request"
 	errorServerError           = "server_error"
 	errorAccessDenied          = "access_denied"
+
+	// Returned by the revocation endpoint (RFC 7009 Section 2.2.1).
+	errorUnsupportedTokenType = "unsupported_token_type"
+
+	// Returned by resources protected by bearer tokens (RFC 6750 Section 3.1).
+	errorInvalidToken = "invalid_token"
+
+	// Returned while polling for a device access token (RFC 8628 Section 3.5).
+	errorAuthorizationPending = "authorization_pending"
+	errorSlowDown             = "slow_down"
+	errorExpiredToken         = "expired_token"
+
+	// Returned when a requested resource is invalid or not allowed (RFC 8707 Section 2).
+	errorInvalidTarget = "invalid_target"
+
+	// Returned when the client requests a scope it isn't allowed to (RFC 6749
+	// Section 5.2).
+	errorInvalidScope = "invalid_scope"
+
+	// Returned when the user can't be authenticated without interaction
+	// (OpenID Connect Core 1.0 Section 3.1.2.6).
+	errorLoginRequired = "login_required"
+
+	// Returned when the user would have to approve the client's access
+	// (OpenID Connect Core 1.0 Section 3.1.2.6).
+	errorConsentRequired = "consent_required"
+
+	// Returned when the user would have to interact with dex in another way,
+	// such as registering (OpenID Connect Core 1.0 Section 3.1.2.6).
+	errorInteractionRequired = "interaction_required"
+
+	// Returned when a request object or request URI passed to the
+	// authorization endpoint is invalid (RFC 9101 Section 6.3).
+	errorInvalidRequestObject = "invalid_request_object"
+	errorInvalidRequestURI    = "invalid_request_uri"
 )
 
 type apiError struct {
@@ -69,17 +106,80 @@ func writeAuthError(w http.ResponseWriter, err error, state string) {
 	writeResponseWithBody(w, http.StatusBadRequest, oerr)
 }
 
-func redirectAuthError(w http.ResponseWriter, err error, state string, redirectURL url.URL) {
+// redirectAuthError sends an error response to an authorization request back
+// to the client's redirect URL. Errors for clients using the form_post response
+// mode are posted to it with the form rendered by tpl.
+func redirectAuthError(w http.ResponseWriter, tpl *template.Template, err error, state string, redirectURL url.URL, responseMode string) {
+	if responseMode == responseModeFormPost {
+		writeFormPost(w, tpl, redirectURL, authErrorParams(err, state))
+		return
+	}
+	w.Header().Set("Location", authErrorURL(err, state, redirectURL, responseMode))
+	w.WriteHeader(http.StatusFound)
+}
+
+// authErrorParams returns the parameters of an error response to an
+// authorization request (RFC 6749 Section 4.1.2.1). Errors which aren't OAuth
+// 2.0 errors are reported as server_error, without a description.
+func authErrorParams(err error, state string) url.Values {
 	oerr, ok := err.(*oauth2.Error)
 	if !ok {
 		oerr = oauth2.NewError(oauth2.ErrorServerError)
 	}
 
+	params := url.Values{}
+	params.Set("error", oerr.Type)
+	if oerr.Description != "" {
+		params.Set("error_description", oerr.Description)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return params
+}
+
+// authErrorURL returns the URL which passes an error response to an
+// authorization request to the client. The parameters are passed in the
+// fragment if the response mode is fragment, and otherwise in the query.
+// Errors can't be passed to form_post clients in a URL; they're posted with
+// the form post page instead.
+func authErrorURL(err error, state string, redirectURL url.URL, responseMode string) string {
+	params := authErrorParams(err, state)
+	if responseMode == responseModeFragment {
+		// The parameters are already encoded, and mustn't be escaped again.
+		redirectURL.Fragment = ""
+		return redirectURL.String() + "#" + params.Encode()
+	}
 	q := redirectURL.Query()
-	q.Set("error", oerr.Type)
-	q.Set("state", state)
+	for k, v := range params {
+		q[k] = v
+	}
 	redirectURL.RawQuery = q.Encode()
+	return redirectURL.String()
+}
 
-	w.Header().Set("Location", redirectURL.String())
-	w.WriteHeader(http.StatusFound)
+// writeBearerError responds to a request for a resource protected by a bearer
+// token, describing the error in the WWW-Authenticate header (RFC 6750 Section 3).
+func writeBearerError(w http.ResponseWriter, err error) {
+	oerr, ok := err.(*oauth2.Error)
+	if !ok {
+		oerr = oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	var status int
+	switch oerr.Type {
+	case errorInvalidToken:
+		status = http.StatusUnauthorized
+	case oauth2.ErrorInvalidRequest:
+		status = http.StatusBadRequest
+	default:
+		status = http.StatusInternalServerError
+	}
+
+	challenge := fmt.Sprintf("Bearer error=%q", oerr.Type)
+	if oerr.Description != "" {
+		challenge += fmt.Sprintf(", error_description=%q", oerr.Description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeResponseWithBody(w, status, oerr)
 }
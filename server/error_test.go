@@ -2,6 +2,7 @@ package server
 
 import (
 	"errors"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -214,10 +215,11 @@ func TestRedirectAuthError(t *testing.T) {
 	wantCode := http.StatusFound
 
 	tests := []struct {
-		err         error
-		state       string
-		redirectURL url.URL
-		wantLoc     string
+		err          error
+		state        string
+		redirectURL  url.URL
+		responseMode string
+		wantLoc      string
 	}{
 		{
 			err:         errors.New("foobar"),
@@ -237,11 +239,33 @@ func TestRedirectAuthError(t *testing.T) {
 			redirectURL: url.URL{Scheme: "http", Host: "server.example.com"},
 			wantLoc:     "http://server.example.com?error=unsupported_response_type&state=bar",
 		},
+		{
+			err: &oauth2.Error{
+				Type:        oauth2.ErrorInvalidRequest,
+				Description: "unsupported prompt",
+			},
+			state:       "foo",
+			redirectURL: url.URL{Scheme: "http", Host: "server.example.com", RawQuery: "x=y"},
+			wantLoc:     "http://server.example.com?error=invalid_request&error_description=unsupported+prompt&state=foo&x=y",
+		},
+		// state is only returned if the client sent it
+		{
+			err:         oauth2.NewError(errorAccessDenied),
+			redirectURL: url.URL{Scheme: "http", Host: "server.example.com"},
+			wantLoc:     "http://server.example.com?error=access_denied",
+		},
+		{
+			err:          oauth2.NewError(errorLoginRequired),
+			state:        "foo",
+			redirectURL:  url.URL{Scheme: "http", Host: "server.example.com", Path: "/callback"},
+			responseMode: responseModeFragment,
+			wantLoc:      "http://server.example.com/callback#error=login_required&state=foo",
+		},
 	}
 
 	for i, tt := range tests {
 		w := httptest.NewRecorder()
-		redirectAuthError(w, tt.err, tt.state, tt.redirectURL)
+		redirectAuthError(w, nil, tt.err, tt.state, tt.redirectURL, tt.responseMode)
 
 		if wantCode != w.Code {
 			t.Errorf("case %d: incorrect HTTP status: want=%d got=%d", i, wantCode, w.Code)
@@ -259,3 +283,23 @@ func TestRedirectAuthError(t *testing.T) {
 		}
 	}
 }
+
+func TestRedirectAuthErrorFormPost(t *testing.T) {
+	tpl := template.Must(template.New("form-post").Parse(`{{ .Action }}{{ range $k, $v := .Params }} {{ $k }}={{ $v }}{{ end }}`))
+
+	w := httptest.NewRecorder()
+	err := &oauth2.Error{Type: errorLoginRequired, Description: "no session"}
+	redirectAuthError(w, tpl, err, "foo", url.URL{Scheme: "http", Host: "client.example.com", Path: "/callback"}, responseModeFormPost)
+
+	// The error is posted to the client rather than passed in the URL.
+	if w.Code != http.StatusOK {
+		t.Errorf("incorrect HTTP status: want=%d got=%d", http.StatusOK, w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "" {
+		t.Errorf("unexpected Location header %q", loc)
+	}
+	want := "http://client.example.com/callback error=login_required error_description=no session state=foo"
+	if got := w.Body.String(); got != want {
+		t.Errorf("incorrect HTTP body: want=%q got=%q", want, got)
+	}
+}
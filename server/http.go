@@ -20,9 +20,13 @@ import (
 
 	"github.com/coreos/dex/client"
 	"github.com/coreos/dex/connector"
+	"github.com/coreos/dex/device"
 	phttp "github.com/coreos/dex/pkg/http"
 	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
 	"github.com/coreos/dex/scope"
+	"github.com/coreos/dex/session"
+	"github.com/coreos/dex/signing"
 )
 
 const (
@@ -46,12 +50,25 @@ var (
 	httpPathDebugVars          = "/debug/vars"
 	httpPathClientRegistration = "/registration"
 	httpPathOOB                = "/oob"
+	httpPathIntrospect         = "/introspect"
+	httpPathRevoke             = "/revoke"
+	httpPathUserInfo           = "/userinfo"
+	httpPathDevice             = "/device"
+	httpPathDeviceCode         = "/device/code"
+	httpPathDeviceCallback     = "/device/callback"
+	httpPathLogout             = "/logout"
+	httpPathConsent            = "/consent"
+	httpPathPushedAuthRequest  = "/par"
+	httpPathFormPost           = "/form-post"
+	httpPathSSOStart           = "/sso-start"
 
 	cookieLastSeen                 = "LastSeen"
 	cookieShowEmailVerifiedMessage = "ShowEmailVerifiedMessage"
 )
 
-func handleDiscoveryFunc(cfg oidc.ProviderConfig) http.HandlerFunc {
+// handleDiscoveryFunc serves the provider config returned by cfgFunc, which
+// changes as the signing algorithms are reconfigured.
+func handleDiscoveryFunc(cfgFunc func() ProviderConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			w.Header().Set("Allow", "GET")
@@ -59,6 +76,7 @@ func handleDiscoveryFunc(cfg oidc.ProviderConfig) http.HandlerFunc {
 			return
 		}
 
+		cfg := cfgFunc()
 		b, err := json.Marshal(&cfg)
 		if err != nil {
 			log.Errorf("Unable to marshal %#v to JSON: %v", cfg, err)
@@ -78,16 +96,14 @@ func handleKeysFunc(km key.PrivateKeyManager, clock clockwork.Clock) http.Handle
 			return
 		}
 
-		jwks, err := km.JWKs()
+		jwks, err := publicJWKs(km)
 		if err != nil {
 			log.Errorf("Failed to get JWKs while serving HTTP request: %v", err)
 			phttp.WriteError(w, http.StatusInternalServerError, "")
 			return
 		}
 
-		keys := struct {
-			Keys []jose.JWK `json:"keys"`
-		}{
+		keys := signing.JWKSet{
 			Keys: jwks,
 		}
 
@@ -259,7 +275,9 @@ func renderLoginPage(w http.ResponseWriter, r *http.Request, srv OIDCServer, idp
 
 		v := r.URL.Query()
 		v.Set("connector_id", idpc.ID())
-		v.Set("response_type", "code")
+		if v.Get("response_type") == "" {
+			v.Set("response_type", "code")
+		}
 		link.URL = httpPathAuth + "?" + v.Encode()
 		td.Links = append(td.Links, link)
 	}
@@ -267,7 +285,7 @@ func renderLoginPage(w http.ResponseWriter, r *http.Request, srv OIDCServer, idp
 	execTemplate(w, tpl, td)
 }
 
-func handleAuthFunc(srv OIDCServer, baseURL url.URL, idpcs []connector.Connector, tpl *template.Template, registrationEnabled bool) http.HandlerFunc {
+func handleAuthFunc(srv OIDCServer, baseURL url.URL, idpcs []connector.Connector, tpl, formPostTpl *template.Template, registrationEnabled bool) http.HandlerFunc {
 	idx := makeConnectorMap(idpcs)
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
@@ -280,17 +298,41 @@ func handleAuthFunc(srv OIDCServer, baseURL url.URL, idpcs []connector.Connector
 		register := q.Get("register") == "1" && registrationEnabled
 		e := q.Get("error")
 		if e != "" {
+			// A connector failed to authenticate the user. The client is told
+			// if the session the connector was passed is still known.
 			sessionKey := q.Get("state")
-			if err := srv.KillSession(sessionKey); err != nil {
+			ru, err := srv.LoginErrorURL(sessionKey, connectorAuthError(q))
+			if err != nil {
 				log.Errorf("Failed killing sessionKey %q: %v", sessionKey, err)
+				renderLoginPage(w, r, srv, idpcs, register, tpl)
+				return
 			}
-			renderLoginPage(w, r, srv, idpcs, register, tpl)
+			w.Header().Set("Location", ru)
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+
+		// The client may have passed the request's parameters in a request
+		// object, or pushed them beforehand.
+		requestURI := q.Get("request_uri")
+		q, err := srv.AuthRequestParams(q)
+		if err != nil {
+			log.Errorf("Invalid auth request: %v", err)
+			writeAuthError(w, err, r.URL.Query().Get("state"))
 			return
 		}
 
+		// Parse errors are reported once the redirect URL has been validated.
+		prompts, promptErr := parsePrompt(q.Get("prompt"))
+		promptNoneRequested := pstrings.ContainsString(prompts, promptNone)
+
+		// The user may not need to pick a connector if they're already
+		// logged in to dex.
+		ssoID := ssoSessionID(r)
+
 		connectorID := q.Get("connector_id")
 		idpc, ok := idx[connectorID]
-		if !ok {
+		if !ok && !promptNoneRequested && ssoID == "" {
 			renderLoginPage(w, r, srv, idpcs, register, tpl)
 			return
 		}
@@ -332,27 +374,184 @@ func handleAuthFunc(srv OIDCServer, baseURL url.URL, idpcs []connector.Connector
 			}
 		}
 
-		if acr.ResponseType != oauth2.ResponseTypeCode {
-			log.Errorf("unexpected ResponseType: %v: ", acr.ResponseType)
-			redirectAuthError(w, oauth2.NewError(oauth2.ErrorUnsupportedResponseType), acr.State, redirectURL)
+		responseType, responseMode, err := parseResponseType(acr.ResponseType, q.Get("response_mode"))
+		if err != nil {
+			log.Errorf("Invalid response type: %v", err)
+			redirectAuthError(w, formPostTpl, err, acr.State, redirectURL, "")
+			return
+		}
+		if responseType != oauth2.ResponseTypeCode && redirectURL.String() == client.OOBRedirectURI {
+			log.Errorf("Response type %q requested with out-of-band redirect URL", responseType)
+			redirectAuthError(w, formPostTpl, oauth2.NewError(oauth2.ErrorUnsupportedResponseType), acr.State, redirectURL, responseMode)
 			return
 		}
+		for _, gt := range responseTypeGrantTypes(responseType) {
+			if !cli.GrantTypeAllowed(gt) {
+				log.Errorf("Client %q is not allowed to use grant type %q", acr.ClientID, gt)
+				redirectAuthError(w, formPostTpl, oauth2.NewError(oauth2.ErrorUnauthorizedClient), acr.State, redirectURL, responseMode)
+				return
+			}
+		}
 
 		// Check scopes.
 		if scopeErr := validateScopes(srv, acr.ClientID, acr.Scope); scopeErr != nil {
 			log.Error(scopeErr)
-			writeAuthError(w, scopeErr, acr.State)
+			redirectAuthError(w, formPostTpl, scopeErr, acr.State, redirectURL, responseMode)
 			return
 		}
 
+		codeChallenge := q.Get("code_challenge")
+		codeChallengeMethod, err := parseCodeChallenge(codeChallenge, q.Get("code_challenge_method"))
+		if err != nil {
+			log.Errorf("Invalid code challenge: %v", err)
+			redirectAuthError(w, formPostTpl, err, acr.State, redirectURL, responseMode)
+			return
+		}
+
+		if promptErr != nil {
+			log.Errorf("Invalid prompt: %v", promptErr)
+			redirectAuthError(w, formPostTpl, promptErr, acr.State, redirectURL, responseMode)
+			return
+		}
+		maxAge, err := parseMaxAge(q.Get("max_age"))
+		if err != nil {
+			log.Errorf("Invalid max_age: %v", err)
+			redirectAuthError(w, formPostTpl, err, acr.State, redirectURL, responseMode)
+			return
+		}
+		var hintSubject string
+		if hint := q.Get("id_token_hint"); hint != "" {
+			if hintSubject, err = srv.IDTokenHintSubject(acr.ClientID, hint); err != nil {
+				log.Errorf("Invalid id_token_hint: %v", err)
+				redirectAuthError(w, formPostTpl, oauth2.NewError(oauth2.ErrorInvalidRequest), acr.State, redirectURL, responseMode)
+				return
+			}
+		}
+
 		nonce := q.Get("nonce")
+		if nonce == "" && responseType != oauth2.ResponseTypeCode {
+			// The nonce is the only protection against ID tokens returned
+			// from the authorization endpoint being replayed.
+			log.Errorf("Response type %q requested without nonce", responseType)
+			oerr := oauth2.NewError(oauth2.ErrorInvalidRequest)
+			oerr.Description = fmt.Sprintf("nonce is required with response_type %q", responseType)
+			redirectAuthError(w, formPostTpl, oerr, acr.State, redirectURL, responseMode)
+			return
+		}
+
+		var claimsReq *session.ClaimsRequest
+		if c := q.Get("claims"); c != "" {
+			if claimsReq, err = session.ParseClaimsRequest(c); err != nil {
+				log.Errorf("Invalid claims request: %v", err)
+				oerr := oauth2.NewError(oauth2.ErrorInvalidRequest)
+				oerr.Description = "invalid claims parameter"
+				redirectAuthError(w, formPostTpl, oerr, acr.State, redirectURL, responseMode)
+				return
+			}
+		}
 
-		key, err := srv.NewSession(connectorID, acr.ClientID, acr.State, redirectURL, nonce, register, acr.Scope)
+		if register && promptNoneRequested {
+			// The user can't register without filling in a form.
+			redirectAuthError(w, formPostTpl, oauth2.NewError(errorInteractionRequired), acr.State, redirectURL, responseMode)
+			return
+		}
+
+		var sso *session.SSOSession
+		if ssoID != "" && !register && !pstrings.ContainsString(prompts, promptLogin) && !pstrings.ContainsString(prompts, promptSelectAccount) {
+			sso = srv.UsableSSOSession(ssoID, connectorID, maxAge, hintSubject)
+		}
+		if sso != nil && promptNoneRequested {
+			// The user can't be asked to approve the client's access.
+			consentRequired, err := srv.ConsentRequired(acr.ClientID, sso.UserID, acr.Scope)
+			if err != nil {
+				log.Errorf("Failed checking consent: %v", err)
+				redirectAuthError(w, formPostTpl, oauth2.NewError(oauth2.ErrorServerError), acr.State, redirectURL, responseMode)
+				return
+			}
+			if consentRequired {
+				redirectAuthError(w, formPostTpl, oauth2.NewError(errorConsentRequired), acr.State, redirectURL, responseMode)
+				return
+			}
+		}
+		if sso != nil {
+			key, err := srv.NewSession(session.AuthRequest{
+				ConnectorID:         sso.ConnectorID,
+				ClientID:            acr.ClientID,
+				ClientState:         acr.State,
+				RedirectURL:         redirectURL,
+				Nonce:               nonce,
+				Scope:               acr.Scope,
+				CodeChallenge:       codeChallenge,
+				CodeChallengeMethod: codeChallengeMethod,
+				ClaimsRequest:       claimsReq,
+				ResponseType:        responseType,
+				ResponseMode:        responseMode,
+				PromptConsent:       pstrings.ContainsString(prompts, promptConsent),
+			})
+			if err != nil {
+				log.Errorf("Error creating new session: %v: ", err)
+				redirectAuthError(w, formPostTpl, err, acr.State, redirectURL, responseMode)
+				return
+			}
+			if !usePushedAuthRequest(w, srv, formPostTpl, requestURI, acr.State, redirectURL, responseMode) {
+				return
+			}
+			ru, err := srv.SSOLogin(*sso, key)
+			if err != nil {
+				log.Errorf("SSO login failed: %v", err)
+				redirectAuthError(w, formPostTpl, err, acr.State, redirectURL, responseMode)
+				return
+			}
+			w.Header().Set("Location", ru)
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+
+		if promptNoneRequested {
+			// The user can't be authenticated without interacting with a
+			// connector.
+			redirectAuthError(w, formPostTpl, oauth2.NewError(errorLoginRequired), acr.State, redirectURL, responseMode)
+			return
+		}
+		if idpc == nil {
+			renderLoginPage(w, r, srv, idpcs, register, tpl)
+			return
+		}
+
+		// Once the user logs in, an SSO session is started for them if they
+		// finish logging in with this browser, which the nonce is bound to.
+		ssoLoginNonce, err := session.NewSSOLoginNonce()
+		if err != nil {
+			log.Errorf("Error generating SSO login nonce: %v", err)
+			redirectAuthError(w, formPostTpl, oauth2.NewError(oauth2.ErrorServerError), acr.State, redirectURL, responseMode)
+			return
+		}
+
+		key, err := srv.NewSession(session.AuthRequest{
+			ConnectorID:         connectorID,
+			ClientID:            acr.ClientID,
+			ClientState:         acr.State,
+			RedirectURL:         redirectURL,
+			Nonce:               nonce,
+			Register:            register,
+			Scope:               acr.Scope,
+			CodeChallenge:       codeChallenge,
+			CodeChallengeMethod: codeChallengeMethod,
+			SSOLoginNonce:       ssoLoginNonce,
+			ClaimsRequest:       claimsReq,
+			ResponseType:        responseType,
+			ResponseMode:        responseMode,
+			PromptConsent:       pstrings.ContainsString(prompts, promptConsent),
+		})
 		if err != nil {
 			log.Errorf("Error creating new session: %v: ", err)
-			redirectAuthError(w, err, acr.State, redirectURL)
+			redirectAuthError(w, formPostTpl, err, acr.State, redirectURL, responseMode)
 			return
 		}
+		if !usePushedAuthRequest(w, srv, formPostTpl, requestURI, acr.State, redirectURL, responseMode) {
+			return
+		}
+		http.SetCookie(w, newSSOLoginCookie(baseURL, ssoLoginNonce))
 
 		if register {
 			_, ok := idpc.(*connector.LocalConnector)
@@ -366,17 +565,19 @@ func handleAuthFunc(srv OIDCServer, baseURL url.URL, idpcs []connector.Connector
 			}
 		}
 
-		var p string
-		if register {
-			p = "select_account consent"
+		var p []string
+		if shouldReprompt(r) || register || pstrings.ContainsString(prompts, promptSelectAccount) {
+			p = append(p, promptSelectAccount)
 		}
-		if shouldReprompt(r) || register {
-			p = "select_account"
+		if pstrings.ContainsString(prompts, promptLogin) || maxAge == 0 {
+			// Ask the connector to authenticate the user again rather than
+			// relying on their session with the upstream provider.
+			p = append(p, promptLogin)
 		}
-		lu, err := idpc.LoginURL(key, p)
+		lu, err := idpc.LoginURL(key, strings.Join(p, " "))
 		if err != nil {
 			log.Errorf("Connector.LoginURL failed: %v", err)
-			redirectAuthError(w, err, acr.State, redirectURL)
+			redirectAuthError(w, formPostTpl, err, acr.State, redirectURL, responseMode)
 			return
 		}
 
@@ -387,7 +588,51 @@ func handleAuthFunc(srv OIDCServer, baseURL url.URL, idpcs []connector.Connector
 	}
 }
 
+// connectorAuthError returns the error a client is sent when a connector
+// redirects back to the authorization endpoint with an error. Only the user
+// denying access is reported as such; other failures are dex's own.
+func connectorAuthError(q url.Values) error {
+	typ := oauth2.ErrorServerError
+	if q.Get("error") == oauth2.ErrorAccessDenied {
+		typ = oauth2.ErrorAccessDenied
+	}
+	err := oauth2.NewError(typ)
+	desc := q.Get("error_description")
+	if desc == "" {
+		desc = q.Get("error")
+	}
+	if connectorID := q.Get("connector_id"); connectorID != "" {
+		err.Description = fmt.Sprintf("login with %s failed: %s", connectorID, desc)
+	} else {
+		err.Description = fmt.Sprintf("login failed: %s", desc)
+	}
+	return err
+}
+
+// usePushedAuthRequest deletes the pushed authorization request a session was
+// started with, if any, so it can't be used again. If it has already been
+// used, an error is sent to the client and false is returned.
+func usePushedAuthRequest(w http.ResponseWriter, srv OIDCServer, formPostTpl *template.Template, requestURI, state string, redirectURL url.URL, responseMode string) bool {
+	if requestURI == "" {
+		return true
+	}
+	if err := srv.DeletePushedAuthRequest(requestURI); err != nil {
+		log.Errorf("Failed to use request_uri: %v", err)
+		redirectAuthError(w, formPostTpl, err, state, redirectURL, responseMode)
+		return false
+	}
+	return true
+}
+
 func validateScopes(srv OIDCServer, clientID string, scopes []string) error {
+	cli, err := srv.Client(clientID)
+	if err == client.ErrorNotFound {
+		return oauth2.NewError(oauth2.ErrorInvalidClient)
+	}
+	if err != nil {
+		return err
+	}
+
 	foundOpenIDScope := false
 	for i, curScope := range scopes {
 		if i > 0 && curScope == scopes[i-1] {
@@ -398,6 +643,12 @@ func validateScopes(srv OIDCServer, clientID string, scopes []string) error {
 			return err
 		}
 
+		if !cli.ScopeAllowed(curScope) {
+			err := oauth2.NewError(errorInvalidScope)
+			err.Description = fmt.Sprintf("client is not allowed to request scope %q", curScope)
+			return err
+		}
+
 		switch {
 		case strings.HasPrefix(curScope, scope.ScopeGoogleCrossClient):
 			otherClient := curScope[len(scope.ScopeGoogleCrossClient):]
@@ -427,9 +678,8 @@ func validateScopes(srv OIDCServer, clientID string, scopes []string) error {
 		case curScope == "offline_access":
 			// According to the spec, for offline_access scope, the client must
 			// use a response_type value that would result in an Authorization
-			// Code.  Currently oauth2.ResponseTypeCode is the only supported
-			// response type, and it's been checked above, so we don't need to
-			// check it again here.
+			// Code. Refresh tokens are only issued from the token endpoint, so
+			// the scope is ignored by the implicit flow.
 			//
 			// TODO(yifan): Verify that 'consent' should be in 'prompt'.
 		default:
@@ -465,34 +715,33 @@ func handleTokenFunc(srv OIDCServer) http.HandlerFunc {
 		}
 
 		state := r.PostForm.Get("state")
+		grantType := r.PostForm.Get("grant_type")
 
-		user, password, ok := r.BasicAuth()
-		if !ok {
-			log.Errorf("error parsing basic auth")
-			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), state)
-			return
-		}
-
-		decodedUser, err := url.QueryUnescape(user)
-		if err != nil {
-			log.Errorf("error decoding user: %v", err)
+		creds, ok, err := clientCredentials(r)
+		switch {
+		case err != nil:
+			log.Errorf("error decoding client credentials: %v", err)
 			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), state)
 			return
-		}
-
-		decodedPassword, err := url.QueryUnescape(password)
-		if err != nil {
-			log.Errorf("error decoding password: %v", err)
+		case ok:
+		case grantType == oauth2.GrantTypeAuthCode && r.PostForm.Get("code_verifier") != "" && r.PostForm.Get("client_id") != "",
+			grantType == device.GrantTypeDeviceCode && r.PostForm.Get("client_id") != "":
+			// Public clients using PKCE or the device authorization grant
+			// identify themselves with the client_id parameter alone
+			// (RFC 6749 Section 4.1.3, RFC 8628 Section 3.4).
+			creds = oidc.ClientCredentials{ID: r.PostForm.Get("client_id")}
+		default:
+			log.Errorf("missing client credentials")
 			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), state)
 			return
 		}
 
-		creds := oidc.ClientCredentials{ID: decodedUser, Secret: decodedPassword}
-
-		var jwt *jose.JWT
+		var jwt, accessToken *jose.JWT
 		var refreshToken string
 		var expiresAt time.Time
-		grantType := r.PostForm.Get("grant_type")
+
+		// Clients may request access tokens for specific resources (RFC 8707).
+		resources := r.PostForm["resource"]
 
 		switch grantType {
 		case oauth2.GrantTypeAuthCode:
@@ -502,19 +751,50 @@ func handleTokenFunc(srv OIDCServer) http.HandlerFunc {
 				writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidRequest), state)
 				return
 			}
-			jwt, refreshToken, expiresAt, err = srv.CodeToken(creds, code)
+			jwt, accessToken, refreshToken, expiresAt, err = srv.CodeToken(creds, code, r.PostForm.Get("code_verifier"), resources)
 			if err != nil {
 				log.Errorf("couldn't exchange code for token: %v", err)
 				writeTokenError(w, err, state)
 				return
 			}
 		case oauth2.GrantTypeClientCreds:
-			jwt, expiresAt, err = srv.ClientCredsToken(creds)
+			jwt, accessToken, expiresAt, err = srv.ClientCredsToken(creds, resources)
 			if err != nil {
 				log.Errorf("couldn't creds for token: %v", err)
 				writeTokenError(w, err, state)
 				return
 			}
+		case oauth2.GrantTypeUserCreds:
+			username := r.PostForm.Get("username")
+			password := r.PostForm.Get("password")
+			if username == "" || password == "" {
+				log.Errorf("missing username or password param")
+				writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidRequest), state)
+				return
+			}
+			scopes := strings.Fields(r.PostForm.Get("scope"))
+			if err := validateScopes(srv, creds.ID, scopes); err != nil {
+				writeTokenError(w, err, state)
+				return
+			}
+			jwt, accessToken, refreshToken, expiresAt, err = srv.PasswordToken(creds, r.PostForm.Get("connector_id"), username, password, scopes, resources)
+			if err != nil {
+				log.Errorf("couldn't exchange password for token: %v", err)
+				writeTokenError(w, err, state)
+				return
+			}
+		case device.GrantTypeDeviceCode:
+			deviceCode := r.PostForm.Get("device_code")
+			if deviceCode == "" {
+				log.Errorf("missing device_code param")
+				writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidRequest), state)
+				return
+			}
+			jwt, accessToken, refreshToken, expiresAt, err = srv.DeviceToken(creds, deviceCode, resources)
+			if err != nil {
+				writeTokenError(w, err, state)
+				return
+			}
 		case oauth2.GrantTypeRefreshToken:
 			token := r.PostForm.Get("refresh_token")
 			scopes := r.PostForm.Get("scope")
@@ -522,11 +802,40 @@ func handleTokenFunc(srv OIDCServer) http.HandlerFunc {
 				writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidRequest), state)
 				return
 			}
-			jwt, refreshToken, expiresAt, err = srv.RefreshToken(creds, strings.Split(scopes, " "), token)
+			jwt, accessToken, refreshToken, expiresAt, err = srv.RefreshToken(creds, strings.Split(scopes, " "), token, resources)
 			if err != nil {
 				writeTokenError(w, err, state)
 				return
 			}
+		case client.GrantTypeTokenExchange:
+			req := TokenExchangeRequest{
+				SubjectToken:     r.PostForm.Get("subject_token"),
+				SubjectTokenType: r.PostForm.Get("subject_token_type"),
+				ActorToken:       r.PostForm.Get("actor_token"),
+				ActorTokenType:   r.PostForm.Get("actor_token_type"),
+				Audience:         r.PostForm["audience"],
+				Resources:        resources,
+				Scope:            strings.Fields(r.PostForm.Get("scope")),
+			}
+			var desc string
+			switch rtt := r.PostForm.Get("requested_token_type"); {
+			case req.SubjectToken == "" || req.SubjectTokenType == "":
+				desc = "subject_token and subject_token_type are required"
+			case (req.ActorToken == "") != (req.ActorTokenType == ""):
+				desc = "actor_token and actor_token_type must be passed together"
+			case rtt != "" && rtt != tokenTypeAccessToken:
+				desc = fmt.Sprintf("unsupported requested_token_type %q", rtt)
+			}
+			if desc != "" {
+				writeTokenError(w, tokenExchangeError(desc), state)
+				return
+			}
+			accessToken, expiresAt, err = srv.ExchangeToken(creds, req)
+			if err != nil {
+				log.Errorf("couldn't exchange token: %v", err)
+				writeTokenError(w, err, state)
+				return
+			}
 		default:
 			log.Errorf("unsupported grant: %v", grantType)
 			writeTokenError(w, oauth2.NewError(oauth2.ErrorUnsupportedGrantType), state)
@@ -534,12 +843,18 @@ func handleTokenFunc(srv OIDCServer) http.HandlerFunc {
 		}
 
 		t := oAuth2Token{
-			AccessToken:  jwt.Encode(),
-			IDToken:      jwt.Encode(),
+			AccessToken:  accessToken.Encode(),
 			TokenType:    "bearer",
 			RefreshToken: refreshToken,
 			ExpiresIn:    int64(expiresAt.Sub(time.Now()).Seconds()),
 		}
+		if jwt != nil {
+			t.IDToken = jwt.Encode()
+		} else {
+			// Token exchange only issues an access token (RFC 8693
+			// Section 2.2.1).
+			t.IssuedTokenType = tokenTypeAccessToken
+		}
 
 		b, err := json.Marshal(t)
 		if err != nil {
@@ -554,6 +869,27 @@ func handleTokenFunc(srv OIDCServer) http.HandlerFunc {
 	}
 }
 
+// basicAuthClientCredentials returns the client credentials presented using
+// the Basic HTTP authentication scheme (RFC 6749 Section 2.3.1), if any.
+func basicAuthClientCredentials(r *http.Request) (oidc.ClientCredentials, bool, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return oidc.ClientCredentials{}, false, nil
+	}
+
+	decodedUser, err := url.QueryUnescape(user)
+	if err != nil {
+		return oidc.ClientCredentials{}, false, fmt.Errorf("error decoding user: %v", err)
+	}
+
+	decodedPassword, err := url.QueryUnescape(password)
+	if err != nil {
+		return oidc.ClientCredentials{}, false, fmt.Errorf("error decoding password: %v", err)
+	}
+
+	return oidc.ClientCredentials{ID: decodedUser, Secret: decodedPassword}, true, nil
+}
+
 func handleOOBFunc(s *Server, tpl *template.Template) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
@@ -562,7 +898,16 @@ func handleOOBFunc(s *Server, tpl *template.Template) http.HandlerFunc {
 			return
 		}
 
-		key := r.URL.Query().Get("code")
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			execTemplateWithStatus(w, tpl, map[string]string{
+				"error":             e,
+				"error_description": q.Get("error_description"),
+			}, http.StatusBadRequest)
+			return
+		}
+
+		key := q.Get("code")
 		if key == "" {
 			phttp.WriteError(w, http.StatusBadRequest, "Invalid Session")
 			return
@@ -592,11 +937,12 @@ func makeHealthHandler(checks []health.Checkable) http.Handler {
 }
 
 type oAuth2Token struct {
-	AccessToken  string `json:"access_token"`
-	IDToken      string `json:"id_token"`
-	TokenType    string `json:"token_type"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	ExpiresIn    int64  `json:"expires_in"`
+	AccessToken     string `json:"access_token"`
+	IDToken         string `json:"id_token,omitempty"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	ExpiresIn       int64  `json:"expires_in"`
 }
 
 func createLastSeenCookie() *http.Cookie {
@@ -11,15 +11,20 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/jonboulle/clockwork"
+	"github.com/kylelemons/godebug/pretty"
 
 	"github.com/coreos/dex/client"
 	"github.com/coreos/dex/connector"
+	"github.com/coreos/dex/device"
 	"github.com/coreos/dex/scope"
+	"github.com/coreos/dex/session"
+	"github.com/coreos/dex/signing"
 	"github.com/coreos/go-oidc/jose"
 	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
@@ -55,7 +60,7 @@ func (c *fakeConnector) TrustedEmailProvider() bool {
 
 func TestHandleAuthFuncMethodNotAllowed(t *testing.T) {
 	for _, m := range []string{"POST", "PUT", "DELETE"} {
-		hdlr := handleAuthFunc(nil, url.URL{}, nil, nil, true)
+		hdlr := handleAuthFunc(nil, url.URL{}, nil, nil, nil, true)
 		req, err := http.NewRequest(m, "http://example.com", nil)
 		if err != nil {
 			t.Errorf("case %s: unable to create HTTP request: %v", m, err)
@@ -135,6 +140,18 @@ func TestHandleAuthFuncResponsesSingleRedirectURL(t *testing.T) {
 			wantCode:     http.StatusFound,
 			wantLocation: "http://fake.example.com",
 		},
+		// loopback redirect_uri with a path for public client
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"redirect_uri":  []string{"http://127.0.0.1:49152/callback"},
+				"client_id":     []string{testPublicClientID},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://fake.example.com",
+		},
 		// valid OOB  redirect_uri for public client
 		{
 			query: url.Values{
@@ -180,7 +197,74 @@ func TestHandleAuthFuncResponsesSingleRedirectURL(t *testing.T) {
 				"scope":         []string{"openid"},
 			},
 			wantCode:     http.StatusFound,
-			wantLocation: "http://client.example.com/callback?error=unsupported_response_type&state=",
+			wantLocation: "http://client.example.com/callback?error=unsupported_response_type&error_description=unsupported+response_type+%22token%22",
+		},
+
+		// implicit flow, redirects to connector
+		{
+			query: url.Values{
+				"response_type": []string{"id_token token"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"nonce":         []string{"abc"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://fake.example.com",
+		},
+
+		// hybrid flow posting the response, with the response type's values
+		// in another order
+		{
+			query: url.Values{
+				"response_type": []string{"id_token code"},
+				"response_mode": []string{"form_post"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"nonce":         []string{"abc"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://fake.example.com",
+		},
+
+		// ID tokens can't be requested without a nonce
+		{
+			query: url.Values{
+				"response_type": []string{"id_token"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback#error=invalid_request&error_description=nonce+is+required+with+response_type+%22id_token%22",
+		},
+
+		// tokens can't be passed in the query
+		{
+			query: url.Values{
+				"response_type": []string{"code id_token"},
+				"response_mode": []string{"query"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"nonce":         []string{"abc"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=invalid_request&error_description=response_mode+query+can%27t+be+used+with+response_type+%22code+id_token%22",
+		},
+
+		// unsupported response mode
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"response_mode": []string{"web_message"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=invalid_request&error_description=unsupported+response_mode+%22web_message%22",
 		},
 
 		// no 'openid' in scope
@@ -191,8 +275,130 @@ func TestHandleAuthFuncResponsesSingleRedirectURL(t *testing.T) {
 				"client_id":     []string{"client.example.com"},
 				"connector_id":  []string{"fake"},
 			},
-			wantCode: http.StatusBadRequest,
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=invalid_request&error_description=Invalid+auth+request%3A+missing+%27openid%27+in+%27scope%27",
+		},
+
+		// valid PKCE code challenge
+		{
+			query: url.Values{
+				"response_type":         []string{"code"},
+				"redirect_uri":          []string{"http://localhost:8080"},
+				"client_id":             []string{testPublicClientID},
+				"connector_id":          []string{"fake"},
+				"scope":                 []string{"openid"},
+				"code_challenge":        []string{"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+				"code_challenge_method": []string{"S256"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://fake.example.com",
+		},
+
+		// unsupported PKCE code challenge method, redirects back to client
+		{
+			query: url.Values{
+				"response_type":         []string{"code"},
+				"client_id":             []string{"client.example.com"},
+				"connector_id":          []string{"fake"},
+				"scope":                 []string{"openid"},
+				"code_challenge":        []string{"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+				"code_challenge_method": []string{"S512"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=invalid_request&error_description=unsupported+code_challenge_method+%22S512%22",
+		},
+		// prompt=none, users can't be authenticated without interaction
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"prompt":        []string{"none"},
+				"state":         []string{"abc"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=login_required&state=abc",
+		},
+
+		// prompt=none without a connector doesn't render the login page
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{"client.example.com"},
+				"scope":         []string{"openid"},
+				"prompt":        []string{"none"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=login_required",
+		},
+
+		// prompt=none combined with another value
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"prompt":        []string{"none login"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=invalid_request&error_description=prompt+value+none+must+not+be+combined+with+other+values",
+		},
+
+		// unknown prompt value
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"prompt":        []string{"always"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=invalid_request&error_description=unsupported+prompt+value+%22always%22",
+		},
+
+		// prompt=login and max_age are passed on to the connector
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"prompt":        []string{"login consent"},
+				"max_age":       []string{"0"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://fake.example.com",
+		},
+
+		// invalid max_age
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"max_age":       []string{"-1"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=invalid_request&error_description=max_age+must+be+a+non-negative+integer",
+		},
+
+		// id_token_hint which wasn't issued by dex
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{"client.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+				"id_token_hint": []string{"not-a-jwt"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://client.example.com/callback?error=invalid_request",
 		},
+
 		// empty response_type
 		{
 			query: url.Values{
@@ -202,7 +408,7 @@ func TestHandleAuthFuncResponsesSingleRedirectURL(t *testing.T) {
 				"scope":        []string{"openid"},
 			},
 			wantCode:     http.StatusFound,
-			wantLocation: "http://client.example.com/callback?error=unsupported_response_type&state=",
+			wantLocation: "http://client.example.com/callback?error=unsupported_response_type&error_description=unsupported+response_type+%22%22",
 		},
 
 		// empty client_id
@@ -262,7 +468,7 @@ func TestHandleAuthFuncResponsesSingleRedirectURL(t *testing.T) {
 			t.Fatalf("error making test fixtures: %v", err)
 		}
 
-		hdlr := handleAuthFunc(f.srv, tt.baseURL, idpcs, nil, true)
+		hdlr := handleAuthFunc(f.srv, tt.baseURL, idpcs, nil, f.srv.FormPostTemplate, true)
 		w := httptest.NewRecorder()
 		u := fmt.Sprintf("http://server.example.com?%s", tt.query.Encode())
 		req, err := http.NewRequest("GET", u, nil)
@@ -284,89 +490,1119 @@ func TestHandleAuthFuncResponsesSingleRedirectURL(t *testing.T) {
 	}
 }
 
-func TestHandleAuthFuncResponsesMultipleRedirectURLs(t *testing.T) {
+func TestHandleAuthFuncConnectorError(t *testing.T) {
 	idpcs := []connector.Connector{
 		&fakeConnector{loginURL: "http://fake.example.com"},
 	}
 
-	clients := []client.Client{
-		client.Client{
-			Credentials: oidc.ClientCredentials{
-				ID:     "foo.example.com",
-				Secret: base64.URLEncoding.EncodeToString([]byte("secrete")),
+	tests := []struct {
+		responseType string
+		responseMode string
+		query        url.Values
+		validKey     bool
+
+		wantLocation string
+	}{
+		// the user denied access at the upstream provider
+		{
+			query: url.Values{
+				"error":             {"access_denied"},
+				"error_description": {"user denied access"},
+				"connector_id":      {"fake"},
 			},
-			Metadata: oidc.ClientMetadata{
-				RedirectURIs: []url.URL{
-					url.URL{Scheme: "http", Host: "foo.example.com", Path: "/callback"},
-					url.URL{Scheme: "http", Host: "bar.example.com", Path: "/callback"},
-				},
+			validKey:     true,
+			wantLocation: "http://client.example.com/callback?error=access_denied&error_description=login+with+fake+failed%3A+user+denied+access&state=xyz",
+		},
+		{
+			responseType: oauth2.ResponseTypeIDToken,
+			responseMode: responseModeFragment,
+			query: url.Values{
+				"error":        {"unsupported_response_type"},
+				"connector_id": {"fake"},
+			},
+			validKey:     true,
+			wantLocation: "http://client.example.com/callback#error=server_error&error_description=login+with+fake+failed%3A+unsupported_response_type&state=xyz",
+		},
+		// the session is unknown, so the login page is shown
+		{
+			query: url.Values{
+				"error":        {"access_denied"},
+				"connector_id": {"fake"},
 			},
 		},
 	}
-	f, err := makeTestFixturesWithOptions(testFixtureOptions{
-		clients: clientsToLoadableClients(clients),
-	})
-	if err != nil {
-		t.Fatalf("error making test fixtures: %v", err)
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("error making test fixtures: %v", err)
+		}
+		key, err := f.srv.NewSession(session.AuthRequest{
+			ConnectorID:  "fake",
+			ClientID:     testClientID,
+			ClientState:  "xyz",
+			RedirectURL:  testRedirectURL,
+			Scope:        []string{"openid"},
+			ResponseType: tt.responseType,
+			ResponseMode: tt.responseMode,
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if tt.validKey {
+			tt.query.Set("state", key)
+		}
+
+		hdlr := handleAuthFunc(f.srv, url.URL{}, idpcs, template.Must(template.New("login").Parse("login")), f.srv.FormPostTemplate, true)
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://server.example.com?"+tt.query.Encode(), nil)
+		if err != nil {
+			t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+		}
+		hdlr.ServeHTTP(w, req)
+
+		if tt.wantLocation == "" {
+			if w.Code != http.StatusOK {
+				t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusOK, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusFound {
+			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusFound, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Location"); got != tt.wantLocation {
+			t.Errorf("case %d: HTTP Location header mismatch: want=%s got=%s", i, tt.wantLocation, got)
+		}
+	}
+}
+
+func TestHandleAuthFuncSSOSession(t *testing.T) {
+	idpcs := []connector.Connector{
+		&fakeConnector{loginURL: "http://fake.example.com"},
 	}
 
+	clientRedirect := "http://client.example.com/callback?code="
 	tests := []struct {
-		query        url.Values
-		wantCode     int
-		wantLocation string
+		ssoID string
+		query url.Values
+
+		wantLocationPrefix string
+		wantLoginCookie    bool
 	}{
-		// provided redirect_uri matches client's first
+		// logged in users skip the connector
 		{
+			ssoID: "sso-1",
 			query: url.Values{
 				"response_type": []string{"code"},
-				"redirect_uri":  []string{"http://foo.example.com/callback"},
-				"client_id":     []string{"foo.example.com"},
-				"connector_id":  []string{"fake"},
+				"client_id":     []string{testClientID},
 				"scope":         []string{"openid"},
 			},
-			wantCode:     http.StatusFound,
-			wantLocation: "http://fake.example.com",
+			wantLocationPrefix: clientRedirect,
 		},
-
-		// provided redirect_uri matches client's second
 		{
+			ssoID: "sso-1",
 			query: url.Values{
 				"response_type": []string{"code"},
-				"redirect_uri":  []string{"http://bar.example.com/callback"},
-				"client_id":     []string{"foo.example.com"},
+				"client_id":     []string{testClientID},
+				"scope":         []string{"openid"},
+				"prompt":        []string{"none"},
+				"max_age":       []string{"3600"},
+			},
+			wantLocationPrefix: clientRedirect,
+		},
+		// the user authenticated too long ago
+		{
+			ssoID: "sso-1",
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{testClientID},
+				"scope":         []string{"openid"},
+				"prompt":        []string{"none"},
+				"max_age":       []string{"60"},
+			},
+			wantLocationPrefix: "http://client.example.com/callback?error=login_required",
+		},
+		// the client asks for the user to log in again
+		{
+			ssoID: "sso-1",
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{testClientID},
 				"connector_id":  []string{"fake"},
 				"scope":         []string{"openid"},
+				"prompt":        []string{"login"},
 			},
-			wantCode:     http.StatusFound,
-			wantLocation: "http://fake.example.com",
+			wantLocationPrefix: "http://fake.example.com",
+			wantLoginCookie:    true,
 		},
-
-		// provided redirect_uri does not match either of client's
+		// the client asks for another connector
 		{
+			ssoID: "sso-1",
 			query: url.Values{
 				"response_type": []string{"code"},
-				"redirect_uri":  []string{"http://unrecognized.example.com/callback"},
-				"client_id":     []string{"foo.example.com"},
+				"client_id":     []string{testClientID},
 				"connector_id":  []string{"fake"},
 				"scope":         []string{"openid"},
 			},
-			wantCode: http.StatusBadRequest,
+			wantLocationPrefix: "http://fake.example.com",
+			wantLoginCookie:    true,
 		},
-
-		// no redirect_uri provided
+		// unknown SSO session
 		{
+			ssoID: "sso-unknown",
 			query: url.Values{
 				"response_type": []string{"code"},
-				"client_id":     []string{"foo.example.com"},
+				"client_id":     []string{testClientID},
+				"scope":         []string{"openid"},
+				"prompt":        []string{"none"},
+			},
+			wantLocationPrefix: "http://client.example.com/callback?error=login_required",
+		},
+		// logging in with a connector binds the session to the browser
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{testClientID},
 				"connector_id":  []string{"fake"},
 				"scope":         []string{"openid"},
 			},
-			wantCode: http.StatusBadRequest,
+			wantLocationPrefix: "http://fake.example.com",
+			wantLoginCookie:    true,
 		},
 	}
 
 	for i, tt := range tests {
-		hdlr := handleAuthFunc(f.srv, url.URL{}, idpcs, nil, true)
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("error making test fixtures: %v", err)
+		}
+		err = f.srv.SSOSessionRepo.Create(session.SSOSession{
+			ID:          "sso-1",
+			UserID:      testUserID1,
+			ConnectorID: testConnectorID1,
+			Identity:    oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1},
+			AuthTime:    time.Now().Add(-10 * time.Minute),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		hdlr := handleAuthFunc(f.srv, url.URL{}, idpcs, nil, f.srv.FormPostTemplate, true)
+		w := httptest.NewRecorder()
+		u := fmt.Sprintf("http://server.example.com?%s", tt.query.Encode())
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+		}
+		if tt.ssoID != "" {
+			req.AddCookie(&http.Cookie{Name: cookieSSOSession, Value: tt.ssoID})
+		}
+
+		hdlr.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusFound, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Location"); !strings.HasPrefix(got, tt.wantLocationPrefix) {
+			t.Errorf("case %d: want Location starting with %q, got %q", i, tt.wantLocationPrefix, got)
+		}
+		// The SSO session cookie is only ever set once the user has logged in.
+		cookies := strings.Join(w.HeaderMap["Set-Cookie"], ";")
+		if strings.Contains(cookies, cookieSSOSession+"=") {
+			t.Errorf("case %d: unexpected SSO session cookie: %s", i, cookies)
+		}
+		gotLoginCookie := strings.Contains(cookies, cookieSSOLogin+"=")
+		if gotLoginCookie != tt.wantLoginCookie {
+			t.Errorf("case %d: want SSO login cookie set=%t, got %t", i, tt.wantLoginCookie, gotLoginCookie)
+		}
+	}
+}
+
+// keyConnector is a fake connector whose login page is passed the session key,
+// which the connector's callback logs the user in with.
+type keyConnector struct {
+	fakeConnector
+	id string
+}
+
+func (c *keyConnector) ID() string {
+	return c.id
+}
+
+func (c *keyConnector) LoginURL(sessionKey, prompt string) (string, error) {
+	return c.loginURL + "?" + url.Values{"state": {sessionKey}}.Encode(), nil
+}
+
+func TestHandleSSOStartFunc(t *testing.T) {
+	idpcs := []connector.Connector{
+		&keyConnector{fakeConnector: fakeConnector{loginURL: "http://fake.example.com"}, id: testConnectorID1},
+	}
+	ident := oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1}
+
+	tests := []struct {
+		// sameBrowser is whether the user finishes logging in with the
+		// browser which started the login. Otherwise loginCookie is the
+		// SSO login nonce cookie the other browser presents, if any.
+		sameBrowser bool
+		loginCookie string
+
+		wantSSOSession bool
+	}{
+		{
+			sameBrowser:    true,
+			wantSSOSession: true,
+		},
+		// somebody else started the login, and had the user finish it
+		{
+			sameBrowser: false,
+		},
+		{
+			sameBrowser: false,
+			loginCookie: "other-nonce",
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("error making test fixtures: %v", err)
+		}
+
+		// The first browser starts the login.
+		q := url.Values{
+			"response_type": []string{"code"},
+			"client_id":     []string{testClientID},
+			"connector_id":  []string{testConnectorID1},
+			"scope":         []string{"openid"},
+		}
+		req, err := http.NewRequest("GET", "http://server.example.com/auth?"+q.Encode(), nil)
+		if err != nil {
+			t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+		}
+		w := httptest.NewRecorder()
+		handleAuthFunc(f.srv, testIssuerURL, idpcs, nil, f.srv.FormPostTemplate, true).ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Fatalf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusFound, w.Code)
+		}
+		firstBrowser := w.Result().Cookies()
+		loginURL, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		// The user finishes logging in with the connector.
+		ru, err := f.srv.Login(ident, loginURL.Query().Get("state"))
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		req, err = http.NewRequest("GET", ru, nil)
+		if err != nil {
+			t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+		}
+		if req.URL.Path != httpPathSSOStart {
+			t.Fatalf("case %d: want redirect to %s, got %s", i, httpPathSSOStart, ru)
+		}
+		if tt.sameBrowser {
+			for _, c := range firstBrowser {
+				req.AddCookie(c)
+			}
+		} else if tt.loginCookie != "" {
+			req.AddCookie(&http.Cookie{Name: cookieSSOLogin, Value: tt.loginCookie})
+		}
+		w = httptest.NewRecorder()
+		handleSSOStartFunc(f.srv).ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Fatalf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusFound, w.Code)
+		}
+
+		// Either way, the user is logged in to the client.
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if loc.Host != testRedirectURL.Host || loc.Query().Get("code") == "" {
+			t.Errorf("case %d: want redirect to client with a code, got %s", i, loc)
+		}
+
+		var ssoID string
+		for _, c := range w.Result().Cookies() {
+			if c.Name == cookieSSOSession {
+				ssoID = c.Value
+			}
+		}
+		if !tt.wantSSOSession {
+			if ssoID != "" {
+				t.Errorf("case %d: unexpected SSO session cookie", i)
+			}
+			// The browser which started the login isn't logged in either.
+			req, err = http.NewRequest("GET", "http://server.example.com/auth?"+q.Encode()+"&prompt=none", nil)
+			if err != nil {
+				t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+			}
+			for _, c := range firstBrowser {
+				req.AddCookie(c)
+			}
+			w = httptest.NewRecorder()
+			handleAuthFunc(f.srv, testIssuerURL, idpcs, nil, f.srv.FormPostTemplate, true).ServeHTTP(w, req)
+			if got := w.Header().Get("Location"); !strings.Contains(got, "error=login_required") {
+				t.Errorf("case %d: want login_required, got %s", i, got)
+			}
+			continue
+		}
+
+		sso, err := f.srv.SSOSession(ssoID)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if sso.UserID != testUserID1 {
+			t.Errorf("case %d: want SSO session of user %q, got %q", i, testUserID1, sso.UserID)
+		}
+		for _, c := range firstBrowser {
+			if c.Name == cookieSSOLogin && c.Value == ssoID {
+				t.Errorf("case %d: SSO session ID was known before the user logged in", i)
+			}
+		}
+	}
+}
+
+func TestHandleLogoutFunc(t *testing.T) {
+	postLogoutRedirectURL := url.URL{Scheme: "http", Host: "client.example.com", Path: "/logged-out"}
+	clients := []client.Client{
+		client.Client{
+			Credentials: testClientCredentials,
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{testRedirectURL},
+			},
+			PostLogoutRedirectURIs: []url.URL{postLogoutRedirectURL},
+		},
+	}
+
+	tests := []struct {
+		method  string
+		ssoID   string
+		query   url.Values
+		useHint bool
+
+		wantCode       int
+		wantLocation   string
+		wantLoggedOut  bool
+		wantSSOCleared bool
+	}{
+		// the client identifies the logged in user
+		{
+			method:  "GET",
+			ssoID:   "sso-1",
+			useHint: true,
+			query: url.Values{
+				"post_logout_redirect_uri": []string{postLogoutRedirectURL.String()},
+				"state":                    []string{"foo"},
+			},
+			wantCode:       http.StatusFound,
+			wantLocation:   postLogoutRedirectURL.String() + "?state=foo",
+			wantLoggedOut:  true,
+			wantSSOCleared: true,
+		},
+		// the user is asked to confirm without a hint
+		{
+			method:   "GET",
+			ssoID:    "sso-1",
+			query:    url.Values{},
+			wantCode: http.StatusOK,
+		},
+		// which they do
+		{
+			method: "POST",
+			ssoID:  "sso-1",
+			query: url.Values{
+				"confirm": []string{"true"},
+			},
+			wantCode:       http.StatusOK,
+			wantLoggedOut:  true,
+			wantSSOCleared: true,
+		},
+		// no SSO session
+		{
+			method:  "GET",
+			useHint: true,
+			query: url.Values{
+				"post_logout_redirect_uri": []string{postLogoutRedirectURL.String()},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: postLogoutRedirectURL.String(),
+		},
+		// unregistered post_logout_redirect_uri
+		{
+			method:  "GET",
+			ssoID:   "sso-1",
+			useHint: true,
+			query: url.Values{
+				"post_logout_redirect_uri": []string{testRedirectURL.String()},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		// post_logout_redirect_uri without a hint identifying the client
+		{
+			method: "GET",
+			ssoID:  "sso-1",
+			query: url.Values{
+				"post_logout_redirect_uri": []string{postLogoutRedirectURL.String()},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		// invalid hint
+		{
+			method: "GET",
+			ssoID:  "sso-1",
+			query: url.Values{
+				"id_token_hint": []string{"not-a-token"},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			method:   "PUT",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixturesWithOptions(testFixtureOptions{
+			clients: clientsToLoadableClients(clients),
+		})
+		if err != nil {
+			t.Fatalf("error making test fixtures: %v", err)
+		}
+		err = f.srv.SSOSessionRepo.Create(session.SSOSession{
+			ID:          "sso-1",
+			UserID:      testUserID1,
+			ConnectorID: testConnectorID1,
+			AuthTime:    time.Now(),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		if tt.useHint {
+			sm := f.sessionManager
+			sessionID, err := sm.NewSession(session.AuthRequest{
+				ConnectorID: testConnectorID1,
+				ClientID:    testClientID,
+				RedirectURL: testRedirectURL,
+				Scope:       []string{"openid"},
+			})
+			if err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+			if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+			if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+			key, err := sm.NewSessionKey(sessionID)
+			if err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+			idToken, _, _, _, err := f.srv.CodeToken(testClientCredentials, key, "", nil)
+			if err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+			tt.query.Set("id_token_hint", idToken.Encode())
+		}
+
+		var req *http.Request
+		if tt.method == "POST" {
+			req, err = http.NewRequest(tt.method, "http://server.example.com/logout", strings.NewReader(tt.query.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, err = http.NewRequest(tt.method, "http://server.example.com/logout?"+tt.query.Encode(), nil)
+		}
+		if err != nil {
+			t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+		}
+		if tt.ssoID != "" {
+			req.AddCookie(&http.Cookie{Name: cookieSSOSession, Value: tt.ssoID})
+		}
+
+		w := httptest.NewRecorder()
+		handleLogoutFunc(f.srv, f.srv.LogoutTemplate).ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, tt.wantCode, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Location"); got != tt.wantLocation {
+			t.Errorf("case %d: want Location %q, got %q", i, tt.wantLocation, got)
+		}
+
+		_, err = f.srv.SSOSessionRepo.Get("sso-1")
+		if loggedOut := err == session.ErrorSSOSessionNotFound; loggedOut != tt.wantLoggedOut {
+			t.Errorf("case %d: want logged out=%t, got %t", i, tt.wantLoggedOut, loggedOut)
+		}
+		cleared := strings.Contains(strings.Join(w.HeaderMap["Set-Cookie"], ";"), cookieSSOSession+"=;")
+		if cleared != tt.wantSSOCleared {
+			t.Errorf("case %d: want SSO cookie cleared=%t, got %t", i, tt.wantSSOCleared, cleared)
+		}
+	}
+}
+
+func TestHandleConsentFunc(t *testing.T) {
+	idpcs := []connector.Connector{
+		&fakeConnector{loginURL: "http://fake.example.com"},
+	}
+	clients := []client.Client{
+		client.Client{
+			Credentials: testClientCredentials,
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{testRedirectURL},
+				ClientName:   "Example App",
+			},
+		},
+	}
+	codeInput := regexp.MustCompile(`name="code" value="([^"]+)"`)
+
+	tests := []struct {
+		approve bool
+
+		wantLocationPrefix string
+		wantConsent        bool
+	}{
+		{
+			approve:            true,
+			wantLocationPrefix: "http://client.example.com/callback?code=",
+			wantConsent:        true,
+		},
+		{
+			approve:            false,
+			wantLocationPrefix: "http://client.example.com/callback?error=access_denied",
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixturesWithOptions(testFixtureOptions{
+			clients: clientsToLoadableClients(clients),
+		})
+		if err != nil {
+			t.Fatalf("error making test fixtures: %v", err)
+		}
+		err = f.srv.SSOSessionRepo.Create(session.SSOSession{
+			ID:          "sso-1",
+			UserID:      testUserID1,
+			ConnectorID: testConnectorID1,
+			Identity:    oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1},
+			AuthTime:    time.Now(),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		authorize := func(prompt string) string {
+			q := url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{testClientID},
+				"scope":         []string{"openid email"},
+				"state":         []string{"foo"},
+				"prompt":        []string{prompt},
+			}
+			req, err := http.NewRequest("GET", "http://server.example.com?"+q.Encode(), nil)
+			if err != nil {
+				t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+			}
+			req.AddCookie(&http.Cookie{Name: cookieSSOSession, Value: "sso-1"})
+			w := httptest.NewRecorder()
+			handleAuthFunc(f.srv, url.URL{}, idpcs, nil, f.srv.FormPostTemplate, true).ServeHTTP(w, req)
+			return w.Header().Get("Location")
+		}
+
+		// Consent can't be asked for without interacting with the user.
+		if got, want := authorize("none"), "http://client.example.com/callback?error=consent_required"; !strings.HasPrefix(got, want) {
+			t.Errorf("case %d: want Location starting with %q, got %q", i, want, got)
+		}
+
+		consentPrefix := "http://server.example.com/consent?code="
+		loc := authorize("")
+		if !strings.HasPrefix(loc, consentPrefix) {
+			t.Fatalf("case %d: want Location starting with %q, got %q", i, consentPrefix, loc)
+		}
+		consentURL, err := url.Parse(loc)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		req, err := http.NewRequest("GET", consentURL.String(), nil)
+		if err != nil {
+			t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+		}
+		w := httptest.NewRecorder()
+		handleConsentFunc(f.srv, f.srv.ConsentTemplate).ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusOK, w.Code)
+		}
+		body := w.Body.String()
+		for _, want := range []string{"Example App", "View your email address"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("case %d: want consent page to contain %q", i, want)
+			}
+		}
+		m := codeInput.FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("case %d: consent page has no code", i)
+		}
+
+		form := url.Values{"code": []string{m[1]}}
+		if tt.approve {
+			form.Set("approve", "true")
+		} else {
+			form.Set("deny", "true")
+		}
+		req, err = http.NewRequest("POST", "http://server.example.com/consent", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w = httptest.NewRecorder()
+		handleConsentFunc(f.srv, f.srv.ConsentTemplate).ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Fatalf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusFound, w.Code)
+		}
+		if got := w.Header().Get("Location"); !strings.HasPrefix(got, tt.wantLocationPrefix) {
+			t.Errorf("case %d: want Location starting with %q, got %q", i, tt.wantLocationPrefix, got)
+		}
+
+		// Codes can't be reused.
+		w = httptest.NewRecorder()
+		handleConsentFunc(f.srv, f.srv.ConsentTemplate).ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, http.StatusBadRequest, w.Code)
+		}
+
+		// Once given, the user isn't asked again.
+		_, err = f.srv.ConsentRepo.Get(testUserID1, testClientID)
+		if gotConsent := err == nil; gotConsent != tt.wantConsent {
+			t.Errorf("case %d: want consent=%t, got %t", i, tt.wantConsent, gotConsent)
+		}
+		if tt.wantConsent {
+			if got := authorize("none"); !strings.HasPrefix(got, "http://client.example.com/callback?code=") {
+				t.Errorf("case %d: want code redirect, got %q", i, got)
+			}
+
+			// Unless the client asks for them to be prompted again, and
+			// then only once.
+			loc := authorize("consent")
+			if !strings.HasPrefix(loc, consentPrefix) {
+				t.Fatalf("case %d: want Location starting with %q, got %q", i, consentPrefix, loc)
+			}
+			req, err := http.NewRequest("GET", loc, nil)
+			if err != nil {
+				t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+			}
+			w := httptest.NewRecorder()
+			handleConsentFunc(f.srv, f.srv.ConsentTemplate).ServeHTTP(w, req)
+			m := codeInput.FindStringSubmatch(w.Body.String())
+			if m == nil {
+				t.Fatalf("case %d: consent page has no code", i)
+			}
+			form := url.Values{"code": []string{m[1]}, "approve": []string{"true"}}
+			req, err = http.NewRequest("POST", "http://server.example.com/consent", strings.NewReader(form.Encode()))
+			if err != nil {
+				t.Fatalf("case %d: unable to form HTTP request: %v", i, err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w = httptest.NewRecorder()
+			handleConsentFunc(f.srv, f.srv.ConsentTemplate).ServeHTTP(w, req)
+			if got := w.Header().Get("Location"); !strings.HasPrefix(got, "http://client.example.com/callback?code=") {
+				t.Errorf("case %d: want code redirect after prompt=consent, got %q", i, got)
+			}
+		}
+	}
+}
+
+func TestHandleFormPostFunc(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	ident := oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1}
+	key, err := f.srv.NewSession(session.AuthRequest{
+		ConnectorID:  testConnectorID1,
+		ClientID:     testClientID,
+		ClientState:  "foo",
+		RedirectURL:  testRedirectURL,
+		Nonce:        "nonce",
+		Scope:        []string{"openid"},
+		ResponseType: "code id_token",
+		ResponseMode: "form_post",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loc, err := f.srv.Login(ident, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	formPostPrefix := "http://server.example.com/form-post?code="
+	if !strings.HasPrefix(loc, formPostPrefix) {
+		t.Fatalf("want Location starting with %q, got %q", formPostPrefix, loc)
+	}
+
+	req, err := http.NewRequest("GET", loc, nil)
+	if err != nil {
+		t.Fatalf("unable to form HTTP request: %v", err)
+	}
+	w := httptest.NewRecorder()
+	handleFormPostFunc(f.srv, f.srv.FormPostTemplate).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HTTP code mismatch: want=%d got=%d", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("want Cache-Control %q, got %q", "no-store", got)
+	}
+	body := w.Body.String()
+	if want := `action="http://client.example.com/callback"`; !strings.Contains(body, want) {
+		t.Errorf("want form post page to contain %q", want)
+	}
+	for _, name := range []string{"code", "id_token", "state"} {
+		input := regexp.MustCompile(`name="` + name + `" value="([^"]+)"`)
+		if !input.MatchString(body) {
+			t.Errorf("want form post page to have a %s input", name)
+		}
+	}
+
+	// The response can't be posted again.
+	w = httptest.NewRecorder()
+	handleFormPostFunc(f.srv, f.srv.FormPostTemplate).ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("HTTP code mismatch: want=%d got=%d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestHandleFormPostFuncLoginError(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	key, err := f.srv.NewSession(session.AuthRequest{
+		ConnectorID:  testConnectorID1,
+		ClientID:     testClientID,
+		ClientState:  "foo",
+		RedirectURL:  testRedirectURL,
+		Scope:        []string{"openid"},
+		ResponseType: "code id_token",
+		ResponseMode: "form_post",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A connector which failed to log the user in has the error posted to
+	// the client, rather than passed in the redirect URI's query.
+	loc, err := f.srv.LoginErrorURL(key, &oauth2.Error{Type: errorAccessDenied, Description: "login failed"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	formPostPrefix := "http://server.example.com/form-post?code="
+	if !strings.HasPrefix(loc, formPostPrefix) {
+		t.Fatalf("want Location starting with %q, got %q", formPostPrefix, loc)
+	}
+
+	req, err := http.NewRequest("GET", loc, nil)
+	if err != nil {
+		t.Fatalf("unable to form HTTP request: %v", err)
+	}
+	w := httptest.NewRecorder()
+	handleFormPostFunc(f.srv, f.srv.FormPostTemplate).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HTTP code mismatch: want=%d got=%d", http.StatusOK, w.Code)
+	}
+	body := w.Body.String()
+	if want := `action="http://client.example.com/callback"`; !strings.Contains(body, want) {
+		t.Errorf("want form post page to contain %q", want)
+	}
+	for name, value := range map[string]string{"error": "access_denied", "error_description": "login failed", "state": "foo"} {
+		if want := `name="` + name + `" value="` + value + `"`; !strings.Contains(body, want) {
+			t.Errorf("want form post page to contain %q", want)
+		}
+	}
+	for _, name := range []string{"code", "id_token"} {
+		if strings.Contains(body, `name="`+name+`"`) {
+			t.Errorf("unexpected %s input in form post page", name)
+		}
+	}
+}
+
+// authErrorResponse returns the response mode an error response to an
+// authorization request was passed to the client in, along with its
+// parameters. Redirects to dex's form post page are followed.
+func authErrorResponse(t *testing.T, f *testFixtures, w *httptest.ResponseRecorder) (string, url.Values) {
+	if w.Code == http.StatusFound {
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("invalid Location: %v", err)
+		}
+		switch {
+		case loc.Host == testIssuerURL.Host && loc.Path == httpPathFormPost:
+			req, err := http.NewRequest("GET", loc.String(), nil)
+			if err != nil {
+				t.Fatalf("unable to form HTTP request: %v", err)
+			}
+			w = httptest.NewRecorder()
+			handleFormPostFunc(f.srv, f.srv.FormPostTemplate).ServeHTTP(w, req)
+		case loc.Host != testRedirectURL.Host:
+			t.Fatalf("want redirect to the client, got %s", loc)
+		case loc.Fragment != "":
+			params, err := url.ParseQuery(loc.Fragment)
+			if err != nil {
+				t.Fatalf("invalid fragment: %v", err)
+			}
+			return responseModeFragment, params
+		default:
+			return responseModeQuery, loc.Query()
+		}
+	}
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("HTTP code mismatch: want=%d got=%d", http.StatusOK, w.Code)
+	}
+	body := w.Body.String()
+	if want := `action="` + testRedirectURL.String() + `"`; !strings.Contains(body, want) {
+		t.Fatalf("want form post page to contain %q, got %s", want, body)
+	}
+	params := url.Values{}
+	for _, m := range regexp.MustCompile(`name="([^"]+)" value="([^"]*)"`).FindAllStringSubmatch(body, -1) {
+		params.Set(m[1], m[2])
+	}
+	return responseModeFormPost, params
+}
+
+func TestAuthErrorResponseModes(t *testing.T) {
+	idpcs := []connector.Connector{
+		&fakeConnector{loginURL: "http://fake.example.com"},
+	}
+	clients := []client.Client{
+		client.Client{
+			Credentials: testClientCredentials,
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{testRedirectURL},
+			},
+		},
+	}
+
+	authorize := func(f *testFixtures, responseMode string, q url.Values, ssoID string) *httptest.ResponseRecorder {
+		q.Set("response_type", "code")
+		q.Set("response_mode", responseMode)
+		q.Set("client_id", testClientID)
+		q.Set("scope", "openid")
+		q.Set("state", "foo")
+		req, err := http.NewRequest("GET", "http://server.example.com/auth?"+q.Encode(), nil)
+		if err != nil {
+			t.Fatalf("unable to form HTTP request: %v", err)
+		}
+		if ssoID != "" {
+			req.AddCookie(&http.Cookie{Name: cookieSSOSession, Value: ssoID})
+		}
+		w := httptest.NewRecorder()
+		handleAuthFunc(f.srv, testIssuerURL, idpcs, nil, f.srv.FormPostTemplate, true).ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name string
+		// respond returns the response which passes the error to the
+		// client.
+		respond   func(f *testFixtures, responseMode string) *httptest.ResponseRecorder
+		wantError string
+	}{
+		{
+			name: "no SSO session",
+			respond: func(f *testFixtures, responseMode string) *httptest.ResponseRecorder {
+				return authorize(f, responseMode, url.Values{"prompt": {"none"}}, "")
+			},
+			wantError: errorLoginRequired,
+		},
+		{
+			name: "client not approved",
+			respond: func(f *testFixtures, responseMode string) *httptest.ResponseRecorder {
+				return authorize(f, responseMode, url.Values{"prompt": {"none"}}, "sso-1")
+			},
+			wantError: errorConsentRequired,
+		},
+		{
+			name: "registration",
+			respond: func(f *testFixtures, responseMode string) *httptest.ResponseRecorder {
+				return authorize(f, responseMode, url.Values{"prompt": {"none"}, "register": {"1"}}, "")
+			},
+			wantError: errorInteractionRequired,
+		},
+		{
+			name: "consent denied",
+			respond: func(f *testFixtures, responseMode string) *httptest.ResponseRecorder {
+				w := authorize(f, responseMode, url.Values{}, "sso-1")
+				consentURL, err := url.Parse(w.Header().Get("Location"))
+				if err != nil {
+					t.Fatalf("invalid Location: %v", err)
+				}
+				form := url.Values{"code": {consentURL.Query().Get("code")}, "deny": {"true"}}
+				req, err := http.NewRequest("POST", "http://server.example.com/consent", strings.NewReader(form.Encode()))
+				if err != nil {
+					t.Fatalf("unable to form HTTP request: %v", err)
+				}
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w = httptest.NewRecorder()
+				handleConsentFunc(f.srv, f.srv.ConsentTemplate).ServeHTTP(w, req)
+				return w
+			},
+			wantError: errorAccessDenied,
+		},
+		{
+			name: "disabled user",
+			respond: func(f *testFixtures, responseMode string) *httptest.ResponseRecorder {
+				if err := f.srv.UserManager.Disable(testUserID1, true); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				key, err := f.srv.NewSession(session.AuthRequest{
+					ConnectorID:  testConnectorID1,
+					ClientID:     testClientID,
+					ClientState:  "foo",
+					RedirectURL:  testRedirectURL,
+					Scope:        []string{"openid"},
+					ResponseType: oauth2.ResponseTypeCode,
+					ResponseMode: responseMode,
+				})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				ru, err := f.srv.Login(oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1}, key)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				w := httptest.NewRecorder()
+				w.Header().Set("Location", ru)
+				w.WriteHeader(http.StatusFound)
+				return w
+			},
+			wantError: oauth2.ErrorAccessDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, responseMode := range []string{responseModeQuery, responseModeFragment, responseModeFormPost} {
+			f, err := makeTestFixturesWithOptions(testFixtureOptions{
+				clients: clientsToLoadableClients(clients),
+			})
+			if err != nil {
+				t.Fatalf("error making test fixtures: %v", err)
+			}
+			err = f.srv.SSOSessionRepo.Create(session.SSOSession{
+				ID:          "sso-1",
+				UserID:      testUserID1,
+				ConnectorID: testConnectorID1,
+				Identity:    oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1},
+				AuthTime:    time.Now(),
+				ExpiresAt:   time.Now().Add(time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			gotMode, params := authErrorResponse(t, f, tt.respond(f, responseMode))
+			if gotMode != responseMode {
+				t.Errorf("case %s, %s: error passed with response mode %s", tt.name, responseMode, gotMode)
+			}
+			if got := params.Get("error"); got != tt.wantError {
+				t.Errorf("case %s, %s: want error %q, got %q", tt.name, responseMode, tt.wantError, got)
+			}
+			if got := params.Get("state"); got != "foo" {
+				t.Errorf("case %s, %s: want state %q, got %q", tt.name, responseMode, "foo", got)
+			}
+		}
+	}
+}
+
+func TestHandleAuthFuncResponsesMultipleRedirectURLs(t *testing.T) {
+	idpcs := []connector.Connector{
+		&fakeConnector{loginURL: "http://fake.example.com"},
+	}
+
+	clients := []client.Client{
+		client.Client{
+			Credentials: oidc.ClientCredentials{
+				ID:     "foo.example.com",
+				Secret: base64.URLEncoding.EncodeToString([]byte("secrete")),
+			},
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{
+					url.URL{Scheme: "http", Host: "foo.example.com", Path: "/callback"},
+					url.URL{Scheme: "http", Host: "bar.example.com", Path: "/callback"},
+				},
+			},
+		},
+	}
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{
+		clients: clientsToLoadableClients(clients),
+	})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+
+	tests := []struct {
+		query        url.Values
+		wantCode     int
+		wantLocation string
+	}{
+		// provided redirect_uri matches client's first
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"redirect_uri":  []string{"http://foo.example.com/callback"},
+				"client_id":     []string{"foo.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://fake.example.com",
+		},
+
+		// provided redirect_uri matches client's second
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"redirect_uri":  []string{"http://bar.example.com/callback"},
+				"client_id":     []string{"foo.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+			},
+			wantCode:     http.StatusFound,
+			wantLocation: "http://fake.example.com",
+		},
+
+		// provided redirect_uri does not match either of client's
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"redirect_uri":  []string{"http://unrecognized.example.com/callback"},
+				"client_id":     []string{"foo.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+
+		// no redirect_uri provided
+		{
+			query: url.Values{
+				"response_type": []string{"code"},
+				"client_id":     []string{"foo.example.com"},
+				"connector_id":  []string{"fake"},
+				"scope":         []string{"openid"},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		hdlr := handleAuthFunc(f.srv, url.URL{}, idpcs, nil, f.srv.FormPostTemplate, true)
 		w := httptest.NewRecorder()
 		u := fmt.Sprintf("http://server.example.com?%s", tt.query.Encode())
 		req, err := http.NewRequest("GET", u, nil)
@@ -377,302 +1613,794 @@ func TestHandleAuthFuncResponsesMultipleRedirectURLs(t *testing.T) {
 
 		hdlr.ServeHTTP(w, req)
 		if tt.wantCode != w.Code {
-			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, tt.wantCode, w.Code)
-			t.Errorf("case %d: BODY: %v", i, w.Body.String())
-			t.Errorf("case %d: LOCO: %v", i, w.HeaderMap.Get("Location"))
+			t.Errorf("case %d: HTTP code mismatch: want=%d got=%d", i, tt.wantCode, w.Code)
+			t.Errorf("case %d: BODY: %v", i, w.Body.String())
+			t.Errorf("case %d: LOCO: %v", i, w.HeaderMap.Get("Location"))
+			continue
+		}
+
+		gotLocation := w.Header().Get("Location")
+		if tt.wantLocation != gotLocation {
+			t.Errorf("case %d: HTTP Location header mismatch: want=%s got=%s", i, tt.wantLocation, gotLocation)
+		}
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	f, err := makeCrossClientTestFixtures()
+	if err != nil {
+		t.Fatalf("couldn't make test fixtures: %v", err)
+	}
+	_, err = f.clientManager.New(client.Client{
+		Credentials: oidc.ClientCredentials{ID: "client_restricted"},
+		Metadata: oidc.ClientMetadata{
+			RedirectURIs: []url.URL{testRedirectURL},
+		},
+		AllowedScopes: []string{"email"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("couldn't create client: %v", err)
+	}
+
+	tests := []struct {
+		clientID string
+		scopes   []string
+		wantErr  bool
+	}{
+		{
+			// ERR: no openid scope
+			clientID: testClientID,
+			scopes:   []string{},
+			wantErr:  true,
+		},
+		{
+			// OK: minimum scopes
+			clientID: testClientID,
+			scopes:   []string{"openid"},
+			wantErr:  false,
+		},
+		{
+			// OK: offline_access
+			clientID: testClientID,
+			scopes:   []string{"openid", "offline_access"},
+			wantErr:  false,
+		},
+		{
+			// ERR: unknown scope
+			clientID: testClientID,
+			scopes:   []string{"openid", "wat"},
+			wantErr:  true,
+		},
+		{
+			// ERR: invalid cross client auth
+			clientID: testClientID,
+			scopes:   []string{"openid", scope.ScopeGoogleCrossClient + "client_a"},
+			wantErr:  true,
+		},
+		{
+			// OK: valid cross client auth (though perverse - a client
+			// requesting cross-client auth for itself)
+			clientID: "client_a",
+			scopes:   []string{"openid", scope.ScopeGoogleCrossClient + "client_a"},
+			wantErr:  false,
+		},
+		{
+
+			// OK: valid cross client auth
+			clientID: "client_a",
+			scopes:   []string{"openid", scope.ScopeGoogleCrossClient + "client_b"},
+			wantErr:  false,
+		},
+		{
+
+			// ERR: valid cross client auth...but duplicated scope.
+			clientID: "client_a",
+			scopes: []string{"openid",
+				scope.ScopeGoogleCrossClient + "client_b",
+				scope.ScopeGoogleCrossClient + "client_b",
+			},
+			wantErr: true,
+		},
+		{
+			// OK: valid cross client auth with >1 clients including itself
+			clientID: "client_a",
+			scopes: []string{
+				"openid",
+				scope.ScopeGoogleCrossClient + "client_a",
+				scope.ScopeGoogleCrossClient + "client_b",
+				scope.ScopeGoogleCrossClient + "client_c",
+			},
+			wantErr: false,
+		},
+		{
+			// ERR: valid cross client auth with >1 clients including itself...but no openid!
+			clientID: "client_a",
+			scopes: []string{
+				scope.ScopeGoogleCrossClient + "client_a",
+				scope.ScopeGoogleCrossClient + "client_b",
+				scope.ScopeGoogleCrossClient + "client_c",
+			},
+			wantErr: true,
+		},
+		{
+			// OK: scopes the client is restricted to
+			clientID: "client_restricted",
+			scopes:   []string{"openid", "email"},
+			wantErr:  false,
+		},
+		{
+			// ERR: scope the client is not allowed to request
+			clientID: "client_restricted",
+			scopes:   []string{"openid", "offline_access"},
+			wantErr:  true,
+		},
+		{
+			// ERR: unknown client
+			clientID: "XXX",
+			scopes:   []string{"openid"},
+			wantErr:  true,
+		},
+	}
+
+	for i, tt := range tests {
+		err := validateScopes(f.srv, tt.clientID, tt.scopes)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("case %d: want non-nil err", i)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("case %d: unexpected err: %v", i, err)
+		}
+	}
+}
+
+func TestHandleTokenFunc(t *testing.T) {
+	fx, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("could not run test fixtures: %v", err)
+	}
+
+	tests := []struct {
+		query    url.Values
+		user     string
+		passwd   string
+		wantCode int
+	}{
+		// bad grant_type
+		{
+			query: url.Values{
+				"grant_type": []string{"invalid!"},
+				"code":       []string{"someCode"},
+			},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusBadRequest,
+		},
+
+		// authorization_code needs code param
+		{
+			query: url.Values{
+				"grant_type": []string{"authorization_code"},
+			},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusBadRequest,
+		},
+
+		// empty code
+		{
+			query: url.Values{
+				"grant_type": []string{"authorization_code"},
+				"code":       []string{""},
+			},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusBadRequest,
+		},
+
+		// valid code but bad creds
+		{
+			query: url.Values{
+				"grant_type": []string{"authorization_code"},
+				"code":       []string{"code-2"},
+			},
+			user:     "XASD",
+			passwd:   base64.URLEncoding.EncodeToString([]byte("failSecrete")),
+			wantCode: http.StatusUnauthorized,
+		},
+
+		// bad code
+		{
+			query: url.Values{
+				"grant_type": []string{"authorization_code"},
+				"code":       []string{"asdasd"},
+			},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusBadRequest,
+		},
+
+		// OK testcase
+		{
+			query: url.Values{
+				"grant_type": []string{"authorization_code"},
+				"code":       []string{"code-2"},
+			},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for i, tt := range tests {
+		hdlr := handleTokenFunc(fx.srv)
+		w := httptest.NewRecorder()
+
+		req, err := http.NewRequest("POST", "http://example.com/token", strings.NewReader(tt.query.Encode()))
+		if err != nil {
+			t.Errorf("unable to create HTTP request, error=%v", err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(tt.user, tt.passwd)
+
+		// need to create session in order to exchange the code (generated by the NewSessionKey func) for token
+		setSession := func() error {
+			sid, err := fx.sessionManager.NewSession(session.AuthRequest{
+				ConnectorID: "local",
+				ClientID:    testClientID,
+				RedirectURL: testRedirectURL,
+				Register:    true,
+				Scope:       []string{"openid"},
+			})
+			if err != nil {
+				return fmt.Errorf("case %d: cannot create session, error=%v", i, err)
+			}
+
+			_, err = fx.sessionManager.AttachRemoteIdentity(sid, oidc.Identity{})
+			if err != nil {
+				return fmt.Errorf("case %d: cannot attach remoteID, error=%v", i, err)
+			}
+
+			_, err = fx.sessionManager.AttachUser(sid, "ID-Verified")
+			if err != nil {
+				return fmt.Errorf("case %d: cannot attach user, error=%v", i, err)
+			}
+
+			_, err = fx.sessionManager.NewSessionKey(sid)
+			if err != nil {
+				return fmt.Errorf("case %d: cannot create session code, error=%v", i, err)
+			}
+
+			return nil
+
+		}
+
+		if err := setSession(); err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		hdlr.ServeHTTP(w, req)
+		if tt.wantCode != w.Code {
+			t.Errorf("case %d: expected HTTP %d, got %v", i, tt.wantCode, w.Code)
+		}
+
+	}
+
+}
+
+func TestHandleTokenFuncMethodNotAllowed(t *testing.T) {
+	for _, m := range []string{"GET", "PUT", "DELETE"} {
+		hdlr := handleTokenFunc(nil)
+		req, err := http.NewRequest(m, "http://example.com", nil)
+		if err != nil {
+			t.Errorf("case %s: unable to create HTTP request: %v", m, err)
 			continue
 		}
 
-		gotLocation := w.Header().Get("Location")
-		if tt.wantLocation != gotLocation {
-			t.Errorf("case %d: HTTP Location header mismatch: want=%s got=%s", i, tt.wantLocation, gotLocation)
+		w := httptest.NewRecorder()
+		hdlr.ServeHTTP(w, req)
+
+		want := http.StatusMethodNotAllowed
+		got := w.Code
+		if want != got {
+			t.Errorf("case %s: expected HTTP %d, got %d", m, want, got)
 		}
 	}
 }
 
-func TestValidateScopes(t *testing.T) {
-	f, err := makeCrossClientTestFixtures()
+func TestHandleTokenFuncState(t *testing.T) {
+	want := "test-state"
+	v := url.Values{
+		"state": {want},
+	}
+	hdlr := handleTokenFunc(nil)
+	req, err := http.NewRequest("POST", "http://example.com", strings.NewReader(v.Encode()))
 	if err != nil {
-		t.Fatalf("couldn't make test fixtures: %v", err)
+		t.Errorf("unable to create HTTP request, error=%v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	hdlr.ServeHTTP(w, req)
+
+	// should have errored and returned state in the response body
+	var resp map[string]string
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Errorf("error unmarshaling response, error=%v", err)
+	}
+
+	got := resp["state"]
+	if want != got {
+		t.Errorf("unexpected state, want=%v, got=%v", want, got)
+	}
+}
+
+func TestHandleIntrospectFunc(t *testing.T) {
+	fx, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("could not run test fixtures: %v", err)
+	}
+	refreshToken, err := fx.srv.RefreshTokenRepo.Create(testUserID1, testClientID, testConnectorID1, []string{"openid", "offline_access"}, nil)
+	if err != nil {
+		t.Fatalf("could not create refresh token: %v", err)
 	}
 
 	tests := []struct {
-		clientID string
-		scopes   []string
-		wantErr  bool
+		query      url.Values
+		user       string
+		passwd     string
+		wantCode   int
+		wantActive bool
 	}{
+		// active token
 		{
-			// ERR: no openid scope
-			clientID: "XXX",
-			scopes:   []string{},
-			wantErr:  true,
+			query:      url.Values{"token": []string{refreshToken}},
+			user:       testClientID,
+			passwd:     base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode:   http.StatusOK,
+			wantActive: true,
 		},
+		// unrecognized token
 		{
-			// OK: minimum scopes
-			clientID: "XXX",
-			scopes:   []string{"openid"},
-			wantErr:  false,
+			query:    url.Values{"token": []string{"asdasd"}},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusOK,
 		},
+		// missing token
 		{
-			// OK: offline_access
-			clientID: "XXX",
-			scopes:   []string{"openid", "offline_access"},
-			wantErr:  false,
+			query:    url.Values{},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusBadRequest,
 		},
+		// bad creds
 		{
-			// ERR: unknown scope
-			clientID: "XXX",
-			scopes:   []string{"openid", "wat"},
-			wantErr:  true,
+			query:    url.Values{"token": []string{refreshToken}},
+			user:     "XASD",
+			passwd:   base64.URLEncoding.EncodeToString([]byte("failSecrete")),
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for i, tt := range tests {
+		hdlr := handleIntrospectFunc(fx.srv)
+		w := httptest.NewRecorder()
+
+		req, err := http.NewRequest("POST", "http://example.com/introspect", strings.NewReader(tt.query.Encode()))
+		if err != nil {
+			t.Errorf("unable to create HTTP request, error=%v", err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(tt.user, tt.passwd)
+
+		hdlr.ServeHTTP(w, req)
+		if tt.wantCode != w.Code {
+			t.Errorf("case %d: expected HTTP %d, got %v", i, tt.wantCode, w.Code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var resp Introspection
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("case %d: error unmarshaling response: %v", i, err)
+			continue
+		}
+		if resp.Active != tt.wantActive {
+			t.Errorf("case %d: expected active=%t, got %t", i, tt.wantActive, resp.Active)
+		}
+	}
+}
+
+func TestHandleIntrospectFuncMethodNotAllowed(t *testing.T) {
+	for _, m := range []string{"GET", "PUT", "DELETE"} {
+		hdlr := handleIntrospectFunc(nil)
+		req, err := http.NewRequest(m, "http://example.com", nil)
+		if err != nil {
+			t.Errorf("case %s: unable to create HTTP request: %v", m, err)
+			continue
+		}
+
+		w := httptest.NewRecorder()
+		hdlr.ServeHTTP(w, req)
+
+		want := http.StatusMethodNotAllowed
+		got := w.Code
+		if want != got {
+			t.Errorf("case %s: expected HTTP %d, got %d", m, want, got)
+		}
+	}
+}
+
+func TestHandleRevokeFunc(t *testing.T) {
+	tests := []struct {
+		query    url.Values
+		user     string
+		passwd   string
+		wantCode int
+	}{
+		// refresh token
+		{
+			query:    url.Values{"token": []string{getRefreshTokenEncoded("1", "refresh-1")}},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusOK,
 		},
+		// unrecognized token
 		{
-			// ERR: invalid cross client auth
-			clientID: "XXX",
-			scopes:   []string{"openid", scope.ScopeGoogleCrossClient + "client_a"},
-			wantErr:  true,
+			query:    url.Values{"token": []string{"asdasd"}},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusOK,
+		},
+		// missing token
+		{
+			query:    url.Values{},
+			user:     testClientID,
+			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			wantCode: http.StatusBadRequest,
+		},
+		// bad creds
+		{
+			query:    url.Values{"token": []string{getRefreshTokenEncoded("1", "refresh-1")}},
+			user:     "XASD",
+			passwd:   base64.URLEncoding.EncodeToString([]byte("failSecrete")),
+			wantCode: http.StatusUnauthorized,
 		},
+	}
+
+	for i, tt := range tests {
+		fx, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("could not run test fixtures: %v", err)
+		}
+		// NOTE: This assumes the first refresh token is "1/refresh-1".
+		if _, err := fx.srv.RefreshTokenRepo.Create(testUserID1, testClientID, testConnectorID1, []string{"openid", "offline_access"}, nil); err != nil {
+			t.Fatalf("could not create refresh token: %v", err)
+		}
+
+		hdlr := handleRevokeFunc(fx.srv)
+		w := httptest.NewRecorder()
+
+		req, err := http.NewRequest("POST", "http://example.com/revoke", strings.NewReader(tt.query.Encode()))
+		if err != nil {
+			t.Errorf("unable to create HTTP request, error=%v", err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(tt.user, tt.passwd)
+
+		hdlr.ServeHTTP(w, req)
+		if tt.wantCode != w.Code {
+			t.Errorf("case %d: expected HTTP %d, got %v", i, tt.wantCode, w.Code)
+		}
+	}
+}
+
+func TestHandleUserInfoFunc(t *testing.T) {
+	fx, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("could not run test fixtures: %v", err)
+	}
+	claims := oidc.NewClaims(testIssuerURL.String(), testUserID1, testClientID, time.Now(), time.Now().Add(time.Hour))
+	jwt, err := jose.NewSignedJWT(claims, testPrivKey.Signer())
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+
+	tests := []struct {
+		method        string
+		authorization string
+		wantCode      int
+		wantSub       string
+	}{
 		{
-			// OK: valid cross client auth (though perverse - a client
-			// requesting cross-client auth for itself)
-			clientID: "client_a",
-			scopes:   []string{"openid", scope.ScopeGoogleCrossClient + "client_a"},
-			wantErr:  false,
+			method:        "GET",
+			authorization: "Bearer " + jwt.Encode(),
+			wantCode:      http.StatusOK,
+			wantSub:       testUserID1,
 		},
 		{
-
-			// OK: valid cross client auth
-			clientID: "client_a",
-			scopes:   []string{"openid", scope.ScopeGoogleCrossClient + "client_b"},
-			wantErr:  false,
+			method:        "POST",
+			authorization: "Bearer " + jwt.Encode(),
+			wantCode:      http.StatusOK,
+			wantSub:       testUserID1,
 		},
+		// invalid token
 		{
-
-			// ERR: valid cross client auth...but duplicated scope.
-			clientID: "client_a",
-			scopes: []string{"openid",
-				scope.ScopeGoogleCrossClient + "client_b",
-				scope.ScopeGoogleCrossClient + "client_b",
-			},
-			wantErr: true,
+			method:        "GET",
+			authorization: "Bearer asdasd",
+			wantCode:      http.StatusUnauthorized,
 		},
+		// missing token
 		{
-			// OK: valid cross client auth with >1 clients including itself
-			clientID: "client_a",
-			scopes: []string{
-				"openid",
-				scope.ScopeGoogleCrossClient + "client_a",
-				scope.ScopeGoogleCrossClient + "client_b",
-				scope.ScopeGoogleCrossClient + "client_c",
-			},
-			wantErr: false,
+			method:   "GET",
+			wantCode: http.StatusUnauthorized,
 		},
 		{
-			// ERR: valid cross client auth with >1 clients including itself...but no openid!
-			clientID: "client_a",
-			scopes: []string{
-				scope.ScopeGoogleCrossClient + "client_a",
-				scope.ScopeGoogleCrossClient + "client_b",
-				scope.ScopeGoogleCrossClient + "client_c",
-			},
-			wantErr: true,
+			method:        "DELETE",
+			authorization: "Bearer " + jwt.Encode(),
+			wantCode:      http.StatusMethodNotAllowed,
 		},
 	}
 
 	for i, tt := range tests {
-		err := validateScopes(f.srv, tt.clientID, tt.scopes)
-		if tt.wantErr {
-			if err == nil {
-				t.Errorf("case %d: want non-nil err", i)
-			}
+		hdlr := handleUserInfoFunc(fx.srv)
+		w := httptest.NewRecorder()
+
+		req, err := http.NewRequest(tt.method, "http://example.com/userinfo", nil)
+		if err != nil {
+			t.Errorf("unable to create HTTP request, error=%v", err)
 			continue
 		}
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
 
-		if err != nil {
-			t.Errorf("case %d: unexpected err: %v", i, err)
+		hdlr.ServeHTTP(w, req)
+		if tt.wantCode != w.Code {
+			t.Errorf("case %d: expected HTTP %d, got %v", i, tt.wantCode, w.Code)
+			continue
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("case %d: expected WWW-Authenticate header", i)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var resp map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("case %d: error unmarshaling response: %v", i, err)
+			continue
+		}
+		if resp["sub"] != tt.wantSub {
+			t.Errorf("case %d: expected sub %q, got %v", i, tt.wantSub, resp["sub"])
 		}
 	}
 }
 
-func TestHandleTokenFunc(t *testing.T) {
+func TestHandleDeviceFlow(t *testing.T) {
 	fx, err := makeTestFixtures()
 	if err != nil {
 		t.Fatalf("could not run test fixtures: %v", err)
 	}
+	verificationURL := url.URL{Scheme: "http", Host: "server.example.com", Path: "/device"}
+
+	// Request a device code.
+	form := url.Values{
+		"client_id": []string{testPublicClientID},
+		"scope":     []string{"openid"},
+	}
+	req, err := http.NewRequest("POST", "http://example.com/device/code", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("unable to create HTTP request, error=%v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleDeviceCodeFunc(fx.srv, verificationURL).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected HTTP %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp deviceAuthorizationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error unmarshaling response: %v", err)
+	}
+	if resp.VerificationURI != verificationURL.String() {
+		t.Errorf("expected verification_uri %q, got %q", verificationURL.String(), resp.VerificationURI)
+	}
+	if resp.Interval != int64(device.DefaultPollInterval.Seconds()) {
+		t.Errorf("expected interval %v, got %d", device.DefaultPollInterval, resp.Interval)
+	}
 
 	tests := []struct {
-		query    url.Values
-		user     string
-		passwd   string
+		method string
+		query  url.Values
+		// csrf posts the CSRF token of the last rendered confirmation form,
+		// along with its cookie.
+		csrf     bool
 		wantCode int
+		wantBody []string
 	}{
-		// bad grant_type
+		// no user code, render form
 		{
-			query: url.Values{
-				"grant_type": []string{"invalid!"},
-				"code":       []string{"someCode"},
-			},
-			user:     testClientID,
-			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
-			wantCode: http.StatusBadRequest,
+			method:   "GET",
+			query:    url.Values{},
+			wantCode: http.StatusOK,
 		},
-
-		// authorization_code needs code param
+		// unrecognized user code
 		{
-			query: url.Values{
-				"grant_type": []string{"authorization_code"},
-			},
-			user:     testClientID,
-			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
+			method:   "GET",
+			query:    url.Values{"user_code": []string{"XXXX-XXXX"}},
 			wantCode: http.StatusBadRequest,
 		},
-
-		// empty code
+		// valid user code, render the client, its scopes and connectors
 		{
+			method:   "GET",
+			query:    url.Values{"user_code": []string{strings.ToLower(resp.UserCode)}},
+			wantCode: http.StatusOK,
+			wantBody: []string{testPublicClientID, "Verify your identity", `value="` + testConnectorLocalID + `"`},
+		},
+		// the form posting the user code renders the same page
+		{
+			method:   "POST",
+			query:    url.Values{"user_code": []string{resp.UserCode}},
+			wantCode: http.StatusOK,
+			wantBody: []string{testPublicClientID},
+		},
+		// a link to the page can't start logging in
+		{
+			method: "GET",
 			query: url.Values{
-				"grant_type": []string{"authorization_code"},
-				"code":       []string{""},
+				"user_code":    []string{resp.UserCode},
+				"connector_id": []string{testConnectorLocalID},
 			},
-			user:     testClientID,
-			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
-			wantCode: http.StatusBadRequest,
+			wantCode: http.StatusOK,
+			wantBody: []string{testPublicClientID},
 		},
-
-		// valid code but bad creds
+		// a form posted from another site, without the CSRF token, can't
+		// start logging in either
 		{
+			method: "POST",
 			query: url.Values{
-				"grant_type": []string{"authorization_code"},
-				"code":       []string{"code-2"},
+				"user_code":    []string{resp.UserCode},
+				"connector_id": []string{testConnectorLocalID},
 			},
-			user:     "XASD",
-			passwd:   base64.URLEncoding.EncodeToString([]byte("failSecrete")),
-			wantCode: http.StatusUnauthorized,
+			wantCode: http.StatusOK,
+			wantBody: []string{testPublicClientID},
 		},
-
-		// bad code
+		// confirmed user code and connector, redirect to connector
 		{
+			method: "POST",
 			query: url.Values{
-				"grant_type": []string{"authorization_code"},
-				"code":       []string{"asdasd"},
+				"user_code":    []string{resp.UserCode},
+				"connector_id": []string{testConnectorLocalID},
 			},
-			user:     testClientID,
-			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
-			wantCode: http.StatusBadRequest,
+			csrf:     true,
+			wantCode: http.StatusFound,
 		},
-
-		// OK testcase
+		// user code has already been used
 		{
+			method: "POST",
 			query: url.Values{
-				"grant_type": []string{"authorization_code"},
-				"code":       []string{"code-2"},
+				"user_code":    []string{resp.UserCode},
+				"connector_id": []string{testConnectorLocalID},
 			},
-			user:     testClientID,
-			passwd:   base64.URLEncoding.EncodeToString([]byte("secret")),
-			wantCode: http.StatusOK,
+			csrf:     true,
+			wantCode: http.StatusBadRequest,
 		},
 	}
 
+	var csrfCookie *http.Cookie
 	for i, tt := range tests {
-		hdlr := handleTokenFunc(fx.srv)
-		w := httptest.NewRecorder()
-
-		req, err := http.NewRequest("POST", "http://example.com/token", strings.NewReader(tt.query.Encode()))
+		var req *http.Request
+		if tt.method == "POST" {
+			if tt.csrf {
+				tt.query.Set("csrf_token", csrfCookie.Value)
+			}
+			req, err = http.NewRequest("POST", "http://example.com/device", strings.NewReader(tt.query.Encode()))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		} else {
+			req, err = http.NewRequest("GET", "http://example.com/device?"+tt.query.Encode(), nil)
+		}
 		if err != nil {
-			t.Errorf("unable to create HTTP request, error=%v", err)
-			continue
+			t.Fatalf("case %d: unable to create HTTP request, error=%v", i, err)
 		}
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		req.SetBasicAuth(tt.user, tt.passwd)
-
-		// need to create session in order to exchange the code (generated by the NewSessionKey func) for token
-		setSession := func() error {
-			sid, err := fx.sessionManager.NewSession("local", testClientID, "", testRedirectURL, "", true, []string{"openid"})
-			if err != nil {
-				return fmt.Errorf("case %d: cannot create session, error=%v", i, err)
+		if tt.csrf {
+			req.AddCookie(csrfCookie)
+		}
+		w := httptest.NewRecorder()
+		handleDeviceFunc(fx.srv, fx.srv.DeviceTemplate).ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("case %d: expected HTTP %d, got %d", i, tt.wantCode, w.Code)
+		}
+		for _, want := range tt.wantBody {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("case %d: expected body to contain %q", i, want)
 			}
-
-			_, err = fx.sessionManager.AttachRemoteIdentity(sid, oidc.Identity{})
-			if err != nil {
-				return fmt.Errorf("case %d: cannot attach remoteID, error=%v", i, err)
+		}
+		for _, c := range w.Result().Cookies() {
+			if c.Name == cookieDeviceCSRF && c.Value != "" {
+				csrfCookie = c
+				if !strings.Contains(w.Body.String(), `value="`+c.Value+`"`) {
+					t.Errorf("case %d: expected the form to post the CSRF token of the cookie", i)
+				}
 			}
+		}
 
-			_, err = fx.sessionManager.AttachUser(sid, "ID-Verified")
+		// Rendering the verification page never binds the grant.
+		if tt.wantCode == http.StatusOK {
+			g, err := fx.srv.DeviceGrantRepo.GetByDeviceCode(resp.DeviceCode)
 			if err != nil {
-				return fmt.Errorf("case %d: cannot attach user, error=%v", i, err)
+				t.Fatalf("case %d: unexpected error: %v", i, err)
 			}
-
-			_, err = fx.sessionManager.NewSessionKey(sid)
-			if err != nil {
-				return fmt.Errorf("case %d: cannot create session code, error=%v", i, err)
+			if g.SessionID != "" {
+				t.Errorf("case %d: device grant bound to a session without confirmation", i)
 			}
-
-			return nil
-
-		}
-
-		if err := setSession(); err != nil {
-			t.Errorf("case %d: %v", i, err)
-			continue
-		}
-
-		hdlr.ServeHTTP(w, req)
-		if tt.wantCode != w.Code {
-			t.Errorf("case %d: expected HTTP %d, got %v", i, tt.wantCode, w.Code)
 		}
-
 	}
 
-}
-
-func TestHandleTokenFuncMethodNotAllowed(t *testing.T) {
-	for _, m := range []string{"GET", "PUT", "DELETE"} {
-		hdlr := handleTokenFunc(nil)
-		req, err := http.NewRequest(m, "http://example.com", nil)
-		if err != nil {
-			t.Errorf("case %s: unable to create HTTP request: %v", m, err)
-			continue
-		}
-
-		w := httptest.NewRecorder()
-		hdlr.ServeHTTP(w, req)
-
-		want := http.StatusMethodNotAllowed
-		got := w.Code
-		if want != got {
-			t.Errorf("case %s: expected HTTP %d, got %d", m, want, got)
-		}
+	// Log in as a known user, then follow the redirect to the device callback.
+	g, err := fx.srv.DeviceGrantRepo.GetByDeviceCode(resp.DeviceCode)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-}
-
-func TestHandleTokenFuncState(t *testing.T) {
-	want := "test-state"
-	v := url.Values{
-		"state": {want},
+	if g.SessionID == "" {
+		t.Fatalf("expected session to be bound to device grant")
 	}
-	hdlr := handleTokenFunc(nil)
-	req, err := http.NewRequest("POST", "http://example.com", strings.NewReader(v.Encode()))
+	if _, err = fx.sessionManager.AttachRemoteIdentity(g.SessionID, oidc.Identity{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = fx.sessionManager.AttachUser(g.SessionID, testUserID1)
 	if err != nil {
-		t.Errorf("unable to create HTTP request, error=%v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	w := httptest.NewRecorder()
-	hdlr.ServeHTTP(w, req)
-
-	// should have errored and returned state in the response body
-	var resp map[string]string
-	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
-		t.Errorf("error unmarshaling response, error=%v", err)
+	key, err := fx.sessionManager.NewSessionKey(g.SessionID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	q := url.Values{"code": []string{key}, "state": []string{g.UserCode}}
+	req, err = http.NewRequest("GET", "http://example.com/device/callback?"+q.Encode(), nil)
+	if err != nil {
+		t.Fatalf("unable to create HTTP request, error=%v", err)
+	}
+	w = httptest.NewRecorder()
+	handleDeviceCallbackFunc(fx.srv, fx.srv.DeviceTemplate).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected HTTP %d, got %d", http.StatusOK, w.Code)
 	}
 
-	got := resp["state"]
-	if want != got {
-		t.Errorf("unexpected state, want=%v, got=%v", want, got)
+	// The device can now exchange the device code for a token.
+	form = url.Values{
+		"grant_type":  []string{device.GrantTypeDeviceCode},
+		"client_id":   []string{testPublicClientID},
+		"device_code": []string{resp.DeviceCode},
+	}
+	req, err = http.NewRequest("POST", "http://example.com/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("unable to create HTTP request, error=%v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handleTokenFunc(fx.srv).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected HTTP %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
 	}
 }
 
 func TestHandleDiscoveryFuncMethodNotAllowed(t *testing.T) {
 	for _, m := range []string{"POST", "PUT", "DELETE"} {
-		hdlr := handleDiscoveryFunc(oidc.ProviderConfig{})
+		hdlr := handleDiscoveryFunc(func() ProviderConfig { return ProviderConfig{} })
 		req, err := http.NewRequest(m, "http://example.com", nil)
 		if err != nil {
 			t.Errorf("case %s: unable to create HTTP request: %v", m, err)
@@ -716,7 +2444,7 @@ func TestHandleDiscoveryFunc(t *testing.T) {
 	}
 
 	w := httptest.NewRecorder()
-	hdlr := handleDiscoveryFunc(cfg)
+	hdlr := handleDiscoveryFunc(func() ProviderConfig { return ProviderConfig{ProviderConfig: cfg} })
 	hdlr.ServeHTTP(w, req)
 
 	if w.Code != http.StatusOK {
@@ -817,6 +2545,43 @@ func TestHandleKeysFunc(t *testing.T) {
 	}
 }
 
+func TestHandleKeysFuncAlgorithms(t *testing.T) {
+	var keys []*signing.PrivateKey
+	for _, alg := range signing.Algorithms {
+		k, err := signing.GeneratePrivateKey(alg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys = append(keys, k)
+	}
+	km := signing.NewPrivateKeyManager()
+	if err := km.Set(signing.NewPrivateKeySet(keys, signing.Algorithms, time.Now().Add(time.Minute))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://server.example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed creating HTTP request: err=%v", err)
+	}
+	w := httptest.NewRecorder()
+	handleKeysFunc(km, clockwork.NewRealClock()).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Incorrect status code: want=200 got=%d", w.Code)
+	}
+
+	var got signing.JWKSet
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed decoding JWK set: %v", err)
+	}
+	want := signing.JWKSet{}
+	for _, k := range keys {
+		want.Keys = append(want.Keys, k.JWK())
+	}
+	if diff := pretty.Compare(want, got); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+}
+
 func TestShouldReprompt(t *testing.T) {
 	tests := []struct {
 		c *http.Cookie
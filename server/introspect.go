@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/refresh"
+	"github.com/coreos/dex/scope"
+	"github.com/coreos/dex/user"
+)
+
+// Introspection is the response to a token introspection request.
+// See: https://tools.ietf.org/html/rfc7662#section-2.2
+type Introspection struct {
+	Active   bool     `json:"active"`
+	Subject  string   `json:"sub,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+	Audience []string `json:"aud,omitempty"`
+	Expiry   int64    `json:"exp,omitempty"`
+	Scope    string   `json:"scope,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+
+	// UserID is the dex ID of the user whose pairwise subject is the token's,
+	// only told to admin clients.
+	UserID string `json:"user_id,omitempty"`
+}
+
+var inactiveToken = &Introspection{Active: false}
+
+// Introspect reports whether the token is active. ID tokens, access tokens and
+// refresh tokens issued by this server can be introspected; JWTs and refresh
+// tokens are distinct formats, so no token type hint is required. Refresh tokens are only
+// reported as active to the client they were issued to.
+func (s *Server) Introspect(creds oidc.ClientCredentials, token string) (*Introspection, error) {
+	if err := s.authenticateClient(creds, false); err != nil {
+		return nil, err
+	}
+
+	cli, err := s.Client(creds.ID)
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", creds.ID, err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	var resp *Introspection
+	if _, err = jose.ParseJWT(token); err == nil {
+		resp, err = s.introspectJWT(token)
+	} else {
+		resp, err = s.introspectRefreshToken(creds.ID, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Only admin clients may resolve pairwise subjects back to users.
+	if resp.UserID != "" && (!cli.Admin || resp.UserID == resp.Subject) {
+		resp.UserID = ""
+	}
+	return resp, nil
+}
+
+func (s *Server) introspectJWT(token string) (*Introspection, error) {
+	_, claims, err := s.verifyToken(token)
+	if err != nil {
+		log.Infof("Introspected token is not valid: %v", err)
+		return inactiveToken, nil
+	}
+
+	sub, _, _ := claims.StringClaim("sub")
+	exp, _, _ := claims.TimeClaim("exp")
+	scp, _, _ := claims.StringClaim(claimScope)
+	aud := audienceClaim(claims)
+
+	clientID := tokenClientID(claims)
+
+	var userID string
+	if sub == clientID {
+		// Tokens issued using client credentials have the client as their
+		// subject.
+		if _, err := s.Client(sub); err != nil {
+			return inactiveToken, nil
+		}
+	} else {
+		userID, err = s.subjectUserID(clientID, sub)
+		switch err {
+		case nil:
+		case user.ErrorNotFound, client.ErrorNotFound:
+			return inactiveToken, nil
+		default:
+			log.Errorf("Failed to resolve subject %q: %v", sub, err)
+			return nil, oauth2.NewError(oauth2.ErrorServerError)
+		}
+
+		usr, err := s.UserRepo.Get(nil, userID)
+		switch err {
+		case nil:
+			if usr.Disabled {
+				return inactiveToken, nil
+			}
+		case user.ErrorNotFound:
+			return inactiveToken, nil
+		default:
+			log.Errorf("Failed to fetch user %q from repo: %v", userID, err)
+			return nil, oauth2.NewError(oauth2.ErrorServerError)
+		}
+	}
+
+	groups, _, _ := claims.StringsClaim("groups")
+	return &Introspection{
+		Active:   true,
+		Subject:  sub,
+		ClientID: clientID,
+		Audience: aud,
+		Expiry:   exp.Unix(),
+		Scope:    scp,
+		Groups:   groups,
+		UserID:   userID,
+	}, nil
+}
+
+func (s *Server) introspectRefreshToken(clientID, token string) (*Introspection, error) {
+	userID, connectorID, scopes, _, err := s.RefreshTokenRepo.Verify(clientID, token)
+	switch err {
+	case nil:
+	case refresh.ErrorInvalidToken, refresh.ErrorInvalidClientID:
+		return inactiveToken, nil
+	default:
+		log.Errorf("Failed to verify refresh token: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	usr, err := s.UserRepo.Get(nil, userID)
+	switch err {
+	case nil:
+		if usr.Disabled {
+			return inactiveToken, nil
+		}
+	case user.ErrorNotFound:
+		return inactiveToken, nil
+	default:
+		log.Errorf("Failed to fetch user %q from repo: %v", userID, err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	var groups []string
+	if scopes.HasScope(scope.ScopeGroups) {
+		if groups, err = s.userGroups(userID, connectorID); err != nil {
+			log.Errorf("failed to get groups for refresh token: %v", err)
+			return nil, oauth2.NewError(oauth2.ErrorServerError)
+		}
+	}
+
+	sub, err := s.subject(clientID, userID)
+	if err != nil {
+		log.Errorf("Failed to determine subject of user %q: %v", userID, err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	return &Introspection{
+		Active:   true,
+		Subject:  sub,
+		ClientID: clientID,
+		Scope:    strings.Join(scopes, " "),
+		Groups:   groups,
+		UserID:   userID,
+	}, nil
+}
+
+func handleIntrospectFunc(srv OIDCServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, fmt.Sprintf("POST only acceptable method"))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Errorf("error parsing request: %v", err)
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidRequest), "")
+			return
+		}
+
+		creds, ok, err := clientCredentials(r)
+		if err != nil || !ok {
+			log.Errorf("error parsing client credentials: %v", err)
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), "")
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+			err.Description = "missing token"
+			writeTokenError(w, err, "")
+			return
+		}
+
+		resp, err := srv.Introspect(creds, token)
+		if err != nil {
+			writeTokenError(w, err, "")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeResponseWithBody(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/coreos/dex/client"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/session"
+)
+
+// logoutHint verifies an ID token passed as the id_token_hint of a logout
+// request, returning the client it was issued to and the user it identifies.
+// The token may have expired.
+func (s *Server) logoutHint(hint string) (clientID, userID string, err error) {
+	claims, err := s.verifyIDToken(hint)
+	if err != nil {
+		return "", "", err
+	}
+
+	// An ID token's client is its authorized party or audience.
+	clientID, ok, _ := claims.StringClaim("azp")
+	if !ok {
+		aud := audienceClaim(claims)
+		if len(aud) != 1 {
+			return "", "", errors.New("token has no authorized party")
+		}
+		clientID = aud[0]
+	}
+	sub, _, _ := claims.StringClaim("sub")
+	if userID, err = s.subjectUserID(clientID, sub); err != nil {
+		return "", "", err
+	}
+	return clientID, userID, nil
+}
+
+type logoutTemplateData struct {
+	Error     bool
+	Message   string
+	LoggedOut bool
+
+	// The parameters of the logout request, submitted again once the user
+	// confirms they want to log out.
+	IDTokenHint           string
+	PostLogoutRedirectURI string
+	State                 string
+}
+
+// handleLogoutFunc ends the browser's SSO session at the request of a client
+// (OpenID Connect RP-Initiated Logout 1.0), then sends the user back to the
+// client if it asked for a registered post logout redirect URI.
+func handleLogoutFunc(s *Server, tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, "GET and POST only acceptable methods")
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Errorf("error parsing request: %v", err)
+			phttp.WriteError(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+
+		td := logoutTemplateData{
+			IDTokenHint:           r.Form.Get("id_token_hint"),
+			PostLogoutRedirectURI: r.Form.Get("post_logout_redirect_uri"),
+			State:                 r.Form.Get("state"),
+		}
+		writeLogoutError := func(msg string) {
+			td.Error = true
+			td.Message = msg
+			execTemplateWithStatus(w, tpl, td, http.StatusBadRequest)
+		}
+
+		var clientID, hintSubject string
+		if td.IDTokenHint != "" {
+			var err error
+			if clientID, hintSubject, err = s.logoutHint(td.IDTokenHint); err != nil {
+				log.Errorf("Invalid logout id_token_hint: %v", err)
+				writeLogoutError("The logout request is invalid.")
+				return
+			}
+		}
+
+		// The user may only be sent to a URI the client registered, so the
+		// client has to be identified by an ID token.
+		var redirectURL *url.URL
+		if td.PostLogoutRedirectURI != "" {
+			if clientID == "" {
+				writeLogoutError("The logout request is invalid.")
+				return
+			}
+			cli, err := s.Client(clientID)
+			switch {
+			case err == client.ErrorNotFound:
+				writeLogoutError("The logout request is invalid.")
+				return
+			case err != nil:
+				log.Errorf("Failed fetching client %s from repo: %v", clientID, err)
+				phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			u, err := url.Parse(td.PostLogoutRedirectURI)
+			if err == nil {
+				var ru url.URL
+				ru, err = cli.ValidPostLogoutRedirectURL(u)
+				redirectURL = &ru
+			}
+			if err != nil {
+				log.Errorf("Invalid post_logout_redirect_uri for client %s: %q", clientID, td.PostLogoutRedirectURI)
+				writeLogoutError("The logout request is invalid.")
+				return
+			}
+		}
+
+		ssoID := ssoSessionID(r)
+		var sso *session.SSOSession
+		if ssoID != "" {
+			var err error
+			sso, err = s.SSOSessionRepo.Get(ssoID)
+			if err != nil && err != session.ErrorSSOSessionNotFound {
+				log.Errorf("Failed fetching SSO session: %v", err)
+				phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+		}
+
+		// Unless the client shows it knows who is logged in, the user has to
+		// confirm, so merely following a link doesn't log them out.
+		if sso != nil && sso.UserID != hintSubject && r.PostForm.Get("confirm") == "" {
+			execTemplate(w, tpl, td)
+			return
+		}
+
+		if sso != nil {
+			if err := s.SSOSessionRepo.Delete(sso.ID); err != nil {
+				log.Errorf("Failed deleting SSO session: %v", err)
+				phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			log.Infof("SSO session ended by logout: user=%s clientID=%s", sso.UserID, clientID)
+			s.NotifyLogout(*sso)
+		}
+		if ssoID != "" {
+			http.SetCookie(w, expiredSSOSessionCookie(s.IssuerURL))
+		}
+
+		if redirectURL != nil {
+			if td.State != "" {
+				q := redirectURL.Query()
+				q.Set("state", td.State)
+				redirectURL.RawQuery = q.Encode()
+			}
+			w.Header().Set("Location", redirectURL.String())
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+
+		td.LoggedOut = true
+		execTemplate(w, tpl, td)
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/par"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+)
+
+// clientAuthParams are the parameters a client authenticates to the pushed
+// authorization request endpoint with, which aren't part of the authorization
+// request.
+var clientAuthParams = []string{"client_secret", "client_assertion", "client_assertion_type"}
+
+// PushAuthRequest validates an authorization request pushed by the client and
+// stores it, to be referred to by the returned request's URI (RFC 9126
+// Section 2).
+func (s *Server) PushAuthRequest(creds oidc.ClientCredentials, params url.Values) (*par.Request, error) {
+	if err := s.authenticateClient(creds, true); err != nil {
+		return nil, err
+	}
+
+	if params.Get("request_uri") != "" {
+		return nil, oauth2.NewError(oauth2.ErrorInvalidRequest)
+	}
+	if request := params.Get("request"); request != "" {
+		var err error
+		if params, err = s.requestObjectParams(creds.ID, request, true); err != nil {
+			return nil, err
+		}
+	}
+	if clientID := params.Get("client_id"); clientID != "" && clientID != creds.ID {
+		log.Errorf("Pushed authorization request for client %s sent by %s", clientID, creds.ID)
+		return nil, oauth2.NewError(oauth2.ErrorInvalidRequest)
+	}
+	params.Set("client_id", creds.ID)
+
+	acr, err := oauth2.ParseAuthCodeRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := s.Client(creds.ID)
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", creds.ID, err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	redirectURL, err := cli.ValidRedirectURL(acr.RedirectURL)
+	if err != nil {
+		log.Errorf("Invalid redirect URL for client %s: %v", creds.ID, err)
+		return nil, oauth2.NewError(oauth2.ErrorInvalidRequest)
+	}
+	responseType, _, err := parseResponseType(acr.ResponseType, params.Get("response_mode"))
+	if err != nil {
+		return nil, err
+	}
+	if responseType != oauth2.ResponseTypeCode && redirectURL.String() == client.OOBRedirectURI {
+		return nil, oauth2.NewError(oauth2.ErrorUnsupportedResponseType)
+	}
+	for _, gt := range responseTypeGrantTypes(responseType) {
+		if !cli.GrantTypeAllowed(gt) {
+			log.Errorf("Client %q is not allowed to use grant type %q", creds.ID, gt)
+			return nil, oauth2.NewError(oauth2.ErrorUnauthorizedClient)
+		}
+	}
+	if err := validateScopes(s, creds.ID, acr.Scope); err != nil {
+		return nil, err
+	}
+	if _, err := parseCodeChallenge(params.Get("code_challenge"), params.Get("code_challenge_method")); err != nil {
+		return nil, err
+	}
+
+	requestURI, err := par.NewRequestURI()
+	if err != nil {
+		log.Errorf("Failed to generate request URI: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	req := par.Request{
+		RequestURI: requestURI,
+		ClientID:   creds.ID,
+		Params:     params,
+		ExpiresAt:  time.Now().UTC().Add(par.DefaultValidityWindow),
+	}
+	if err := s.PushedAuthRequestRepo.Create(req); err != nil {
+		log.Errorf("Failed to create pushed authorization request: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	log.Infof("Pushed authorization request created: clientID=%s", creds.ID)
+	return &req, nil
+}
+
+// pushedAuthRequestParams returns the parameters of the authorization request
+// the client pushed with the given request URI.
+func (s *Server) pushedAuthRequestParams(clientID, requestURI string) (url.Values, error) {
+	// Request objects are never fetched from URIs of the client's.
+	if !strings.HasPrefix(requestURI, par.RequestURIPrefix) {
+		log.Errorf("Unsupported request_uri %q", requestURI)
+		return nil, oauth2.NewError(errorInvalidRequestURI)
+	}
+
+	req, err := s.PushedAuthRequestRepo.Get(requestURI)
+	switch err {
+	case nil:
+	case par.ErrorNotFound, par.ErrorExpired:
+		log.Errorf("Invalid request_uri: %v", err)
+		return nil, oauth2.NewError(errorInvalidRequestURI)
+	default:
+		log.Errorf("Failed to fetch pushed authorization request: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	if req.ClientID != clientID {
+		log.Errorf("Pushed authorization request of client %s used by %q", req.ClientID, clientID)
+		return nil, oauth2.NewError(errorInvalidRequestURI)
+	}
+	return req.Params, nil
+}
+
+// DeletePushedAuthRequest deletes a pushed authorization request once a
+// session has been started with it, so its request URI can only be used once
+// (RFC 9126 Section 7.3).
+func (s *Server) DeletePushedAuthRequest(requestURI string) error {
+	switch err := s.PushedAuthRequestRepo.Delete(requestURI); err {
+	case nil:
+		return nil
+	case par.ErrorNotFound:
+		return oauth2.NewError(errorInvalidRequestURI)
+	default:
+		log.Errorf("Failed to delete pushed authorization request: %v", err)
+		return oauth2.NewError(oauth2.ErrorServerError)
+	}
+}
+
+type pushedAuthRequestResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+func handlePushedAuthRequestFunc(srv OIDCServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, "POST only acceptable method")
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Errorf("error parsing request: %v", err)
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidRequest), "")
+			return
+		}
+
+		creds, ok, err := clientCredentials(r)
+		switch {
+		case err != nil:
+			log.Errorf("error decoding client credentials: %v", err)
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), "")
+			return
+		case ok:
+		case r.PostForm.Get("client_id") != "":
+			creds = oidc.ClientCredentials{ID: r.PostForm.Get("client_id")}
+		default:
+			log.Errorf("missing client credentials")
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), "")
+			return
+		}
+
+		params := url.Values{}
+		for k, v := range r.PostForm {
+			if !pstrings.ContainsString(clientAuthParams, k) {
+				params[k] = v
+			}
+		}
+
+		req, err := srv.PushAuthRequest(creds, params)
+		if err != nil {
+			writeTokenError(w, err, "")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeResponseWithBody(w, http.StatusCreated, pushedAuthRequestResponse{
+			RequestURI: req.RequestURI,
+			ExpiresIn:  int64(par.DefaultValidityWindow.Seconds()),
+		})
+	}
+}
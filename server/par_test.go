@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/connector"
+	"github.com/coreos/dex/par"
+)
+
+func TestHandleAuthFuncRequestObject(t *testing.T) {
+	rsaKey, err := key.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clients := []client.LoadableClient{
+		{
+			Client: client.Client{
+				Credentials: testClientCredentials,
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{testRedirectURL},
+					JWKS:         &jose.JWKSet{Keys: []jose.JWK{rsaKey.JWK()}},
+				},
+			},
+		},
+	}
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	idpcs := []connector.Connector{&fakeConnector{loginURL: "http://fake.example.com"}}
+
+	requestObject := func(signer jose.Signer, edit func(jose.Claims)) string {
+		claims := jose.Claims{
+			"iss":           testClientID,
+			"aud":           testIssuerURL.String(),
+			"exp":           time.Now().Add(time.Minute).Unix(),
+			"client_id":     testClientID,
+			"response_type": "code",
+			"scope":         "openid",
+		}
+		if edit != nil {
+			edit(claims)
+		}
+		jwt, err := jose.NewSignedJWT(claims, signer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return jwt.Encode()
+	}
+
+	tests := []struct {
+		query    url.Values
+		wantCode int
+	}{
+		{
+			query: url.Values{
+				"client_id":    {testClientID},
+				"connector_id": {"fake"},
+				"request":      {requestObject(rsaKey.Signer(), nil)},
+			},
+			wantCode: http.StatusFound,
+		},
+		{
+			// Parameters outside the request object are ignored.
+			query: url.Values{
+				"client_id":     {testClientID},
+				"connector_id":  {"fake"},
+				"response_type": {"code"},
+				"request": {requestObject(rsaKey.Signer(), func(c jose.Claims) {
+					c["response_type"] = "token"
+				})},
+			},
+			wantCode: http.StatusFound,
+		},
+		{
+			query: url.Values{
+				"client_id":    {"other.example.com"},
+				"connector_id": {"fake"},
+				"request":      {requestObject(rsaKey.Signer(), nil)},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			query: url.Values{
+				"client_id":    {testClientID},
+				"connector_id": {"fake"},
+				"request": {requestObject(rsaKey.Signer(), func(c jose.Claims) {
+					c["aud"] = "https://other.example.com"
+				})},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			query: url.Values{
+				"client_id":    {testClientID},
+				"connector_id": {"fake"},
+				"request": {requestObject(rsaKey.Signer(), func(c jose.Claims) {
+					c["exp"] = time.Now().Add(-time.Minute).Unix()
+				})},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			query: url.Values{
+				"client_id":    {testClientID},
+				"connector_id": {"fake"},
+				"request": {requestObject(rsaKey.Signer(), func(c jose.Claims) {
+					delete(c, "exp")
+				})},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			query: url.Values{
+				"client_id":    {testClientID},
+				"connector_id": {"fake"},
+				"request": {requestObject(rsaKey.Signer(), func(c jose.Claims) {
+					c["exp"] = time.Now().Add(2 * maxRequestObjectLifetime).Unix()
+				})},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			query: url.Values{
+				"client_id":    {testClientID},
+				"connector_id": {"fake"},
+				"request": {requestObject(rsaKey.Signer(), func(c jose.Claims) {
+					c["iat"] = time.Now().Add(-2 * maxRequestObjectLifetime).Unix()
+				})},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			query: url.Values{
+				"client_id":    {testClientID},
+				"connector_id": {"fake"},
+				"request":      {requestObject(testPrivKey.Signer(), nil)},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			query: url.Values{
+				"client_id":    {testClientID},
+				"connector_id": {"fake"},
+				"request":      {requestObject(rsaKey.Signer(), nil)},
+				"request_uri":  {par.RequestURIPrefix + "abc"},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		hdlr := handleAuthFunc(f.srv, testIssuerURL, idpcs, nil, f.srv.FormPostTemplate, false)
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://server.example.com/auth?"+tt.query.Encode(), nil)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		hdlr.ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("case %d: want HTTP %d, got %d: %s", i, tt.wantCode, w.Code, w.Body)
+		}
+	}
+}
+
+func TestHandlePushedAuthRequestFunc(t *testing.T) {
+	rsaKey, err := key.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret := base64.URLEncoding.EncodeToString([]byte("secret"))
+	clients := []client.LoadableClient{
+		{
+			Client: client.Client{
+				Credentials: oidc.ClientCredentials{ID: testClientID, Secret: secret},
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{testRedirectURL},
+					JWKS:         &jose.JWKSet{Keys: []jose.JWK{rsaKey.JWK()}},
+				},
+				RequirePushedAuthorizationRequests: true,
+			},
+		},
+	}
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	idpcs := []connector.Connector{&fakeConnector{loginURL: "http://fake.example.com"}}
+	loginTpl := template.Must(template.New("login").Parse("{{range .Links}}{{.URL}}{{end}}"))
+
+	push := func(form url.Values) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://server.example.com/par", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(testClientID, secret)
+		w := httptest.NewRecorder()
+		handlePushedAuthRequestFunc(f.srv).ServeHTTP(w, req)
+		return w
+	}
+	authorize := func(q url.Values) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://server.example.com/auth?"+q.Encode(), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		w := httptest.NewRecorder()
+		handleAuthFunc(f.srv, testIssuerURL, idpcs, loginTpl, f.srv.FormPostTemplate, false).ServeHTTP(w, req)
+		return w
+	}
+
+	invalid := []url.Values{
+		{"response_type": {"token"}, "scope": {"openid"}},
+		{"response_type": {"code"}, "scope": {"openid"}, "redirect_uri": {"https://evil.example.com/callback"}},
+		{"response_type": {"code"}, "scope": {"openid"}, "request_uri": {par.RequestURIPrefix + "abc"}},
+		{"response_type": {"code"}, "scope": {"openid"}, "client_id": {"other.example.com"}},
+		{"response_type": {"code"}, "scope": {"openid"}, "response_mode": {"bogus"}},
+		{"response_type": {"code id_token"}, "scope": {"openid"}, "response_mode": {"query"}},
+	}
+	for i, form := range invalid {
+		if w := push(form); w.Code != http.StatusBadRequest {
+			t.Errorf("case %d: want HTTP %d, got %d: %s", i, http.StatusBadRequest, w.Code, w.Body)
+		}
+	}
+
+	// Any supported response type can be pushed.
+	if w := push(url.Values{"response_type": {"id_token code"}, "scope": {"openid"}, "nonce": {"abc"}}); w.Code != http.StatusCreated {
+		t.Errorf("want HTTP %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	// Pushed request objects can only be used once.
+	jwt, err := jose.NewSignedJWT(jose.Claims{
+		"iss":           testClientID,
+		"aud":           testIssuerURL.String(),
+		"exp":           time.Now().Add(time.Minute).Unix(),
+		"jti":           "request-1",
+		"client_id":     testClientID,
+		"response_type": "code",
+		"scope":         "openid",
+	}, rsaKey.Signer())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w := push(url.Values{"request": {jwt.Encode()}}); w.Code != http.StatusCreated {
+		t.Errorf("want HTTP %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	if w := push(url.Values{"request": {jwt.Encode()}}); w.Code != http.StatusBadRequest {
+		t.Errorf("want HTTP %d for a replayed request object, got %d: %s", http.StatusBadRequest, w.Code, w.Body)
+	}
+
+	w := push(url.Values{"response_type": {"code"}, "scope": {"openid"}, "state": {"abc"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want HTTP %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	var resp pushedAuthRequestResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(resp.RequestURI, par.RequestURIPrefix) || resp.ExpiresIn != int64(par.DefaultValidityWindow.Seconds()) {
+		t.Errorf("unexpected response: %#v", resp)
+	}
+
+	// The client must push its authorization requests.
+	w = authorize(url.Values{"client_id": {testClientID}, "response_type": {"code"}, "scope": {"openid"}, "connector_id": {"fake"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("want HTTP %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	q := url.Values{"client_id": {testClientID}, "request_uri": {resp.RequestURI}}
+	// Without a connector, the login page is shown, linking to the
+	// connectors with the same request URI.
+	if w = authorize(q); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "request_uri=") {
+		t.Errorf("want login page, got HTTP %d: %s", w.Code, w.Body)
+	}
+	q.Set("connector_id", "fake")
+	if w = authorize(url.Values{"client_id": {"other.example.com"}, "request_uri": q["request_uri"], "connector_id": {"fake"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("want HTTP %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w = authorize(q); w.Code != http.StatusFound || w.Header().Get("Location") != "http://fake.example.com" {
+		t.Errorf("want redirect to connector, got HTTP %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// Request URIs can only be used once.
+	w = authorize(q)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("want HTTP %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), fmt.Sprintf("%q", errorInvalidRequestURI)) {
+		t.Errorf("want error %q, got %s", errorInvalidRequestURI, w.Body)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/connector"
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/session"
+)
+
+// PasswordToken implements the resource owner password credentials grant
+// (RFC 6749 Section 4.3). Only clients whose AllowedGrantTypes explicitly list
+// the "password" grant type may use it. The credentials are checked by the named
+// connector, or PasswordGrantConnectorID if none is given.
+func (s *Server) PasswordToken(creds oidc.ClientCredentials, connectorID, username, password string, scope, resources []string) (*jose.JWT, *jose.JWT, string, time.Time, error) {
+	if err := s.authenticateClient(creds, false); err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	if err := s.checkGrantType(creds.ID, oauth2.GrantTypeUserCreds); err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	aud, err := s.accessTokenAudience(creds.ID, resources)
+	if err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	if connectorID == "" {
+		connectorID = s.PasswordGrantConnectorID
+	}
+	idpc, ok := s.connector(connectorID)
+	if !ok {
+		err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+		err.Description = "invalid connector_id"
+		return nil, nil, "", time.Time{}, err
+	}
+	pc, ok := idpc.(connector.PasswordConnector)
+	if !ok {
+		err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+		err.Description = "connector does not support the password grant"
+		return nil, nil, "", time.Time{}, err
+	}
+
+	ident, err := pc.Identity(username, password)
+	if err != nil || ident == nil {
+		log.Errorf("Password grant failed for client %s: %v", creds.ID, err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	}
+
+	sessionID, err := s.SessionManager.NewSession(session.AuthRequest{
+		ConnectorID: connectorID,
+		ClientID:    creds.ID,
+		Scope:       scope,
+	})
+	if err != nil {
+		log.Errorf("Error creating new session: %v", err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	ses, redirect, err := s.login(sessionID, *ident, s.SessionManager.Clock.Now())
+	switch oerr, _ := err.(*oauth2.Error); {
+	case oerr != nil && oerr.Type == oauth2.ErrorAccessDenied:
+		// The user is disabled.
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	case err != nil:
+		log.Errorf("Password grant login failed: %v", err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	case redirect != "":
+		// The identity isn't associated with a dex user, and registration
+		// requires the user's browser.
+		log.Errorf("Session %s password grant identity has no user: clientID=%s", sessionID, creds.ID)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	}
+
+	if ses, err = s.SessionManager.Kill(sessionID); err != nil {
+		log.Errorf("Failed to kill session %s: %v", sessionID, err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	jwt, accessToken, refreshToken, expiresAt, err := s.sessionToken(ses, aud)
+	if err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	log.Infof("Session %s password token sent: clientID=%s", sessionID, creds.ID)
+	return jwt, accessToken, refreshToken, expiresAt, nil
+}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/coreos/dex/email"
 	"github.com/coreos/dex/pkg/html"
+	"github.com/coreos/dex/session"
 	"github.com/coreos/dex/user"
 )
 
@@ -251,7 +252,13 @@ func TestSendResetPasswordEmailHandler(t *testing.T) {
 			t.Fatalf("case %d: could not make test fixtures: %v", i, err)
 		}
 
-		_, err = f.srv.NewSession("local", testClientID, "", f.redirectURL, "", true, []string{"openid"})
+		_, err = f.srv.NewSession(session.AuthRequest{
+			ConnectorID: "local",
+			ClientID:    testClientID,
+			RedirectURL: f.redirectURL,
+			Register:    true,
+			Scope:       []string{"openid"},
+		})
 		if err != nil {
 			t.Fatalf("case %d: could not create new session: %v", i, err)
 		}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/coreos/go-oidc/oauth2"
+)
+
+// Proof Key for Code Exchange by OAuth Public Clients.
+// See: https://tools.ietf.org/html/rfc7636
+const (
+	codeChallengeMethodPlain = "plain"
+	codeChallengeMethodS256  = "S256"
+
+	// Code verifiers, and therefore plain code challenges, must be between 43
+	// and 128 characters long (RFC 7636 Section 4.1).
+	minCodeVerifierLength = 43
+	maxCodeVerifierLength = 128
+)
+
+var codeChallengeMethodsSupported = []string{codeChallengeMethodPlain, codeChallengeMethodS256}
+
+// validCodeVerifier checks that s only contains the unreserved characters
+// permitted in a code verifier and is of an acceptable length.
+func validCodeVerifier(s string) bool {
+	if len(s) < minCodeVerifierLength || len(s) > maxCodeVerifierLength {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '.' || c == '_' || c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseCodeChallenge validates the PKCE parameters of an authorization request,
+// returning the code challenge method to store alongside the challenge.
+// An empty challenge indicates the client is not using PKCE.
+func parseCodeChallenge(challenge, method string) (string, error) {
+	if challenge == "" {
+		if method != "" {
+			err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+			err.Description = "code_challenge_method provided without code_challenge"
+			return "", err
+		}
+		return "", nil
+	}
+
+	// Defaults to "plain" if not present in the request (RFC 7636 Section 4.3).
+	if method == "" {
+		method = codeChallengeMethodPlain
+	}
+
+	switch method {
+	case codeChallengeMethodPlain:
+		if !validCodeVerifier(challenge) {
+			err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+			err.Description = "invalid code_challenge"
+			return "", err
+		}
+	case codeChallengeMethodS256:
+		// The base64url encoding of a SHA256 hash without padding.
+		if b, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || len(b) != sha256.Size {
+			err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+			err.Description = "invalid code_challenge"
+			return "", err
+		}
+	default:
+		err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+		err.Description = fmt.Sprintf("unsupported code_challenge_method %q", method)
+		return "", err
+	}
+	return method, nil
+}
+
+// verifyCodeChallenge reports whether the code verifier presented at the token
+// endpoint matches the code challenge provided in the authorization request.
+func verifyCodeChallenge(challenge, method, verifier string) bool {
+	if !validCodeVerifier(verifier) {
+		return false
+	}
+
+	var computed string
+	switch method {
+	case codeChallengeMethodPlain:
+		computed = verifier
+	case codeChallengeMethodS256:
+		sum := sha256.Sum256([]byte(verifier))
+		computed = base64.RawURLEncoding.EncodeToString(sum[:])
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
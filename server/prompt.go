@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+
+	pstrings "github.com/coreos/dex/pkg/strings"
+)
+
+// Values of the prompt authentication request parameter
+// (OpenID Connect Core 1.0 Section 3.1.2.1).
+const (
+	promptNone          = "none"
+	promptLogin         = "login"
+	promptConsent       = "consent"
+	promptSelectAccount = "select_account"
+)
+
+// parsePrompt splits the space delimited prompt parameter into its values.
+// "none" must not be combined with any other value.
+func parsePrompt(prompt string) ([]string, error) {
+	prompts := strings.Fields(prompt)
+	for _, p := range prompts {
+		switch p {
+		case promptNone:
+			if len(prompts) > 1 {
+				err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+				err.Description = "prompt value none must not be combined with other values"
+				return nil, err
+			}
+		case promptLogin, promptConsent, promptSelectAccount:
+		default:
+			err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+			err.Description = fmt.Sprintf("unsupported prompt value %q", p)
+			return nil, err
+		}
+	}
+	return prompts, nil
+}
+
+// parseMaxAge parses the max_age parameter, the number of seconds since the
+// user last actively authenticated after which they must do so again. -1 is
+// returned if the parameter was not given.
+func parseMaxAge(maxAge string) (int, error) {
+	if maxAge == "" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(maxAge)
+	if err != nil || n < 0 {
+		err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+		err.Description = "max_age must be a non-negative integer"
+		return 0, err
+	}
+	return n, nil
+}
+
+// IDTokenHintSubject verifies an ID token previously issued to the client,
+// passed as the id_token_hint authentication request parameter, and returns the
+// user it was issued for. The token may have expired.
+func (s *Server) IDTokenHintSubject(clientID, hint string) (string, error) {
+	claims, err := s.verifyIDToken(hint)
+	if err == nil && !pstrings.ContainsString(audienceClaim(claims), clientID) {
+		err = fmt.Errorf("token was not issued to client %q", clientID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sub, _, _ := claims.StringClaim("sub")
+	return s.subjectUserID(clientID, sub)
+}
+
+// audienceClaim returns the aud claim, which may be a single string or an array.
+func audienceClaim(claims jose.Claims) []string {
+	if aud, ok, _ := claims.StringsClaim("aud"); ok {
+		return aud
+	}
+	if aud, ok, _ := claims.StringClaim("aud"); ok {
+		return []string{aud}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+
+	"github.com/coreos/go-oidc/oidc"
+)
+
+// ProviderConfig is the OpenID Provider Metadata served by dex. It extends
+// oidc.ProviderConfig with metadata defined by OAuth 2.0 extensions which are
+// not part of the OpenID Connect Discovery specification.
+type ProviderConfig struct {
+	oidc.ProviderConfig
+
+	// PKCE code challenge methods supported (RFC 7636 Section 4.3).
+	CodeChallengeMethodsSupported []string
+
+	// Token introspection endpoint and the client authentication methods it
+	// accepts (RFC 7662, RFC 8414 Section 2).
+	IntrospectionEndpoint                     *url.URL
+	IntrospectionEndpointAuthMethodsSupported []string
+
+	// Token revocation endpoint and the client authentication methods it
+	// accepts (RFC 7009, RFC 8414 Section 2).
+	RevocationEndpoint                     *url.URL
+	RevocationEndpointAuthMethodsSupported []string
+
+	// Device authorization endpoint (RFC 8628 Section 4).
+	DeviceAuthorizationEndpoint *url.URL
+
+	// Pushed authorization request endpoint (RFC 9126 Section 5).
+	PushedAuthorizationRequestEndpoint *url.URL
+
+	// Whether logout tokens are sent to clients, and whether they identify
+	// the session with a sid claim (OpenID Connect Back-Channel Logout 1.0
+	// Section 2.1).
+	BackchannelLogoutSupported        bool
+	BackchannelLogoutSessionSupported bool
+}
+
+type encodableProviderConfigExtensions struct {
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint,omitempty"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported,omitempty"`
+
+	RevocationEndpoint                     string   `json:"revocation_endpoint,omitempty"`
+	RevocationEndpointAuthMethodsSupported []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`
+
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint,omitempty"`
+
+	BackchannelLogoutSupported        bool `json:"backchannel_logout_supported,omitempty"`
+	BackchannelLogoutSessionSupported bool `json:"backchannel_logout_session_supported,omitempty"`
+}
+
+// MarshalJSON encodes the OpenID Provider Metadata followed by any extension
+// metadata, preserving the field order of the embedded oidc.ProviderConfig.
+func (p *ProviderConfig) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(&p.ProviderConfig)
+	if err != nil {
+		return nil, err
+	}
+	ext, err := json.Marshal(encodableProviderConfigExtensions{
+		CodeChallengeMethodsSupported: p.CodeChallengeMethodsSupported,
+
+		IntrospectionEndpoint:                     uriToString(p.IntrospectionEndpoint),
+		IntrospectionEndpointAuthMethodsSupported: p.IntrospectionEndpointAuthMethodsSupported,
+
+		RevocationEndpoint:                     uriToString(p.RevocationEndpoint),
+		RevocationEndpointAuthMethodsSupported: p.RevocationEndpointAuthMethodsSupported,
+
+		DeviceAuthorizationEndpoint: uriToString(p.DeviceAuthorizationEndpoint),
+
+		PushedAuthorizationRequestEndpoint: uriToString(p.PushedAuthorizationRequestEndpoint),
+
+		BackchannelLogoutSupported:        p.BackchannelLogoutSupported,
+		BackchannelLogoutSessionSupported: p.BackchannelLogoutSessionSupported,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return appendJSONObject(b, ext), nil
+}
+
+// appendJSONObject splices the fields of the JSON object ext onto the end of
+// the JSON object b.
+func appendJSONObject(b, ext []byte) []byte {
+	if len(ext) <= len("{}") {
+		return b
+	}
+	b = bytes.TrimSuffix(b, []byte("}"))
+	if len(b) > len("{") {
+		b = append(b, ',')
+	}
+	return append(b, ext[1:]...)
+}
+
+func uriToString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
@@ -109,8 +109,21 @@ func handleRegisterFunc(s *Server, tpl Template) http.HandlerFunc {
 
 		if exists {
 			// we have to create a new session to be able to run the server.Login function
-			newSessionKey, err := s.NewSession(ses.ConnectorID, ses.ClientID,
-				ses.ClientState, ses.RedirectURL, ses.Nonce, false, ses.Scope)
+			newSessionKey, err := s.NewSession(session.AuthRequest{
+				ConnectorID:         ses.ConnectorID,
+				ClientID:            ses.ClientID,
+				ClientState:         ses.ClientState,
+				RedirectURL:         ses.RedirectURL,
+				Nonce:               ses.Nonce,
+				Scope:               ses.Scope,
+				CodeChallenge:       ses.CodeChallenge,
+				CodeChallengeMethod: ses.CodeChallengeMethod,
+				SSOLoginNonce:       ses.SSOLoginNonce,
+				ClaimsRequest:       ses.ClaimsRequest,
+				ResponseType:        ses.ResponseType,
+				ResponseMode:        ses.ResponseMode,
+				PromptConsent:       ses.PromptConsent,
+			})
 			if err != nil {
 				internalError(w, err)
 				return
@@ -246,6 +259,12 @@ func handleRegisterFunc(s *Server, tpl Template) http.HandlerFunc {
 			return
 		}
 
+		ses, err = s.startBrowserSSOSession(w, r, ses)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
 		usr, err := s.UserRepo.Get(nil, userID)
 		if err != nil {
 			internalError(w, err)
@@ -260,8 +279,18 @@ func handleRegisterFunc(s *Server, tpl Template) http.HandlerFunc {
 			}
 		}
 
-		w.Header().Set("Location", makeClientRedirectURL(
-			ses.RedirectURL, code, ses.ClientState).String())
+		ru := makeClientRedirectURL(ses.RedirectURL, code, ses.ClientState)
+		consentRequired, err := s.sessionConsentRequired(ses)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if consentRequired {
+			cu := s.consentURL(code)
+			ru = &cu
+		}
+
+		w.Header().Set("Location", ru.String())
 		w.WriteHeader(http.StatusSeeOther)
 		return
 	}
@@ -12,6 +12,7 @@ import (
 	"github.com/kylelemons/godebug/pretty"
 
 	"github.com/coreos/dex/pkg/html"
+	"github.com/coreos/dex/session"
 	"github.com/coreos/dex/user"
 	"github.com/coreos/go-oidc/oidc"
 )
@@ -295,7 +296,13 @@ func TestHandleRegister(t *testing.T) {
 				})
 		}
 
-		key, err := f.srv.NewSession(tt.connID, testClientID, "", f.redirectURL, "", true, []string{"openid"})
+		key, err := f.srv.NewSession(session.AuthRequest{
+			ConnectorID: tt.connID,
+			ClientID:    testClientID,
+			RedirectURL: f.redirectURL,
+			Register:    true,
+			Scope:       []string{"openid"},
+		})
 		t.Logf("case %d: key for NewSession: %v", i, key)
 
 		if tt.attachRemote {
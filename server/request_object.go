@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+)
+
+// maxRequestObjectLifetime is the longest a request object may be valid for,
+// limiting how long it can be replayed at the authorization endpoint.
+const maxRequestObjectLifetime = time.Hour
+
+var (
+	// requestObjectClaimsIgnored are claims of a request object which aren't
+	// authorization request parameters.
+	requestObjectClaimsIgnored = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "request", "request_uri"}
+
+	// uiParams are dex's own authorization endpoint parameters, used by the
+	// login page rather than the client, and so are never found in a request
+	// object or a pushed authorization request.
+	uiParams = []string{"connector_id", "register"}
+)
+
+// AuthRequestParams returns the parameters of an authorization request. They
+// may be passed by value in a request object (RFC 9101), or by reference with
+// the request URI of a pushed authorization request (RFC 9126), in which case
+// any other parameters in the query are ignored.
+func (s *Server) AuthRequestParams(q url.Values) (url.Values, error) {
+	clientID := q.Get("client_id")
+	request, requestURI := q.Get("request"), q.Get("request_uri")
+
+	params := q
+	var err error
+	switch {
+	case request != "" && requestURI != "":
+		err = oauth2.NewError(oauth2.ErrorInvalidRequest)
+	case request != "":
+		params, err = s.requestObjectParams(clientID, request, false)
+	case requestURI != "":
+		params, err = s.pushedAuthRequestParams(clientID, requestURI)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	clientID = params.Get("client_id")
+	cli, err := s.Client(clientID)
+	if err == nil && cli.RequirePushedAuthorizationRequests && requestURI == "" {
+		log.Errorf("Client %s must push its authorization requests", clientID)
+		oerr := oauth2.NewError(oauth2.ErrorInvalidRequest)
+		oerr.Description = "pushed authorization request required"
+		return nil, oerr
+	}
+
+	for _, p := range uiParams {
+		if v, ok := q[p]; ok {
+			params[p] = v
+		}
+	}
+	return params, nil
+}
+
+// requestObjectParams verifies a request object signed by the client and
+// returns the authorization request parameters it holds (RFC 9101 Section 6).
+// If clientID is not empty, the request object must have been sent by that
+// client. Pushed request objects are only accepted once; those passed to the
+// authorization endpoint can't be, as the login page sends them again with the
+// chosen connector.
+func (s *Server) requestObjectParams(clientID, request string, pushed bool) (url.Values, error) {
+	params, err := s.verifyRequestObject(clientID, request, pushed)
+	if err != nil {
+		log.Errorf("Invalid request object: %v", err)
+		return nil, oauth2.NewError(errorInvalidRequestObject)
+	}
+	return params, nil
+}
+
+func (s *Server) verifyRequestObject(clientID, request string, pushed bool) (url.Values, error) {
+	jwt, err := jose.ParseJWT(request)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := jwt.Claims()
+	if err != nil {
+		return nil, err
+	}
+
+	cid, _, _ := claims.StringClaim("client_id")
+	if cid == "" {
+		return nil, errors.New("missing client_id")
+	}
+	if clientID != "" && clientID != cid {
+		return nil, errors.New("client_id doesn't match the request's")
+	}
+	cli, err := s.Client(cid)
+	if err == client.ErrorNotFound {
+		return nil, fmt.Errorf("unknown client %s", cid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if iss, _, _ := claims.StringClaim("iss"); iss != cid {
+		return nil, errors.New("issuer must be the client ID")
+	}
+	if !pstrings.ContainsString(audienceClaim(claims), s.IssuerURL.String()) {
+		return nil, errors.New("audience doesn't include the issuer")
+	}
+
+	now := time.Now()
+	exp, ok, err := claims.TimeClaim("exp")
+	if err != nil || !ok {
+		return nil, errors.New("missing expiry")
+	}
+	if now.After(exp) {
+		return nil, errors.New("request object has expired")
+	}
+	if exp.Sub(now) > maxRequestObjectLifetime {
+		return nil, errors.New("request object expires too far in the future")
+	}
+	if iat, ok, err := claims.TimeClaim("iat"); err != nil || (ok && exp.Sub(iat) > maxRequestObjectLifetime) {
+		return nil, errors.New("request object is valid for too long")
+	}
+	if nbf, ok, err := claims.TimeClaim("nbf"); err != nil || (ok && now.Before(nbf)) {
+		return nil, errors.New("request object is not valid yet")
+	}
+
+	alg := jwt.Header[jose.HeaderKeyAlgorithm]
+	if want := cli.Metadata.RequestObjectOptions.SigningAlg; want != "" && alg != want {
+		return nil, fmt.Errorf("request object signed with %q rather than %q", alg, want)
+	}
+	if err := s.verifyClientSignature(cli, jwt); err != nil {
+		return nil, err
+	}
+	if jti, _, _ := claims.StringClaim("jti"); pushed && jti != "" {
+		if err := s.ClientAssertionRepo.Use(cid, jti, exp); err != nil {
+			return nil, err
+		}
+	}
+
+	params := url.Values{}
+	for name, v := range claims {
+		if pstrings.ContainsString(requestObjectClaimsIgnored, name) {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			params.Set(name, v)
+		case float64:
+			params.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			// Parameters with structured values, such as claims, are
+			// JSON encoded.
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			params.Set(name, string(b))
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/refresh"
+)
+
+// RevokeToken revokes a single refresh token. As required by RFC 7009 Section
+// 2.2, revoking an unrecognized token is not an error.
+func (s *Server) RevokeToken(creds oidc.ClientCredentials, token string) error {
+	if err := s.authenticateClient(creds, false); err != nil {
+		return err
+	}
+
+	// ID tokens are self-contained and can't be revoked.
+	if _, err := jose.ParseJWT(token); err == nil {
+		err := oauth2.NewError(errorUnsupportedTokenType)
+		err.Description = "only refresh tokens can be revoked"
+		return err
+	}
+
+	userID, _, _, _, err := s.RefreshTokenRepo.Verify(creds.ID, token)
+	switch err {
+	case nil:
+	case refresh.ErrorInvalidToken:
+		log.Infof("Ignoring revocation of unrecognized token: clientID=%s", creds.ID)
+		return nil
+	case refresh.ErrorInvalidClientID:
+		log.Errorf("Client %s attempted to revoke a token issued to another client", creds.ID)
+		return oauth2.NewError(oauth2.ErrorInvalidGrant)
+	default:
+		log.Errorf("Failed to verify refresh token: %v", err)
+		return oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	switch err := s.RefreshTokenRepo.Revoke(userID, token); err {
+	case nil, refresh.ErrorInvalidToken:
+	default:
+		log.Errorf("Failed to revoke refresh token: %v", err)
+		return oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	log.Infof("Refresh token revoked: clientID=%s", creds.ID)
+	return nil
+}
+
+func handleRevokeFunc(srv OIDCServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, fmt.Sprintf("POST only acceptable method"))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Errorf("error parsing request: %v", err)
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidRequest), "")
+			return
+		}
+
+		creds, ok, err := clientCredentials(r)
+		if err != nil || !ok {
+			log.Errorf("error parsing client credentials: %v", err)
+			writeTokenError(w, oauth2.NewError(oauth2.ErrorInvalidClient), "")
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+			err.Description = "missing token"
+			writeTokenError(w, err, "")
+			return
+		}
+
+		// The token_type_hint parameter is ignored, only refresh tokens can
+		// be revoked.
+		if err := srv.RevokeToken(creds, token); err != nil {
+			writeTokenError(w, err, "")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -22,7 +22,12 @@ import (
 	"github.com/coreos/dex/client"
 	clientmanager "github.com/coreos/dex/client/manager"
 	"github.com/coreos/dex/connector"
+	"github.com/coreos/dex/consent"
+	"github.com/coreos/dex/device"
+	"github.com/coreos/dex/par"
+	phttp "github.com/coreos/dex/pkg/http"
 	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
 	"github.com/coreos/dex/refresh"
 	"github.com/coreos/dex/scope"
 	"github.com/coreos/dex/session"
@@ -40,29 +45,106 @@ const (
 	SendResetPasswordEmailTemplateName = "send-reset-password.html"
 	ResetPasswordTemplateName          = "reset-password.html"
 	OOBTemplateName                    = "oob-template.html"
+	DeviceTemplateName                 = "device.html"
+	LogoutTemplateName                 = "logout.html"
+	ConsentTemplateName                = "consent.html"
+	FormPostTemplateName               = "form-post.html"
 	APIVersion                         = "v1"
 )
 
 type OIDCServer interface {
 	Client(string) (client.Client, error)
-	NewSession(connectorID, clientID, clientState string, redirectURL url.URL, nonce string, register bool, scope []string) (string, error)
+	// NewSession starts an authentication session, returning the key used to
+	// refer to it. If the request names an SSO session, it is started once
+	// the user logs in.
+	NewSession(session.AuthRequest) (string, error)
 	Login(oidc.Identity, string) (string, error)
 
-	// CodeToken exchanges a code for an ID token and a refresh token string on success.
-	CodeToken(creds oidc.ClientCredentials, sessionKey string) (*jose.JWT, string, time.Time, error)
+	// SSOSession returns the SSO session with the given ID, if it is still
+	// valid for its user.
+	SSOSession(id string) (*session.SSOSession, error)
 
-	ClientCredsToken(creds oidc.ClientCredentials) (*jose.JWT, time.Time, error)
+	// UsableSSOSession returns the SSO session with the given ID if it can
+	// be used to authenticate the user for an authentication request.
+	UsableSSOSession(id, connectorID string, maxAge int, hintSubject string) *session.SSOSession
 
-	// RefreshToken takes a previously generated refresh token and returns a new ID token and new refresh token
-	// if the token is valid.
-	RefreshToken(creds oidc.ClientCredentials, scopes scope.Scopes, token string) (*jose.JWT, string, time.Time, error)
+	// SSOLogin logs in the user of the SSO session without involving a
+	// connector, returning the URL to redirect the user to.
+	SSOLogin(sso session.SSOSession, key string) (string, error)
+
+	// CodeToken exchanges a code for an ID token, an access token and a refresh token string on success.
+	// If a code challenge was provided when the code was requested, codeVerifier must match it.
+	// The returned time is the expiry of the access token, which is issued for the requested
+	// resources (RFC 8707), or the client's configured resources if none are given.
+	CodeToken(creds oidc.ClientCredentials, sessionKey, codeVerifier string, resources []string) (*jose.JWT, *jose.JWT, string, time.Time, error)
+
+	ClientCredsToken(creds oidc.ClientCredentials, resources []string) (*jose.JWT, *jose.JWT, time.Time, error)
+
+	// PasswordToken exchanges a username and password, checked by the given
+	// connector, for an ID token, an access token and a refresh token string.
+	PasswordToken(creds oidc.ClientCredentials, connectorID, username, password string, scope, resources []string) (*jose.JWT, *jose.JWT, string, time.Time, error)
+
+	// RefreshToken takes a previously generated refresh token and returns a new ID token, access token
+	// and new refresh token if the token is valid.
+	RefreshToken(creds oidc.ClientCredentials, scopes scope.Scopes, token string, resources []string) (*jose.JWT, *jose.JWT, string, time.Time, error)
 
 	KillSession(string) error
 
+	// LoginErrorURL ends the session referred to by sessionKey, whose user
+	// couldn't be logged in, returning the URL which passes err to the
+	// client.
+	LoginErrorURL(sessionKey string, err error) (string, error)
+
 	CrossClientAuthAllowed(requestingClientID, authorizingClientID string) (bool, error)
+
+	// Introspect reports whether a token issued by this server is active, and
+	// if so, the metadata associated with it.
+	Introspect(creds oidc.ClientCredentials, token string) (*Introspection, error)
+
+	// RevokeToken revokes a refresh token issued to the client.
+	RevokeToken(creds oidc.ClientCredentials, token string) error
+
+	// UserInfo returns claims about the user the bearer token was issued for.
+	UserInfo(token string) (jose.Claims, error)
+
+	// NewDeviceGrant starts a device authorization for the client.
+	NewDeviceGrant(creds oidc.ClientCredentials, scope []string) (*device.Grant, error)
+
+	// DeviceToken exchanges an approved device code for an ID token, an access
+	// token and a refresh token string.
+	DeviceToken(creds oidc.ClientCredentials, deviceCode string, resources []string) (*jose.JWT, *jose.JWT, string, time.Time, error)
+
+	// ExchangeToken exchanges a token issued to a user for an access token
+	// the client can use on the user's behalf (RFC 8693). The returned time is
+	// the expiry of the access token.
+	ExchangeToken(creds oidc.ClientCredentials, req TokenExchangeRequest) (*jose.JWT, time.Time, error)
+
+	// IDTokenHintSubject returns the user an ID token passed as an
+	// id_token_hint was issued for, if it was issued to the client by this server.
+	IDTokenHintSubject(clientID, hint string) (string, error)
+
+	// ConsentRequired reports whether the user has to approve the client's
+	// access to the scopes before logging in to it.
+	ConsentRequired(clientID, userID string, scopes scope.Scopes) (bool, error)
+
+	// AuthRequestParams returns the parameters of an authorization request,
+	// resolving those passed in a request object or pushed by the client.
+	AuthRequestParams(q url.Values) (url.Values, error)
+
+	// PushAuthRequest stores an authorization request pushed by the client.
+	PushAuthRequest(creds oidc.ClientCredentials, params url.Values) (*par.Request, error)
+
+	// DeletePushedAuthRequest deletes a pushed authorization request once
+	// it has been used.
+	DeletePushedAuthRequest(requestURI string) error
+}
+
+// JWTVerifier checks the claims and signature of a JWT.
+type JWTVerifier interface {
+	Verify(jwt jose.JWT) error
 }
 
-type JWTVerifierFactory func(clientID string) oidc.JWTVerifier
+type JWTVerifierFactory func(clientID string) JWTVerifier
 
 type Server struct {
 	IssuerURL url.URL
@@ -74,6 +156,10 @@ type Server struct {
 	SendResetPasswordEmailTemplate *template.Template
 	ResetPasswordTemplate          *template.Template
 	OOBTemplate                    *template.Template
+	DeviceTemplate                 *template.Template
+	LogoutTemplate                 *template.Template
+	ConsentTemplate                *template.Template
+	FormPostTemplate               *template.Template
 
 	HealthChecks []health.Checkable
 	// TODO(ericchiang): Make this a map of ID to connector.
@@ -83,9 +169,16 @@ type Server struct {
 	ConnectorConfigRepo connector.ConnectorConfigRepo
 	KeySetRepo          key.PrivateKeySetRepo
 	RefreshTokenRepo    refresh.RefreshTokenRepo
+	DeviceGrantRepo     device.GrantRepo
+	SSOSessionRepo      session.SSOSessionRepo
+	ConsentRepo         consent.ConsentRepo
+	ClientAssertionRepo client.AssertionRepo
 	UserRepo            user.UserRepo
 	PasswordInfoRepo    user.PasswordInfoRepo
 
+	PushedAuthRequestRepo par.RequestRepo
+	PairwiseSubjectRepo   user.PairwiseSubjectRepo
+
 	ClientManager  *clientmanager.ClientManager
 	KeyManager     key.PrivateKeyManager
 	SessionManager *sessionmanager.SessionManager
@@ -98,6 +191,55 @@ type Server struct {
 	EnableClientCredentialAccess bool
 	RegisterOnFirstLogin         bool
 
+	// AccessTokenValidityWindow is the lifetime of access tokens issued to
+	// clients without their own. If zero, DefaultAccessTokenValidityWindow is
+	// used.
+	AccessTokenValidityWindow time.Duration
+
+	// SSOSessionValidityWindow is how long users stay logged in to dex. If
+	// zero, session.DefaultSSOSessionValidityWindow is used.
+	SSOSessionValidityWindow time.Duration
+
+	// RefreshTokenAbsoluteLifetime limits how long after users authorize a
+	// client its refresh tokens can be used, and RefreshTokenIdleLifetime how
+	// long a refresh token can be used before it must be renewed. They're
+	// unlimited if zero.
+	RefreshTokenAbsoluteLifetime time.Duration
+	RefreshTokenIdleLifetime     time.Duration
+
+	// PasswordGrantConnectorID is the connector used to check the resource
+	// owner's credentials when a password grant request doesn't name one.
+	PasswordGrantConnectorID string
+
+	// BackchannelLogoutClient delivers logout tokens to clients. If nil, an
+	// HTTP client with a 10 second timeout is used.
+	BackchannelLogoutClient phttp.Client
+
+	// ClientJWKSClient fetches the keys of clients which authenticate with
+	// JWTs signed by keys served from their jwks_uri. If nil, an HTTP client
+	// with a 10 second timeout is used.
+	ClientJWKSClient phttp.Client
+
+	// SectorIdentifierClient fetches the redirect URIs listed by the sector
+	// identifier URIs of clients registering the pairwise subject type. If
+	// nil, an HTTP client with a 10 second timeout is used.
+	SectorIdentifierClient phttp.Client
+
+	// PairwiseSubjectSalt is hashed into the subjects of users of clients
+	// which registered the pairwise subject type. It must be kept secret and
+	// never change, or the subjects would change too. Without it, no tokens
+	// are issued to pairwise clients, and none can register.
+	PairwiseSubjectSalt []byte
+
+	// AllowedOrigins are the origins, besides those of clients' redirect
+	// URIs, whose scripts may call the discovery, keys, token and UserInfo
+	// endpoints. "*" allows every origin.
+	AllowedOrigins []string
+
+	// clientOrigins caches the origins of clients' redirect URIs, which are
+	// allowed too.
+	clientOrigins originCache
+
 	dbMap            *gorp.DbMap
 	localConnectorID string
 }
@@ -134,21 +276,69 @@ func (s *Server) KillSession(sessionKey string) error {
 	return err
 }
 
-func (s *Server) ProviderConfig() oidc.ProviderConfig {
+var (
+	// scopesSupported are the scopes clients may request, aside from
+	// cross-client scopes.
+	scopesSupported = []string{"openid", "email", "profile", scope.ScopeGroups, "offline_access"}
+
+	// claimsSupported are the claims which may be returned in ID tokens or
+	// from the UserInfo endpoint.
+	claimsSupported = []string{"aud", "auth_time", "email", "email_verified", "exp", "groups", "iat", "iss", "name", "nonce", "sid", "sub"}
+)
+
+func (s *Server) ProviderConfig() ProviderConfig {
 	authEndpoint := s.absURL(httpPathAuth)
 	tokenEndpoint := s.absURL(httpPathToken)
 	keysEndpoint := s.absURL(httpPathKeys)
-	cfg := oidc.ProviderConfig{
-		Issuer:        &s.IssuerURL,
-		AuthEndpoint:  &authEndpoint,
-		TokenEndpoint: &tokenEndpoint,
-		KeysEndpoint:  &keysEndpoint,
+	introspectionEndpoint := s.absURL(httpPathIntrospect)
+	revocationEndpoint := s.absURL(httpPathRevoke)
+	userInfoEndpoint := s.absURL(httpPathUserInfo)
+	deviceAuthEndpoint := s.absURL(httpPathDeviceCode)
+	parEndpoint := s.absURL(httpPathPushedAuthRequest)
+	endSessionEndpoint := s.absURL(httpPathLogout)
+	subjectTypesSupported := []string{oidc.SubjectTypePublic}
+	if len(s.PairwiseSubjectSalt) > 0 {
+		subjectTypesSupported = append(subjectTypesSupported, oidc.SubjectTypePairwise)
+	}
+	cfg := ProviderConfig{
+		ProviderConfig: oidc.ProviderConfig{
+			Issuer:        &s.IssuerURL,
+			AuthEndpoint:  &authEndpoint,
+			TokenEndpoint: &tokenEndpoint,
+			KeysEndpoint:  &keysEndpoint,
+
+			UserInfoEndpoint:   &userInfoEndpoint,
+			EndSessionEndpoint: &endSessionEndpoint,
+
+			GrantTypesSupported:               []string{oauth2.GrantTypeAuthCode, oauth2.GrantTypeImplicit, oauth2.GrantTypeClientCreds, oauth2.GrantTypeUserCreds, device.GrantTypeDeviceCode, client.GrantTypeTokenExchange},
+			ResponseTypesSupported:            responseTypesSupported,
+			ResponseModesSupported:            responseModesSupported,
+			SubjectTypesSupported:             subjectTypesSupported,
+			ScopesSupported:                   scopesSupported,
+			ClaimsSupported:                   claimsSupported,
+			IDTokenSigningAlgValues:           s.SigningAlgorithms(),
+			TokenEndpointAuthMethodsSupported: clientAuthMethodsSupported,
+
+			TokenEndpointAuthSigningAlgValuesSupported: clientAuthSigningAlgsSupported,
+
+			ClaimsParameterSupported:  true,
+			RequestParameterSupported: true,
+			ReqObjSigningAlgValues:    clientAuthSigningAlgsSupported,
+		},
+		CodeChallengeMethodsSupported: codeChallengeMethodsSupported,
+
+		IntrospectionEndpoint:                     &introspectionEndpoint,
+		IntrospectionEndpointAuthMethodsSupported: clientAuthMethodsSupported,
+
+		RevocationEndpoint:                     &revocationEndpoint,
+		RevocationEndpointAuthMethodsSupported: clientAuthMethodsSupported,
 
-		GrantTypesSupported:               []string{oauth2.GrantTypeAuthCode, oauth2.GrantTypeClientCreds},
-		ResponseTypesSupported:            []string{"code"},
-		SubjectTypesSupported:             []string{"public"},
-		IDTokenSigningAlgValues:           []string{"RS256"},
-		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic"},
+		DeviceAuthorizationEndpoint: &deviceAuthEndpoint,
+
+		PushedAuthorizationRequestEndpoint: &parEndpoint,
+
+		BackchannelLogoutSupported:        true,
+		BackchannelLogoutSessionSupported: true,
 	}
 
 	if s.EnableClientRegistration {
@@ -238,11 +428,22 @@ func (s *Server) HTTPHandler() http.Handler {
 		}
 	}
 
-	handleFunc(httpPathDiscovery, handleDiscoveryFunc(s.ProviderConfig()))
-	handleFunc(httpPathAuth, handleAuthFunc(s, s.IssuerURL, s.Connectors, s.LoginTemplate, s.EnableRegistration))
+	handle(httpPathDiscovery, s.handleCORS(handleDiscoveryFunc(s.ProviderConfig), "GET"))
+	handleFunc(httpPathAuth, handleAuthFunc(s, s.IssuerURL, s.Connectors, s.LoginTemplate, s.FormPostTemplate, s.EnableRegistration))
 	handleFunc(httpPathOOB, handleOOBFunc(s, s.OOBTemplate))
-	handleFunc(httpPathToken, handleTokenFunc(s))
-	handleFunc(httpPathKeys, handleKeysFunc(s.KeyManager, clock))
+	handle(httpPathToken, s.handleCORS(handleTokenFunc(s), "POST"))
+	handle(httpPathKeys, s.handleCORS(handleKeysFunc(s.KeyManager, clock), "GET"))
+	handleFunc(httpPathIntrospect, handleIntrospectFunc(s))
+	handleFunc(httpPathRevoke, handleRevokeFunc(s))
+	handle(httpPathUserInfo, s.handleCORS(handleUserInfoFunc(s), "GET", "POST"))
+	handleFunc(httpPathDeviceCode, handleDeviceCodeFunc(s, s.absURL(httpPathDevice)))
+	handleFunc(httpPathDevice, handleDeviceFunc(s, s.DeviceTemplate))
+	handleFunc(httpPathDeviceCallback, handleDeviceCallbackFunc(s, s.DeviceTemplate))
+	handleFunc(httpPathLogout, handleLogoutFunc(s, s.LogoutTemplate))
+	handleFunc(httpPathConsent, handleConsentFunc(s, s.ConsentTemplate))
+	handleFunc(httpPathFormPost, handleFormPostFunc(s, s.FormPostTemplate))
+	handleFunc(httpPathSSOStart, handleSSOStartFunc(s))
+	handleFunc(httpPathPushedAuthRequest, handlePushedAuthRequestFunc(s))
 	handle(httpPathHealth, makeHealthHandler(checks))
 
 	if s.EnableRegistration {
@@ -283,6 +484,7 @@ func (s *Server) HTTPHandler() http.Handler {
 
 	if s.EnableClientRegistration {
 		handleFunc(httpPathClientRegistration, s.handleClientRegistration)
+		handleFunc(httpPathClientRegistration+"/", s.handleClientConfiguration)
 	}
 
 	handleFunc(httpPathDebugVars, health.ExpvarHandler)
@@ -301,7 +503,7 @@ func (s *Server) HTTPHandler() http.Handler {
 	apiBasePath := path.Join(httpPathAPI, APIVersion)
 	registerDiscoveryResource(apiBasePath, mux)
 
-	usersAPI := usersapi.NewUsersAPI(s.UserManager, s.ClientManager, s.RefreshTokenRepo, s.UserEmailer, s.localConnectorID, s.EnableClientCredentialAccess)
+	usersAPI := usersapi.NewUsersAPI(s.UserManager, s.ClientManager, s.RefreshTokenRepo, s.SSOSessionRepo, s.ConsentRepo, s.PairwiseSubjectRepo, s, s.UserEmailer, s.localConnectorID, s.EnableClientCredentialAccess)
 	handler := NewUserMgmtServer(usersAPI, s.JWTVerifierFactory(), s.UserManager, s.ClientManager, s.EnableClientCredentialAccess).HTTPHandler()
 
 	handleStripPrefix(apiBasePath+"/", handler)
@@ -323,13 +525,13 @@ func (s *Server) Client(clientID string) (client.Client, error) {
 	return s.ClientManager.Get(clientID)
 }
 
-func (s *Server) NewSession(ipdcID, clientID, clientState string, redirectURL url.URL, nonce string, register bool, scope []string) (string, error) {
-	sessionID, err := s.SessionManager.NewSession(ipdcID, clientID, clientState, redirectURL, nonce, register, scope)
+func (s *Server) NewSession(req session.AuthRequest) (string, error) {
+	sessionID, err := s.SessionManager.NewSession(req)
 	if err != nil {
 		return "", err
 	}
 
-	log.Infof("Session %s created: clientID=%s clientState=%s", sessionID, clientID, clientState)
+	log.Infof("Session %s created: clientID=%s clientState=%s", sessionID, req.ClientID, req.ClientState)
 	return s.SessionManager.NewSessionKey(sessionID)
 }
 
@@ -348,39 +550,110 @@ func (s *Server) Login(ident oidc.Identity, key string) (string, error) {
 		return "", err
 	}
 
-	ses, err := s.SessionManager.AttachRemoteIdentity(sessionID, ident)
+	// Once the session is known, errors are passed to the client rather
+	// than shown to the user by the connector.
+	ses, redirect, err := s.login(sessionID, ident, s.SessionManager.Clock.Now())
 	if err != nil {
-		return "", err
+		log.Errorf("Session %s login failed: %v", sessionID, err)
+		return s.loginErrorURL(sessionID, err)
+	}
+	if redirect != "" {
+		return redirect, nil
+	}
+
+	if ses.SSOLoginNonce != "" {
+		// The connector's response may not be served to the browser which
+		// started the session, so whether to start an SSO session is decided
+		// in a further step.
+		ru, err := s.ssoStartURL(sessionID)
+		if err != nil {
+			log.Errorf("Session %s failed creating SSO start URL: %v", sessionID, err)
+			return s.loginErrorURL(sessionID, err)
+		}
+		return ru, nil
+	}
+
+	ru, err := s.authResponseURL(ses)
+	if err != nil {
+		log.Errorf("Session %s failed creating authorization response: %v", sessionID, err)
+		return s.loginErrorURL(sessionID, err)
+	}
+	return ru, nil
+}
+
+func (s *Server) LoginErrorURL(sessionKey string, err error) (string, error) {
+	sessionID, kerr := s.SessionManager.ExchangeKey(sessionKey)
+	if kerr != nil {
+		return "", kerr
+	}
+	return s.loginErrorURL(sessionID, err)
+}
+
+// loginErrorURL ends a session whose user couldn't be logged in, returning the
+// URL which passes err to the client. Clients using the OOB redirect URL
+// can't be redirected to, so the error is shown to the user instead. Errors
+// for clients using the form_post response mode are posted to them by the
+// form post page.
+func (s *Server) loginErrorURL(sessionID string, err error) (string, error) {
+	ses, kerr := s.SessionManager.Kill(sessionID)
+	if kerr != nil {
+		return "", fmt.Errorf("killing session: %v", kerr)
+	}
+
+	ru := ses.RedirectURL
+	if ru.String() == client.OOBRedirectURI {
+		ru = s.absURL(httpPathOOB)
+	} else if ses.ResponseMode == responseModeFormPost {
+		oerr, ok := err.(*oauth2.Error)
+		if !ok {
+			oerr = oauth2.NewError(oauth2.ErrorServerError)
+		}
+		if _, kerr := s.SessionManager.AttachAuthError(sessionID, oerr); kerr != nil {
+			return "", fmt.Errorf("attaching error to session: %v", kerr)
+		}
+		return s.formPostURL(sessionID)
+	}
+	return authErrorURL(err, ses.ClientState, ru, ses.ResponseMode), nil
+}
+
+// login attaches the remote identity the user authenticated as at authTime to
+// the session and looks up the dex user it belongs to. If the user must
+// interact with dex further, for instance to register, the URL to redirect
+// them to is returned instead.
+func (s *Server) login(sessionID string, ident oidc.Identity, authTime time.Time) (*session.Session, string, error) {
+	ses, err := s.SessionManager.AttachRemoteIdentityAt(sessionID, ident, authTime)
+	if err != nil {
+		return nil, "", err
 	}
 	log.Infof("Session %s remote identity attached: clientID=%s identity=%#v", sessionID, ses.ClientID, ident)
 
 	// Get the connector used to log the user in.
 	conn, ok := s.connector(ses.ConnectorID)
 	if !ok {
-		return "", fmt.Errorf("session contained invalid connector ID (%s)", ses.ConnectorID)
+		return nil, "", fmt.Errorf("session contained invalid connector ID (%s)", ses.ConnectorID)
 	}
 
 	// If the client has requested access to groups, add them here.
-	if ses.Scope.HasScope(scope.ScopeGroups) {
+	if ses.GroupsRequested() {
 		grouper, ok := conn.(connector.GroupsConnector)
 		if !ok {
-			return "", fmt.Errorf("scope %q provided but connector does not support groups", scope.ScopeGroups)
+			return nil, "", fmt.Errorf("groups requested but connector does not support groups")
 		}
 		groups, err := grouper.Groups(ident.ID)
 		if err != nil {
-			return "", fmt.Errorf("failed to retrieve user groups for %q %v", ident.ID, err)
+			return nil, "", fmt.Errorf("failed to retrieve user groups for %q %v", ident.ID, err)
 		}
 
 		// Update the session.
 		if ses, err = s.SessionManager.AttachGroups(sessionID, groups); err != nil {
-			return "", fmt.Errorf("failed save groups")
+			return nil, "", fmt.Errorf("failed save groups")
 		}
 	}
 
 	if ses.Register {
 		code, err := s.SessionManager.NewSessionKey(sessionID)
 		if err != nil {
-			return "", err
+			return nil, "", err
 		}
 
 		ru := s.absURL(httpPathRegister)
@@ -388,7 +661,7 @@ func (s *Server) Login(ident oidc.Identity, key string) (string, error) {
 		q.Set("code", code)
 		q.Set("state", ses.ClientState)
 		ru.RawQuery = q.Encode()
-		return ru.String(), nil
+		return nil, ru.String(), nil
 	}
 
 	remoteIdentity := user.RemoteIdentity{ConnectorID: ses.ConnectorID, ID: ses.Identity.ID}
@@ -398,14 +671,14 @@ func (s *Server) Login(ident oidc.Identity, key string) (string, error) {
 		if ses.Identity.Email == "" {
 			// User doesn't have an existing account. Ask them to register.
 			u := newLoginURLFromSession(s.IssuerURL, ses, true, []string{ses.ConnectorID}, "register-maybe")
-			return u.String(), nil
+			return nil, u.String(), nil
 		}
 
 		// Does the user have an existing account with a different connector?
 		if connID, err := getConnectorForUserByEmail(s.UserRepo, ses.Identity.Email); err == nil {
 			// Ask user to sign in through existing account.
 			u := newLoginURLFromSession(s.IssuerURL, ses, false, []string{connID}, "wrong-connector")
-			return u.String(), nil
+			return nil, u.String(), nil
 		}
 
 		// RegisterOnFirstLogin doesn't work for the local connector
@@ -414,261 +687,356 @@ func (s *Server) Login(ident oidc.Identity, key string) (string, error) {
 		if !tryToRegister {
 			// User doesn't have an existing account. Ask them to register.
 			u := newLoginURLFromSession(s.IssuerURL, ses, true, []string{ses.ConnectorID}, "register-maybe")
-			return u.String(), nil
+			return nil, u.String(), nil
 		}
 
 		// First time logging in through a remote connector. Attempt to register.
 		emailVerified := conn.TrustedEmailProvider()
 		usrID, err := s.UserManager.RegisterWithRemoteIdentity(ses.Identity.Email, emailVerified, remoteIdentity)
 		if err != nil {
-			return "", fmt.Errorf("failed to register user: %v", err)
+			return nil, "", fmt.Errorf("failed to register user: %v", err)
 		}
 		usr, err = s.UserManager.Get(usrID)
 		if err != nil {
-			return "", fmt.Errorf("getting created user: %v", err)
+			return nil, "", fmt.Errorf("getting created user: %v", err)
 		}
 
 		if ses.Identity.Name != "" && ses.Identity.Name != usr.DisplayName {
 			err = s.UserManager.SetDisplayName(usr, ses.Identity.Name)
 			if err != nil {
-				return "", fmt.Errorf("couldn't set display name for user: %v", err)
+				return nil, "", fmt.Errorf("couldn't set display name for user: %v", err)
 			}
 		}
 	} else if err != nil {
-		return "", fmt.Errorf("getting user: %v", err)
+		return nil, "", fmt.Errorf("getting user: %v", err)
 	}
 
 	if usr.Disabled {
 		log.Errorf("user %s disabled", ses.Identity.Email)
-		return "", user.ErrorNotFound
+		err := oauth2.NewError(oauth2.ErrorAccessDenied)
+		err.Description = "user account is disabled"
+		return nil, "", err
 	}
 
 	ses, err = s.SessionManager.AttachUser(sessionID, usr.ID)
 	if err != nil {
-		return "", fmt.Errorf("attaching user to session: %v", err)
+		return nil, "", fmt.Errorf("attaching user to session: %v", err)
 	}
 	log.Infof("Session %s user identified: clientID=%s user=%#v", sessionID, ses.ClientID, usr)
 
-	code, err := s.SessionManager.NewSessionKey(sessionID)
-	if err != nil {
-		return "", fmt.Errorf("creating new session key: %v", err)
-	}
-
-	ru := ses.RedirectURL
-	if ru.String() == client.OOBRedirectURI {
-		ru = s.absURL(httpPathOOB)
-	}
-	q := ru.Query()
-	q.Set("code", code)
-	q.Set("state", ses.ClientState)
-	ru.RawQuery = q.Encode()
-
-	return ru.String(), nil
+	return ses, "", nil
 }
 
-func (s *Server) ClientCredsToken(creds oidc.ClientCredentials) (*jose.JWT, time.Time, error) {
+func (s *Server) ClientCredsToken(creds oidc.ClientCredentials, resources []string) (*jose.JWT, *jose.JWT, time.Time, error) {
 	cli, err := s.Client(creds.ID)
 	if err != nil {
-		return nil, time.Time{}, err
+		return nil, nil, time.Time{}, err
 	}
 
 	if cli.Public {
-		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorInvalidClient)
+		return nil, nil, time.Time{}, oauth2.NewError(oauth2.ErrorInvalidClient)
 	}
 
-	ok, err := s.ClientManager.Authenticate(creds)
-	if err != nil {
-		log.Errorf("Failed fetching client %s from manager: %v", creds.ID, err)
-		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	if err := s.authenticateClient(creds, false); err != nil {
+		return nil, nil, time.Time{}, err
 	}
-	if !ok {
-		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorInvalidClient)
+
+	if !cli.GrantTypeAllowed(oauth2.GrantTypeClientCreds) {
+		log.Errorf("Client %s is not allowed to use the client credentials grant", creds.ID)
+		return nil, nil, time.Time{}, oauth2.NewError(oauth2.ErrorUnauthorizedClient)
 	}
 
+	aud, err := s.accessTokenAudience(creds.ID, resources)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	// The client authenticates to dex's APIs with this ID token, so it's
+	// signed with RS256 regardless of the client's
+	// id_token_signed_response_alg.
 	signer, err := s.KeyManager.Signer()
 	if err != nil {
 		log.Errorf("Failed to generate ID token: %v", err)
-		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
 	}
 
 	now := time.Now()
-	exp := now.Add(s.SessionManager.ValidityWindow)
+	exp := now.Add(idTokenLifetime(cli, s.SessionManager.ValidityWindow))
 	claims := oidc.NewClaims(s.IssuerURL.String(), creds.ID, creds.ID, now, exp)
 	claims.Add("name", creds.ID)
 
 	jwt, err := jose.NewSignedJWT(claims, signer)
 	if err != nil {
 		log.Errorf("Failed to generate ID token: %v", err)
-		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	accessToken, expiresAt, err := s.accessToken(creds.ID, creds.ID, aud, nil, nil, nil, nil)
+	if err != nil {
+		return nil, nil, time.Time{}, err
 	}
 
 	log.Infof("Client token sent: clientID=%s", creds.ID)
 
-	return jwt, exp, nil
+	return jwt, accessToken, expiresAt, nil
 }
 
-func (s *Server) CodeToken(creds oidc.ClientCredentials, sessionKey string) (*jose.JWT, string, time.Time, error) {
-	ok, err := s.ClientManager.Authenticate(creds)
-	if err != nil {
-		log.Errorf("Failed fetching client %s from repo: %v", creds.ID, err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+func (s *Server) CodeToken(creds oidc.ClientCredentials, sessionKey, codeVerifier string, resources []string) (*jose.JWT, *jose.JWT, string, time.Time, error) {
+	// Public clients using PKCE are not required to present a secret. The
+	// code verifier is checked against the session's code challenge below.
+	if err := s.authenticateClient(creds, codeVerifier != ""); err != nil {
+		return nil, nil, "", time.Time{}, err
 	}
-	if !ok {
-		log.Errorf("Failed to Authenticate client %s", creds.ID)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidClient)
+
+	if err := s.checkGrantType(creds.ID, oauth2.GrantTypeAuthCode); err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	aud, err := s.accessTokenAudience(creds.ID, resources)
+	if err != nil {
+		return nil, nil, "", time.Time{}, err
 	}
 
 	sessionID, err := s.SessionManager.ExchangeKey(sessionKey)
 	if err != nil {
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
 	}
 
 	ses, err := s.SessionManager.Kill(sessionID)
 	if err != nil {
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidRequest)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidRequest)
 	}
 
 	if ses.ClientID != creds.ID {
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
 	}
 
-	signer, err := s.KeyManager.Signer()
-	if err != nil {
-		log.Errorf("Failed to generate ID token: %v", err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	if ses.CodeChallenge != "" || codeVerifier != "" {
+		if !verifyCodeChallenge(ses.CodeChallenge, ses.CodeChallengeMethod, codeVerifier) {
+			log.Errorf("Session %s code verifier did not match code challenge", sessionID)
+			err := oauth2.NewError(oauth2.ErrorInvalidGrant)
+			err.Description = "invalid code_verifier"
+			return nil, nil, "", time.Time{}, err
+		}
 	}
 
-	user, err := s.UserRepo.Get(nil, ses.UserID)
+	jwt, accessToken, refreshToken, expiresAt, err := s.sessionToken(ses, aud)
 	if err != nil {
-		log.Errorf("Failed to fetch user %q from repo: %v: ", ses.UserID, err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, "", time.Time{}, err
 	}
 
-	claims := ses.Claims(s.IssuerURL.String())
-	user.AddToClaims(claims)
+	log.Infof("Session %s token sent: clientID=%s", sessionID, creds.ID)
+	return jwt, accessToken, refreshToken, expiresAt, nil
+}
 
-	s.addClaimsFromScope(claims, ses.Scope, ses.ClientID)
+// sessionToken issues an ID token and an access token for a session which has
+// identified a user, along with a refresh token if the session requested
+// offline access. The returned time is the expiry of the access token.
+func (s *Server) sessionToken(ses *session.Session, aud []string) (*jose.JWT, *jose.JWT, string, time.Time, error) {
+	cli, err := s.Client(ses.ClientID)
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", ses.ClientID, err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
 
-	jwt, err := jose.NewSignedJWT(claims, signer)
+	accessToken, expiresAt, err := s.sessionAccessToken(ses, aud)
 	if err != nil {
-		log.Errorf("Failed to generate ID token: %v", err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, "", time.Time{}, err
 	}
 
-	// Generate refresh token when 'scope' contains 'offline_access'.
+	jwt, err := s.sessionIDToken(ses, cli, accessToken, "")
+	if err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	// Generate refresh token when 'scope' contains 'offline_access', unless
+	// the client isn't allowed to use it.
 	var refreshToken string
 
 	for _, scope := range ses.Scope {
-		if scope == "offline_access" {
-			log.Infof("Session %s requests offline access, will generate refresh token", sessionID)
+		if scope == "offline_access" && cli.GrantTypeAllowed(oauth2.GrantTypeRefreshToken) {
+			log.Infof("Session %s requests offline access, will generate refresh token", ses.ID)
 
-			refreshToken, err = s.RefreshTokenRepo.Create(ses.UserID, creds.ID, ses.ConnectorID, ses.Scope)
+			refreshToken, err = s.RefreshTokenRepo.Create(ses.UserID, ses.ClientID, ses.ConnectorID, ses.Scope, ses.ClaimsRequest)
 			switch err {
 			case nil:
 				break
 			default:
 				log.Errorf("Failed to generate refresh token: %v", err)
-				return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+				return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
 			}
 			break
 		}
 	}
 
-	log.Infof("Session %s token sent: clientID=%s", sessionID, creds.ID)
-	return jwt, refreshToken, ses.ExpiresAt, nil
+	return jwt, accessToken, refreshToken, expiresAt, nil
 }
 
-func (s *Server) RefreshToken(creds oidc.ClientCredentials, scopes scope.Scopes, token string) (*jose.JWT, string, time.Time, error) {
-	ok, err := s.ClientManager.Authenticate(creds)
+// sessionIDToken issues an ID token for a session which has identified a user.
+// If an access token or an authorization code is issued alongside it, their
+// hashes are included in the at_hash and c_hash claims.
+func (s *Server) sessionIDToken(ses *session.Session, cli client.Client, accessToken *jose.JWT, code string) (*jose.JWT, error) {
+	signer, err := s.idTokenSigner(ses.ClientID)
+	if err != nil {
+		log.Errorf("Failed to generate ID token: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	user, err := s.UserRepo.Get(nil, ses.UserID)
+	if err != nil {
+		log.Errorf("Failed to fetch user %q from repo: %v: ", ses.UserID, err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	sub, err := s.subject(ses.ClientID, ses.UserID)
+	if err != nil {
+		log.Errorf("Failed to determine subject of user %q: %v", ses.UserID, err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	claims := ses.Claims(s.IssuerURL.String())
+	claims.Add("sub", sub)
+	if cli.IDTokenLifetime > 0 {
+		claims.Add("exp", ses.CreatedAt.Add(cli.IDTokenLifetime).Unix())
+	}
+	user.AddToClaims(claims, ses.ClaimsRequest.IDTokenClaims())
+
+	s.addClaimsFromScope(claims, ses.Scope, ses.ClientID)
+
+	if accessToken != nil {
+		claims.Add("at_hash", tokenHash(signer.Alg(), accessToken.Encode()))
+	}
+	if code != "" {
+		claims.Add("c_hash", tokenHash(signer.Alg(), code))
+	}
+
+	jwt, err := jose.NewSignedJWT(claims, signer)
+	if err != nil {
+		log.Errorf("Failed to generate ID token: %v", err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	return jwt, nil
+}
+
+// sessionAccessToken issues an access token for a session which has identified
+// a user.
+func (s *Server) sessionAccessToken(ses *session.Session, aud []string) (*jose.JWT, time.Time, error) {
+	sub, err := s.subject(ses.ClientID, ses.UserID)
+	if err != nil {
+		log.Errorf("Failed to determine subject of user %q: %v", ses.UserID, err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	// The UserInfo endpoint returns the groups found in the access token, and
+	// the claims it names.
+	var groups []string
+	if ses.UserInfoGroupsRequested() {
+		groups = ses.Groups
+		if groups == nil {
+			groups = []string{}
+		}
+	}
+
+	return s.accessToken(ses.ClientID, sub, aud, ses.Scope, groups, ses.ClaimsRequest.UserInfoClaims(), nil)
+}
+
+func (s *Server) RefreshToken(creds oidc.ClientCredentials, scopes scope.Scopes, token string, resources []string) (*jose.JWT, *jose.JWT, string, time.Time, error) {
+	if err := s.authenticateClient(creds, false); err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	cli, err := s.Client(creds.ID)
 	if err != nil {
 		log.Errorf("Failed fetching client %s from repo: %v", creds.ID, err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
 	}
-	if !ok {
-		log.Errorf("Failed to Authenticate client %s", creds.ID)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidClient)
+	if !cli.GrantTypeAllowed(oauth2.GrantTypeRefreshToken) {
+		log.Errorf("Client %s is not allowed to use the refresh token grant", creds.ID)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorUnauthorizedClient)
 	}
 
-	userID, connectorID, rtScopes, err := s.RefreshTokenRepo.Verify(creds.ID, token)
+	aud, err := s.accessTokenAudience(creds.ID, resources)
+	if err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	userID, connectorID, rtScopes, claimsReq, err := s.RefreshTokenRepo.Verify(creds.ID, token)
 	switch err {
 	case nil:
 		break
 	case refresh.ErrorInvalidToken:
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidRequest)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidRequest)
 	case refresh.ErrorInvalidClientID:
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidClient)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidClient)
 	default:
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	// Users who were disabled or deleted since the token was issued lose the
+	// access it grants, so its family is revoked for good.
+	usr, err := s.UserRepo.Get(nil, userID)
+	switch {
+	case err == user.ErrorNotFound || (err == nil && usr.Disabled):
+		log.Infof("Refresh token of client %s presented for disabled or deleted user %s, revoking it", creds.ID, userID)
+		if err := s.RefreshTokenRepo.Revoke(userID, token); err != nil {
+			log.Errorf("Failed to revoke refresh token of user %s: %v", userID, err)
+			return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		}
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	case err != nil:
+		log.Errorf("Failed to fetch user %q from repo: %v: ", userID, err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
 	}
 
 	if len(scopes) == 0 {
 		scopes = rtScopes
 	} else {
 		if !rtScopes.Contains(scopes) {
-			return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidRequest)
+			return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidRequest)
 		}
 	}
 
-	usr, err := s.UserRepo.Get(nil, userID)
-	if err != nil {
-		// The error can be user.ErrorNotFound, but we are not deleting
-		// user at this moment, so this shouldn't happen.
-		log.Errorf("Failed to fetch user %q from repo: %v: ", userID, err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
-	}
-
-	var groups []string
-	if rtScopes.HasScope(scope.ScopeGroups) {
-		conn, ok := s.connector(connectorID)
-		if !ok {
-			log.Errorf("refresh token contained invalid connector ID (%s)", connectorID)
-			return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	// The client may have been restricted since the token was issued.
+	for _, sc := range scopes {
+		if sc != "" && !cli.ScopeAllowed(sc) {
+			err := oauth2.NewError(errorInvalidScope)
+			err.Description = fmt.Sprintf("client is not allowed to request scope %q", sc)
+			return nil, nil, "", time.Time{}, err
 		}
+	}
 
-		grouper, ok := conn.(connector.GroupsConnector)
-		if !ok {
-			log.Errorf("refresh token requested groups for connector (%s) that doesn't support groups", connectorID)
-			return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
-		}
+	// The claims request the token was issued with applies to refreshed
+	// tokens too.
+	idTokenGroups := rtScopes.HasScope(scope.ScopeGroups) || pstrings.ContainsString(claimsReq.IDTokenClaims(), "groups")
+	userInfoGroups := rtScopes.HasScope(scope.ScopeGroups) || pstrings.ContainsString(claimsReq.UserInfoClaims(), "groups")
 
-		remoteIdentities, err := s.UserRepo.GetRemoteIdentities(nil, userID)
-		if err != nil {
-			log.Errorf("failed to get remote identities: %v", err)
-			return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
-		}
-		remoteIdentity, ok := func() (user.RemoteIdentity, bool) {
-			for _, ri := range remoteIdentities {
-				if ri.ConnectorID == connectorID {
-					return ri, true
-				}
-			}
-			return user.RemoteIdentity{}, false
-		}()
-		if !ok {
-			log.Errorf("failed to get remote identity for connector %s", connectorID)
-			return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
-		}
-		if groups, err = grouper.Groups(remoteIdentity.ID); err != nil {
-			log.Errorf("failed to get groups for refresh token: %v", connectorID)
-			return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	var groups []string
+	if idTokenGroups || userInfoGroups {
+		if groups, err = s.userGroups(userID, connectorID); err != nil {
+			log.Errorf("failed to get groups for refresh token: %v", err)
+			return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
 		}
 	}
 
-	signer, err := s.KeyManager.Signer()
+	signer, err := s.idTokenSigner(creds.ID)
 	if err != nil {
 		log.Errorf("Failed to refresh ID token: %v", err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
 	}
 
-	now := time.Now()
-	expiresAt := now.Add(session.DefaultSessionValidityWindow)
+	sub, err := s.subject(creds.ID, usr.ID)
+	if err != nil {
+		log.Errorf("Failed to determine subject of user %q: %v", usr.ID, err)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
 
-	claims := oidc.NewClaims(s.IssuerURL.String(), usr.ID, creds.ID, now, expiresAt)
-	usr.AddToClaims(claims)
-	if rtScopes.HasScope(scope.ScopeGroups) {
-		if groups == nil {
-			groups = []string{}
-		}
+	now := time.Now()
+	claims := oidc.NewClaims(s.IssuerURL.String(), sub, creds.ID, now, now.Add(idTokenLifetime(cli, session.DefaultSessionValidityWindow)))
+	usr.AddToClaims(claims, claimsReq.IDTokenClaims())
+	if groups == nil {
+		groups = []string{}
+	}
+	if idTokenGroups {
 		claims["groups"] = groups
 	}
 
@@ -677,18 +1045,83 @@ func (s *Server) RefreshToken(creds oidc.ClientCredentials, scopes scope.Scopes,
 	jwt, err := jose.NewSignedJWT(claims, signer)
 	if err != nil {
 		log.Errorf("Failed to generate ID token: %v", err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
 	}
 
-	refreshToken, err := s.RefreshTokenRepo.RenewRefreshToken(creds.ID, userID, token)
+	// The UserInfo endpoint returns the groups found in the access token.
+	var accessTokenGroups []string
+	if userInfoGroups {
+		accessTokenGroups = groups
+	}
+	accessToken, expiresAt, err := s.accessToken(creds.ID, sub, aud, scopes, accessTokenGroups, claimsReq.UserInfoClaims(), nil)
 	if err != nil {
+		return nil, nil, "", time.Time{}, err
+	}
+
+	refreshToken, err := s.RefreshTokenRepo.RenewRefreshToken(creds.ID, userID, token)
+	switch err {
+	case nil:
+	case refresh.ErrorInvalidToken:
+		// Another request rotated the token since it was verified.
+		log.Infof("Refresh token of client %s was rotated concurrently", creds.ID)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorInvalidGrant)
+	default:
 		log.Errorf("Failed to generate new refresh token: %v", err)
-		return nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+		return nil, nil, "", time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
 	}
 
 	log.Infof("New token sent: clientID=%s", creds.ID)
 
-	return jwt, refreshToken, expiresAt, nil
+	return jwt, accessToken, refreshToken, expiresAt, nil
+}
+
+// checkGrantType returns an unauthorized_client error if the client isn't
+// allowed to use the grant type.
+func (s *Server) checkGrantType(clientID, grantType string) error {
+	cli, err := s.Client(clientID)
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", clientID, err)
+		return oauth2.NewError(oauth2.ErrorServerError)
+	}
+	if !cli.GrantTypeAllowed(grantType) {
+		log.Errorf("Client %s is not allowed to use the %s grant", clientID, grantType)
+		return oauth2.NewError(oauth2.ErrorUnauthorizedClient)
+	}
+	return nil
+}
+
+// idTokenLifetime returns the lifetime of the ID tokens issued to the client,
+// which is def unless the client was configured with its own.
+func idTokenLifetime(cli client.Client, def time.Duration) time.Duration {
+	if cli.IDTokenLifetime > 0 {
+		return cli.IDTokenLifetime
+	}
+	return def
+}
+
+// userGroups returns the groups of the user's remote identity on the given
+// connector.
+func (s *Server) userGroups(userID, connectorID string) ([]string, error) {
+	conn, ok := s.connector(connectorID)
+	if !ok {
+		return nil, fmt.Errorf("invalid connector ID (%s)", connectorID)
+	}
+
+	grouper, ok := conn.(connector.GroupsConnector)
+	if !ok {
+		return nil, fmt.Errorf("connector (%s) doesn't support groups", connectorID)
+	}
+
+	remoteIdentities, err := s.UserRepo.GetRemoteIdentities(nil, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote identities: %v", err)
+	}
+	for _, ri := range remoteIdentities {
+		if ri.ConnectorID == connectorID {
+			return grouper.Groups(ri.ID)
+		}
+	}
+	return nil, fmt.Errorf("failed to get remote identity for connector %s", connectorID)
 }
 
 func (s *Server) CrossClientAuthAllowed(requestingClientID, authorizingClientID string) (bool, error) {
@@ -704,21 +1137,88 @@ func (s *Server) CrossClientAuthAllowed(requestingClientID, authorizingClientID
 	return false, nil
 }
 
+// JWTVerifierFactory returns verifiers of ID tokens issued by this server,
+// whichever algorithm they were signed with. Access tokens are rejected.
 func (s *Server) JWTVerifierFactory() JWTVerifierFactory {
-	noop := func() error { return nil }
+	return func(clientID string) JWTVerifier {
+		return jwtVerifierFunc(func(jwt jose.JWT) error {
+			if isAccessToken(jwt) {
+				return errors.New("token is an access token")
+			}
+			if err := oidc.VerifyClaims(jwt, s.IssuerURL.String(), clientID); err != nil {
+				return fmt.Errorf("JWT claims invalid: %v", err)
+			}
+			return s.verifySignature(jwt)
+		})
+	}
+}
 
-	keyFunc := func() []key.PublicKey {
-		keys, err := s.KeyManager.PublicKeys()
-		if err != nil {
-			log.Errorf("error getting public keys from manager: %v", err)
-			return []key.PublicKey{}
-		}
-		return keys
+type jwtVerifierFunc func(jwt jose.JWT) error
+
+func (f jwtVerifierFunc) Verify(jwt jose.JWT) error {
+	return f(jwt)
+}
+
+// verifyToken checks that the ID or access token was signed by this server and has not
+// expired, returning it along with its claims. Callers tell the two apart with
+// isAccessToken.
+func (s *Server) verifyToken(token string) (jose.JWT, jose.Claims, error) {
+	jwt, claims, err := s.verifyTokenSignature(token)
+	if err != nil {
+		return jose.JWT{}, nil, err
 	}
-	return func(clientID string) oidc.JWTVerifier {
+	exp, ok, err := claims.TimeClaim("exp")
+	if err != nil {
+		return jose.JWT{}, nil, err
+	}
+	if !ok || exp.Before(time.Now()) {
+		return jose.JWT{}, nil, errors.New("token is expired")
+	}
+	return jwt, claims, nil
+}
 
-		return oidc.NewJWTVerifier(s.IssuerURL.String(), clientID, noop, keyFunc)
+// verifyIDToken checks that the token is an ID token signed by this server,
+// returning its claims. The token may have expired. Access and logout tokens
+// are signed with the same keys, but are told apart by their typ header, and
+// logout tokens by their events claim too.
+func (s *Server) verifyIDToken(token string) (jose.Claims, error) {
+	jwt, claims, err := s.verifyTokenSignature(token)
+	if err != nil {
+		return nil, err
+	}
+	if typ := jwt.Header[jose.HeaderMediaType]; typ != "" && !strings.EqualFold(typ, "JWT") {
+		return nil, fmt.Errorf("token of type %q is not an ID token", typ)
+	}
+	if _, ok := claims["events"]; ok {
+		return nil, errors.New("token is a security event token")
+	}
+	return claims, nil
+}
+
+// verifyTokenSignature checks that the token was signed by this server,
+// returning it along with its claims. Unlike verifyToken, the token may have
+// expired.
+func (s *Server) verifyTokenSignature(token string) (jose.JWT, jose.Claims, error) {
+	jwt, err := jose.ParseJWT(token)
+	if err != nil {
+		return jose.JWT{}, nil, err
+	}
+
+	if err := s.verifySignature(jwt); err != nil {
+		return jose.JWT{}, nil, err
+	}
+
+	claims, err := jwt.Claims()
+	if err != nil {
+		return jose.JWT{}, nil, err
+	}
+	if iss, _, _ := claims.StringClaim("iss"); iss != s.IssuerURL.String() {
+		return jose.JWT{}, nil, fmt.Errorf("unexpected token issuer %q", iss)
+	}
+	if sub, _, _ := claims.StringClaim("sub"); sub == "" {
+		return jose.JWT{}, nil, errors.New("missing required 'sub' claim")
 	}
+	return jwt, claims, nil
 }
 
 // addClaimsFromScope adds claims that are based on the scopes that the client requested.
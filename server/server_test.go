@@ -4,6 +4,8 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
 	"strings"
@@ -14,13 +16,20 @@ import (
 	"github.com/coreos/go-oidc/key"
 	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
+	"github.com/jonboulle/clockwork"
 	"github.com/kylelemons/godebug/pretty"
 
 	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/consent"
 	"github.com/coreos/dex/db"
+	"github.com/coreos/dex/device"
+	pstrings "github.com/coreos/dex/pkg/strings"
+	"github.com/coreos/dex/refresh"
 	"github.com/coreos/dex/refresh/refreshtest"
 	"github.com/coreos/dex/scope"
+	"github.com/coreos/dex/session"
 	"github.com/coreos/dex/session/manager"
+	"github.com/coreos/dex/signing"
 	"github.com/coreos/dex/user"
 )
 
@@ -112,25 +121,63 @@ func getRefreshTokenEncoded(id, value string) string {
 }
 
 func TestServerProviderConfig(t *testing.T) {
-	srv := &Server{IssuerURL: url.URL{Scheme: "http", Host: "server.example.com"}}
+	srv := &Server{
+		IssuerURL:           url.URL{Scheme: "http", Host: "server.example.com"},
+		PairwiseSubjectSalt: []byte("salt"),
+	}
+
+	authMethods := []string{"client_secret_basic", "client_secret_post", "client_secret_jwt", "private_key_jwt"}
+	want := ProviderConfig{
+		ProviderConfig: oidc.ProviderConfig{
+			Issuer:        &url.URL{Scheme: "http", Host: "server.example.com"},
+			AuthEndpoint:  &url.URL{Scheme: "http", Host: "server.example.com", Path: "/auth"},
+			TokenEndpoint: &url.URL{Scheme: "http", Host: "server.example.com", Path: "/token"},
+			KeysEndpoint:  &url.URL{Scheme: "http", Host: "server.example.com", Path: "/keys"},
+
+			UserInfoEndpoint:   &url.URL{Scheme: "http", Host: "server.example.com", Path: "/userinfo"},
+			EndSessionEndpoint: &url.URL{Scheme: "http", Host: "server.example.com", Path: "/logout"},
+
+			GrantTypesSupported:               []string{oauth2.GrantTypeAuthCode, oauth2.GrantTypeImplicit, oauth2.GrantTypeClientCreds, oauth2.GrantTypeUserCreds, device.GrantTypeDeviceCode, client.GrantTypeTokenExchange},
+			ResponseTypesSupported:            []string{"code", "id_token", "id_token token", "code id_token", "code token", "code id_token token"},
+			ResponseModesSupported:            []string{"query", "fragment", "form_post"},
+			SubjectTypesSupported:             []string{"public", "pairwise"},
+			ScopesSupported:                   []string{"openid", "email", "profile", "groups", "offline_access"},
+			ClaimsSupported:                   []string{"aud", "auth_time", "email", "email_verified", "exp", "groups", "iat", "iss", "name", "nonce", "sid", "sub"},
+			IDTokenSigningAlgValues:           []string{"RS256"},
+			TokenEndpointAuthMethodsSupported: authMethods,
+
+			TokenEndpointAuthSigningAlgValuesSupported: []string{"HS256", "RS256", "PS256", "ES256", "EdDSA"},
+
+			ClaimsParameterSupported:  true,
+			RequestParameterSupported: true,
+			ReqObjSigningAlgValues:    []string{"HS256", "RS256", "PS256", "ES256", "EdDSA"},
+		},
+		CodeChallengeMethodsSupported: []string{"plain", "S256"},
 
-	want := oidc.ProviderConfig{
-		Issuer:        &url.URL{Scheme: "http", Host: "server.example.com"},
-		AuthEndpoint:  &url.URL{Scheme: "http", Host: "server.example.com", Path: "/auth"},
-		TokenEndpoint: &url.URL{Scheme: "http", Host: "server.example.com", Path: "/token"},
-		KeysEndpoint:  &url.URL{Scheme: "http", Host: "server.example.com", Path: "/keys"},
+		IntrospectionEndpoint:                     &url.URL{Scheme: "http", Host: "server.example.com", Path: "/introspect"},
+		IntrospectionEndpointAuthMethodsSupported: authMethods,
 
-		GrantTypesSupported:               []string{oauth2.GrantTypeAuthCode, oauth2.GrantTypeClientCreds},
-		ResponseTypesSupported:            []string{"code"},
-		SubjectTypesSupported:             []string{"public"},
-		IDTokenSigningAlgValues:           []string{"RS256"},
-		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic"},
+		RevocationEndpoint:                     &url.URL{Scheme: "http", Host: "server.example.com", Path: "/revoke"},
+		RevocationEndpointAuthMethodsSupported: authMethods,
+
+		DeviceAuthorizationEndpoint: &url.URL{Scheme: "http", Host: "server.example.com", Path: "/device/code"},
+
+		PushedAuthorizationRequestEndpoint: &url.URL{Scheme: "http", Host: "server.example.com", Path: "/par"},
+
+		BackchannelLogoutSupported:        true,
+		BackchannelLogoutSessionSupported: true,
 	}
 	got := srv.ProviderConfig()
 
 	if diff := pretty.Compare(want, got); diff != "" {
 		t.Fatalf("provider config did not match expected: %s", diff)
 	}
+
+	// Pairwise subjects aren't supported without a salt.
+	srv.PairwiseSubjectSalt = nil
+	if got := srv.ProviderConfig().SubjectTypesSupported; !reflect.DeepEqual(got, []string{"public"}) {
+		t.Errorf("want subject types [public] without a pairwise subject salt, got %v", got)
+	}
 }
 
 func TestServerNewSession(t *testing.T) {
@@ -157,7 +204,14 @@ func TestServerNewSession(t *testing.T) {
 		},
 	}
 
-	key, err := srv.NewSession("bogus_idpc", ci.Credentials.ID, state, ci.Metadata.RedirectURIs[0], nonce, false, []string{"openid"})
+	key, err := srv.NewSession(session.AuthRequest{
+		ConnectorID: "bogus_idpc",
+		ClientID:    ci.Credentials.ID,
+		ClientState: state,
+		RedirectURL: ci.Metadata.RedirectURIs[0],
+		Nonce:       nonce,
+		Scope:       []string{"openid"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -200,8 +254,8 @@ func TestServerLogin(t *testing.T) {
 		email        string
 		configure    func(s *Server)
 
-		wantError bool // should server.Login fail?
-		wantLogin bool // should server.Login redirect back to the app?
+		wantError string // the error server.Login should redirect back to the app with
+		wantLogin bool   // should server.Login redirect back to the app?
 	}{
 		{
 			testCase:     "good user",
@@ -228,7 +282,7 @@ func TestServerLogin(t *testing.T) {
 			userID:       testUserID1,
 			remoteUserID: testUserRemoteID1,
 			email:        testUserEmail1,
-			wantError:    true,
+			wantError:    "server_error",
 		},
 		{
 			testCase:     "unregistered user",
@@ -272,7 +326,13 @@ func TestServerLogin(t *testing.T) {
 		}
 
 		sm := f.sessionManager
-		sessionID, err := sm.NewSession(tt.connectorID, tt.clientID, "bogus", testRedirectURL, "", false, []string{"openid"})
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID: tt.connectorID,
+			ClientID:    tt.clientID,
+			ClientState: "bogus",
+			RedirectURL: testRedirectURL,
+			Scope:       []string{"openid"},
+		})
 		if err != nil {
 			t.Errorf("case %s: new session: %v", tt.testCase, err)
 			continue
@@ -287,13 +347,18 @@ func TestServerLogin(t *testing.T) {
 		ident := oidc.Identity{ID: tt.remoteUserID, Name: "elroy", Email: tt.email}
 		redirectURL, err := f.srv.Login(ident, key)
 		if err != nil {
-			if !tt.wantError {
-				t.Errorf("case %s: server.Login: %v", tt.testCase, err)
-			}
+			t.Errorf("case %s: server.Login: %v", tt.testCase, err)
 			continue
 		}
-		if tt.wantError {
-			t.Errorf("case %s: expected server.Login to fail", tt.testCase)
+		if tt.wantError != "" {
+			u, err := url.Parse(redirectURL)
+			if err != nil {
+				t.Errorf("case %s: invalid redirect URL: %v", tt.testCase, err)
+				continue
+			}
+			if got := u.Query().Get("error"); got != tt.wantError || !strings.HasPrefix(redirectURL, testRedirectURL.String()) {
+				t.Errorf("case %s: want redirect to the app with error %q, got %s", tt.testCase, tt.wantError, redirectURL)
+			}
 			continue
 		}
 
@@ -341,11 +406,17 @@ func TestServerLoginDisabledUser(t *testing.T) {
 	}
 
 	err = f.userRepo.AddRemoteIdentity(nil, "disabled-1", user.RemoteIdentity{
-		ConnectorID: "test_connector_id",
+		ConnectorID: testConnectorIDOpenID,
 		ID:          "disabled-connector-id",
 	})
 
-	sessionID, err := f.sessionManager.NewSession("test_connector_id", testClientID, "bogus", testRedirectURL, "", false, []string{"openid"})
+	sessionID, err := f.sessionManager.NewSession(session.AuthRequest{
+		ConnectorID: testConnectorIDOpenID,
+		ClientID:    testClientID,
+		ClientState: "bogus",
+		RedirectURL: testRedirectURL,
+		Scope:       []string{"openid"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -356,9 +427,25 @@ func TestServerLoginDisabledUser(t *testing.T) {
 		t.Fatalf("Unexpected error: %v", err)
 	}
 
-	_, err = f.srv.Login(ident, key)
-	if err == nil {
-		t.Errorf("disabled user was allowed to log in")
+	redirectURL, err := f.srv.Login(ident, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	q := u.Query()
+	if q.Get("error") != oauth2.ErrorAccessDenied || q.Get("error_description") != "user account is disabled" || q.Get("state") != "bogus" {
+		t.Errorf("disabled user was allowed to log in: redirected to %s", redirectURL)
+	}
+
+	ses, err := f.sessionManager.Get(sessionID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ses.State != session.SessionStateDead {
+		t.Errorf("want session state %q, got %q", session.SessionStateDead, ses.State)
 	}
 }
 
@@ -369,7 +456,13 @@ func TestServerLoginDisplayName(t *testing.T) {
 	}
 
 	sm := f.sessionManager
-	sessionID, err := sm.NewSession(testConnectorIDOpenID, testClientID, "bogus", testRedirectURL, "", false, []string{"openid"})
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: testConnectorIDOpenID,
+		ClientID:    testClientID,
+		ClientState: "bogus",
+		RedirectURL: testRedirectURL,
+		Scope:       []string{"openid"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -424,7 +517,12 @@ func TestServerCodeToken(t *testing.T) {
 	}
 
 	for i, tt := range tests {
-		sessionID, err := sm.NewSession("bogus_idpc", testClientID, "bogus", url.URL{}, "", false, tt.scope)
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID: "bogus_idpc",
+			ClientID:    testClientID,
+			ClientState: "bogus",
+			Scope:       tt.scope,
+		})
 		if err != nil {
 			t.Fatalf("case %d: unexpected error: %v", i, err)
 		}
@@ -443,9 +541,9 @@ func TestServerCodeToken(t *testing.T) {
 			t.Fatalf("case %d: unexpected error: %v", i, err)
 		}
 
-		jwt, token, expiresAt, err := f.srv.CodeToken(oidc.ClientCredentials{
+		jwt, _, token, expiresAt, err := f.srv.CodeToken(oidc.ClientCredentials{
 			ID:     testClientID,
-			Secret: clientTestSecret}, key)
+			Secret: clientTestSecret}, key, "", nil)
 		if err != nil {
 			t.Fatalf("case %d: unexpected error: %v", i, err)
 		}
@@ -458,6 +556,413 @@ func TestServerCodeToken(t *testing.T) {
 		if expiresAt.IsZero() {
 			t.Fatalf("case %d: expect non-zero expiration time", i)
 		}
+		claims, err := jwt.Claims()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if authTime, ok, _ := claims.Int64Claim("auth_time"); !ok || authTime == 0 {
+			t.Fatalf("case %d: expect auth_time claim, got %v", i, claims["auth_time"])
+		}
+	}
+}
+
+func TestServerCodeTokenClaimsRequest(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("Error creating test fixtures: %v", err)
+	}
+	sm := f.sessionManager
+
+	tests := []struct {
+		claims *session.ClaimsRequest
+
+		wantIDToken  []string
+		wantUserInfo []string
+	}{
+		{
+			claims:       nil,
+			wantIDToken:  []string{"email"},
+			wantUserInfo: []string{"email"},
+		},
+		{
+			claims: &session.ClaimsRequest{
+				IDToken: map[string]*session.ClaimRequest{
+					"email_verified": {Essential: true},
+					"groups":         nil,
+				},
+			},
+			wantIDToken:  []string{"email", "email_verified", "groups"},
+			wantUserInfo: []string{"email"},
+		},
+		{
+			claims: &session.ClaimsRequest{
+				UserInfo: map[string]*session.ClaimRequest{"groups": nil},
+			},
+			wantIDToken:  []string{"email"},
+			wantUserInfo: []string{"email", "groups"},
+		},
+		// claims requested for the UserInfo endpoint aren't in the ID token
+		{
+			claims: &session.ClaimsRequest{
+				UserInfo: map[string]*session.ClaimRequest{"email_verified": {Essential: true}},
+			},
+			wantIDToken:  []string{"email"},
+			wantUserInfo: []string{"email", "email_verified"},
+		},
+	}
+
+	for i, tt := range tests {
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID:   testConnectorID1,
+			ClientID:      testClientID,
+			ClientState:   "bogus",
+			Scope:         []string{"openid"},
+			ClaimsRequest: tt.claims,
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachGroups(sessionID, []string{"admins"}); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		key, err := sm.NewSessionKey(sessionID)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		jwt, accessToken, _, _, err := f.srv.CodeToken(oidc.ClientCredentials{
+			ID:     testClientID,
+			Secret: clientTestSecret}, key, "", nil)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		claims, err := jwt.Claims()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		info, err := f.srv.UserInfo(accessToken.Encode())
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		for _, c := range []string{"email", "email_verified", "groups"} {
+			if _, ok := claims[c]; ok != pstrings.ContainsString(tt.wantIDToken, c) {
+				t.Errorf("case %d: want %q in ID token %t, got claims %v", i, c, !ok, claims)
+			}
+			if _, ok := info[c]; ok != pstrings.ContainsString(tt.wantUserInfo, c) {
+				t.Errorf("case %d: want %q in UserInfo %t, got claims %v", i, c, !ok, info)
+			}
+		}
+	}
+}
+
+func TestServerRefreshTokenClaimsRequest(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("Error creating test fixtures: %v", err)
+	}
+	sm := f.sessionManager
+
+	// The user's email isn't verified, so email_verified is only returned
+	// where it was requested.
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: testConnectorID1,
+		ClientID:    testClientID,
+		ClientState: "bogus",
+		Scope:       []string{"openid", "offline_access"},
+		ClaimsRequest: &session.ClaimsRequest{
+			UserInfo: map[string]*session.ClaimRequest{"email_verified": {Essential: true}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	key, err := sm.NewSessionKey(sessionID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, _, refreshToken, _, err := f.srv.CodeToken(testClientCredentials, key, "", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The claims request applies to tokens issued on every renewal.
+	for i := 0; i < 2; i++ {
+		var jwt, accessToken *jose.JWT
+		jwt, accessToken, refreshToken, _, err = f.srv.RefreshToken(testClientCredentials, nil, refreshToken, nil)
+		if err != nil {
+			t.Fatalf("refresh %d: unexpected error: %v", i, err)
+		}
+		claims, err := jwt.Claims()
+		if err != nil {
+			t.Fatalf("refresh %d: unexpected error: %v", i, err)
+		}
+		if _, ok := claims["email_verified"]; ok {
+			t.Errorf("refresh %d: want no email_verified in ID token, got claims %v", i, claims)
+		}
+		info, err := f.srv.UserInfo(accessToken.Encode())
+		if err != nil {
+			t.Fatalf("refresh %d: unexpected error: %v", i, err)
+		}
+		if v, ok := info["email_verified"]; !ok || v != false {
+			t.Errorf("refresh %d: want email_verified false in UserInfo, got claims %v", i, info)
+		}
+	}
+}
+
+func TestServerCodeTokenPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	s256Challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		creds    oidc.ClientCredentials
+		clientID string
+
+		challenge       string
+		challengeMethod string
+		verifier        string
+
+		wantErr bool
+	}{
+		// plain challenge, public client without a secret
+		{
+			creds:           oidc.ClientCredentials{ID: testPublicClientID},
+			clientID:        testPublicClientID,
+			challenge:       verifier,
+			challengeMethod: codeChallengeMethodPlain,
+			verifier:        verifier,
+		},
+		// S256 challenge, public client without a secret
+		{
+			creds:           oidc.ClientCredentials{ID: testPublicClientID},
+			clientID:        testPublicClientID,
+			challenge:       s256Challenge,
+			challengeMethod: codeChallengeMethodS256,
+			verifier:        verifier,
+		},
+		// S256 challenge, confidential client authenticating normally
+		{
+			creds:           testClientCredentials,
+			clientID:        testClientID,
+			challenge:       s256Challenge,
+			challengeMethod: codeChallengeMethodS256,
+			verifier:        verifier,
+		},
+		// wrong verifier
+		{
+			creds:           oidc.ClientCredentials{ID: testPublicClientID},
+			clientID:        testPublicClientID,
+			challenge:       s256Challenge,
+			challengeMethod: codeChallengeMethodS256,
+			verifier:        strings.Repeat("a", minCodeVerifierLength),
+			wantErr:         true,
+		},
+		// missing verifier
+		{
+			creds:           testClientCredentials,
+			clientID:        testClientID,
+			challenge:       s256Challenge,
+			challengeMethod: codeChallengeMethodS256,
+			wantErr:         true,
+		},
+		// verifier without a challenge
+		{
+			creds:    testClientCredentials,
+			clientID: testClientID,
+			verifier: verifier,
+			wantErr:  true,
+		},
+		// confidential clients can't skip authentication
+		{
+			creds:           oidc.ClientCredentials{ID: testClientID},
+			clientID:        testClientID,
+			challenge:       verifier,
+			challengeMethod: codeChallengeMethodPlain,
+			verifier:        verifier,
+			wantErr:         true,
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("case %d: error creating test fixtures: %v", i, err)
+		}
+		sm := f.sessionManager
+
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID:         "bogus_idpc",
+			ClientID:            tt.clientID,
+			ClientState:         "bogus",
+			Scope:               []string{"openid"},
+			CodeChallenge:       tt.challenge,
+			CodeChallengeMethod: tt.challengeMethod,
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		key, err := sm.NewSessionKey(sessionID)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		jwt, _, _, _, err := f.srv.CodeToken(tt.creds, key, tt.verifier, nil)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("case %d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if jwt == nil {
+			t.Errorf("case %d: expect non-nil jwt", i)
+		}
+	}
+}
+
+func TestServerIDTokenSigningAlg(t *testing.T) {
+	newClient := func(id, alg string) client.LoadableClient {
+		return client.LoadableClient{
+			Client: client.Client{
+				Credentials: oidc.ClientCredentials{
+					ID:     id,
+					Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
+				},
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{testRedirectURL},
+					IDTokenResponseOptions: oidc.JWAOptions{
+						SigningAlg: alg,
+					},
+				},
+				FirstParty: true,
+			},
+		}
+	}
+	clients := []client.LoadableClient{
+		newClient("default.example.com", ""),
+		newClient("es256.example.com", signing.ES256),
+		newClient("rs256.example.com", signing.RS256),
+		newClient("ps256.example.com", signing.PS256),
+	}
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+
+	var keys []*signing.PrivateKey
+	for _, alg := range []string{signing.ES256, signing.RS256} {
+		k, err := signing.GeneratePrivateKey(alg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys = append(keys, k)
+	}
+	km := signing.NewPrivateKeyManager()
+	if err := km.Set(signing.NewPrivateKeySet(keys, []string{signing.ES256, signing.RS256}, time.Now().Add(time.Minute))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.srv.KeyManager = km
+
+	if got := f.srv.ProviderConfig().IDTokenSigningAlgValues; !reflect.DeepEqual([]string{signing.ES256, signing.RS256}, got) {
+		t.Errorf("want id_token_signing_alg_values_supported [ES256 RS256], got %v", got)
+	}
+
+	tests := []struct {
+		clientID string
+
+		wantAlg string
+		wantErr bool
+	}{
+		{
+			// The first algorithm is the default.
+			clientID: "default.example.com",
+			wantAlg:  signing.ES256,
+		},
+		{
+			clientID: "es256.example.com",
+			wantAlg:  signing.ES256,
+		},
+		{
+			clientID: "rs256.example.com",
+			wantAlg:  signing.RS256,
+		},
+		{
+			// No PS256 keys are active.
+			clientID: "ps256.example.com",
+			wantErr:  true,
+		},
+	}
+
+	for i, tt := range tests {
+		sm := f.sessionManager
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID: testConnectorID1,
+			ClientID:    tt.clientID,
+			ClientState: "bogus",
+			RedirectURL: testRedirectURL,
+			Scope:       []string{"openid"},
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		key, err := sm.NewSessionKey(sessionID)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		idToken, accessToken, _, _, err := f.srv.CodeToken(f.clientCreds[tt.clientID], key, "", nil)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("case %d: want error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+
+		if alg := idToken.Header[jose.HeaderKeyAlgorithm]; alg != tt.wantAlg {
+			t.Errorf("case %d: want ID token signed with %s, got %s", i, tt.wantAlg, alg)
+		}
+		// Access tokens are only used by dex, and always signed with RS256.
+		if alg := accessToken.Header[jose.HeaderKeyAlgorithm]; alg != signing.RS256 {
+			t.Errorf("case %d: want access token signed with RS256, got %s", i, alg)
+		}
+
+		if _, err := f.srv.verifyIDToken(idToken.Encode()); err != nil {
+			t.Errorf("case %d: ID token not verified: %v", i, err)
+		}
+		if err := f.srv.JWTVerifierFactory()(tt.clientID).Verify(*idToken); err != nil {
+			t.Errorf("case %d: ID token not verified: %v", i, err)
+		}
 	}
 }
 
@@ -468,7 +973,12 @@ func TestServerTokenUnrecognizedKey(t *testing.T) {
 	}
 	sm := f.sessionManager
 
-	sessionID, err := sm.NewSession("connector_id", testClientID, "bogus", url.URL{}, "", false, []string{"openid", "offline_access"})
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: "connector_id",
+		ClientID:    testClientID,
+		ClientState: "bogus",
+		Scope:       []string{"openid", "offline_access"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -478,7 +988,7 @@ func TestServerTokenUnrecognizedKey(t *testing.T) {
 		t.Fatalf("Unexpected error: %v", err)
 	}
 
-	jwt, token, expiresAt, err := f.srv.CodeToken(testClientCredentials, "foo")
+	jwt, _, token, expiresAt, err := f.srv.CodeToken(testClientCredentials, "foo", "", nil)
 	if err == nil {
 		t.Fatalf("Expected non-nil error")
 	}
@@ -566,7 +1076,12 @@ func TestServerTokenFail(t *testing.T) {
 			signer: tt.signer,
 		}
 
-		sessionID, err := sm.NewSession(testConnectorID1, testClientID, "bogus", url.URL{}, "", false, tt.scope)
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID: testConnectorID1,
+			ClientID:    testClientID,
+			ClientState: "bogus",
+			Scope:       tt.scope,
+		})
 		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
@@ -586,7 +1101,7 @@ func TestServerTokenFail(t *testing.T) {
 			t.Fatalf("Unexpected error: %v", err)
 		}
 
-		jwt, token, expiresAt, err := f.srv.CodeToken(tt.argCC, tt.argKey)
+		jwt, _, token, expiresAt, err := f.srv.CodeToken(tt.argCC, tt.argKey, "", nil)
 		if token != tt.refreshToken {
 			fmt.Printf("case %d: expect refresh token %q, got %q\n", i, tt.refreshToken, token)
 			t.Fatalf("case %d: expect refresh token %q, got %q", i, tt.refreshToken, token)
@@ -623,7 +1138,8 @@ func TestServerRefreshToken(t *testing.T) {
 	signerFixture := &StaticSigner{sig: []byte("beer"), err: nil}
 
 	// NOTE(ericchiang): These tests assume that the database ID of the first
-	// refresh token will be "1".
+	// refresh token will be "1". Renewed tokens are kept to detect them being
+	// reused, so the renewed token's ID is "2".
 	tests := []struct {
 		token                string
 		expectedRefreshToken string
@@ -638,7 +1154,7 @@ func TestServerRefreshToken(t *testing.T) {
 		// Everything is good.
 		{
 			token:                getRefreshTokenEncoded("1", "refresh-1"),
-			expectedRefreshToken: getRefreshTokenEncoded("1", "refresh-2"),
+			expectedRefreshToken: getRefreshTokenEncoded("2", "refresh-2"),
 			clientID:             testClientID,
 			creds:                testClientCredentials,
 			signer:               signerFixture,
@@ -748,7 +1264,7 @@ func TestServerRefreshToken(t *testing.T) {
 		// Valid Cross-Client
 		{
 			token:                getRefreshTokenEncoded("1", "refresh-1"),
-			expectedRefreshToken: getRefreshTokenEncoded("1", "refresh-2"),
+			expectedRefreshToken: getRefreshTokenEncoded("2", "refresh-2"),
 			clientID:             "client_a",
 			creds: oidc.ClientCredentials{
 				ID: "client_a",
@@ -765,7 +1281,7 @@ func TestServerRefreshToken(t *testing.T) {
 		// being used.
 		{
 			token:                getRefreshTokenEncoded("1", "refresh-1"),
-			expectedRefreshToken: getRefreshTokenEncoded("1", "refresh-2"),
+			expectedRefreshToken: getRefreshTokenEncoded("2", "refresh-2"),
 			clientID:             "client_a",
 			creds: oidc.ClientCredentials{
 				ID: "client_a",
@@ -781,7 +1297,7 @@ func TestServerRefreshToken(t *testing.T) {
 		// when creating the refresh token, which is ok.
 		{
 			token:                getRefreshTokenEncoded("1", "refresh-1"),
-			expectedRefreshToken: getRefreshTokenEncoded("1", "refresh-2"),
+			expectedRefreshToken: getRefreshTokenEncoded("2", "refresh-2"),
 			clientID:             "client_a",
 			creds: oidc.ClientCredentials{
 				ID: "client_a",
@@ -796,7 +1312,7 @@ func TestServerRefreshToken(t *testing.T) {
 		// Valid Cross-Client - asking for multiple clients in the audience.
 		{
 			token:                getRefreshTokenEncoded("1", "refresh-1"),
-			expectedRefreshToken: getRefreshTokenEncoded("1", "refresh-2"),
+			expectedRefreshToken: getRefreshTokenEncoded("2", "refresh-2"),
 			clientID:             "client_a",
 			creds: oidc.ClientCredentials{
 				ID: "client_a",
@@ -840,11 +1356,11 @@ func TestServerRefreshToken(t *testing.T) {
 			t.Errorf("case %d: error creating other client: %v", i, err)
 		}
 
-		if _, err := f.srv.RefreshTokenRepo.Create(testUserID1, tt.clientID, "", tt.createScopes); err != nil {
+		if _, err := f.srv.RefreshTokenRepo.Create(testUserID1, tt.clientID, "", tt.createScopes, nil); err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
 
-		jwt, refreshToken, expiresIn, err := f.srv.RefreshToken(tt.creds, tt.refreshScopes, tt.token)
+		jwt, _, refreshToken, expiresIn, err := f.srv.RefreshToken(tt.creds, tt.refreshScopes, tt.token, nil)
 		if !reflect.DeepEqual(err, tt.err) {
 			t.Errorf("Case %d: expect: %v, got: %v", i, tt.err, err)
 		}
@@ -890,3 +1406,1436 @@ func TestServerRefreshToken(t *testing.T) {
 		}
 	}
 }
+
+func TestServerRefreshTokenDisabledUser(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	f.srv.RefreshTokenRepo = refreshtest.NewTestRefreshTokenRepo()
+
+	token, err := f.srv.RefreshTokenRepo.Create(testUserID1, testClientID, "", []string{"openid"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, token, _, err = f.srv.RefreshToken(testClientCredentials, nil, token, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := f.srv.UserManager.Disable(testUserID1, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, _, _, _, err = f.srv.RefreshToken(testClientCredentials, nil, token, nil)
+	if want := oauth2.NewError(oauth2.ErrorInvalidGrant); !reflect.DeepEqual(err, want) {
+		t.Fatalf("want err %v, got %v", want, err)
+	}
+
+	// The token's family stays revoked once the user is enabled again.
+	if err := f.srv.UserManager.Disable(testUserID1, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, _, _, _, err = f.srv.RefreshToken(testClientCredentials, nil, token, nil)
+	if want := oauth2.NewError(oauth2.ErrorInvalidRequest); !reflect.DeepEqual(err, want) {
+		t.Errorf("want err %v, got %v", want, err)
+	}
+}
+
+// racingRefreshTokenRepo rotates refresh tokens right before renewing them, as
+// a concurrent request presenting the same token would.
+type racingRefreshTokenRepo struct {
+	refresh.RefreshTokenRepo
+}
+
+func (r racingRefreshTokenRepo) RenewRefreshToken(clientID, userID, token string) (string, error) {
+	if _, err := r.RefreshTokenRepo.RenewRefreshToken(clientID, userID, token); err != nil {
+		return "", err
+	}
+	return r.RefreshTokenRepo.RenewRefreshToken(clientID, userID, token)
+}
+
+func TestServerRefreshTokenConcurrentRotation(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	f.srv.RefreshTokenRepo = racingRefreshTokenRepo{refreshtest.NewTestRefreshTokenRepo()}
+
+	token, err := f.srv.RefreshTokenRepo.Create(testUserID1, testClientID, "", []string{"openid"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, _, _, _, err = f.srv.RefreshToken(testClientCredentials, nil, token, nil)
+	if want := oauth2.NewError(oauth2.ErrorInvalidGrant); !reflect.DeepEqual(err, want) {
+		t.Errorf("want err %v, got %v", want, err)
+	}
+}
+
+func TestServerIntrospect(t *testing.T) {
+	otherKey, err := key.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	signToken := func(signer jose.Signer, sub, aud string, exp time.Time) string {
+		claims := oidc.NewClaims(testIssuerURL.String(), sub, aud, time.Now(), exp)
+		jwt, err := jose.NewSignedJWT(claims, signer)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return jwt.Encode()
+	}
+
+	tests := []struct {
+		creds oidc.ClientCredentials
+		// token returns the token to introspect, given the test fixtures.
+		token   func(f *testFixtures) string
+		disable bool
+
+		want    *Introspection
+		wantErr error
+	}{
+		// valid ID token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				return signToken(testPrivKey.Signer(), testUserID1, testClientID, time.Now().Add(time.Hour))
+			},
+			want: &Introspection{Active: true, Subject: testUserID1, ClientID: testClientID, Audience: []string{testClientID}},
+		},
+		// ID tokens can be introspected by other clients
+		{
+			creds: testPublicClientCredentials,
+			token: func(f *testFixtures) string {
+				return signToken(testPrivKey.Signer(), testUserID1, testClientID, time.Now().Add(time.Hour))
+			},
+			want: &Introspection{Active: true, Subject: testUserID1, ClientID: testClientID, Audience: []string{testClientID}},
+		},
+		// ID token of a disabled user
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				return signToken(testPrivKey.Signer(), testUserID1, testClientID, time.Now().Add(time.Hour))
+			},
+			disable: true,
+			want:    &Introspection{Active: false},
+		},
+		// expired ID token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				return signToken(testPrivKey.Signer(), testUserID1, testClientID, time.Now().Add(-time.Minute))
+			},
+			want: &Introspection{Active: false},
+		},
+		// ID token not signed by the server
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				return signToken(otherKey.Signer(), testUserID1, testClientID, time.Now().Add(time.Hour))
+			},
+			want: &Introspection{Active: false},
+		},
+		// client credentials token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				jwt, _, _, err := f.srv.ClientCredsToken(testClientCredentials, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return jwt.Encode()
+			},
+			want: &Introspection{Active: true, Subject: testClientID, ClientID: testClientID, Audience: []string{testClientID}},
+		},
+		// client credentials access token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				_, accessToken, _, err := f.srv.ClientCredsToken(testClientCredentials, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return accessToken.Encode()
+			},
+			want: &Introspection{Active: true, Subject: testClientID, ClientID: testClientID, Audience: []string{testIssuerURL.String()}},
+		},
+		// access token issued for a resource
+		{
+			creds: testPublicClientCredentials,
+			token: func(f *testFixtures) string {
+				jwt, _, err := f.srv.accessToken(testClientID, testUserID1, []string{"https://api.example.com"}, []string{"openid", "email"}, nil, nil, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return jwt.Encode()
+			},
+			want: &Introspection{Active: true, Subject: testUserID1, ClientID: testClientID, Audience: []string{"https://api.example.com"}, Scope: "openid email"},
+		},
+		// refresh token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				token, err := f.srv.RefreshTokenRepo.Create(testUserID1, testClientID, testConnectorID1, []string{"openid", "offline_access"}, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return token
+			},
+			want: &Introspection{Active: true, Subject: testUserID1, ClientID: testClientID, Scope: "openid offline_access"},
+		},
+		// refresh token of a disabled user
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				token, err := f.srv.RefreshTokenRepo.Create(testUserID1, testClientID, testConnectorID1, []string{"openid", "offline_access"}, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return token
+			},
+			disable: true,
+			want:    &Introspection{Active: false},
+		},
+		// refresh token issued to another client
+		{
+			creds: testPublicClientCredentials,
+			token: func(f *testFixtures) string {
+				token, err := f.srv.RefreshTokenRepo.Create(testUserID1, testClientID, testConnectorID1, []string{"openid", "offline_access"}, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return token
+			},
+			want: &Introspection{Active: false},
+		},
+		// unrecognized token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string { return "garbage" },
+			want:  &Introspection{Active: false},
+		},
+		// bad client credentials
+		{
+			creds: oidc.ClientCredentials{ID: testClientID, Secret: "bad"},
+			token: func(f *testFixtures) string {
+				return signToken(testPrivKey.Signer(), testUserID1, testClientID, time.Now().Add(time.Hour))
+			},
+			wantErr: oauth2.NewError(oauth2.ErrorInvalidClient),
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("case %d: error making test fixtures: %v", i, err)
+		}
+		token := tt.token(f)
+		if tt.disable {
+			if err := f.srv.UserManager.Disable(testUserID1, true); err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+		}
+
+		got, err := f.srv.Introspect(tt.creds, token)
+		if !reflect.DeepEqual(err, tt.wantErr) {
+			t.Errorf("case %d: want error %v, got %v", i, tt.wantErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// Expiry depends on when the token was issued.
+		got.Expiry = 0
+		if diff := pretty.Compare(tt.want, got); diff != "" {
+			t.Errorf("case %d: Compare(want, got) = %v", i, diff)
+		}
+	}
+}
+
+func TestServerRevokeToken(t *testing.T) {
+	tests := []struct {
+		creds oidc.ClientCredentials
+		// token returns the token to revoke, given the test fixtures.
+		token func(f *testFixtures) string
+
+		wantErr     error
+		wantRevoked bool
+	}{
+		// refresh token
+		{
+			creds:       testClientCredentials,
+			wantRevoked: true,
+		},
+		// unrecognized token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string { return getRefreshTokenEncoded("1", "bogus") },
+		},
+		// malformed token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string { return "garbage" },
+		},
+		// ID token
+		{
+			creds: testClientCredentials,
+			token: func(f *testFixtures) string {
+				jwt, _, _, err := f.srv.ClientCredsToken(testClientCredentials, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return jwt.Encode()
+			},
+			wantErr: &oauth2.Error{Type: errorUnsupportedTokenType, Description: "only refresh tokens can be revoked"},
+		},
+		// token issued to another client
+		{
+			creds:   testPublicClientCredentials,
+			wantErr: oauth2.NewError(oauth2.ErrorInvalidGrant),
+		},
+		// bad client credentials
+		{
+			creds:   oidc.ClientCredentials{ID: testClientID, Secret: "bad"},
+			wantErr: oauth2.NewError(oauth2.ErrorInvalidClient),
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("case %d: error making test fixtures: %v", i, err)
+		}
+		refreshToken, err := f.srv.RefreshTokenRepo.Create(testUserID1, testClientID, testConnectorID1, []string{"openid", "offline_access"}, nil)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		token := refreshToken
+		if tt.token != nil {
+			token = tt.token(f)
+		}
+
+		err = f.srv.RevokeToken(tt.creds, token)
+		if !reflect.DeepEqual(err, tt.wantErr) {
+			t.Errorf("case %d: want error %v, got %v", i, tt.wantErr, err)
+			continue
+		}
+
+		_, _, _, _, err = f.srv.RefreshTokenRepo.Verify(testClientID, refreshToken)
+		if revoked := err != nil; revoked != tt.wantRevoked {
+			t.Errorf("case %d: want revoked=%t, got %t", i, tt.wantRevoked, revoked)
+		}
+	}
+}
+
+func TestServerUserInfo(t *testing.T) {
+	signToken := func(f *testFixtures, sub string, groups, userInfoClaims []string) string {
+		claims := oidc.NewClaims(testIssuerURL.String(), sub, testClientID, time.Now(), time.Now().Add(time.Hour))
+		if groups != nil {
+			claims.Add("groups", groups)
+		}
+		if userInfoClaims != nil {
+			claims.Add(claimUserInfoClaims, userInfoClaims)
+		}
+		jwt, err := jose.NewSignedJWT(claims, testPrivKey.Signer())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return jwt.Encode()
+	}
+
+	tests := []struct {
+		sub            string
+		groups         []string
+		userInfoClaims []string
+		disable        bool
+
+		want    jose.Claims
+		wantErr error
+	}{
+		{
+			sub: testUserID1,
+			want: jose.Claims{
+				"sub":   testUserID1,
+				"name":  "",
+				"email": "email-1@example.com",
+			},
+		},
+		// unverified emails are only reported as such if asked for
+		{
+			sub:            testUserID1,
+			userInfoClaims: []string{"email_verified"},
+			want: jose.Claims{
+				"sub":            testUserID1,
+				"name":           "",
+				"email":          "email-1@example.com",
+				"email_verified": false,
+			},
+		},
+		{
+			sub: "ID-Verified",
+			want: jose.Claims{
+				"sub":            "ID-Verified",
+				"name":           "",
+				"email":          "email-verified@example.com",
+				"email_verified": true,
+			},
+		},
+		// groups are returned when present in the token
+		{
+			sub:    testUserID1,
+			groups: []string{"admins"},
+			want: jose.Claims{
+				"sub":    testUserID1,
+				"name":   "",
+				"email":  "email-1@example.com",
+				"groups": []string{"admins"},
+			},
+		},
+		// disabled user
+		{
+			sub:     testUserID1,
+			disable: true,
+			wantErr: oauth2.NewError(errorInvalidToken),
+		},
+		// token not issued to a user
+		{
+			sub:     testClientID,
+			wantErr: oauth2.NewError(errorInvalidToken),
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("case %d: error making test fixtures: %v", i, err)
+		}
+		if tt.disable {
+			if err := f.srv.UserManager.Disable(tt.sub, true); err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+		}
+
+		got, err := f.srv.UserInfo(signToken(f, tt.sub, tt.groups, tt.userInfoClaims))
+		if !reflect.DeepEqual(err, tt.wantErr) {
+			t.Errorf("case %d: want error %v, got %v", i, tt.wantErr, err)
+			continue
+		}
+		if diff := pretty.Compare(tt.want, got); diff != "" {
+			t.Errorf("case %d: Compare(want, got) = %v", i, diff)
+		}
+	}
+}
+
+func TestServerDeviceToken(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	sm := f.sessionManager
+
+	g, err := f.srv.NewDeviceGrant(testClientCredentials, []string{"openid", "offline_access"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Public clients don't need a secret.
+	if _, err := f.srv.NewDeviceGrant(oidc.ClientCredentials{ID: testPublicClientID}, []string{"openid"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// But other clients do.
+	if _, err := f.srv.NewDeviceGrant(oidc.ClientCredentials{ID: testClientID}, []string{"openid"}); err == nil {
+		t.Errorf("Expected error for unauthenticated client")
+	}
+
+	poll := func(creds oidc.ClientCredentials, deviceCode, wantErr string) {
+		_, _, _, _, err := f.srv.DeviceToken(creds, deviceCode, nil)
+		oerr, ok := err.(*oauth2.Error)
+		if !ok || oerr.Type != wantErr {
+			t.Errorf("want error %q, got %v", wantErr, err)
+		}
+	}
+
+	poll(testClientCredentials, g.DeviceCode, errorAuthorizationPending)
+	// Polling again immediately is too fast.
+	poll(testClientCredentials, g.DeviceCode, errorSlowDown)
+	poll(testClientCredentials, "bogus", oauth2.ErrorInvalidGrant)
+	poll(testPublicClientCredentials, g.DeviceCode, oauth2.ErrorInvalidGrant)
+
+	g, err = f.srv.DeviceGrantRepo.GetByDeviceCode(g.DeviceCode)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := device.DefaultPollInterval + device.SlowDownIncrement; g.Interval != want {
+		t.Errorf("want poll interval %v, got %v", want, g.Interval)
+	}
+
+	// Approve the grant, as if the user had logged in on the verification page.
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: testConnectorID1,
+		ClientID:    testClientID,
+		ClientState: g.UserCode,
+		Scope:       g.Scope,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g.SessionID = sessionID
+	g.Approved = true
+	g.LastPolledAt = time.Time{}
+	if err := f.srv.DeviceGrantRepo.Update(*g); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	jwt, _, refreshToken, expiresAt, err := f.srv.DeviceToken(testClientCredentials, g.DeviceCode, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if jwt == nil {
+		t.Errorf("Expected non-nil jwt")
+	}
+	if refreshToken == "" {
+		t.Errorf("Expected non-empty refresh token")
+	}
+	if expiresAt.IsZero() {
+		t.Errorf("Expected non-zero expiration time")
+	}
+
+	// Device codes can only be exchanged once.
+	poll(testClientCredentials, g.DeviceCode, oauth2.ErrorInvalidGrant)
+}
+
+func TestServerPasswordToken(t *testing.T) {
+	passwordClientCreds := oidc.ClientCredentials{
+		ID:     "password.example.com",
+		Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
+	}
+	clients := append([]client.LoadableClient{
+		{
+			Client: client.Client{
+				Credentials: passwordClientCreds,
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{testRedirectURL},
+				},
+				AllowedGrantTypes: []string{oauth2.GrantTypeAuthCode, oauth2.GrantTypeUserCreds, oauth2.GrantTypeRefreshToken},
+			},
+		},
+	}, testClients...)
+
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	f.srv.PasswordGrantConnectorID = testConnectorLocalID
+
+	userID, err := f.srv.UserManager.RegisterWithPassword("local@example.com", "password", testConnectorLocalID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	disabledID, err := f.srv.UserManager.RegisterWithPassword("disabled@example.com", "password", testConnectorLocalID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.srv.UserManager.Disable(disabledID, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		creds       oidc.ClientCredentials
+		connectorID string
+		username    string
+		password    string
+		wantErr     string
+	}{
+		// default connector
+		{
+			creds:    passwordClientCreds,
+			username: "local@example.com",
+			password: "password",
+		},
+		// explicit connector
+		{
+			creds:       passwordClientCreds,
+			connectorID: testConnectorLocalID,
+			username:    "local@example.com",
+			password:    "password",
+		},
+		// wrong password
+		{
+			creds:    passwordClientCreds,
+			username: "local@example.com",
+			password: "wrong",
+			wantErr:  oauth2.ErrorInvalidGrant,
+		},
+		// unknown user
+		{
+			creds:    passwordClientCreds,
+			username: "nobody@example.com",
+			password: "password",
+			wantErr:  oauth2.ErrorInvalidGrant,
+		},
+		// disabled user
+		{
+			creds:    passwordClientCreds,
+			username: "disabled@example.com",
+			password: "password",
+			wantErr:  oauth2.ErrorInvalidGrant,
+		},
+		// client not allowed to use the password grant
+		{
+			creds:    testClientCredentials,
+			username: "local@example.com",
+			password: "password",
+			wantErr:  oauth2.ErrorUnauthorizedClient,
+		},
+		// bad client secret
+		{
+			creds:    oidc.ClientCredentials{ID: passwordClientCreds.ID, Secret: clientTestSecret + "x"},
+			username: "local@example.com",
+			password: "password",
+			wantErr:  oauth2.ErrorInvalidClient,
+		},
+		// connector doesn't accept passwords
+		{
+			creds:       passwordClientCreds,
+			connectorID: testConnectorIDOpenID,
+			username:    "local@example.com",
+			password:    "password",
+			wantErr:     oauth2.ErrorInvalidRequest,
+		},
+		// unknown connector
+		{
+			creds:       passwordClientCreds,
+			connectorID: "bogus",
+			username:    "local@example.com",
+			password:    "password",
+			wantErr:     oauth2.ErrorInvalidRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		jwt, _, refreshToken, _, err := f.srv.PasswordToken(tt.creds, tt.connectorID, tt.username, tt.password, []string{"openid", "offline_access"}, nil)
+		if tt.wantErr != "" {
+			oerr, ok := err.(*oauth2.Error)
+			if !ok || oerr.Type != tt.wantErr {
+				t.Errorf("case %d: want error %q, got %v", i, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		claims, err := jwt.Claims()
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if sub, _, _ := claims.StringClaim("sub"); sub != userID {
+			t.Errorf("case %d: want sub %q, got %q", i, userID, sub)
+		}
+		if aud, _, _ := claims.StringClaim("aud"); aud != passwordClientCreds.ID {
+			t.Errorf("case %d: want aud %q, got %q", i, passwordClientCreds.ID, aud)
+		}
+		if refreshToken == "" {
+			t.Errorf("case %d: expected non-empty refresh token", i)
+		}
+	}
+}
+
+func TestServerAccessToken(t *testing.T) {
+	apiURL := "https://api.example.com"
+	otherAPIURL := "https://other-api.example.com"
+
+	resourceClientCreds := oidc.ClientCredentials{
+		ID:     "resources.example.com",
+		Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
+	}
+	clients := append([]client.LoadableClient{
+		{
+			Client: client.Client{
+				Credentials: resourceClientCreds,
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{testRedirectURL},
+				},
+				Resources: []string{apiURL, otherAPIURL},
+			},
+		},
+	}, testClients...)
+
+	tests := []struct {
+		creds     oidc.ClientCredentials
+		resources []string
+
+		wantAud []string
+		wantErr string
+	}{
+		// no resources requested or configured, the token is for dex itself
+		{
+			creds:   testClientCredentials,
+			wantAud: []string{testIssuerURL.String()},
+		},
+		// clients without configured resources can't request any
+		{
+			creds:     testClientCredentials,
+			resources: []string{apiURL},
+			wantErr:   errorInvalidTarget,
+		},
+		{
+			creds:     testClientCredentials,
+			resources: []string{"https://" + resourceClientCreds.ID},
+			wantErr:   errorInvalidTarget,
+		},
+		// resources must be absolute URIs
+		{
+			creds:     resourceClientCreds,
+			resources: []string{"api"},
+			wantErr:   errorInvalidTarget,
+		},
+		// resources must not have a fragment
+		{
+			creds:     resourceClientCreds,
+			resources: []string{apiURL + "#frag"},
+			wantErr:   errorInvalidTarget,
+		},
+		// configured resources
+		{
+			creds:   resourceClientCreds,
+			wantAud: []string{apiURL, otherAPIURL},
+		},
+		// one of the configured resources
+		{
+			creds:     resourceClientCreds,
+			resources: []string{otherAPIURL},
+			wantAud:   []string{otherAPIURL},
+		},
+		// resource not configured for the client
+		{
+			creds:     resourceClientCreds,
+			resources: []string{"https://unknown.example.com"},
+			wantErr:   errorInvalidTarget,
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+		if err != nil {
+			t.Fatalf("case %d: error making test fixtures: %v", i, err)
+		}
+		sm := f.sessionManager
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID: testConnectorID1,
+			ClientID:    tt.creds.ID,
+			ClientState: "bogus",
+			RedirectURL: testRedirectURL,
+			Scope:       []string{"openid", "email"},
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		key, err := sm.NewSessionKey(sessionID)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		idToken, accessToken, _, expiresAt, err := f.srv.CodeToken(tt.creds, key, "", tt.resources)
+		if tt.wantErr != "" {
+			oerr, ok := err.(*oauth2.Error)
+			if !ok || oerr.Type != tt.wantErr {
+				t.Errorf("case %d: want error %q, got %v", i, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+
+		claims, err := accessToken.Claims()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		aud, ok, _ := claims.StringsClaim("aud")
+		if !ok {
+			a, _, _ := claims.StringClaim("aud")
+			aud = []string{a}
+		}
+		if !reflect.DeepEqual(tt.wantAud, aud) {
+			t.Errorf("case %d: want aud %v, got %v", i, tt.wantAud, aud)
+		}
+		if clientID, _, _ := claims.StringClaim("client_id"); clientID != tt.creds.ID {
+			t.Errorf("case %d: want client_id %q, got %q", i, tt.creds.ID, clientID)
+		}
+		if scp, _, _ := claims.StringClaim("scope"); scp != "openid email" {
+			t.Errorf("case %d: want scope %q, got %q", i, "openid email", scp)
+		}
+		if exp, _, _ := claims.TimeClaim("exp"); exp.Unix() != expiresAt.Unix() {
+			t.Errorf("case %d: want exp %v, got %v", i, expiresAt, exp)
+		}
+		if want := time.Now().Add(DefaultAccessTokenValidityWindow); expiresAt.After(want) {
+			t.Errorf("case %d: access token expires too late: %v", i, expiresAt)
+		}
+
+		// Access tokens are told apart from ID tokens by their typ header, so
+		// they can't be passed off as ID tokens for their audience.
+		if typ := accessToken.Header[jose.HeaderMediaType]; typ != "at+jwt" {
+			t.Errorf("case %d: want access token typ %q, got %q", i, "at+jwt", typ)
+		}
+		if err := f.srv.JWTVerifierFactory()(aud[0]).Verify(*accessToken); err == nil {
+			t.Errorf("case %d: access token verified as an ID token", i)
+		}
+		if _, err := f.srv.verifyIDToken(accessToken.Encode()); err == nil {
+			t.Errorf("case %d: access token verified as an ID token", i)
+		}
+		if _, err := f.srv.IDTokenHintSubject(tt.creds.ID, accessToken.Encode()); err == nil {
+			t.Errorf("case %d: access token accepted as an id_token_hint", i)
+		}
+
+		// The ID token is still issued to the client.
+		idClaims, err := idToken.Claims()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if aud, _, _ := idClaims.StringClaim("aud"); aud != tt.creds.ID {
+			t.Errorf("case %d: want ID token aud %q, got %q", i, tt.creds.ID, aud)
+		}
+
+		// Access tokens can be used at the UserInfo endpoint.
+		info, err := f.srv.UserInfo(accessToken.Encode())
+		if err != nil {
+			t.Errorf("case %d: unexpected userinfo error: %v", i, err)
+			continue
+		}
+		if sub, _, _ := info.StringClaim("sub"); sub != testUserID1 {
+			t.Errorf("case %d: want userinfo sub %q, got %q", i, testUserID1, sub)
+		}
+	}
+}
+
+func TestServerClientTokenPolicy(t *testing.T) {
+	restrictedCreds := oidc.ClientCredentials{
+		ID:     "restricted.example.com",
+		Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
+	}
+	clients := append([]client.LoadableClient{
+		{
+			Client: client.Client{
+				Credentials: restrictedCreds,
+				Metadata: oidc.ClientMetadata{
+					RedirectURIs: []url.URL{testRedirectURL},
+				},
+				IDTokenLifetime:     time.Hour,
+				AccessTokenLifetime: 10 * time.Minute,
+				AllowedGrantTypes:   []string{oauth2.GrantTypeAuthCode},
+			},
+		},
+	}, testClients...)
+
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	sm := f.sessionManager
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: testConnectorID1,
+		ClientID:    restrictedCreds.ID,
+		ClientState: "bogus",
+		RedirectURL: testRedirectURL,
+		Scope:       []string{"openid", "offline_access"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ses, err := sm.AttachUser(sessionID, testUserID1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key, err := sm.NewSessionKey(sessionID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	idToken, _, refreshToken, expiresAt, err := f.srv.CodeToken(restrictedCreds, key, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims, err := idToken.Claims()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, _, _ := claims.TimeClaim("exp"); exp.Unix() != ses.CreatedAt.Add(time.Hour).Unix() {
+		t.Errorf("want ID token to expire after the client's lifetime, got %v", exp)
+	}
+	if want := time.Now().Add(10 * time.Minute); expiresAt.After(want) {
+		t.Errorf("access token expires too late: %v", expiresAt)
+	}
+	// The client may not use refresh tokens, so none is issued.
+	if refreshToken != "" {
+		t.Errorf("want no refresh token, got %q", refreshToken)
+	}
+
+	if _, _, _, err := f.srv.ClientCredsToken(restrictedCreds, nil); !isOAuth2Error(err, oauth2.ErrorUnauthorizedClient) {
+		t.Errorf("want client credentials grant error %q, got %v", oauth2.ErrorUnauthorizedClient, err)
+	}
+	token := getRefreshTokenEncoded("1", "refresh-1")
+	if _, _, _, _, err := f.srv.RefreshToken(restrictedCreds, nil, token, nil); !isOAuth2Error(err, oauth2.ErrorUnauthorizedClient) {
+		t.Errorf("want refresh token grant error %q, got %v", oauth2.ErrorUnauthorizedClient, err)
+	}
+}
+
+func isOAuth2Error(err error, typ string) bool {
+	oerr, ok := err.(*oauth2.Error)
+	return ok && oerr.Type == typ
+}
+
+func TestServerIDTokenHintSubject(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	sm := f.sessionManager
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: testConnectorID1,
+		ClientID:    testClientID,
+		ClientState: "bogus",
+		RedirectURL: testRedirectURL,
+		Scope:       []string{"openid"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key, err := sm.NewSessionKey(sessionID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	idToken, accessToken, _, _, err := f.srv.CodeToken(testClientCredentials, key, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims, err := idToken.Claims()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims["events"] = map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}}
+	eventToken, err := jose.NewSignedJWT(claims, testPrivKey.Signer())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		clientID string
+		hint     string
+
+		wantSub string
+		wantErr bool
+	}{
+		{
+			clientID: testClientID,
+			hint:     idToken.Encode(),
+			wantSub:  testUserID1,
+		},
+		// issued to another client
+		{
+			clientID: testPublicClientID,
+			hint:     idToken.Encode(),
+			wantErr:  true,
+		},
+		// access tokens aren't ID tokens
+		{
+			clientID: testClientID,
+			hint:     accessToken.Encode(),
+			wantErr:  true,
+		},
+		// nor are tokens carrying security events, whatever their type
+		{
+			clientID: testClientID,
+			hint:     eventToken.Encode(),
+			wantErr:  true,
+		},
+		{
+			clientID: testClientID,
+			hint:     "not-a-jwt",
+			wantErr:  true,
+		},
+	}
+
+	for i, tt := range tests {
+		sub, err := f.srv.IDTokenHintSubject(tt.clientID, tt.hint)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("case %d: want error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if sub != tt.wantSub {
+			t.Errorf("case %d: want sub %q, got %q", i, tt.wantSub, sub)
+		}
+	}
+}
+
+func TestServerSSOSession(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	ident := oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1}
+
+	// Logging in interactively starts an SSO session in the browser the
+	// session was started in.
+	key, err := f.srv.NewSession(session.AuthRequest{
+		ConnectorID:   testConnectorID1,
+		ClientID:      testClientID,
+		ClientState:   "bogus",
+		RedirectURL:   testRedirectURL,
+		Scope:         []string{"openid"},
+		SSOLoginNonce: "nonce-1",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ru, err := f.srv.Login(ident, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req, err := http.NewRequest("GET", ru, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.AddCookie(&http.Cookie{Name: cookieSSOLogin, Value: "nonce-1"})
+	w := httptest.NewRecorder()
+	handleSSOStartFunc(f.srv).ServeHTTP(w, req)
+	var ssoID string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == cookieSSOSession {
+			ssoID = c.Value
+		}
+	}
+	sso, err := f.srv.SSOSession(ssoID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sso.UserID != testUserID1 || sso.ConnectorID != testConnectorID1 {
+		t.Errorf("unexpected SSO session: %#v", sso)
+	}
+	if sso.AuthTime.IsZero() {
+		t.Errorf("expected SSO session to have an auth time")
+	}
+
+	// Other clients can log the user in using the SSO session.
+	key, err = f.srv.NewSession(session.AuthRequest{
+		ConnectorID: sso.ConnectorID,
+		ClientID:    testClientID,
+		ClientState: "state",
+		RedirectURL: testRedirectURL,
+		Scope:       []string{"openid"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ru, err = f.srv.SSOLogin(*sso, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, err := url.Parse(ru)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u.Host != testRedirectURL.Host || u.Query().Get("state") != "state" {
+		t.Fatalf("unexpected redirect: %s", ru)
+	}
+	idToken, _, _, _, err := f.srv.CodeToken(testClientCredentials, u.Query().Get("code"), "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims, err := idToken.Claims()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sub, _, _ := claims.StringClaim("sub"); sub != testUserID1 {
+		t.Errorf("want sub %q, got %q", testUserID1, sub)
+	}
+	if authTime, _, _ := claims.Int64Claim("auth_time"); authTime != sso.AuthTime.Unix() {
+		t.Errorf("want auth_time %d, got %d", sso.AuthTime.Unix(), authTime)
+	}
+	if sid, _, _ := claims.StringClaim("sid"); sid != session.SID(ssoID) {
+		t.Errorf("want sid %q, got %q", session.SID(ssoID), sid)
+	}
+	if sso, err = f.srv.SSOSession(ssoID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare([]string{testClientID}, sso.ClientIDs); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+
+	// SSO sessions of disabled users can't be used.
+	if err := f.srv.UserManager.Disable(testUserID1, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := f.srv.SSOSession(ssoID); err != session.ErrorSSOSessionNotFound {
+		t.Errorf("want %v, got %v", session.ErrorSSOSessionNotFound, err)
+	}
+}
+
+func TestServerUsableSSOSession(t *testing.T) {
+	f, err := makeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	// The session manager's clock isn't the wall clock.
+	clock := clockwork.NewFakeClock()
+	f.srv.SessionManager.Clock = clock
+	err = f.srv.SSOSessionRepo.Create(session.SSOSession{
+		ID:          "sso-1",
+		UserID:      testUserID1,
+		ConnectorID: testConnectorID1,
+		Identity:    oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1},
+		AuthTime:    clock.Now().Add(-10 * time.Minute),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sso := f.srv.UsableSSOSession("sso-1", "", 20*60, ""); sso == nil {
+		t.Errorf("want SSO session usable within max_age")
+	}
+	if sso := f.srv.UsableSSOSession("sso-1", "", 5*60, ""); sso != nil {
+		t.Errorf("want SSO session unusable once max_age has passed")
+	}
+	clock.Advance(15 * time.Minute)
+	if sso := f.srv.UsableSSOSession("sso-1", "", 20*60, ""); sso != nil {
+		t.Errorf("want SSO session unusable once max_age has passed on the session manager's clock")
+	}
+}
+
+func TestServerAuthResponse(t *testing.T) {
+	ident := oidc.Identity{ID: testUserRemoteID1, Email: testUserEmail1}
+
+	tests := []struct {
+		responseType string
+		responseMode string
+
+		wantFragment bool
+		wantCode     bool
+		wantIDToken  bool
+		wantToken    bool
+	}{
+		{
+			responseType: "",
+			wantCode:     true,
+		},
+		{
+			responseType: "code",
+			responseMode: "query",
+			wantCode:     true,
+		},
+		{
+			responseType: "code",
+			responseMode: "fragment",
+			wantFragment: true,
+			wantCode:     true,
+		},
+		{
+			responseType: "id_token",
+			responseMode: "fragment",
+			wantFragment: true,
+			wantIDToken:  true,
+		},
+		{
+			responseType: "id_token token",
+			responseMode: "fragment",
+			wantFragment: true,
+			wantIDToken:  true,
+			wantToken:    true,
+		},
+		{
+			responseType: "code id_token",
+			responseMode: "fragment",
+			wantFragment: true,
+			wantCode:     true,
+			wantIDToken:  true,
+		},
+		{
+			responseType: "code id_token token",
+			responseMode: "fragment",
+			wantFragment: true,
+			wantCode:     true,
+			wantIDToken:  true,
+			wantToken:    true,
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTestFixtures()
+		if err != nil {
+			t.Fatalf("error making test fixtures: %v", err)
+		}
+		key, err := f.srv.NewSession(session.AuthRequest{
+			ConnectorID:  testConnectorID1,
+			ClientID:     testClientID,
+			ClientState:  "state",
+			RedirectURL:  testRedirectURL,
+			Nonce:        "nonce",
+			Scope:        []string{"openid"},
+			ResponseType: tt.responseType,
+			ResponseMode: tt.responseMode,
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		ru, err := f.srv.Login(ident, key)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		u, err := url.Parse(ru)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+
+		params := u.Query()
+		if tt.wantFragment {
+			if u.RawQuery != "" {
+				t.Errorf("case %d: want no query, got %q", i, u.RawQuery)
+			}
+			if params, err = url.ParseQuery(u.Fragment); err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+		} else if u.Fragment != "" {
+			t.Errorf("case %d: want no fragment, got %q", i, u.Fragment)
+		}
+		if params.Get("state") != "state" {
+			t.Errorf("case %d: want state %q, got %q", i, "state", params.Get("state"))
+		}
+
+		code, accessToken := params.Get("code"), params.Get("access_token")
+		if gotCode := code != ""; gotCode != tt.wantCode {
+			t.Errorf("case %d: want code=%t, got %t", i, tt.wantCode, gotCode)
+		}
+		if gotToken := accessToken != ""; gotToken != tt.wantToken {
+			t.Errorf("case %d: want access token=%t, got %t", i, tt.wantToken, gotToken)
+		}
+		if tt.wantToken && params.Get("token_type") != "bearer" {
+			t.Errorf("case %d: want token type bearer, got %q", i, params.Get("token_type"))
+		}
+
+		if !tt.wantIDToken {
+			if params.Get("id_token") != "" {
+				t.Errorf("case %d: want no ID token", i)
+			}
+			continue
+		}
+		jwt, err := jose.ParseJWT(params.Get("id_token"))
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		claims, err := jwt.Claims()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if nonce, _, _ := claims.StringClaim("nonce"); nonce != "nonce" {
+			t.Errorf("case %d: want nonce %q, got %q", i, "nonce", nonce)
+		}
+		var wantAtHash, wantCHash string
+		if accessToken != "" {
+			wantAtHash = tokenHash(signing.RS256, accessToken)
+		}
+		if code != "" {
+			wantCHash = tokenHash(signing.RS256, code)
+		}
+		if atHash, _, _ := claims.StringClaim("at_hash"); atHash != wantAtHash {
+			t.Errorf("case %d: want at_hash %q, got %q", i, wantAtHash, atHash)
+		}
+		if cHash, _, _ := claims.StringClaim("c_hash"); cHash != wantCHash {
+			t.Errorf("case %d: want c_hash %q, got %q", i, wantCHash, cHash)
+		}
+
+		// Codes returned alongside ID tokens can still be exchanged for
+		// tokens.
+		if code != "" {
+			if _, _, _, _, err := f.srv.CodeToken(testClientCredentials, code, "", nil); err != nil {
+				t.Errorf("case %d: unexpected error: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestTokenHash(t *testing.T) {
+	// Example from OpenID Connect Core 1.0 Appendix A.3.
+	accessToken := "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y"
+	if got, want := tokenHash(signing.RS256, accessToken), "77QmUPtjPfzWtF2AnpK9RQ"; got != want {
+		t.Errorf("want at_hash %q, got %q", want, got)
+	}
+}
+
+func TestServerNotifyLogout(t *testing.T) {
+	tokens := make(chan string, 2)
+	failures := 1
+	hdlr := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first delivery fails, and has to be retried.
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		tokens <- r.PostFormValue("logout_token")
+	})
+	rp := httptest.NewServer(hdlr)
+	defer rp.Close()
+	backchannelLogoutURI, err := url.Parse(rp.URL + "/logout")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clients := []client.Client{
+		{
+			Credentials: testClientCredentials,
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{testRedirectURL},
+			},
+			BackchannelLogoutURI: backchannelLogoutURI,
+		},
+		{
+			Credentials: testPublicClientCredentials,
+			Public:      true,
+		},
+	}
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{
+		clients: clientsToLoadableClients(clients),
+	})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+
+	f.srv.NotifyLogout(session.SSOSession{
+		ID:        "sso-1",
+		UserID:    testUserID1,
+		ClientIDs: []string{testClientID, testPublicClientID},
+	})
+
+	var token string
+	select {
+	case token = <-tokens:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("logout token wasn't delivered")
+	}
+	jwt, claims, err := f.srv.verifyTokenSignature(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if typ := jwt.Header[jose.HeaderMediaType]; typ != logoutTokenJWTType {
+		t.Errorf("want typ %q, got %q", logoutTokenJWTType, typ)
+	}
+	if _, err := f.srv.IDTokenHintSubject(testClientID, token); err == nil {
+		t.Errorf("logout token accepted as an ID token hint")
+	}
+	if sub, _, _ := claims.StringClaim("sub"); sub != testUserID1 {
+		t.Errorf("want sub %q, got %q", testUserID1, sub)
+	}
+	if aud, _, _ := claims.StringClaim("aud"); aud != testClientID {
+		t.Errorf("want aud %q, got %q", testClientID, aud)
+	}
+	if sid, _, _ := claims.StringClaim("sid"); sid != session.SID("sso-1") {
+		t.Errorf("want sid %q, got %q", session.SID("sso-1"), sid)
+	}
+	events, ok := claims["events"].(map[string]interface{})
+	if _, hasEvent := events[backchannelLogoutEvent]; !ok || !hasEvent {
+		t.Errorf("want %s event, got %v", backchannelLogoutEvent, claims["events"])
+	}
+	if _, ok := claims["nonce"]; ok {
+		t.Errorf("logout tokens must not contain a nonce")
+	}
+
+	// Clients without a back-channel logout URI aren't notified.
+	select {
+	case token = <-tokens:
+		t.Errorf("unexpected logout token delivered: %s", token)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServerConsentRequired(t *testing.T) {
+	thirdPartyClientID := "third-party.example.com"
+	clients := []client.Client{
+		client.Client{
+			Credentials: testClientCredentials,
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{testRedirectURL},
+			},
+			FirstParty: true,
+		},
+		client.Client{
+			Credentials: oidc.ClientCredentials{
+				ID:     thirdPartyClientID,
+				Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
+			},
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{testRedirectURL},
+			},
+		},
+	}
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{
+		clients: clientsToLoadableClients(clients),
+	})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	err = f.srv.ConsentRepo.Set(consent.Consent{
+		UserID:   testUserID1,
+		ClientID: thirdPartyClientID,
+		Scope:    []string{"openid", "email"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		clientID string
+		userID   string
+		scopes   scope.Scopes
+		want     bool
+	}{
+		// first-party clients never need consent
+		{testClientID, testUserID1, scope.Scopes{"openid", "groups"}, false},
+		{thirdPartyClientID, testUserID1, scope.Scopes{"openid"}, false},
+		{thirdPartyClientID, testUserID1, scope.Scopes{"openid", "email"}, false},
+		// a scope the user wasn't asked about
+		{thirdPartyClientID, testUserID1, scope.Scopes{"openid", "offline_access"}, true},
+		// another user
+		{thirdPartyClientID, "ID-Verified", scope.Scopes{"openid"}, true},
+	}
+
+	for i, tt := range tests {
+		got, err := f.srv.ConsentRequired(tt.clientID, tt.userID, tt.scopes)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("case %d: want consent required=%t, got %t", i, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+
+	"github.com/coreos/dex/signing"
+)
+
+// multiAlgKeyManager is implemented by key managers which hold keys for other
+// algorithms than RS256, such as signing.PrivateKeyManager. With any other
+// key manager, tokens are only signed with RS256.
+type multiAlgKeyManager interface {
+	SignerFor(alg string) (jose.Signer, error)
+	Algorithms() []string
+	AllJWKs() ([]signing.JWK, error)
+}
+
+// SigningAlgorithms returns the algorithms ID tokens may be signed with. The
+// first is used for clients which didn't register an
+// id_token_signed_response_alg.
+func (s *Server) SigningAlgorithms() []string {
+	if km, ok := s.KeyManager.(multiAlgKeyManager); ok {
+		if algs := km.Algorithms(); len(algs) > 0 {
+			return algs
+		}
+	}
+	return []string{signing.RS256}
+}
+
+// idTokenSigner returns the signer of ID tokens issued to the client, using
+// the algorithm it registered as its id_token_signed_response_alg, or the
+// default one. Tokens only used by dex itself are always signed with RS256 by
+// KeyManager.Signer.
+func (s *Server) idTokenSigner(clientID string) (jose.Signer, error) {
+	cli, err := s.Client(clientID)
+	if err != nil {
+		return nil, err
+	}
+	alg := cli.Metadata.IDTokenResponseOptions.SigningAlg
+
+	km, ok := s.KeyManager.(multiAlgKeyManager)
+	if !ok {
+		if alg != "" && alg != signing.RS256 {
+			return nil, signing.ErrorUnsupportedAlgorithm
+		}
+		return s.KeyManager.Signer()
+	}
+	if alg == "" {
+		alg = s.SigningAlgorithms()[0]
+	}
+	return km.SignerFor(alg)
+}
+
+// tokenHash returns the value of the at_hash or c_hash claim of an ID token
+// signed with alg for the access token or code: the left-most half of the
+// hash of its ASCII representation, base64url encoded (OpenID Connect Core 1.0
+// Section 3.3.2.11). Ed25519 signatures use SHA-512, the others SHA-256.
+func tokenHash(alg, token string) string {
+	var h []byte
+	if alg == signing.EdDSA {
+		sum := sha512.Sum512([]byte(token))
+		h = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(token))
+		h = sum[:]
+	}
+	return base64.RawURLEncoding.EncodeToString(h[:len(h)/2])
+}
+
+// publicJWKs returns the public keys of every algorithm the key manager signs
+// tokens with.
+func publicJWKs(km key.PrivateKeyManager) ([]signing.JWK, error) {
+	if km, ok := km.(multiAlgKeyManager); ok {
+		return km.AllJWKs()
+	}
+
+	jwks, err := km.JWKs()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]signing.JWK, len(jwks))
+	for i, jwk := range jwks {
+		keys[i] = signing.NewJWK(jwk)
+	}
+	return keys, nil
+}
+
+// verifySignature checks that the JWT was signed by this server, with any of
+// the algorithms it signs tokens with.
+func (s *Server) verifySignature(jwt jose.JWT) error {
+	keys, err := publicJWKs(s.KeyManager)
+	if err != nil {
+		return err
+	}
+	if !signing.VerifySignature(jwt, keys) {
+		return errors.New("invalid token signature")
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/session"
+	"github.com/coreos/dex/user"
+)
+
+const (
+	cookieSSOSession = "dex_sso_session"
+	cookieSSOLogin   = "dex_sso_login"
+)
+
+// SSOSession returns the SSO session with the given ID. Sessions of users who
+// have since been disabled or deleted, or which were started with a connector
+// which no longer exists, are treated as if they don't exist.
+func (s *Server) SSOSession(id string) (*session.SSOSession, error) {
+	sso, err := s.SSOSessionRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.connector(sso.ConnectorID); !ok {
+		return nil, session.ErrorSSOSessionNotFound
+	}
+
+	usr, err := s.UserRepo.Get(nil, sso.UserID)
+	switch {
+	case err == user.ErrorNotFound || err == nil && usr.Disabled:
+		return nil, session.ErrorSSOSessionNotFound
+	case err != nil:
+		return nil, err
+	}
+	return sso, nil
+}
+
+// SSOLogin identifies the user of the session referred to by key as the user
+// of the SSO session, rather than having them authenticate with a connector.
+// The session's ID token reports the time the SSO session started as the
+// user's auth_time.
+func (s *Server) SSOLogin(sso session.SSOSession, key string) (string, error) {
+	sessionID, err := s.SessionManager.ExchangeKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	// The session's ID token identifies the SSO session by its sid.
+	if _, err := s.SessionManager.AttachSSOSession(sessionID, sso.ID); err != nil {
+		return "", err
+	}
+
+	ses, redirect, err := s.login(sessionID, sso.Identity, sso.AuthTime)
+	if err != nil || redirect != "" {
+		return redirect, err
+	}
+	if ses.UserID != sso.UserID {
+		return "", fmt.Errorf("SSO session %s identity belongs to user %s, expected %s", sso.ID, ses.UserID, sso.UserID)
+	}
+	if err := s.SSOSessionRepo.AddClient(sso.ID, ses.ClientID); err != nil {
+		return "", err
+	}
+	log.Infof("Session %s logged in using SSO session: clientID=%s", sessionID, ses.ClientID)
+
+	return s.authResponseURL(ses)
+}
+
+// ssoStartURL returns the URL of the last step of an interactive login, which
+// is served in the browser the user finished logging in with.
+func (s *Server) ssoStartURL(sessionID string) (string, error) {
+	key, err := s.SessionManager.NewSessionKey(sessionID)
+	if err != nil {
+		return "", err
+	}
+	u := s.absURL(httpPathSSOStart)
+	q := u.Query()
+	q.Set("code", key)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// handleSSOStartFunc serves the last step of an interactive login. The user's
+// SSO session is started in their browser, which is then redirected on to the
+// client.
+func handleSSOStartFunc(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, "GET only acceptable method")
+			return
+		}
+
+		sessionID, err := s.SessionManager.ExchangeKey(r.URL.Query().Get("code"))
+		if err != nil {
+			phttp.WriteError(w, http.StatusBadRequest, "invalid or expired login")
+			return
+		}
+		ses, err := s.SessionManager.Get(sessionID)
+		if err != nil {
+			log.Errorf("Failed fetching session %s: %v", sessionID, err)
+			phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if ses.State != session.SessionStateIdentified {
+			phttp.WriteError(w, http.StatusBadRequest, "invalid or expired login")
+			return
+		}
+
+		redirect := func(ru string) {
+			w.Header().Set("Location", ru)
+			w.WriteHeader(http.StatusFound)
+		}
+		loginError := func(err error) {
+			ru, err := s.loginErrorURL(sessionID, err)
+			if err != nil {
+				log.Errorf("Failed ending session %s: %v", sessionID, err)
+				phttp.WriteError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			redirect(ru)
+		}
+
+		ses, err = s.startBrowserSSOSession(w, r, ses)
+		if err != nil {
+			log.Errorf("Session %s failed starting SSO session: %v", sessionID, err)
+			loginError(err)
+			return
+		}
+		ru, err := s.authResponseURL(ses)
+		if err != nil {
+			log.Errorf("Session %s failed creating authorization response: %v", sessionID, err)
+			loginError(err)
+			return
+		}
+		redirect(ru)
+	}
+}
+
+// startBrowserSSOSession starts an SSO session for the user of the identified
+// session and sets its cookie, if the browser the user finished logging in
+// with is the one which started the session. Otherwise, the user is only
+// logged in to the client: their browser may not be the one the SSO session
+// would be used from.
+func (s *Server) startBrowserSSOSession(w http.ResponseWriter, r *http.Request, ses *session.Session) (*session.Session, error) {
+	if ses.SSOLoginNonce == "" {
+		return ses, nil
+	}
+	http.SetCookie(w, expiredSSOLoginCookie(s.IssuerURL))
+	c, err := r.Cookie(cookieSSOLogin)
+	if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(ses.SSOLoginNonce)) != 1 {
+		log.Infof("Session %s finished in another browser than it was started in, not starting SSO session", ses.ID)
+		return ses, nil
+	}
+
+	// IDs are never reused, so a browser can't be made to log in to an SSO
+	// session whose ID is known to somebody else.
+	id, err := session.NewSSOSessionID()
+	if err != nil {
+		return nil, err
+	}
+	if ses, err = s.SessionManager.AttachSSOSession(ses.ID, id); err != nil {
+		return nil, err
+	}
+	if err := s.startSSOSession(ses); err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, newSSOSessionCookie(s.IssuerURL, id))
+	return ses, nil
+}
+
+// startSSOSession records the SSO session attached to the identified session,
+// so later authentication requests from the same browser can skip the
+// connector.
+func (s *Server) startSSOSession(ses *session.Session) error {
+	validity := s.SSOSessionValidityWindow
+	if validity == 0 {
+		validity = session.DefaultSSOSessionValidityWindow
+	}
+	now := s.SessionManager.Clock.Now()
+
+	sso := session.SSOSession{
+		ID:          ses.SSOSessionID,
+		UserID:      ses.UserID,
+		ConnectorID: ses.ConnectorID,
+		Identity:    ses.Identity,
+		AuthTime:    ses.AuthTime,
+		ClientIDs:   []string{ses.ClientID},
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(validity),
+	}
+	if err := s.SSOSessionRepo.Create(sso); err != nil {
+		return err
+	}
+	log.Infof("Session %s started SSO session: user=%s", ses.ID, ses.UserID)
+	return nil
+}
+
+// UsableSSOSession returns the browser's SSO session if it can be used to
+// authenticate the user for an authentication request, or nil if the user has
+// to log in with a connector. The user's auth_time is compared against the
+// session manager's clock, which it was recorded with.
+func (s *Server) UsableSSOSession(id, connectorID string, maxAge int, hintSubject string) *session.SSOSession {
+	sso, err := s.SSOSession(id)
+	if err != nil {
+		if err != session.ErrorSSOSessionNotFound {
+			log.Errorf("Failed fetching SSO session: %v", err)
+		}
+		return nil
+	}
+
+	switch {
+	case connectorID != "" && connectorID != sso.ConnectorID:
+		// The client wants the user to log in with another connector.
+		return nil
+	case maxAge >= 0 && s.SessionManager.Clock.Now().Sub(sso.AuthTime) > time.Duration(maxAge)*time.Second:
+		// The user authenticated too long ago (OpenID Connect Core 1.0
+		// Section 3.1.2.1).
+		return nil
+	case hintSubject != "" && hintSubject != sso.UserID:
+		// The client expects a different user to be logged in.
+		return nil
+	}
+	return sso
+}
+
+// newSSOSessionCookie creates the cookie holding the browser's SSO session ID.
+// It only lasts as long as the browser session, and is never sent to other
+// paths than dex's own.
+func newSSOSessionCookie(baseURL url.URL, id string) *http.Cookie {
+	path := baseURL.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     cookieSSOSession,
+		Value:    id,
+		Path:     path,
+		Secure:   baseURL.Scheme == "https",
+		HttpOnly: true,
+	}
+}
+
+// expiredSSOSessionCookie creates a cookie which removes the browser's SSO
+// session cookie.
+func expiredSSOSessionCookie(baseURL url.URL) *http.Cookie {
+	c := newSSOSessionCookie(baseURL, "")
+	c.MaxAge = -1
+	return c
+}
+
+// newSSOLoginCookie creates the cookie holding the SSO login nonce of the
+// session the browser started.
+func newSSOLoginCookie(baseURL url.URL, nonce string) *http.Cookie {
+	c := newSSOSessionCookie(baseURL, nonce)
+	c.Name = cookieSSOLogin
+	return c
+}
+
+// expiredSSOLoginCookie creates a cookie which removes the browser's SSO login
+// nonce cookie.
+func expiredSSOLoginCookie(baseURL url.URL) *http.Cookie {
+	c := newSSOLoginCookie(baseURL, "")
+	c.MaxAge = -1
+	return c
+}
+
+// ssoSessionID returns the ID of the SSO session the browser has, if any.
+func ssoSessionID(r *http.Request) string {
+	c, err := r.Cookie(cookieSSOSession)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
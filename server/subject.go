@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/coreos/dex/user"
+)
+
+var errMissingPairwiseSubjectSalt = errors.New("no pairwise subject salt is configured")
+
+// subject returns the subject identifier the user is known by to the client.
+// It is the user's ID, unless the client registered the pairwise subject type,
+// in which case it's derived from the client's sector and recorded so that it
+// can be resolved back to the user. Without a pairwise subject salt, users
+// aren't issued pairwise subjects at all.
+// See: http://openid.net/specs/openid-connect-core-1_0.html#SubjectIDTypes
+func (s *Server) subject(clientID, userID string) (string, error) {
+	cli, err := s.Client(clientID)
+	if err != nil {
+		return "", err
+	}
+	if !cli.PairwiseSubject() {
+		return userID, nil
+	}
+
+	if len(s.PairwiseSubjectSalt) == 0 {
+		return "", errMissingPairwiseSubjectSalt
+	}
+
+	sector, err := cli.SectorIdentifier()
+	if err != nil {
+		return "", err
+	}
+	ps := user.NewPairwiseSubject(sector, userID, s.PairwiseSubjectSalt)
+	switch _, err := s.PairwiseSubjectRepo.Get(ps.Subject); err {
+	case nil:
+		// The subject was recorded when it was first issued.
+		return ps.Subject, nil
+	case user.ErrorNotFound:
+	default:
+		return "", err
+	}
+	if err := s.PairwiseSubjectRepo.Create(ps); err != nil {
+		return "", err
+	}
+	return ps.Subject, nil
+}
+
+// subjectUserID resolves a subject identifier issued to the client back to the
+// ID of the user. The pairwise subjects of users are only resolved for clients
+// of the sector they were issued for; others are reported as
+// user.ErrorNotFound.
+func (s *Server) subjectUserID(clientID, sub string) (string, error) {
+	cli, err := s.Client(clientID)
+	if err != nil {
+		return "", err
+	}
+	if !cli.PairwiseSubject() {
+		return sub, nil
+	}
+
+	sector, err := cli.SectorIdentifier()
+	if err != nil {
+		return "", err
+	}
+	ps, err := s.PairwiseSubjectRepo.Get(sub)
+	if err != nil {
+		return "", err
+	}
+	if ps.SectorIdentifier != sector {
+		return "", user.ErrorNotFound
+	}
+	return ps.UserID, nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/session"
+)
+
+func TestServerPairwiseSubject(t *testing.T) {
+	newClient := func(id string, admin bool, m oidc.ClientMetadata) client.LoadableClient {
+		if m.RedirectURIs == nil {
+			m.RedirectURIs = []url.URL{{Scheme: "https", Host: id, Path: "/callback"}}
+		}
+		return client.LoadableClient{
+			Client: client.Client{
+				Credentials: oidc.ClientCredentials{ID: id, Secret: clientTestSecret},
+				Metadata:    m,
+				Admin:       admin,
+			},
+		}
+	}
+	clients := []client.LoadableClient{
+		newClient("public.example.com", true, oidc.ClientMetadata{}),
+		newClient("app.example.com", false, oidc.ClientMetadata{SubjectType: oidc.SubjectTypePairwise}),
+		newClient("other.example.com", false, oidc.ClientMetadata{SubjectType: oidc.SubjectTypePairwise}),
+		newClient("sector.example.com", false, oidc.ClientMetadata{
+			SubjectType:         oidc.SubjectTypePairwise,
+			SectorIdentifierURI: &url.URL{Scheme: "https", Host: "app.example.com", Path: "/sector.json"},
+		}),
+	}
+	f, err := makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	sm := f.sessionManager
+
+	// token returns the subject of the ID token issued to the client, and
+	// its access token.
+	token := func(clientID string) (string, string) {
+		sessionID, err := sm.NewSession(session.AuthRequest{
+			ConnectorID: testConnectorID1,
+			ClientID:    clientID,
+			ClientState: "bogus",
+			Scope:       []string{"openid"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err = sm.AttachRemoteIdentity(sessionID, oidc.Identity{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err = sm.AttachUser(sessionID, testUserID1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		key, err := sm.NewSessionKey(sessionID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		jwt, accessToken, _, _, err := f.srv.CodeToken(oidc.ClientCredentials{ID: clientID, Secret: clientTestSecret}, key, "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		claims, err := jwt.Claims()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		atClaims, err := accessToken.Claims()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sub, _, _ := claims.StringClaim("sub")
+		if atSub, _, _ := atClaims.StringClaim("sub"); atSub != sub {
+			t.Errorf("%s: want access token subject %q, got %q", clientID, sub, atSub)
+		}
+		return sub, accessToken.Encode()
+	}
+
+	if sub, _ := token("public.example.com"); sub != testUserID1 {
+		t.Errorf("want public subject %q, got %q", testUserID1, sub)
+	}
+	appSub, appToken := token("app.example.com")
+	if appSub == testUserID1 {
+		t.Errorf("want pairwise subject, got the user ID")
+	}
+	if sub, _ := token("app.example.com"); sub != appSub {
+		t.Errorf("want stable subject %q, got %q", appSub, sub)
+	}
+	if sub, _ := token("sector.example.com"); sub != appSub {
+		t.Errorf("want the subject of the sector %q, got %q", appSub, sub)
+	}
+	otherSub, _ := token("other.example.com")
+	if otherSub == appSub || otherSub == testUserID1 {
+		t.Errorf("want distinct pairwise subject, got %q", otherSub)
+	}
+
+	info, err := f.srv.UserInfo(appToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sub, _, _ := info.StringClaim("sub"); sub != appSub {
+		t.Errorf("want UserInfo subject %q, got %q", appSub, sub)
+	}
+	if _, ok := info["email"]; !ok {
+		t.Errorf("want UserInfo email, got claims %v", info)
+	}
+
+	// Only admin clients are told which user a pairwise subject is.
+	introspections := []struct {
+		clientID   string
+		wantUserID string
+	}{
+		{"public.example.com", testUserID1},
+		{"other.example.com", ""},
+	}
+	for _, tt := range introspections {
+		in, err := f.srv.Introspect(oidc.ClientCredentials{ID: tt.clientID, Secret: clientTestSecret}, appToken)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !in.Active || in.Subject != appSub || in.UserID != tt.wantUserID {
+			t.Errorf("%s: unexpected introspection: %#v", tt.clientID, in)
+		}
+	}
+
+	// Without a salt, pairwise clients aren't issued tokens.
+	f.srv.PairwiseSubjectSalt = nil
+	if _, err := f.srv.subject("app.example.com", testUserID1); err != errMissingPairwiseSubjectSalt {
+		t.Errorf("want err %v, got %v", errMissingPairwiseSubjectSalt, err)
+	}
+	if sub, _ := token("public.example.com"); sub != testUserID1 {
+		t.Errorf("want public subject %q, got %q", testUserID1, sub)
+	}
+}
@@ -55,12 +55,14 @@ var (
 						testRedirectURL,
 					},
 				},
+				FirstParty: true,
 			},
 		},
 		{
 			Client: client.Client{
 				Credentials: testPublicClientCredentials,
 				Public:      true,
+				FirstParty:  true,
 			},
 		},
 	}
@@ -114,6 +116,8 @@ var (
 
 	testPrivKey, _ = key.GeneratePrivateKey()
 
+	testKeySecret = []byte("abcdefghijklmnopqrstuvwxyz123456")
+
 	testClientCreds = oidc.ClientCredentials{
 		ID:     testClientID,
 		Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
@@ -217,7 +221,7 @@ func makeTestFixturesWithOptions(options testFixtureOptions) (*testFixtures, err
 	secGen := func() ([]byte, error) {
 		return []byte("secret"), nil
 	}
-	clientRepo, err := db.NewClientRepoFromClients(dbMap, clients)
+	clientRepo, err := db.NewClientRepoFromClients(dbMap, clients, testKeySecret)
 	if err != nil {
 		return nil, err
 	}
@@ -248,6 +252,14 @@ func makeTestFixturesWithOptions(options testFixtureOptions) (*testFixtures, err
 		ClientManager:    clientManager,
 		KeyManager:       km,
 		RefreshTokenRepo: refreshTokenRepo,
+		DeviceGrantRepo:  db.NewDeviceGrantRepo(db.NewMemDB()),
+		SSOSessionRepo:   db.NewSSOSessionRepo(db.NewMemDB()),
+		ConsentRepo:      db.NewConsentRepo(db.NewMemDB()),
+
+		ClientAssertionRepo:   db.NewClientAssertionRepo(db.NewMemDB()),
+		PushedAuthRequestRepo: db.NewPushedAuthRequestRepo(db.NewMemDB()),
+		PairwiseSubjectRepo:   db.NewPairwiseSubjectRepo(db.NewMemDB()),
+		PairwiseSubjectSalt:   []byte("salt"),
 	}
 
 	err = setTemplates(srv, tpl)
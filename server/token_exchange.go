@@ -0,0 +1,254 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+	"github.com/coreos/dex/user"
+)
+
+// Token type identifiers of the tokens which can be exchanged, and of the
+// issued token (RFC 8693 Section 3).
+const (
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeIDToken     = "urn:ietf:params:oauth:token-type:id_token"
+	tokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// TokenExchangeRequest holds the parameters of a token exchange request
+// (RFC 8693 Section 2.1).
+type TokenExchangeRequest struct {
+	// SubjectToken is an ID or access token this server issued for a user,
+	// which the new token is issued on behalf of.
+	SubjectToken     string
+	SubjectTokenType string
+
+	// ActorToken optionally identifies the party acting on the user's
+	// behalf, with an access token obtained using client credentials.
+	ActorToken     string
+	ActorTokenType string
+
+	// Audience lists the IDs of the clients the token is requested for.
+	Audience  []string
+	Resources []string
+	Scope     []string
+}
+
+// ExchangeToken implements the token exchange grant (RFC 8693). A client may
+// exchange a token issued for a user, which was issued to it or names it in
+// its audience, for an access token for other clients, if each of them lists
+// it as a token exchange peer, or for its resources. The new token's act claim
+// names the actor, which is the client unless an actor token is given. Actor
+// tokens must have been issued to the client, or to a client which lists it as
+// a token exchange peer.
+func (s *Server) ExchangeToken(creds oidc.ClientCredentials, req TokenExchangeRequest) (*jose.JWT, time.Time, error) {
+	if err := s.authenticateClient(creds, false); err != nil {
+		return nil, time.Time{}, err
+	}
+	if err := s.checkGrantType(creds.ID, client.GrantTypeTokenExchange); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	cli, err := s.Client(creds.ID)
+	if err != nil {
+		log.Errorf("Failed fetching client %s from repo: %v", creds.ID, err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	subject, claims, err := s.exchangedTokenClaims(req.SubjectToken, req.SubjectTokenType)
+	if err != nil {
+		log.Errorf("Client %s presented an invalid subject token: %v", creds.ID, err)
+		return nil, time.Time{}, tokenExchangeError("invalid subject_token")
+	}
+	if !tokenIssuedFor(subject, claims, cli) {
+		log.Errorf("Client %s presented a subject token issued to another client", creds.ID)
+		return nil, time.Time{}, tokenExchangeError("subject_token was not issued to the client")
+	}
+
+	sub, _, _ := claims.StringClaim("sub")
+	subClientID := tokenClientID(claims)
+	if sub == subClientID {
+		return nil, time.Time{}, tokenExchangeError("subject_token was not issued for a user")
+	}
+	userID, err := s.subjectUserID(subClientID, sub)
+	switch err {
+	case nil:
+	case user.ErrorNotFound, client.ErrorNotFound:
+		return nil, time.Time{}, tokenExchangeError("invalid subject_token")
+	default:
+		log.Errorf("Failed to resolve subject %q: %v", sub, err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	usr, err := s.UserRepo.Get(nil, userID)
+	switch {
+	case err == user.ErrorNotFound || (err == nil && usr.Disabled):
+		return nil, time.Time{}, tokenExchangeError("invalid subject_token")
+	case err != nil:
+		log.Errorf("Failed to fetch user %q from repo: %v", userID, err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+
+	act := jose.Claims{"sub": creds.ID}
+	if req.ActorToken != "" {
+		actorJWT, actor, err := s.exchangedTokenClaims(req.ActorToken, req.ActorTokenType)
+		if err != nil {
+			log.Errorf("Client %s presented an invalid actor token: %v", creds.ID, err)
+			return nil, time.Time{}, tokenExchangeError("invalid actor_token")
+		}
+		actorID, _, _ := actor.StringClaim("sub")
+		if !isAccessToken(actorJWT) || actorID != tokenClientID(actor) {
+			return nil, time.Time{}, tokenExchangeError("actor_token must be an access token obtained using client credentials")
+		}
+		if actorID != creds.ID {
+			ok, err := s.isTokenExchangePeer(actorID, creds.ID)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			if !ok {
+				log.Errorf("Client %s presented an actor token of client %s, which doesn't list it as a token exchange peer", creds.ID, actorID)
+				return nil, time.Time{}, tokenExchangeError("actor_token was not issued to the client or one of its peers")
+			}
+		}
+		act = jose.Claims{"sub": actorID}
+	}
+	// A token which was itself obtained by token exchange carries the
+	// previous actors (RFC 8693 Section 4.1).
+	if prev, ok := claims[claimAct]; ok {
+		act[claimAct] = prev
+	}
+
+	scp, _, _ := claims.StringClaim(claimScope)
+	granted := strings.Fields(scp)
+	scopes := granted
+	if len(req.Scope) > 0 {
+		for _, sc := range req.Scope {
+			if !pstrings.ContainsString(granted, sc) || !cli.ScopeAllowed(sc) {
+				err := oauth2.NewError(errorInvalidScope)
+				err.Description = fmt.Sprintf("scope %q was not granted by the subject_token", sc)
+				return nil, time.Time{}, err
+			}
+		}
+		scopes = req.Scope
+	}
+
+	aud, err := s.tokenExchangeAudience(creds.ID, req.Audience, req.Resources)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	newSub, err := s.subject(creds.ID, userID)
+	if err != nil {
+		log.Errorf("Failed to determine subject of user %s for client %s: %v", userID, creds.ID, err)
+		return nil, time.Time{}, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	groups, ok, _ := claims.StringsClaim("groups")
+	if ok && groups == nil {
+		groups = []string{}
+	}
+	userInfoClaims, _, _ := claims.StringsClaim(claimUserInfoClaims)
+
+	accessToken, expiresAt, err := s.accessToken(creds.ID, newSub, aud, scopes, groups, userInfoClaims, act)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	log.Infof("Token exchanged: clientID=%s userID=%s actor=%v aud=%v", creds.ID, userID, act["sub"], aud)
+	return accessToken, expiresAt, nil
+}
+
+// exchangedTokenClaims verifies a subject or actor token of the given token
+// type, returning it along with its claims.
+func (s *Server) exchangedTokenClaims(token, tokenType string) (jose.JWT, jose.Claims, error) {
+	jwt, claims, err := s.verifyToken(token)
+	if err != nil {
+		return jose.JWT{}, nil, err
+	}
+	switch tokenType {
+	case tokenTypeJWT:
+	case tokenTypeAccessToken:
+		if !isAccessToken(jwt) {
+			return jose.JWT{}, nil, errors.New("token is not an access token")
+		}
+	case tokenTypeIDToken:
+		if isAccessToken(jwt) {
+			return jose.JWT{}, nil, errors.New("token is not an ID token")
+		}
+	default:
+		return jose.JWT{}, nil, fmt.Errorf("unsupported token type %q", tokenType)
+	}
+	return jwt, claims, nil
+}
+
+// tokenIssuedFor reports whether a verified token was issued to the client, or
+// for it: ID tokens name it in their audience, and access tokens name it or one
+// of its resources.
+func tokenIssuedFor(jwt jose.JWT, claims jose.Claims, cli client.Client) bool {
+	if tokenClientID(claims) == cli.Credentials.ID {
+		return true
+	}
+	for _, aud := range audienceClaim(claims) {
+		if aud == cli.Credentials.ID || (isAccessToken(jwt) && pstrings.ContainsString(cli.Resources, aud)) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenExchangeAudience determines the audience of a token issued to the
+// client by token exchange. Each requested audience must be a client which
+// lists the requesting client as a token exchange peer, and requested
+// resources must be allowed as they are for other grants. Without either,
+// the audience is that of the client's other access tokens.
+func (s *Server) tokenExchangeAudience(clientID string, audience, resources []string) ([]string, error) {
+	for _, aud := range audience {
+		if aud == clientID {
+			continue
+		}
+		ok, err := s.isTokenExchangePeer(aud, clientID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			err := oauth2.NewError(errorInvalidTarget)
+			err.Description = fmt.Sprintf("client is not allowed to exchange tokens for audience %q", aud)
+			return nil, err
+		}
+	}
+	if len(audience) > 0 && len(resources) == 0 {
+		return audience, nil
+	}
+
+	aud, err := s.accessTokenAudience(clientID, resources)
+	if err != nil {
+		return nil, err
+	}
+	// The requested audience is copied rather than appended to, as it may
+	// share its backing array with the caller's.
+	return append(append([]string{}, audience...), aud...), nil
+}
+
+// isTokenExchangePeer reports whether the client lists peerID as one of its
+// token exchange peers.
+func (s *Server) isTokenExchangePeer(clientID, peerID string) (bool, error) {
+	peers, err := s.ClientRepo.GetTokenExchangePeers(nil, clientID)
+	if err != nil {
+		log.Errorf("Failed fetching token exchange peers of client %s: %v", clientID, err)
+		return false, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	return pstrings.ContainsString(peers, peerID), nil
+}
+
+func tokenExchangeError(desc string) error {
+	err := oauth2.NewError(oauth2.ErrorInvalidRequest)
+	err.Description = desc
+	return err
+}
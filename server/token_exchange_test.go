@@ -0,0 +1,412 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+)
+
+var (
+	testBackendCreds = oidc.ClientCredentials{
+		ID:     "backend.example.com",
+		Secret: base64.URLEncoding.EncodeToString([]byte("secret")),
+	}
+	testDownstreamClientID = "downstream.example.com"
+	testUnrelatedClientID  = "unrelated.example.com"
+)
+
+// makeTokenExchangeTestFixtures returns fixtures with a backend client, which
+// may exchange tokens for the downstream client, and an unrelated client which
+// doesn't allow it.
+func makeTokenExchangeTestFixtures() (*testFixtures, error) {
+	newClient := func(creds oidc.ClientCredentials) client.Client {
+		return client.Client{
+			Credentials: creds,
+			Metadata: oidc.ClientMetadata{
+				RedirectURIs: []url.URL{{Scheme: "https", Host: creds.ID, Path: "/callback"}},
+			},
+		}
+	}
+	secret := base64.URLEncoding.EncodeToString([]byte("secret"))
+	clients := append([]client.LoadableClient{
+		{Client: newClient(testBackendCreds)},
+		{
+			Client:             newClient(oidc.ClientCredentials{ID: testDownstreamClientID, Secret: secret}),
+			TokenExchangePeers: []string{testBackendCreds.ID},
+		},
+		{Client: newClient(oidc.ClientCredentials{ID: testUnrelatedClientID, Secret: secret})},
+	}, testClients...)
+	return makeTestFixturesWithOptions(testFixtureOptions{clients: clients})
+}
+
+func TestServerExchangeToken(t *testing.T) {
+	idToken := func(sub, aud string) string {
+		claims := oidc.NewClaims(testIssuerURL.String(), sub, aud, time.Now(), time.Now().Add(time.Hour))
+		jwt, err := jose.NewSignedJWT(claims, testPrivKey.Signer())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return jwt.Encode()
+	}
+
+	tests := []struct {
+		creds oidc.ClientCredentials
+		// req returns the request, given the test fixtures.
+		req     func(f *testFixtures) TokenExchangeRequest
+		disable bool
+
+		wantAud    []string
+		wantScope  string
+		wantActor  string
+		wantErrTyp string
+	}{
+		// ID token naming the backend in its audience
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeIDToken,
+					Audience:         []string{testDownstreamClientID},
+				}
+			},
+			wantAud:   []string{testDownstreamClientID},
+			wantActor: testBackendCreds.ID,
+		},
+		// access token issued to the backend, with a narrower scope
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				jwt, _, err := f.srv.accessToken(testBackendCreds.ID, testUserID1, []string{testIssuerURL.String()}, []string{"openid", "email"}, nil, nil, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return TokenExchangeRequest{
+					SubjectToken:     jwt.Encode(),
+					SubjectTokenType: tokenTypeAccessToken,
+					Audience:         []string{testDownstreamClientID},
+					Scope:            []string{"openid"},
+				}
+			},
+			wantAud:   []string{testDownstreamClientID},
+			wantScope: "openid",
+			wantActor: testBackendCreds.ID,
+		},
+		// without an audience, the token is for the backend's own resources
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeJWT,
+				}
+			},
+			wantAud:   []string{testIssuerURL.String()},
+			wantActor: testBackendCreds.ID,
+		},
+		// actor token obtained using client credentials by a client which
+		// lists the backend as a token exchange peer
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				creds := oidc.ClientCredentials{ID: testDownstreamClientID, Secret: testBackendCreds.Secret}
+				_, actor, _, err := f.srv.ClientCredsToken(creds, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeIDToken,
+					ActorToken:       actor.Encode(),
+					ActorTokenType:   tokenTypeAccessToken,
+					Audience:         []string{testDownstreamClientID},
+				}
+			},
+			wantAud:   []string{testDownstreamClientID},
+			wantActor: testDownstreamClientID,
+		},
+		// actor token of a client which doesn't list the backend as a peer
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				_, actor, _, err := f.srv.ClientCredsToken(testClientCredentials, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeIDToken,
+					ActorToken:       actor.Encode(),
+					ActorTokenType:   tokenTypeAccessToken,
+					Audience:         []string{testDownstreamClientID},
+				}
+			},
+			wantErrTyp: oauth2.ErrorInvalidRequest,
+		},
+		// actor token issued for a user
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeIDToken,
+					ActorToken:       idToken(testUserID1, testBackendCreds.ID),
+					ActorTokenType:   tokenTypeJWT,
+				}
+			},
+			wantErrTyp: oauth2.ErrorInvalidRequest,
+		},
+		// subject token issued to another client
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testClientID),
+					SubjectTokenType: tokenTypeIDToken,
+					Audience:         []string{testDownstreamClientID},
+				}
+			},
+			wantErrTyp: oauth2.ErrorInvalidRequest,
+		},
+		// subject token of the wrong type
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeAccessToken,
+				}
+			},
+			wantErrTyp: oauth2.ErrorInvalidRequest,
+		},
+		// subject token obtained using client credentials
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				_, token, _, err := f.srv.ClientCredsToken(testBackendCreds, nil)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return TokenExchangeRequest{
+					SubjectToken:     token.Encode(),
+					SubjectTokenType: tokenTypeAccessToken,
+				}
+			},
+			wantErrTyp: oauth2.ErrorInvalidRequest,
+		},
+		// disabled user
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeIDToken,
+				}
+			},
+			disable:    true,
+			wantErrTyp: oauth2.ErrorInvalidRequest,
+		},
+		// audience which doesn't allow the backend to exchange tokens
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeIDToken,
+					Audience:         []string{testUnrelatedClientID},
+				}
+			},
+			wantErrTyp: errorInvalidTarget,
+		},
+		// scope which wasn't granted
+		{
+			creds: testBackendCreds,
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testBackendCreds.ID),
+					SubjectTokenType: tokenTypeIDToken,
+					Scope:            []string{"email"},
+				}
+			},
+			wantErrTyp: errorInvalidScope,
+		},
+		// public clients can't exchange tokens
+		{
+			creds: oidc.ClientCredentials{ID: testPublicClientID},
+			req: func(f *testFixtures) TokenExchangeRequest {
+				return TokenExchangeRequest{
+					SubjectToken:     idToken(testUserID1, testPublicClientID),
+					SubjectTokenType: tokenTypeIDToken,
+				}
+			},
+			wantErrTyp: oauth2.ErrorInvalidClient,
+		},
+	}
+
+	for i, tt := range tests {
+		f, err := makeTokenExchangeTestFixtures()
+		if err != nil {
+			t.Fatalf("error making test fixtures: %v", err)
+		}
+		if tt.disable {
+			if err := f.userRepo.Disable(nil, testUserID1, true); err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+		}
+
+		jwt, _, err := f.srv.ExchangeToken(tt.creds, tt.req(f))
+		if tt.wantErrTyp != "" {
+			if !isOAuth2Error(err, tt.wantErrTyp) {
+				t.Errorf("case %d: want error %q, got %v", i, tt.wantErrTyp, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+
+		claims, err := jwt.Claims()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if sub, _, _ := claims.StringClaim("sub"); sub != testUserID1 {
+			t.Errorf("case %d: want sub %q, got %q", i, testUserID1, sub)
+		}
+		if clientID := tokenClientID(claims); clientID != tt.creds.ID {
+			t.Errorf("case %d: want client_id %q, got %q", i, tt.creds.ID, clientID)
+		}
+		if aud := audienceClaim(claims); !reflect.DeepEqual(aud, tt.wantAud) {
+			t.Errorf("case %d: want aud %v, got %v", i, tt.wantAud, aud)
+		}
+		if scp, _, _ := claims.StringClaim(claimScope); scp != tt.wantScope {
+			t.Errorf("case %d: want scope %q, got %q", i, tt.wantScope, scp)
+		}
+		// The audience can't pass the token off as an ID token.
+		if err := f.srv.JWTVerifierFactory()(tt.wantAud[0]).Verify(*jwt); err == nil {
+			t.Errorf("case %d: exchanged token verified as an ID token for %q", i, tt.wantAud[0])
+		}
+		if _, err := f.srv.verifyIDToken(jwt.Encode()); err == nil {
+			t.Errorf("case %d: exchanged token verified as an ID token", i)
+		}
+		act, _ := claims[claimAct].(map[string]interface{})
+		if actor, _ := act["sub"].(string); actor != tt.wantActor {
+			t.Errorf("case %d: want actor %q, got %v", i, tt.wantActor, claims[claimAct])
+		}
+	}
+}
+
+func TestHandleTokenFuncTokenExchange(t *testing.T) {
+	f, err := makeTokenExchangeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	claims := oidc.NewClaims(testIssuerURL.String(), testUserID1, testBackendCreds.ID, time.Now(), time.Now().Add(time.Hour))
+	jwt, err := jose.NewSignedJWT(claims, testPrivKey.Signer())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		form     url.Values
+		wantCode int
+	}{
+		{
+			form: url.Values{
+				"subject_token":      {jwt.Encode()},
+				"subject_token_type": {tokenTypeIDToken},
+				"audience":           {testDownstreamClientID},
+			},
+			wantCode: http.StatusOK,
+		},
+		// missing subject token type
+		{
+			form: url.Values{
+				"subject_token": {jwt.Encode()},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		// actor token without its type
+		{
+			form: url.Values{
+				"subject_token":      {jwt.Encode()},
+				"subject_token_type": {tokenTypeIDToken},
+				"actor_token":        {jwt.Encode()},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		// only access tokens can be issued
+		{
+			form: url.Values{
+				"subject_token":        {jwt.Encode()},
+				"subject_token_type":   {tokenTypeIDToken},
+				"requested_token_type": {tokenTypeIDToken},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		tt.form.Set("grant_type", client.GrantTypeTokenExchange)
+		req, err := http.NewRequest("POST", "http://example.com/token", strings.NewReader(tt.form.Encode()))
+		if err != nil {
+			t.Fatalf("case %d: unable to create HTTP request: %v", i, err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(testBackendCreds.ID, testBackendCreds.Secret)
+		w := httptest.NewRecorder()
+		handleTokenFunc(f.srv).ServeHTTP(w, req)
+
+		if w.Code != tt.wantCode {
+			t.Errorf("case %d: want HTTP %d, got %d: %s", i, tt.wantCode, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var resp map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if resp["issued_token_type"] != tokenTypeAccessToken {
+			t.Errorf("case %d: want issued_token_type %q, got %v", i, tokenTypeAccessToken, resp["issued_token_type"])
+		}
+		if _, ok := resp["id_token"]; ok {
+			t.Errorf("case %d: want no id_token, got %v", i, resp["id_token"])
+		}
+		if _, ok := resp["access_token"].(string); !ok {
+			t.Errorf("case %d: want an access_token, got %v", i, resp)
+		}
+	}
+}
+
+func TestTokenExchangeAudienceCopiesAudience(t *testing.T) {
+	f, err := makeTokenExchangeTestFixtures()
+	if err != nil {
+		t.Fatalf("error making test fixtures: %v", err)
+	}
+	audience := make([]string, 0, 1)
+	aud, err := f.srv.tokenExchangeAudience(testBackendCreds.ID, audience, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{testIssuerURL.String()}; !reflect.DeepEqual(aud, want) {
+		t.Errorf("want aud %v, got %v", want, aud)
+	}
+	if got := audience[:1][0]; got != "" {
+		t.Errorf("want the requested audience left alone, got %q written to it", got)
+	}
+}
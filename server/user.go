@@ -32,6 +32,9 @@ var (
 	AccountSubTree                = "/account"
 	AccountListRefreshTokens      = addBasePath(AccountSubTree + "/:userid/refresh")
 	AccountRevokeRefreshToken     = addBasePath(AccountSubTree + "/:userid/refresh/:clientid")
+	AccountRevokeSSOSessions      = addBasePath(AccountSubTree + "/:userid/sessions")
+	AccountListConsents           = addBasePath(AccountSubTree + "/:userid/consents")
+	AccountRevokeConsent          = addBasePath(AccountSubTree + "/:userid/consents/:clientid")
 )
 
 type UserMgmtServer struct {
@@ -65,6 +68,9 @@ func (s *UserMgmtServer) HTTPHandler() http.Handler {
 
 	r.GET(AccountListRefreshTokens, s.authAccount(s.listClientsWithRefreshTokens))
 	r.DELETE(AccountRevokeRefreshToken, s.authAccount(s.revokeRefreshTokensForClient))
+	r.DELETE(AccountRevokeSSOSessions, s.authAccount(s.revokeSSOSessions))
+	r.GET(AccountListConsents, s.authAccount(s.listConsents))
+	r.DELETE(AccountRevokeConsent, s.authAccount(s.revokeConsent))
 	return r
 }
 
@@ -234,6 +240,31 @@ func (s *UserMgmtServer) revokeRefreshTokensForClient(w http.ResponseWriter, r *
 	w.WriteHeader(http.StatusOK) // NOTE (ericchiang): http.StatusNoContent or return an empty JSON object?
 }
 
+func (s *UserMgmtServer) revokeSSOSessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, creds api.Creds) {
+	if err := s.api.RevokeSSOSessions(creds, ps.ByName("userid")); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *UserMgmtServer) listConsents(w http.ResponseWriter, r *http.Request, ps httprouter.Params, creds api.Creds) {
+	consents, err := s.api.ListConsents(creds, ps.ByName("userid"))
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	writeResponseWithBody(w, http.StatusOK, schema.ConsentList{Consents: consents})
+}
+
+func (s *UserMgmtServer) revokeConsent(w http.ResponseWriter, r *http.Request, ps httprouter.Params, creds api.Creds) {
+	if err := s.api.RevokeConsent(creds, ps.ByName("userid"), ps.ByName("clientid")); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *UserMgmtServer) writeError(w http.ResponseWriter, err error) {
 	log.Errorf("Error calling user management API: %v: ", err)
 	if apiErr, ok := err.(api.Error); ok {
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/client"
+	phttp "github.com/coreos/dex/pkg/http"
+	"github.com/coreos/dex/pkg/log"
+	"github.com/coreos/dex/user"
+)
+
+// UserInfo returns the standard claims of the user the access token was issued
+// for. ID tokens are also accepted as bearer tokens, for clients which predate
+// dex issuing separate access tokens.
+// See: http://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func (s *Server) UserInfo(token string) (jose.Claims, error) {
+	_, claims, err := s.verifyToken(token)
+	if err != nil {
+		log.Errorf("Invalid userinfo bearer token: %v", err)
+		return nil, oauth2.NewError(errorInvalidToken)
+	}
+
+	sub, _, _ := claims.StringClaim("sub")
+	var usr user.User
+	userID, err := s.subjectUserID(tokenClientID(claims), sub)
+	if err == nil {
+		usr, err = s.UserRepo.Get(nil, userID)
+	}
+	switch err {
+	case nil:
+	case user.ErrorNotFound, client.ErrorNotFound:
+		// Tokens obtained with client credentials aren't issued for a user.
+		log.Errorf("Userinfo requested for unknown user %q", sub)
+		return nil, oauth2.NewError(errorInvalidToken)
+	default:
+		log.Errorf("Failed to fetch user %q from repo: %v", sub, err)
+		return nil, oauth2.NewError(oauth2.ErrorServerError)
+	}
+	if usr.Disabled {
+		log.Errorf("Userinfo requested for disabled user %q", sub)
+		return nil, oauth2.NewError(errorInvalidToken)
+	}
+
+	// The subject is that of the token, which is pairwise for some clients.
+	// Like ID tokens, the response only includes claims such as
+	// email_verified if they apply or were asked for in the claims request.
+	info := jose.Claims{"sub": sub}
+	requested, _, _ := claims.StringsClaim(claimUserInfoClaims)
+	usr.AddToClaims(info, requested)
+
+	// Groups are only included in tokens requested with the groups scope, or
+	// whose claims request asked for them to be returned here.
+	if groups, ok, _ := claims.StringsClaim("groups"); ok {
+		if groups == nil {
+			groups = []string{}
+		}
+		info.Add("groups", groups)
+	}
+	return info, nil
+}
+
+func handleUserInfoFunc(srv OIDCServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			phttp.WriteError(w, http.StatusMethodNotAllowed, "GET and POST only acceptable methods")
+			return
+		}
+
+		token, err := oidc.ExtractBearerToken(r)
+		if err != nil {
+			log.Errorf("Failed to extract token from request: %v", err)
+			err := oauth2.NewError(errorInvalidToken)
+			err.Description = "missing or invalid bearer token"
+			writeBearerError(w, err)
+			return
+		}
+
+		info, err := srv.UserInfo(token)
+		if err != nil {
+			writeBearerError(w, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeResponseWithBody(w, http.StatusOK, info)
+	}
+}
@@ -0,0 +1,62 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// ClaimsRequest is the claims authentication request parameter, which asks for
+// individual claims to be returned in the ID token or from the UserInfo
+// endpoint, in addition to those requested through scopes.
+// See: http://openid.net/specs/openid-connect-core-1_0.html#ClaimsParameter
+type ClaimsRequest struct {
+	UserInfo map[string]*ClaimRequest `json:"userinfo,omitempty"`
+	IDToken  map[string]*ClaimRequest `json:"id_token,omitempty"`
+}
+
+// ClaimRequest asks for a claim to be returned in a particular way. A nil
+// ClaimRequest asks for the claim to be returned in the default manner.
+type ClaimRequest struct {
+	Essential bool          `json:"essential,omitempty"`
+	Value     interface{}   `json:"value,omitempty"`
+	Values    []interface{} `json:"values,omitempty"`
+}
+
+// ParseClaimsRequest parses the JSON encoded claims parameter.
+func ParseClaimsRequest(s string) (*ClaimsRequest, error) {
+	var r ClaimsRequest
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		return nil, err
+	}
+	if r.UserInfo == nil && r.IDToken == nil {
+		return nil, errors.New("claims request must contain userinfo or id_token")
+	}
+	return &r, nil
+}
+
+// IDTokenClaims returns the names of the claims requested in the ID token.
+func (r *ClaimsRequest) IDTokenClaims() []string {
+	if r == nil {
+		return nil
+	}
+	return claimNames(r.IDToken)
+}
+
+// UserInfoClaims returns the names of the claims requested from the UserInfo
+// endpoint.
+func (r *ClaimsRequest) UserInfoClaims() []string {
+	if r == nil {
+		return nil
+	}
+	return claimNames(r.UserInfo)
+}
+
+func claimNames(m map[string]*ClaimRequest) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
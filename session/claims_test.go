@@ -0,0 +1,70 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParseClaimsRequest(t *testing.T) {
+	tests := []struct {
+		claims  string
+		want    *ClaimsRequest
+		wantErr bool
+	}{
+		{
+			claims: `{"userinfo": {"email_verified": {"essential": true}}, "id_token": {"groups": null}}`,
+			want: &ClaimsRequest{
+				UserInfo: map[string]*ClaimRequest{"email_verified": {Essential: true}},
+				IDToken:  map[string]*ClaimRequest{"groups": nil},
+			},
+		},
+		{
+			claims: `{"id_token": {"sub": {"value": "elroy-id"}}}`,
+			want: &ClaimsRequest{
+				IDToken: map[string]*ClaimRequest{"sub": {Value: "elroy-id"}},
+			},
+		},
+		{
+			claims:  `{}`,
+			wantErr: true,
+		},
+		{
+			claims:  `{"id_token": ["groups"]}`,
+			wantErr: true,
+		},
+		{
+			claims:  `groups`,
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		got, err := ParseClaimsRequest(tt.claims)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("case %d: want error %t, got %v", i, tt.wantErr, err)
+			continue
+		}
+		if diff := pretty.Compare(tt.want, got); diff != "" {
+			t.Errorf("case %d: Compare(want, got) = %v", i, diff)
+		}
+	}
+}
+
+func TestClaimsRequestClaims(t *testing.T) {
+	var r *ClaimsRequest
+	if got := r.IDTokenClaims(); got != nil {
+		t.Errorf("want no claims from a nil request, got %v", got)
+	}
+
+	r = &ClaimsRequest{
+		UserInfo: map[string]*ClaimRequest{"name": nil, "email": nil},
+		IDToken:  map[string]*ClaimRequest{"groups": nil},
+	}
+	if diff := pretty.Compare([]string{"email", "name"}, r.UserInfoClaims()); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+	if diff := pretty.Compare([]string{"groups"}, r.IDTokenClaims()); diff != "" {
+		t.Errorf("Compare(want, got) = %v", diff)
+	}
+}
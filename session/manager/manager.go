@@ -5,12 +5,12 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"net/url"
 	"time"
 
 	"github.com/jonboulle/clockwork"
 
 	"github.com/coreos/dex/session"
+	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
 )
 
@@ -46,7 +46,9 @@ type SessionManager struct {
 	keys           session.SessionKeyRepo
 }
 
-func (m *SessionManager) NewSession(connectorID, clientID, clientState string, redirectURL url.URL, nonce string, register bool, scope []string) (string, error) {
+// NewSession starts a session for the authentication request, returning its
+// ID.
+func (m *SessionManager) NewSession(req session.AuthRequest) (string, error) {
 	sID, err := m.GenerateCode()
 	if err != nil {
 		return "", err
@@ -54,17 +56,25 @@ func (m *SessionManager) NewSession(connectorID, clientID, clientState string, r
 
 	now := m.Clock.Now()
 	s := session.Session{
-		ConnectorID: connectorID,
+		ConnectorID: req.ConnectorID,
 		ID:          sID,
 		State:       session.SessionStateNew,
 		CreatedAt:   now,
 		ExpiresAt:   now.Add(m.ValidityWindow),
-		ClientID:    clientID,
-		ClientState: clientState,
-		RedirectURL: redirectURL,
-		Register:    register,
-		Nonce:       nonce,
-		Scope:       scope,
+		ClientID:    req.ClientID,
+		ClientState: req.ClientState,
+		RedirectURL: req.RedirectURL,
+		Register:    req.Register,
+		Nonce:       req.Nonce,
+		Scope:       req.Scope,
+
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		SSOLoginNonce:       req.SSOLoginNonce,
+		ClaimsRequest:       req.ClaimsRequest,
+		ResponseType:        req.ResponseType,
+		ResponseMode:        req.ResponseMode,
+		PromptConsent:       req.PromptConsent,
 	}
 
 	err = m.sessions.Create(s)
@@ -113,6 +123,12 @@ func (m *SessionManager) getSessionInState(sessionID string, state session.Sessi
 }
 
 func (m *SessionManager) AttachRemoteIdentity(sessionID string, ident oidc.Identity) (*session.Session, error) {
+	return m.AttachRemoteIdentityAt(sessionID, ident, m.Clock.Now())
+}
+
+// AttachRemoteIdentityAt attaches a remote identity the user authenticated as
+// at an earlier time, such as at the start of their SSO session.
+func (m *SessionManager) AttachRemoteIdentityAt(sessionID string, ident oidc.Identity, authTime time.Time) (*session.Session, error) {
 	s, err := m.getSessionInState(sessionID, session.SessionStateNew)
 	if err != nil {
 		return nil, err
@@ -120,6 +136,7 @@ func (m *SessionManager) AttachRemoteIdentity(sessionID string, ident oidc.Ident
 
 	s.Identity = ident
 	s.State = session.SessionStateRemoteAttached
+	s.AuthTime = authTime
 
 	if err = m.sessions.Update(*s); err != nil {
 		return nil, err
@@ -156,6 +173,34 @@ func (m *SessionManager) AttachGroups(sessionID string, groups []string) (*sessi
 	return s, nil
 }
 
+// AttachSSOSession records the SSO session the session's user logs in with,
+// or which was started once they logged in.
+func (m *SessionManager) AttachSSOSession(sessionID, ssoSessionID string) (*session.Session, error) {
+	s, err := m.sessions.Get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	s.SSOSessionID = ssoSessionID
+	if err = m.sessions.Update(*s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AttachAuthError records the error response to pass to the client of a
+// session whose user couldn't be logged in.
+func (m *SessionManager) AttachAuthError(sessionID string, authErr *oauth2.Error) (*session.Session, error) {
+	s, err := m.sessions.Get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	s.AuthError = authErr
+	if err = m.sessions.Update(*s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 func (m *SessionManager) Kill(sessionID string) (*session.Session, error) {
 	s, err := m.sessions.Get(sessionID)
 	if err != nil {
@@ -1,7 +1,6 @@
 package manager
 
 import (
-	"net/url"
 	"testing"
 
 	"github.com/coreos/dex/db"
@@ -23,7 +22,12 @@ func newManager() *SessionManager {
 func TestSessionManagerNewSession(t *testing.T) {
 	sm := newManager()
 	sm.GenerateCode = staticGenerateCodeFunc("boo")
-	got, err := sm.NewSession("bogus_idpc", "XXX", "bogus", url.URL{}, "", false, []string{"openid"})
+	got, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: "bogus_idpc",
+		ClientID:    "XXX",
+		ClientState: "bogus",
+		Scope:       []string{"openid"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -34,7 +38,12 @@ func TestSessionManagerNewSession(t *testing.T) {
 
 func TestSessionAttachRemoteIdentityTwice(t *testing.T) {
 	sm := newManager()
-	sessionID, err := sm.NewSession("bogus_idpc", "XXX", "bogus", url.URL{}, "", false, []string{"openid"})
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: "bogus_idpc",
+		ClientID:    "XXX",
+		ClientState: "bogus",
+		Scope:       []string{"openid"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -51,7 +60,12 @@ func TestSessionAttachRemoteIdentityTwice(t *testing.T) {
 
 func TestSessionManagerExchangeKey(t *testing.T) {
 	sm := newManager()
-	sessionID, err := sm.NewSession("connector_id", "XXX", "bogus", url.URL{}, "", false, []string{"openid"})
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: "connector_id",
+		ClientID:    "XXX",
+		ClientState: "bogus",
+		Scope:       []string{"openid"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -87,7 +101,12 @@ func TestSessionManagerGetSessionInStateNoExist(t *testing.T) {
 
 func TestSessionManagerGetSessionInStateWrongState(t *testing.T) {
 	sm := newManager()
-	sessionID, err := sm.NewSession("connector_id", "XXX", "bogus", url.URL{}, "", false, []string{"openid"})
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: "connector_id",
+		ClientID:    "XXX",
+		ClientState: "bogus",
+		Scope:       []string{"openid"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -102,7 +121,12 @@ func TestSessionManagerGetSessionInStateWrongState(t *testing.T) {
 
 func TestSessionManagerKill(t *testing.T) {
 	sm := newManager()
-	sessionID, err := sm.NewSession("connector_id", "XXX", "bogus", url.URL{}, "", false, []string{"openid"})
+	sessionID, err := sm.NewSession(session.AuthRequest{
+		ConnectorID: "connector_id",
+		ClientID:    "XXX",
+		ClientState: "bogus",
+		Scope:       []string{"openid"},
+	})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
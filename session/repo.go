@@ -12,3 +12,21 @@ type SessionKeyRepo interface {
 	Push(SessionKey, time.Duration) error
 	Pop(string) (string, error)
 }
+
+type SSOSessionRepo interface {
+	// Get returns ErrorSSOSessionNotFound if the SSO session does not exist
+	// or has expired.
+	Get(string) (*SSOSession, error)
+	Create(SSOSession) error
+	Delete(string) error
+
+	// AddClient records that the user logged in to the client during the
+	// SSO session.
+	AddClient(id, clientID string) error
+
+	// GetByUser returns all of the user's unexpired SSO sessions.
+	GetByUser(userID string) ([]SSOSession, error)
+
+	// DeleteByUser ends all of the user's SSO sessions.
+	DeleteByUser(userID string) error
+}
@@ -5,8 +5,10 @@ import (
 	"time"
 
 	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oauth2"
 	"github.com/coreos/go-oidc/oidc"
 
+	pstrings "github.com/coreos/dex/pkg/strings"
 	"github.com/coreos/dex/scope"
 )
 
@@ -33,6 +35,26 @@ type SessionKey struct {
 	SessionID string
 }
 
+// AuthRequest holds the parameters of an authentication request, which a
+// session is started with. Each field is recorded in the same field of the
+// session, and may be left empty if the request didn't include it.
+type AuthRequest struct {
+	ConnectorID         string
+	ClientID            string
+	ClientState         string
+	RedirectURL         url.URL
+	Nonce               string
+	Register            bool
+	Scope               []string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	SSOLoginNonce       string
+	ClaimsRequest       *ClaimsRequest
+	ResponseType        string
+	ResponseMode        string
+	PromptConsent       bool
+}
+
 type Session struct {
 	ConnectorID string
 	ID          string
@@ -58,6 +80,69 @@ type Session struct {
 
 	// Groups the user belongs to.
 	Groups []string
+
+	// CodeChallenge is optionally provided in the initial authorization request
+	// by clients using PKCE (RFC 7636). When present, the code can only be
+	// redeemed by presenting a matching code verifier.
+	CodeChallenge string
+
+	// CodeChallengeMethod is the transformation used to derive CodeChallenge
+	// from the code verifier, either "plain" or "S256".
+	CodeChallengeMethod string
+
+	// AuthTime is when the user authenticated with the remote identity
+	// provider, and is propagated to the generated claims.
+	AuthTime time.Time
+
+	// SSOSessionID identifies the SSO session the user logged in with, or
+	// started once they logged in.
+	SSOSessionID string
+
+	// SSOLoginNonce is set in a cookie of the browser which started the
+	// session. An SSO session is only started for the user once they have
+	// logged in if the browser they finish logging in with presents it, so a
+	// browser can't be logged in to a session somebody else started.
+	SSOLoginNonce string
+
+	// ClaimsRequest is optionally provided in the initial authorization
+	// request, asking for individual claims to be returned.
+	ClaimsRequest *ClaimsRequest
+
+	// ResponseType is the 'response_type' field in the authentication
+	// request, in its canonical form. It determines which of a code, an ID
+	// token and an access token are returned from the authorization endpoint.
+	// An empty response type is equivalent to "code".
+	ResponseType string
+
+	// ResponseMode is how the authorization response is passed to the
+	// client: "query", "fragment" or "form_post". An empty response mode is
+	// equivalent to "query".
+	ResponseMode string
+
+	// AuthError is the error response passed to the client instead of an
+	// authorization response, if the user couldn't be logged in.
+	AuthError *oauth2.Error
+
+	// PromptConsent is set if the client asked for the user to approve its
+	// access again, even if they already did (OpenID Connect Core 1.0
+	// Section 3.1.2.1).
+	PromptConsent bool
+}
+
+// GroupsRequested reports whether the client asked for the user's groups,
+// either with the groups scope or in the claims request.
+func (s *Session) GroupsRequested() bool {
+	return s.idTokenGroupsRequested() || s.UserInfoGroupsRequested()
+}
+
+// UserInfoGroupsRequested reports whether the user's groups should be returned
+// from the UserInfo endpoint.
+func (s *Session) UserInfoGroupsRequested() bool {
+	return s.Scope.HasScope(scope.ScopeGroups) || pstrings.ContainsString(s.ClaimsRequest.UserInfoClaims(), "groups")
+}
+
+func (s *Session) idTokenGroupsRequested() bool {
+	return s.Scope.HasScope(scope.ScopeGroups) || pstrings.ContainsString(s.ClaimsRequest.IDTokenClaims(), "groups")
 }
 
 // Claims returns a new set of Claims for the current session.
@@ -68,8 +153,14 @@ func (s *Session) Claims(issuerURL string) jose.Claims {
 	if s.Nonce != "" {
 		claims["nonce"] = s.Nonce
 	}
-	if s.Scope.HasScope(scope.ScopeGroups) {
+	if s.idTokenGroupsRequested() {
 		claims["groups"] = s.Groups
 	}
+	if !s.AuthTime.IsZero() {
+		claims["auth_time"] = s.AuthTime.Unix()
+	}
+	if s.SSOSessionID != "" {
+		claims["sid"] = SID(s.SSOSessionID)
+	}
 	return claims
 }
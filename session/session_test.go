@@ -61,6 +61,52 @@ func TestSessionClaims(t *testing.T) {
 				"exp": now.Add(time.Hour).Unix(),
 			},
 		},
+		// Auth time gets propagated.
+		{
+			ses: Session{
+				CreatedAt: now,
+				ExpiresAt: now.Add(time.Hour),
+				ClientID:  "XXX",
+				Identity: oidc.Identity{
+					ID:    "YYY",
+					Name:  "elroy",
+					Email: "elroy@example.com",
+				},
+				UserID:   "elroy-id",
+				AuthTime: now.Add(-time.Minute),
+			},
+			want: jose.Claims{
+				"iss":       issuerURL,
+				"sub":       "elroy-id",
+				"aud":       "XXX",
+				"iat":       now.Unix(),
+				"exp":       now.Add(time.Hour).Unix(),
+				"auth_time": now.Add(-time.Minute).Unix(),
+			},
+		},
+		// The SSO session is identified by its sid.
+		{
+			ses: Session{
+				CreatedAt: now,
+				ExpiresAt: now.Add(time.Hour),
+				ClientID:  "XXX",
+				Identity: oidc.Identity{
+					ID:    "YYY",
+					Name:  "elroy",
+					Email: "elroy@example.com",
+				},
+				UserID:       "elroy-id",
+				SSOSessionID: "sso-1",
+			},
+			want: jose.Claims{
+				"iss": issuerURL,
+				"sub": "elroy-id",
+				"aud": "XXX",
+				"iat": now.Unix(),
+				"exp": now.Add(time.Hour).Unix(),
+				"sid": SID("sso-1"),
+			},
+		},
 		// Nonce gets propagated.
 		{
 			ses: Session{
@@ -84,6 +130,47 @@ func TestSessionClaims(t *testing.T) {
 				"nonce": "oncenay",
 			},
 		},
+		// Groups may be requested in the ID token alone.
+		{
+			ses: Session{
+				CreatedAt: now,
+				ExpiresAt: now.Add(time.Hour),
+				ClientID:  "XXX",
+				UserID:    "elroy-id",
+				Groups:    []string{"admins"},
+				ClaimsRequest: &ClaimsRequest{
+					IDToken: map[string]*ClaimRequest{"groups": nil},
+				},
+			},
+			want: jose.Claims{
+				"iss":    issuerURL,
+				"sub":    "elroy-id",
+				"aud":    "XXX",
+				"iat":    now.Unix(),
+				"exp":    now.Add(time.Hour).Unix(),
+				"groups": []string{"admins"},
+			},
+		},
+		// Groups requested from the UserInfo endpoint aren't in the ID token.
+		{
+			ses: Session{
+				CreatedAt: now,
+				ExpiresAt: now.Add(time.Hour),
+				ClientID:  "XXX",
+				UserID:    "elroy-id",
+				Groups:    []string{"admins"},
+				ClaimsRequest: &ClaimsRequest{
+					UserInfo: map[string]*ClaimRequest{"groups": nil},
+				},
+			},
+			want: jose.Claims{
+				"iss": issuerURL,
+				"sub": "elroy-id",
+				"aud": "XXX",
+				"iat": now.Unix(),
+				"exp": now.Add(time.Hour).Unix(),
+			},
+		},
 	}
 
 	for i, tt := range tests {
@@ -0,0 +1,71 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/coreos/go-oidc/oidc"
+
+	"github.com/coreos/dex/pkg/crypto"
+)
+
+const (
+	// DefaultSSOSessionValidityWindow is how long users stay logged in to
+	// dex after authenticating with a connector.
+	DefaultSSOSessionValidityWindow = 24 * time.Hour
+
+	ssoSessionIDLength = 32
+)
+
+var ErrorSSOSessionNotFound = errors.New("sso session not found")
+
+// SSOSession is a user's login session with dex itself, shared by every
+// client the user authenticates to from the same browser. While it lasts,
+// authentication requests are completed without sending the user through
+// the connector again.
+type SSOSession struct {
+	// ID is the secret stored in the user's browser.
+	ID string
+
+	UserID      string
+	ConnectorID string
+
+	// Identity is the remote identity the user authenticated as.
+	Identity oidc.Identity
+
+	// AuthTime is when the user authenticated with the connector.
+	AuthTime time.Time
+
+	// ClientIDs are the clients the user has logged in to during the SSO
+	// session.
+	ClientIDs []string
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// NewSSOSessionID generates a random SSO session ID.
+func NewSSOSessionID() (string, error) {
+	b, err := crypto.RandBytes(ssoSessionIDLength)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewSSOLoginNonce generates a random nonce binding a session to the browser
+// which started it.
+func NewSSOLoginNonce() (string, error) {
+	return NewSSOSessionID()
+}
+
+// SID returns the session ID identifying the SSO session to clients, in the
+// sid claim of ID tokens and logout tokens (OpenID Connect Back-Channel Logout
+// 1.0 Section 2.1). Unlike the SSO session ID itself it can't be used to log
+// in.
+func SID(ssoSessionID string) string {
+	h := sha256.Sum256([]byte(ssoSessionID))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
@@ -0,0 +1,188 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/coreos/go-oidc/jose"
+)
+
+// JWK is a public key as a JSON Web Key (RFC 7517). Unlike jose.JWK, it may
+// hold RSA, EC (RFC 7518 Section 6.2) and OKP (RFC 8037 Section 2) keys.
+type JWK struct {
+	ID  string
+	Alg string
+	Use string
+
+	// Key is an *rsa.PublicKey, an *ecdsa.PublicKey or an ed25519.PublicKey.
+	Key crypto.PublicKey
+}
+
+// NewJWK converts an RSA key of go-oidc's jose package.
+func NewJWK(jwk jose.JWK) JWK {
+	alg := jwk.Alg
+	if alg == "" {
+		alg = RS256
+	}
+	return JWK{
+		ID:  jwk.ID,
+		Alg: alg,
+		Use: jwk.Use,
+		Key: &rsa.PublicKey{N: jwk.Modulus, E: jwk.Exponent},
+	}
+}
+
+type jwkJSON struct {
+	ID   string `json:"kid"`
+	Type string `json:"kty"`
+	Alg  string `json:"alg"`
+	Use  string `json:"use"`
+
+	// RSA keys.
+	Exponent string `json:"e,omitempty"`
+	Modulus  string `json:"n,omitempty"`
+
+	// EC and OKP keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+var b64 = base64.RawURLEncoding
+
+func (j JWK) MarshalJSON() ([]byte, error) {
+	t := jwkJSON{
+		ID:  j.ID,
+		Alg: j.Alg,
+		Use: j.Use,
+	}
+
+	switch k := j.Key.(type) {
+	case *rsa.PublicKey:
+		// RSA keys are encoded just as go-oidc encodes them.
+		jwk := jose.JWK{
+			ID:       j.ID,
+			Type:     "RSA",
+			Alg:      j.Alg,
+			Use:      j.Use,
+			Exponent: k.E,
+			Modulus:  k.N,
+		}
+		return json.Marshal(&jwk)
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("unsupported elliptic curve")
+		}
+		t.Type = "EC"
+		t.Curve = "P-256"
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		t.X = b64.EncodeToString(k.X.FillBytes(x))
+		t.Y = b64.EncodeToString(k.Y.FillBytes(y))
+	case ed25519.PublicKey:
+		t.Type = "OKP"
+		t.Curve = "Ed25519"
+		t.X = b64.EncodeToString(k)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", j.Key)
+	}
+
+	return json.Marshal(&t)
+}
+
+func (j *JWK) UnmarshalJSON(data []byte) error {
+	var t jwkJSON
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	decode := func(s string) *big.Int {
+		b, err := b64.DecodeString(strings.TrimRight(s, "="))
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+
+	var key crypto.PublicKey
+	switch {
+	case t.Type == "RSA":
+		n, e := decode(t.Modulus), decode(t.Exponent)
+		if n == nil || e == nil || !e.IsInt64() {
+			return errors.New("invalid RSA key")
+		}
+		key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case t.Type == "EC" && t.Curve == "P-256":
+		x, y := decode(t.X), decode(t.Y)
+		if x == nil || y == nil {
+			return errors.New("invalid EC key")
+		}
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	case t.Type == "OKP" && t.Curve == "Ed25519":
+		x, err := b64.DecodeString(t.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return errors.New("invalid OKP key")
+		}
+		key = ed25519.PublicKey(x)
+	default:
+		return fmt.Errorf("unsupported key type %q", t.Type)
+	}
+
+	j.ID = t.ID
+	j.Alg = t.Alg
+	j.Use = t.Use
+	j.Key = key
+	return nil
+}
+
+// Verifier returns a verifier of signatures made with the key's algorithm.
+func (j JWK) Verifier() jose.Verifier {
+	return &verifier{kid: j.ID, alg: j.Alg, pub: j.Key}
+}
+
+// JWKSet is a JSON Web Key Set (RFC 7517 Section 5).
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// usableWith reports whether the key's type suits the algorithm.
+func (j JWK) usableWith(alg string) bool {
+	switch j.Key.(type) {
+	case *rsa.PublicKey:
+		return alg == RS256 || alg == PS256
+	case *ecdsa.PublicKey:
+		return alg == ES256
+	case ed25519.PublicKey:
+		return alg == EdDSA
+	}
+	return false
+}
+
+// VerifySignature reports whether the JWT was signed by one of the keys. Only
+// keys of the algorithm named by the JWT's header, or keys which don't name an
+// algorithm but whose type suits it, are tried.
+func VerifySignature(jwt jose.JWT, keys []JWK) bool {
+	alg := jwt.Header[jose.HeaderKeyAlgorithm]
+	data := []byte(jwt.Data())
+	for _, k := range keys {
+		if k.Alg == "" && k.usableWith(alg) {
+			k.Alg = alg
+		}
+		if k.Alg != alg {
+			continue
+		}
+		if k.Verifier().Verify(jwt.Signature, data) == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+// Package signing manages the keys dex signs tokens with. Unlike go-oidc's key
+// package, whose keys are always RSA keys used with RS256, each key is used
+// with one of several JSON Web Signature algorithms.
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/coreos/go-oidc/jose"
+
+	pstrings "github.com/coreos/dex/pkg/strings"
+)
+
+// The supported signing algorithms (RFC 7518 Section 3.1, RFC 8037
+// Section 3.1).
+const (
+	RS256 = "RS256"
+	PS256 = "PS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Algorithms lists the supported signing algorithms.
+var Algorithms = []string{RS256, PS256, ES256, EdDSA}
+
+const rsaKeyBits = 2048
+
+var (
+	ErrorUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrorInvalidSignature     = errors.New("invalid signature")
+)
+
+// Supported reports whether alg is one of the supported signing algorithms.
+func Supported(alg string) bool {
+	return pstrings.ContainsString(Algorithms, alg)
+}
+
+// ValidAlgorithms checks the algorithms keys are generated for. RS256 is
+// required, as dex's own tokens are signed with it, and go-oidc based clients
+// can only verify RS256 signatures.
+func ValidAlgorithms(algs []string) error {
+	seen := make(map[string]bool)
+	for _, alg := range algs {
+		if !Supported(alg) {
+			return fmt.Errorf("unsupported signing algorithm %q", alg)
+		}
+		if seen[alg] {
+			return fmt.Errorf("signing algorithm %q listed more than once", alg)
+		}
+		seen[alg] = true
+	}
+	if !seen[RS256] {
+		return errors.New("signing algorithms must include RS256")
+	}
+	return nil
+}
+
+// PrivateKey is a key used to sign tokens with a single algorithm.
+type PrivateKey struct {
+	KeyID string
+	Alg   string
+
+	// Key is an *rsa.PrivateKey for RS256 and PS256, an *ecdsa.PrivateKey on
+	// the P-256 curve for ES256, and an ed25519.PrivateKey for EdDSA.
+	Key crypto.Signer
+}
+
+// GeneratePrivateKey generates a new key for the signing algorithm.
+func GeneratePrivateKey(alg string) (*PrivateKey, error) {
+	var (
+		k   crypto.Signer
+		err error
+	)
+	switch alg {
+	case RS256, PS256:
+		k, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case ES256:
+		k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, k, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrorUnsupportedAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := make([]byte, 20)
+	if _, err := io.ReadFull(rand.Reader, keyID); err != nil {
+		return nil, err
+	}
+
+	return &PrivateKey{
+		KeyID: hex.EncodeToString(keyID),
+		Alg:   alg,
+		Key:   k,
+	}, nil
+}
+
+func (k *PrivateKey) ID() string {
+	return k.KeyID
+}
+
+// Signer returns a signer producing signatures with the key's algorithm.
+func (k *PrivateKey) Signer() jose.Signer {
+	return &signer{
+		verifier: verifier{kid: k.KeyID, alg: k.Alg, pub: k.Key.Public()},
+		key:      k.Key,
+	}
+}
+
+// JWK returns the public key as a JSON Web Key.
+func (k *PrivateKey) JWK() JWK {
+	return JWK{
+		ID:  k.KeyID,
+		Alg: k.Alg,
+		Use: "sig",
+		Key: k.Key.Public(),
+	}
+}
+
+// verifier checks signatures made with a public key.
+type verifier struct {
+	kid string
+	alg string
+	pub crypto.PublicKey
+}
+
+func (v *verifier) ID() string {
+	return v.kid
+}
+
+func (v *verifier) Alg() string {
+	return v.alg
+}
+
+func (v *verifier) Verify(sig []byte, data []byte) error {
+	h := sha256.Sum256(data)
+
+	var ok bool
+	switch pub := v.pub.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch v.alg {
+		case RS256:
+			err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig)
+		case PS256:
+			err = rsa.VerifyPSS(pub, crypto.SHA256, h[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			return ErrorUnsupportedAlgorithm
+		}
+		ok = err == nil
+	case *ecdsa.PublicKey:
+		// The signature is the concatenation of R and S (RFC 7518 Section
+		// 3.4).
+		if v.alg != ES256 {
+			return ErrorUnsupportedAlgorithm
+		}
+		if len(sig) != 64 {
+			return ErrorInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		ok = ecdsa.Verify(pub, h[:], r, s)
+	case ed25519.PublicKey:
+		if v.alg != EdDSA {
+			return ErrorUnsupportedAlgorithm
+		}
+		ok = ed25519.Verify(pub, data, sig)
+	default:
+		return ErrorUnsupportedAlgorithm
+	}
+
+	if !ok {
+		return ErrorInvalidSignature
+	}
+	return nil
+}
+
+// signer signs data with a private key.
+type signer struct {
+	verifier
+	key crypto.Signer
+}
+
+func (s *signer) Sign(data []byte) ([]byte, error) {
+	h := sha256.Sum256(data)
+
+	switch k := s.key.(type) {
+	case *rsa.PrivateKey:
+		if s.alg == PS256 {
+			return rsa.SignPSS(rand.Reader, k, crypto.SHA256, h[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+	case *ecdsa.PrivateKey:
+		r, ss, err := ecdsa.Sign(rand.Reader, k, h[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		ss.FillBytes(sig[32:])
+		return sig, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, data), nil
+	}
+	return nil, ErrorUnsupportedAlgorithm
+}
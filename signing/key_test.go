@@ -0,0 +1,158 @@
+package signing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	for _, alg := range Algorithms {
+		k, err := GeneratePrivateKey(alg)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", alg, err)
+		}
+		other, err := GeneratePrivateKey(alg)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", alg, err)
+		}
+
+		signed, err := jose.NewSignedJWT(jose.Claims{"sub": "elroy"}, k.Signer())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", alg, err)
+			continue
+		}
+		if got := signed.Header[jose.HeaderKeyAlgorithm]; got != alg {
+			t.Errorf("%s: want alg header %q, got %q", alg, alg, got)
+		}
+
+		// The token is parsed again, as a client would.
+		jwt, err := jose.ParseJWT(signed.Encode())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", alg, err)
+			continue
+		}
+		if !VerifySignature(jwt, []JWK{other.JWK(), k.JWK()}) {
+			t.Errorf("%s: signature not verified", alg)
+		}
+		if VerifySignature(jwt, []JWK{other.JWK()}) {
+			t.Errorf("%s: signature verified with the wrong key", alg)
+		}
+
+		// Signatures don't verify with a key of another algorithm.
+		jwk := k.JWK()
+		jwk.Alg = "none"
+		if VerifySignature(jwt, []JWK{jwk}) {
+			t.Errorf("%s: signature verified with the wrong algorithm", alg)
+		}
+
+		// Keys which don't name an algorithm are used with any that suits
+		// their type.
+		jwk.Alg = ""
+		if !VerifySignature(jwt, []JWK{jwk}) {
+			t.Errorf("%s: signature not verified with key without an algorithm", alg)
+		}
+	}
+}
+
+func TestRSAAlgorithmsDontVerifyEachOther(t *testing.T) {
+	k, err := GeneratePrivateKey(PS256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sig, err := k.Signer().Sign([]byte("data"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	jwk := k.JWK()
+	jwk.Alg = RS256
+	if err := jwk.Verifier().Verify(sig, []byte("data")); err == nil {
+		t.Errorf("PS256 signature verified as RS256")
+	}
+}
+
+func TestJWKJSON(t *testing.T) {
+	wantTypes := map[string]string{
+		RS256: "RSA",
+		PS256: "RSA",
+		ES256: "EC",
+		EdDSA: "OKP",
+	}
+	for _, alg := range Algorithms {
+		k, err := GeneratePrivateKey(alg)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", alg, err)
+		}
+
+		b, err := json.Marshal(k.JWK())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", alg, err)
+			continue
+		}
+		var fields map[string]string
+		if err := json.Unmarshal(b, &fields); err != nil {
+			t.Errorf("%s: unexpected error: %v", alg, err)
+			continue
+		}
+		if fields["kty"] != wantTypes[alg] || fields["alg"] != alg || fields["kid"] != k.KeyID || fields["use"] != "sig" {
+			t.Errorf("%s: unexpected JWK %s", alg, b)
+		}
+
+		var got JWK
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Errorf("%s: unexpected error: %v", alg, err)
+			continue
+		}
+		if diff := pretty.Compare(k.JWK(), got); diff != "" {
+			t.Errorf("%s: Compare(want, got) = %v", alg, diff)
+		}
+	}
+}
+
+func TestJWKFromJose(t *testing.T) {
+	k, err := GeneratePrivateKey(RS256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jwt, err := jose.NewSignedJWT(jose.Claims{"sub": "elroy"}, k.Signer())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// go-oidc can decode the published RSA keys.
+	var jwk jose.JWK
+	b, err := json.Marshal(k.JWK())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(b, &jwk); err != nil {
+		t.Fatalf("go-oidc can't decode JWK: %v", err)
+	}
+	if !VerifySignature(*jwt, []JWK{NewJWK(jwk)}) {
+		t.Errorf("signature not verified with converted go-oidc key")
+	}
+}
+
+func TestValidAlgorithms(t *testing.T) {
+	tests := []struct {
+		algs    []string
+		wantErr bool
+	}{
+		{algs: []string{RS256}},
+		{algs: []string{ES256, RS256, PS256, EdDSA}},
+		{algs: nil, wantErr: true},
+		{algs: []string{ES256}, wantErr: true},
+		{algs: []string{RS256, "HS256"}, wantErr: true},
+		{algs: []string{RS256, ES256, ES256}, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		err := ValidAlgorithms(tt.algs)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("case %d: want error %t, got %v", i, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package signing
+
+import (
+	"crypto/rsa"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+	"github.com/jonboulle/clockwork"
+
+	pstrings "github.com/coreos/dex/pkg/strings"
+)
+
+// PrivateKeySet holds the keys tokens are signed with, newest first.
+type PrivateKeySet struct {
+	keys      []*PrivateKey
+	algs      []string
+	expiresAt time.Time
+}
+
+// NewPrivateKeySet returns a key set signing tokens with the algorithms, the
+// first of which is the default. It may hold keys of other algorithms, which
+// are only used to verify tokens signed before the algorithm was disabled.
+func NewPrivateKeySet(keys []*PrivateKey, algs []string, exp time.Time) *PrivateKeySet {
+	return &PrivateKeySet{
+		keys:      keys,
+		algs:      algs,
+		expiresAt: exp.UTC(),
+	}
+}
+
+func (s *PrivateKeySet) Keys() []*PrivateKey {
+	return s.keys
+}
+
+// Algorithms returns the algorithms tokens are signed with, the first being the
+// default.
+func (s *PrivateKeySet) Algorithms() []string {
+	return s.algs
+}
+
+func (s *PrivateKeySet) ExpiresAt() time.Time {
+	return s.expiresAt
+}
+
+// Active returns the newest key of the algorithm, or nil if tokens aren't
+// signed with it.
+func (s *PrivateKeySet) Active(alg string) *PrivateKey {
+	if !pstrings.ContainsString(s.algs, alg) {
+		return nil
+	}
+	for _, k := range s.keys {
+		if k.Alg == alg {
+			return k
+		}
+	}
+	return nil
+}
+
+// NewPrivateKeyManager returns a manager holding no keys until a key set is
+// synced to it.
+func NewPrivateKeyManager() *PrivateKeyManager {
+	return &PrivateKeyManager{
+		clock: clockwork.NewRealClock(),
+	}
+}
+
+// PrivateKeyManager holds the key set a server signs tokens with. It
+// implements key.PrivateKeyManager, whose methods only deal in the RS256 keys,
+// so it may be used wherever go-oidc's manager is.
+type PrivateKeyManager struct {
+	mu     sync.RWMutex
+	keySet *PrivateKeySet
+	clock  clockwork.Clock
+}
+
+var _ key.PrivateKeyManager = &PrivateKeyManager{}
+
+func (m *PrivateKeyManager) ExpiresAt() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.keySet == nil {
+		return m.clock.Now().UTC()
+	}
+	return m.keySet.ExpiresAt()
+}
+
+// Signer returns the signer of the active RS256 key.
+func (m *PrivateKeyManager) Signer() (jose.Signer, error) {
+	return m.SignerFor(RS256)
+}
+
+// SignerFor returns the signer of the active key of the algorithm.
+func (m *PrivateKeyManager) SignerFor(alg string) (jose.Signer, error) {
+	ks, err := m.healthyKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	k := ks.Active(alg)
+	if k == nil {
+		return nil, ErrorUnsupportedAlgorithm
+	}
+	return k.Signer(), nil
+}
+
+// Algorithms returns the algorithms tokens are signed with, the first being the
+// default.
+func (m *PrivateKeyManager) Algorithms() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.keySet == nil {
+		return nil
+	}
+	return m.keySet.Algorithms()
+}
+
+// AllJWKs returns the public keys of every algorithm.
+func (m *PrivateKeyManager) AllJWKs() ([]JWK, error) {
+	ks, err := m.healthyKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := ks.Keys()
+	jwks := make([]JWK, len(keys))
+	for i, k := range keys {
+		jwks[i] = k.JWK()
+	}
+	return jwks, nil
+}
+
+// JWKs returns the public RS256 keys.
+func (m *PrivateKeyManager) JWKs() ([]jose.JWK, error) {
+	ks, err := m.healthyKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks []jose.JWK
+	for _, k := range ks.Keys() {
+		if k.Alg != RS256 {
+			continue
+		}
+		pub := k.Key.Public().(*rsa.PublicKey)
+		jwks = append(jwks, jose.JWK{
+			ID:       k.KeyID,
+			Type:     "RSA",
+			Alg:      RS256,
+			Use:      "sig",
+			Exponent: pub.E,
+			Modulus:  pub.N,
+		})
+	}
+	return jwks, nil
+}
+
+// PublicKeys returns the public RS256 keys.
+func (m *PrivateKeyManager) PublicKeys() ([]key.PublicKey, error) {
+	jwks, err := m.JWKs()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]key.PublicKey, len(jwks))
+	for i, jwk := range jwks {
+		keys[i] = *key.NewPublicKey(jwk)
+	}
+	return keys, nil
+}
+
+func (m *PrivateKeyManager) Healthy() error {
+	_, err := m.healthyKeySet()
+	return err
+}
+
+func (m *PrivateKeyManager) healthyKeySet() (*PrivateKeySet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.keySet == nil {
+		return nil, errors.New("private key manager uninitialized")
+	}
+
+	if len(m.keySet.Keys()) == 0 {
+		return nil, errors.New("private key manager zero keys")
+	}
+
+	if m.keySet.ExpiresAt().Before(m.clock.Now().UTC()) {
+		return nil, errors.New("private key manager keys expired")
+	}
+
+	return m.keySet, nil
+}
+
+func (m *PrivateKeyManager) Set(keySet key.KeySet) error {
+	privKeySet, ok := keySet.(*PrivateKeySet)
+	if !ok {
+		return errors.New("unable to cast to PrivateKeySet")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keySet = privKeySet
+	return nil
+}
+
+// NewPrivateKeySetRepo returns a repo holding a key set in memory.
+func NewPrivateKeySetRepo() key.PrivateKeySetRepo {
+	return &memPrivateKeySetRepo{}
+}
+
+type memPrivateKeySetRepo struct {
+	mu  sync.RWMutex
+	pks *PrivateKeySet
+}
+
+func (r *memPrivateKeySetRepo) Set(ks key.KeySet) error {
+	pks, ok := ks.(*PrivateKeySet)
+	if !ok {
+		return errors.New("unable to cast to PrivateKeySet")
+	} else if pks == nil {
+		return errors.New("nil KeySet")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pks = pks
+	return nil
+}
+
+func (r *memPrivateKeySetRepo) Get() (key.KeySet, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.pks == nil {
+		return nil, key.ErrorNoKeys
+	}
+	return key.KeySet(r.pks), nil
+}
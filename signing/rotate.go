@@ -0,0 +1,181 @@
+package signing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/coreos/go-oidc/key"
+	"github.com/jonboulle/clockwork"
+
+	"github.com/coreos/dex/pkg/log"
+	pstrings "github.com/coreos/dex/pkg/strings"
+	ptime "github.com/coreos/dex/pkg/time"
+)
+
+var (
+	ErrorPrivateKeysExpired = errors.New("private keys have expired")
+)
+
+// NewPrivateKeyRotator returns a rotator which replaces the keys in the repo
+// with a new key for each of the algorithms once half their TTL has passed.
+// The first algorithm is the default one tokens are signed with.
+func NewPrivateKeyRotator(repo key.PrivateKeySetRepo, ttl time.Duration, algs []string) *PrivateKeyRotator {
+	return &PrivateKeyRotator{
+		repo: repo,
+		ttl:  ttl,
+		algs: algs,
+
+		keep:        2,
+		generateKey: GeneratePrivateKey,
+		clock:       clockwork.NewRealClock(),
+	}
+}
+
+// PrivateKeyRotator generates the keys of a key set repo, like go-oidc's
+// key.PrivateKeyRotator, for any of the supported signing algorithms.
+type PrivateKeyRotator struct {
+	repo        key.PrivateKeySetRepo
+	generateKey func(alg string) (*PrivateKey, error)
+	clock       clockwork.Clock
+	keep        int
+	ttl         time.Duration
+	algs        []string
+}
+
+func (r *PrivateKeyRotator) Healthy() error {
+	pks, err := r.privateKeySet()
+	if err != nil {
+		return err
+	}
+
+	if r.clock.Now().After(pks.ExpiresAt()) {
+		return ErrorPrivateKeysExpired
+	}
+
+	return nil
+}
+
+func (r *PrivateKeyRotator) privateKeySet() (*PrivateKeySet, error) {
+	ks, err := r.repo.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	pks, ok := ks.(*PrivateKeySet)
+	if !ok {
+		return nil, errors.New("unable to cast to PrivateKeySet")
+	}
+	return pks, nil
+}
+
+func (r *PrivateKeyRotator) nextRotation() (time.Duration, error) {
+	pks, err := r.privateKeySet()
+	if err == key.ErrorNoKeys {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// The keys are rotated straight away if other algorithms have been
+	// configured.
+	if !equalStrings(pks.Algorithms(), r.algs) {
+		return 0, nil
+	}
+
+	// Ideally, we want to rotate after half the TTL has elapsed.
+	idealRotationTime := pks.ExpiresAt().Add(-r.ttl / 2)
+	if d := idealRotationTime.Sub(r.clock.Now()); d > 0 {
+		return d, nil
+	}
+	return 0, nil
+}
+
+// rotate replaces the key set with one holding a new key for each algorithm,
+// along with the previous keys still used to verify tokens.
+func (r *PrivateKeyRotator) rotate() error {
+	var keys []*PrivateKey
+	for _, alg := range r.algs {
+		k, err := r.generateKey(alg)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+	}
+
+	pks, err := r.privateKeySet()
+	if err != nil && err != key.ErrorNoKeys {
+		return err
+	}
+	if pks != nil {
+		// Previous keys are kept so tokens signed with them can be
+		// verified until they expire. Once tokens are no longer signed
+		// with an algorithm, only the key last used with it is kept, until
+		// the next rotation.
+		count := make(map[string]int)
+		for _, k := range keys {
+			count[k.Alg]++
+		}
+		for _, k := range pks.Keys() {
+			keep := r.keep
+			if !pstrings.ContainsString(r.algs, k.Alg) {
+				if !pstrings.ContainsString(pks.Algorithms(), k.Alg) {
+					continue
+				}
+				keep = 1
+			}
+			if count[k.Alg] >= keep {
+				continue
+			}
+			count[k.Alg]++
+			keys = append(keys, k)
+		}
+	}
+
+	exp := r.clock.Now().UTC().Add(r.ttl)
+	return r.repo.Set(NewPrivateKeySet(keys, r.algs, exp))
+}
+
+// Run rotates the keys in the background until the returned channel is
+// closed.
+func (r *PrivateKeyRotator) Run() chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		for {
+			var nextRotation time.Duration
+			var sleep time.Duration
+			var err error
+			for {
+				if nextRotation, err = r.nextRotation(); err == nil {
+					break
+				}
+				sleep = ptime.ExpBackoff(sleep, time.Minute)
+				log.Errorf("Error getting next key rotation, retrying in %v: %v", sleep, err)
+				time.Sleep(sleep)
+			}
+
+			select {
+			case <-r.clock.After(nextRotation):
+				if err := r.rotate(); err != nil {
+					log.Errorf("Key rotation failed: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
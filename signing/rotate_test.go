@@ -0,0 +1,164 @@
+package signing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/key"
+	"github.com/jonboulle/clockwork"
+)
+
+func keyAlgs(keys []*PrivateKey) []string {
+	var algs []string
+	for _, k := range keys {
+		algs = append(algs, k.Alg)
+	}
+	return algs
+}
+
+func TestPrivateKeyRotatorRotate(t *testing.T) {
+	fc := clockwork.NewFakeClock()
+	repo := NewPrivateKeySetRepo()
+	r := NewPrivateKeyRotator(repo, 10*time.Second, []string{ES256, RS256})
+	r.clock = fc
+
+	tests := []struct {
+		algs []string
+
+		wantKeyAlgs []string
+		wantAlgs    []string
+	}{
+		{
+			algs:        []string{ES256, RS256},
+			wantKeyAlgs: []string{ES256, RS256},
+			wantAlgs:    []string{ES256, RS256},
+		},
+		{
+			algs:        []string{ES256, RS256},
+			wantKeyAlgs: []string{ES256, RS256, ES256, RS256},
+			wantAlgs:    []string{ES256, RS256},
+		},
+		{
+			// Only the newest previous keys are kept.
+			algs:        []string{ES256, RS256},
+			wantKeyAlgs: []string{ES256, RS256, ES256, RS256},
+			wantAlgs:    []string{ES256, RS256},
+		},
+		{
+			// ES256 keys are kept until tokens signed with them expire.
+			algs:        []string{RS256},
+			wantKeyAlgs: []string{RS256, ES256, RS256},
+			wantAlgs:    []string{RS256},
+		},
+		{
+			algs:        []string{RS256},
+			wantKeyAlgs: []string{RS256, RS256},
+			wantAlgs:    []string{RS256},
+		},
+	}
+
+	var prev *PrivateKeySet
+	for i, tt := range tests {
+		r.algs = tt.algs
+		if err := r.rotate(); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		ks, err := repo.Get()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		pks := ks.(*PrivateKeySet)
+
+		if got := keyAlgs(pks.Keys()); !equalStrings(tt.wantKeyAlgs, got) {
+			t.Errorf("case %d: want keys %v, got %v", i, tt.wantKeyAlgs, got)
+		}
+		if got := pks.Algorithms(); !equalStrings(tt.wantAlgs, got) {
+			t.Errorf("case %d: want algorithms %v, got %v", i, tt.wantAlgs, got)
+		}
+		if want := fc.Now().UTC().Add(10 * time.Second); !pks.ExpiresAt().Equal(want) {
+			t.Errorf("case %d: want expiry %v, got %v", i, want, pks.ExpiresAt())
+		}
+		if prev != nil && pks.Keys()[len(tt.algs)] != prev.Keys()[0] {
+			t.Errorf("case %d: newest previous key not kept", i)
+		}
+		prev = pks
+	}
+}
+
+func TestPrivateKeyRotatorNextRotation(t *testing.T) {
+	fc := clockwork.NewFakeClock()
+	repo := NewPrivateKeySetRepo()
+	r := NewPrivateKeyRotator(repo, 10*time.Second, []string{RS256})
+	r.clock = fc
+
+	next, err := r.nextRotation()
+	if err != nil || next != 0 {
+		t.Fatalf("want immediate rotation with no keys, got %v, %v", next, err)
+	}
+
+	if err := r.rotate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next, err = r.nextRotation(); err != nil || next != 5*time.Second {
+		t.Errorf("want rotation after half the TTL, got %v, %v", next, err)
+	}
+
+	// Newly configured algorithms get keys straight away.
+	r.algs = []string{RS256, EdDSA}
+	if next, err = r.nextRotation(); err != nil || next != 0 {
+		t.Errorf("want immediate rotation for new algorithm, got %v, %v", next, err)
+	}
+}
+
+func TestPrivateKeyManager(t *testing.T) {
+	var keys []*PrivateKey
+	for _, alg := range []string{ES256, RS256, PS256} {
+		k, err := GeneratePrivateKey(alg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys = append(keys, k)
+	}
+
+	m := NewPrivateKeyManager()
+	if _, err := m.Signer(); err == nil {
+		t.Errorf("want error from uninitialized manager")
+	}
+
+	// PS256 tokens are no longer signed, but may still be verified.
+	ks := NewPrivateKeySet(keys, []string{ES256, RS256}, time.Now().Add(time.Minute))
+	if err := m.Set(key.KeySet(ks)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := m.Algorithms(); !equalStrings([]string{ES256, RS256}, got) {
+		t.Errorf("want algorithms [ES256 RS256], got %v", got)
+	}
+	for _, alg := range []string{ES256, RS256} {
+		s, err := m.SignerFor(alg)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", alg, err)
+			continue
+		}
+		if s.Alg() != alg {
+			t.Errorf("%s: got signer for %s", alg, s.Alg())
+		}
+	}
+	if _, err := m.SignerFor(PS256); err != ErrorUnsupportedAlgorithm {
+		t.Errorf("PS256: want %v, got %v", ErrorUnsupportedAlgorithm, err)
+	}
+
+	// go-oidc's methods only see the RS256 key.
+	if s, err := m.Signer(); err != nil || s.Alg() != RS256 || s.ID() != keys[1].KeyID {
+		t.Errorf("want RS256 signer, got %v, %v", s, err)
+	}
+	jwks, err := m.JWKs()
+	if err != nil || len(jwks) != 1 || jwks[0].ID != keys[1].KeyID {
+		t.Errorf("want RS256 JWK, got %v, %v", jwks, err)
+	}
+
+	all, err := m.AllJWKs()
+	if err != nil || len(all) != 3 {
+		t.Errorf("want 3 JWKs, got %v, %v", all, err)
+	}
+}
@@ -11,9 +11,11 @@ import (
 
 	"github.com/coreos/dex/client"
 	clientmanager "github.com/coreos/dex/client/manager"
+	"github.com/coreos/dex/consent"
 	"github.com/coreos/dex/pkg/log"
 	"github.com/coreos/dex/refresh"
 	schema "github.com/coreos/dex/schema/workerschema"
+	"github.com/coreos/dex/session"
 	"github.com/coreos/dex/user"
 	usermanager "github.com/coreos/dex/user/manager"
 )
@@ -24,6 +26,7 @@ var (
 		user.ErrorDuplicateEmail: ErrorDuplicateEmail,
 		user.ErrorInvalidEmail:   ErrorInvalidEmail,
 		client.ErrorNotFound:     ErrorInvalidClient,
+		consent.ErrorNotFound:    ErrorResourceNotFound,
 	}
 
 	ErrorInvalidEmail  = newError("invalid_email", "invalid email.", http.StatusBadRequest)
@@ -90,6 +93,10 @@ type UsersAPI struct {
 	localConnectorID string
 	clientManager    *clientmanager.ClientManager
 	refreshRepo      refresh.RefreshTokenRepo
+	ssoSessionRepo   session.SSOSessionRepo
+	consentRepo      consent.ConsentRepo
+	pairwiseRepo     user.PairwiseSubjectRepo
+	logoutNotifier   LogoutNotifier
 	emailer          Emailer
 	allowClientCreds bool
 }
@@ -98,6 +105,12 @@ type Emailer interface {
 	SendInviteEmail(string, url.URL, string) (*url.URL, error)
 }
 
+// LogoutNotifier notifies the clients a user logged in to during an SSO
+// session that it has ended.
+type LogoutNotifier interface {
+	NotifyLogout(session.SSOSession)
+}
+
 type Creds struct {
 	// IDTokens can be issued for multiple clients.
 	ClientIDs []string
@@ -105,10 +118,14 @@ type Creds struct {
 }
 
 // TODO(ericchiang): Don't pass a dbMap. See #385.
-func NewUsersAPI(userManager *usermanager.UserManager, clientManager *clientmanager.ClientManager, refreshRepo refresh.RefreshTokenRepo, emailer Emailer, localConnectorID string, allowClientCreds bool) *UsersAPI {
+func NewUsersAPI(userManager *usermanager.UserManager, clientManager *clientmanager.ClientManager, refreshRepo refresh.RefreshTokenRepo, ssoSessionRepo session.SSOSessionRepo, consentRepo consent.ConsentRepo, pairwiseRepo user.PairwiseSubjectRepo, logoutNotifier LogoutNotifier, emailer Emailer, localConnectorID string, allowClientCreds bool) *UsersAPI {
 	return &UsersAPI{
 		userManager:      userManager,
 		refreshRepo:      refreshRepo,
+		ssoSessionRepo:   ssoSessionRepo,
+		consentRepo:      consentRepo,
+		pairwiseRepo:     pairwiseRepo,
+		logoutNotifier:   logoutNotifier,
 		clientManager:    clientManager,
 		localConnectorID: localConnectorID,
 		emailer:          emailer,
@@ -116,6 +133,9 @@ func NewUsersAPI(userManager *usermanager.UserManager, clientManager *clientmana
 	}
 }
 
+// GetUser returns the user with the given ID. The pairwise subject a user is
+// known by to some clients may be passed instead, so that admins can resolve it
+// back to the user.
 func (u *UsersAPI) GetUser(creds Creds, id string) (schema.User, error) {
 	log.Infof("userAPI: GetUser")
 	if !u.Authorize(creds) {
@@ -123,6 +143,12 @@ func (u *UsersAPI) GetUser(creds Creds, id string) (schema.User, error) {
 	}
 
 	usr, err := u.userManager.Get(id)
+	if err == user.ErrorNotFound {
+		var ps user.PairwiseSubject
+		if ps, err = u.pairwiseRepo.Get(id); err == nil {
+			usr, err = u.userManager.Get(ps.UserID)
+		}
+	}
 
 	if err != nil {
 		return schema.User{}, mapError(err)
@@ -140,6 +166,11 @@ func (u *UsersAPI) DisableUser(creds Creds, userID string, disable bool) (schema
 	if err := u.userManager.Disable(userID, disable); err != nil {
 		return schema.UserDisableResponse{}, mapError(err)
 	}
+	if disable {
+		if err := u.endSSOSessions(userID); err != nil {
+			return schema.UserDisableResponse{}, mapError(err)
+		}
+	}
 
 	return schema.UserDisableResponse{
 		Ok: true,
@@ -312,6 +343,85 @@ func (u *UsersAPI) RevokeRefreshTokensForClient(creds Creds, userID, clientID st
 	return u.refreshRepo.RevokeTokensForClient(userID, clientID)
 }
 
+// ListConsents returns the clients the user has approved access for, with the
+// scopes they approved.
+func (u *UsersAPI) ListConsents(creds Creds, userID string) ([]*schema.Consent, error) {
+	// Users must either be an admin or be requesting data associated with their own account.
+	if !creds.User.Admin && (creds.User.ID != userID) {
+		return nil, ErrorUnauthorized
+	}
+	cs, err := u.consentRepo.GetByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	consents := make([]*schema.Consent, 0, len(cs))
+	for _, c := range cs {
+		sc := &schema.Consent{
+			ClientID:  c.ClientID,
+			Scopes:    c.Scope,
+			UpdatedAt: c.UpdatedAt.UTC().Format(time.RFC3339),
+		}
+		cli, err := u.clientManager.Get(c.ClientID)
+		switch {
+		case err == client.ErrorNotFound:
+			// The client has since been deleted.
+			continue
+		case err != nil:
+			return nil, err
+		}
+		sc.ClientName = cli.Metadata.ClientName
+		if cli.Metadata.ClientURI != nil {
+			sc.ClientURI = cli.Metadata.ClientURI.String()
+		}
+		if cli.Metadata.LogoURI != nil {
+			sc.LogoURI = cli.Metadata.LogoURI.String()
+		}
+		consents = append(consents, sc)
+	}
+	return consents, nil
+}
+
+// RevokeConsent revokes the user's approval of the client, so they're asked
+// again the next time they log in to it. The refresh tokens issued to the
+// client for the user are revoked too.
+func (u *UsersAPI) RevokeConsent(creds Creds, userID, clientID string) error {
+	// Users must either be an admin or be requesting data associated with their own account.
+	if !creds.User.Admin && (creds.User.ID != userID) {
+		return ErrorUnauthorized
+	}
+	if err := u.consentRepo.Delete(userID, clientID); err != nil {
+		return mapError(err)
+	}
+	return u.refreshRepo.RevokeTokensForClient(userID, clientID)
+}
+
+// RevokeSSOSessions ends all of the user's SSO sessions, so the user has to
+// log in through a connector again the next time a client authenticates them.
+func (u *UsersAPI) RevokeSSOSessions(creds Creds, userID string) error {
+	// Users must either be an admin or be requesting data associated with their own account.
+	if !creds.User.Admin && (creds.User.ID != userID) {
+		return ErrorUnauthorized
+	}
+	return u.endSSOSessions(userID)
+}
+
+// endSSOSessions ends all of the user's SSO sessions, notifying the clients
+// they logged in to.
+func (u *UsersAPI) endSSOSessions(userID string) error {
+	ssos, err := u.ssoSessionRepo.GetByUser(userID)
+	if err != nil {
+		return err
+	}
+	if err := u.ssoSessionRepo.DeleteByUser(userID); err != nil {
+		return err
+	}
+	for _, sso := range ssos {
+		u.logoutNotifier.NotifyLogout(sso)
+	}
+	return nil
+}
+
 func (u *UsersAPI) Authorize(creds Creds) bool {
 	if u.allowClientCreds {
 		if creds.User.ID == "" {
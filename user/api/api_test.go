@@ -14,8 +14,10 @@ import (
 	"github.com/coreos/dex/client"
 	clientmanager "github.com/coreos/dex/client/manager"
 	"github.com/coreos/dex/connector"
+	"github.com/coreos/dex/consent"
 	"github.com/coreos/dex/db"
 	schema "github.com/coreos/dex/schema/workerschema"
+	"github.com/coreos/dex/session"
 	"github.com/coreos/dex/user"
 	"github.com/coreos/dex/user/manager"
 )
@@ -28,6 +30,14 @@ type testEmailer struct {
 	lastWasInvite   bool
 }
 
+type testLogoutNotifier struct {
+	notified []string
+}
+
+func (t *testLogoutNotifier) NotifyLogout(sso session.SSOSession) {
+	t.notified = append(t.notified, sso.ID)
+}
+
 // SendResetPasswordEmail returns resetPasswordURL when it can't email, mimicking the behavior of the real UserEmailer.
 func (t *testEmailer) SendResetPasswordEmail(email string, redirectURL url.URL, clientID string) (*url.URL, error) {
 	return t.sendEmail(email, redirectURL, clientID, false)
@@ -225,21 +235,44 @@ func makeTestFixtures(clientCredsFlag bool) (*UsersAPI, *testEmailer) {
 	}
 	refreshRepo := db.NewRefreshTokenRepo(dbMap)
 	for _, token := range refreshTokens {
-		if _, err := refreshRepo.Create(token.userID, token.clientID, "local", []string{"openid"}); err != nil {
+		if _, err := refreshRepo.Create(token.userID, token.clientID, "local", []string{"openid"}, nil); err != nil {
 			panic("Failed to create refresh token: " + err.Error())
 		}
 	}
 
+	// Used in TestListConsents and TestRevokeConsent.
+	consentRepo := db.NewConsentRepo(dbMap)
+	for _, userID := range []string{"ID-1", "ID-2"} {
+		c := consent.Consent{
+			UserID:    userID,
+			ClientID:  goodClientID,
+			Scope:     []string{"openid", "email"},
+			UpdatedAt: clock.Now(),
+		}
+		if err := consentRepo.Set(c); err != nil {
+			panic("Failed to create consent: " + err.Error())
+		}
+	}
+
+	// Used in TestGetUser.
+	pairwiseRepo := db.NewPairwiseSubjectRepo(dbMap)
+	if err := pairwiseRepo.Create(testPairwiseSubject); err != nil {
+		panic("Failed to create pairwise subject: " + err.Error())
+	}
+
 	emailer := &testEmailer{}
-	api := NewUsersAPI(mgr, clientManager, refreshRepo, emailer, "local", clientCredsFlag)
+	api := NewUsersAPI(mgr, clientManager, refreshRepo, db.NewSSOSessionRepo(dbMap), consentRepo, pairwiseRepo, &testLogoutNotifier{}, emailer, "local", clientCredsFlag)
 	return api, emailer
 
 }
 
+var testPairwiseSubject = user.NewPairwiseSubject("client.example.com", "ID-2", []byte("salt"))
+
 func TestGetUser(t *testing.T) {
 	tests := []struct {
 		creds           Creds
 		id              string
+		wantID          string
 		wantErr         error
 		clientCredsFlag bool
 	}{
@@ -270,9 +303,19 @@ func TestGetUser(t *testing.T) {
 			id:              "ID-1",
 			clientCredsFlag: true,
 		},
+		{
+			// Pairwise subjects are resolved to the user.
+			creds:           goodCreds,
+			id:              testPairwiseSubject.Subject,
+			wantID:          "ID-2",
+			clientCredsFlag: false,
+		},
 	}
 
 	for i, tt := range tests {
+		if tt.wantID == "" {
+			tt.wantID = tt.id
+		}
 		api, _ := makeTestFixtures(tt.clientCredsFlag)
 		usr, err := api.GetUser(tt.creds, tt.id)
 		if tt.wantErr != nil {
@@ -285,8 +328,8 @@ func TestGetUser(t *testing.T) {
 			t.Errorf("case %d: want nil err, got: %q ", i, err)
 		}
 
-		if usr.Id != tt.id {
-			t.Errorf("case %d: want=%v, got=%v ", i, tt.id, usr.Id)
+		if usr.Id != tt.wantID {
+			t.Errorf("case %d: want=%v, got=%v ", i, tt.wantID, usr.Id)
 		}
 	}
 }
@@ -576,11 +619,29 @@ func TestDisableUsers(t *testing.T) {
 
 	for i, tt := range tests {
 		api, _ := makeTestFixtures(false)
-		_, err := api.DisableUser(goodCreds, tt.id, tt.disable)
+		err := api.ssoSessionRepo.Create(session.SSOSession{
+			ID:        "sso-1",
+			UserID:    tt.id,
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("case %d: failed to create SSO session: %v", i, err)
+		}
+
+		_, err = api.DisableUser(goodCreds, tt.id, tt.disable)
 		if err != nil {
 			t.Fatalf("case %d: unexpected error: %v", i, err)
 		}
 
+		// Disabled users are logged out.
+		_, err = api.ssoSessionRepo.Get("sso-1")
+		if loggedOut := err == session.ErrorSSOSessionNotFound; loggedOut != tt.disable {
+			t.Errorf("case %d: want SSO session ended=%t, got %t", i, tt.disable, loggedOut)
+		}
+		if notified := api.logoutNotifier.(*testLogoutNotifier).notified; (len(notified) > 0) != tt.disable {
+			t.Errorf("case %d: want clients notified=%t, got %v", i, tt.disable, notified)
+		}
+
 		usr, err := api.GetUser(goodCreds, tt.id)
 		if err != nil {
 			t.Fatalf("case %d: unexpected error: %v", i, err)
@@ -780,3 +841,169 @@ func TestRevokeRefreshToken(t *testing.T) {
 		}
 	}
 }
+
+func TestRevokeSSOSessions(t *testing.T) {
+	tests := []struct {
+		creds    Creds
+		userID   string
+		wantErr  error
+		revoked  []string // SSO sessions expected to be revoked.
+		remained []string // SSO sessions expected to remain.
+	}{
+		// users can log themselves out
+		{
+			creds:    Creds{User: user.User{ID: "ID-2"}},
+			userID:   "ID-2",
+			revoked:  []string{"sso-2a", "sso-2b"},
+			remained: []string{"sso-1"},
+		},
+		// admins can log out other users
+		{
+			creds:    Creds{User: user.User{ID: "ID-1", Admin: true}},
+			userID:   "ID-2",
+			revoked:  []string{"sso-2a", "sso-2b"},
+			remained: []string{"sso-1"},
+		},
+		// other users can't
+		{
+			creds:    Creds{User: user.User{ID: "ID-3"}},
+			userID:   "ID-2",
+			wantErr:  ErrorUnauthorized,
+			remained: []string{"sso-1", "sso-2a", "sso-2b"},
+		},
+	}
+
+	for i, tt := range tests {
+		api, _ := makeTestFixtures(false)
+		for id, userID := range map[string]string{"sso-1": "ID-1", "sso-2a": "ID-2", "sso-2b": "ID-2"} {
+			err := api.ssoSessionRepo.Create(session.SSOSession{
+				ID:        id,
+				UserID:    userID,
+				ExpiresAt: time.Now().Add(time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("case %d: failed to create SSO session: %v", i, err)
+			}
+		}
+
+		err := api.RevokeSSOSessions(tt.creds, tt.userID)
+		if err != tt.wantErr {
+			t.Errorf("case %d: want err=%v, got %v", i, tt.wantErr, err)
+		}
+		for _, id := range tt.revoked {
+			if _, err := api.ssoSessionRepo.Get(id); err != session.ErrorSSOSessionNotFound {
+				t.Errorf("case %d: want SSO session %s revoked, got err=%v", i, id, err)
+			}
+		}
+		for _, id := range tt.remained {
+			if _, err := api.ssoSessionRepo.Get(id); err != nil {
+				t.Errorf("case %d: want SSO session %s to remain, got err=%v", i, id, err)
+			}
+		}
+
+		notified := api.logoutNotifier.(*testLogoutNotifier).notified
+		sort.Strings(notified)
+		if diff := pretty.Compare(tt.revoked, notified); diff != "" {
+			t.Errorf("case %d: Compare(revoked, notified) = %v", i, diff)
+		}
+	}
+}
+
+func TestListConsents(t *testing.T) {
+	tests := []struct {
+		creds   Creds
+		userID  string
+		want    []*schema.Consent
+		wantErr error
+	}{
+		{
+			creds:  Creds{User: user.User{ID: "ID-1"}},
+			userID: "ID-1",
+			want: []*schema.Consent{
+				{
+					ClientID:  goodClientID,
+					Scopes:    []string{"openid", "email"},
+					UpdatedAt: clock.Now().UTC().Format(time.RFC3339),
+				},
+			},
+		},
+		{
+			creds:  Creds{User: user.User{ID: "ID-1", Admin: true}},
+			userID: "ID-3",
+			want:   []*schema.Consent{},
+		},
+		{
+			creds:   Creds{User: user.User{ID: "ID-3"}},
+			userID:  "ID-1",
+			wantErr: ErrorUnauthorized,
+		},
+	}
+
+	for i, tt := range tests {
+		api, _ := makeTestFixtures(false)
+		got, err := api.ListConsents(tt.creds, tt.userID)
+		if err != tt.wantErr {
+			t.Errorf("case %d: want err=%v, got %v", i, tt.wantErr, err)
+			continue
+		}
+		if diff := pretty.Compare(tt.want, got); diff != "" {
+			t.Errorf("case %d: Compare(want, got) = %v", i, diff)
+		}
+	}
+}
+
+func TestRevokeConsent(t *testing.T) {
+	tests := []struct {
+		creds    Creds
+		userID   string
+		clientID string
+		wantErr  error
+	}{
+		// users can revoke their own consents
+		{
+			creds:    Creds{User: user.User{ID: "ID-1"}},
+			userID:   "ID-1",
+			clientID: goodClientID,
+		},
+		// admins can revoke other users' consents
+		{
+			creds:    Creds{User: user.User{ID: "ID-2", Admin: true}},
+			userID:   "ID-1",
+			clientID: goodClientID,
+		},
+		// other users can't
+		{
+			creds:    Creds{User: user.User{ID: "ID-2"}},
+			userID:   "ID-1",
+			clientID: goodClientID,
+			wantErr:  ErrorUnauthorized,
+		},
+		{
+			creds:    Creds{User: user.User{ID: "ID-1"}},
+			userID:   "ID-1",
+			clientID: nonAdminClientID,
+			wantErr:  ErrorResourceNotFound,
+		},
+	}
+
+	for i, tt := range tests {
+		api, _ := makeTestFixtures(false)
+		err := api.RevokeConsent(tt.creds, tt.userID, tt.clientID)
+		if err != tt.wantErr {
+			t.Errorf("case %d: want err=%v, got %v", i, tt.wantErr, err)
+			continue
+		}
+
+		_, err = api.consentRepo.Get(tt.userID, goodClientID)
+		if revoked := err == consent.ErrorNotFound; revoked != (tt.wantErr == nil) {
+			t.Errorf("case %d: want consent revoked=%t, got %t", i, tt.wantErr == nil, revoked)
+		}
+		clients, err := api.refreshRepo.ClientsWithRefreshTokens(tt.userID)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if revoked := len(clients) == 0; revoked != (tt.wantErr == nil) {
+			t.Errorf("case %d: want refresh tokens revoked=%t, got %t", i, tt.wantErr == nil, revoked)
+		}
+	}
+}
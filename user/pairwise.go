@@ -0,0 +1,53 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+)
+
+// PairwiseSubject is the subject identifier a user is known by to the clients
+// of a sector, which registered the pairwise subject type, so that clients of
+// different sectors can't correlate their users.
+// See: http://openid.net/specs/openid-connect-core-1_0.html#PairwiseAlg
+type PairwiseSubject struct {
+	Subject          string
+	SectorIdentifier string
+	UserID           string
+}
+
+// NewPairwiseSubject computes the user's subject identifier for the sector, as
+// the SHA-256 hash of the sector identifier, the user ID and a salt which is
+// kept secret by the server. Each is prefixed with its length, so that no two
+// different sectors and users hash the same input.
+func NewPairwiseSubject(sectorIdentifier, userID string, salt []byte) PairwiseSubject {
+	h := sha256.New()
+	writeLengthPrefixed(h, []byte(sectorIdentifier))
+	writeLengthPrefixed(h, []byte(userID))
+	writeLengthPrefixed(h, salt)
+	return PairwiseSubject{
+		Subject:          base64.RawURLEncoding.EncodeToString(h.Sum(nil)),
+		SectorIdentifier: sectorIdentifier,
+		UserID:           userID,
+	}
+}
+
+func writeLengthPrefixed(h hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+// PairwiseSubjectRepo records the pairwise subjects issued to clients, so they
+// can be resolved back to users.
+type PairwiseSubjectRepo interface {
+	// Create records a pairwise subject. Recording a subject which was
+	// already recorded is not an error.
+	Create(ps PairwiseSubject) error
+
+	// Get returns the pairwise subject with the given subject identifier,
+	// or ErrorNotFound.
+	Get(subject string) (PairwiseSubject, error)
+}
@@ -0,0 +1,26 @@
+package user
+
+import (
+	"testing"
+)
+
+func TestNewPairwiseSubject(t *testing.T) {
+	salt := []byte("salt")
+	sub := NewPairwiseSubject("app.example.com", "user-1", salt).Subject
+	if got := NewPairwiseSubject("app.example.com", "user-1", salt).Subject; got != sub {
+		t.Errorf("want stable subject %q, got %q", sub, got)
+	}
+
+	// Moving bytes from one input to another must change the subject.
+	others := []PairwiseSubject{
+		NewPairwiseSubject("app.example.comuser", "-1", salt),
+		NewPairwiseSubject("app.example.com", "user-1s", []byte("alt")),
+		NewPairwiseSubject("app.example.com", "user-1", []byte("pepper")),
+		NewPairwiseSubject("other.example.com", "user-1", salt),
+	}
+	for _, ps := range others {
+		if ps.Subject == sub {
+			t.Errorf("%q, %q: want a different subject than %q", ps.SectorIdentifier, ps.UserID, sub)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/jonboulle/clockwork"
 	"github.com/pborman/uuid"
 
+	pstrings "github.com/coreos/dex/pkg/strings"
 	"github.com/coreos/dex/repo"
 	"github.com/coreos/go-oidc/jose"
 	"github.com/coreos/go-oidc/key"
@@ -92,13 +93,15 @@ type UserFilter struct {
 }
 
 // AddToClaims adds basic information about the user to the given Claims.
+// email_verified is only added for unverified email addresses if it's one of
+// the requested claims.
 // http://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
-func (u *User) AddToClaims(claims jose.Claims) {
+func (u *User) AddToClaims(claims jose.Claims, requested []string) {
 	claims.Add("name", u.DisplayName)
 	if u.Email != "" {
 		claims.Add("email", u.Email)
-		if u.EmailVerified {
-			claims.Add("email_verified", true)
+		if u.EmailVerified || pstrings.ContainsString(requested, "email_verified") {
+			claims.Add("email_verified", u.EmailVerified)
 		}
 	}
 }
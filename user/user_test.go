@@ -12,6 +12,7 @@ import (
 func TestAddToClaims(t *testing.T) {
 	tests := []struct {
 		user         User
+		requested    []string
 		wantedClaims jose.Claims
 	}{
 		{
@@ -44,11 +45,23 @@ func TestAddToClaims(t *testing.T) {
 				"email_verified": true,
 			},
 		},
+		{
+			user: User{
+				DisplayName: "Test User Name",
+				Email:       "unverified@example.com",
+			},
+			requested: []string{"email_verified"},
+			wantedClaims: jose.Claims{
+				"name":           "Test User Name",
+				"email":          "unverified@example.com",
+				"email_verified": false,
+			},
+		},
 	}
 
 	for i, tt := range tests {
 		claims := jose.Claims{}
-		tt.user.AddToClaims(claims)
+		tt.user.AddToClaims(claims, tt.requested)
 		if !reflect.DeepEqual(claims, tt.wantedClaims) {
 			t.Errorf("case %d: want=%#v, got=%#v", i, tt.wantedClaims, claims)
 		}